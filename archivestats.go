@@ -0,0 +1,164 @@
+package sqlitezstd
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ArchiveStats is what every VFS did for an archive since the process
+// started, whether or not it sets ZstdVFS.Stats, so applications can log
+// and alert on it.
+type ArchiveStats struct {
+	// Reads is the number of reads SQLite made, and BytesRead the number
+	// of uncompressed bytes they returned.
+	Reads     int64
+	BytesRead int64
+	// Hits is the number of reads served without reading the archive,
+	// from the caches of decompressed frames.
+	Hits int64
+	// BytesFetched is the number of compressed bytes read from the
+	// archive, including its seek table, and FetchTime the time reading
+	// them took.
+	BytesFetched int64
+	FetchTime    time.Duration
+	// BytesDecompressed is the number of bytes of the FramesDecoded
+	// frames decompressed, including those read ahead.
+	BytesDecompressed int64
+	FramesDecoded     int64
+	// DecodeTime is the time decompressing those frames took.
+	DecodeTime time.Duration
+	// Connections is the number of connections that have it open now.
+	Connections int64
+}
+
+//nolint: gochecknoglobals
+var (
+	archiveStatsMutex sync.Mutex
+	archiveStats      = map[string]*archiveCounters{}
+)
+
+// StatsFor returns the stats of the archive at path, as given to the VFS,
+// such as a file name or a URL. Its query, if any, is left out, so every
+// DSN of an archive counts together. Archives never opened have none.
+func StatsFor(path string) ArchiveStats {
+	location, _, _ := strings.Cut(path, "?")
+	location = strings.TrimPrefix(location, "file:")
+
+	archiveStatsMutex.Lock()
+	counters, ok := archiveStats[location]
+	archiveStatsMutex.Unlock()
+
+	if !ok {
+		return ArchiveStats{}
+	}
+
+	return counters.snapshot()
+}
+
+// AllStats returns the stats of every archive opened since the process
+// started, by the path given to the VFS, without its query.
+func AllStats() map[string]ArchiveStats {
+	archiveStatsMutex.Lock()
+	defer archiveStatsMutex.Unlock()
+
+	stats := make(map[string]ArchiveStats, len(archiveStats))
+
+	for location, counters := range archiveStats {
+		stats[location] = counters.snapshot()
+	}
+
+	return stats
+}
+
+// countersFor returns the counters shared by every connection to the
+// archive name.
+func countersFor(name string) *archiveCounters {
+	location, _, _ := strings.Cut(name, "?")
+
+	archiveStatsMutex.Lock()
+	defer archiveStatsMutex.Unlock()
+
+	counters, ok := archiveStats[location]
+	if !ok {
+		counters = &archiveCounters{}
+		archiveStats[location] = counters
+	}
+
+	return counters
+}
+
+// archiveCounters counts what was done for an archive, or for one
+// connection to it and, through archive, for the archive as well. Its
+// methods do nothing on nil, for files that are not archives opened by a
+// VFS.
+type archiveCounters struct {
+	archive *archiveCounters
+
+	reads             atomic.Int64
+	bytesRead         atomic.Int64
+	hits              atomic.Int64
+	bytesFetched      atomic.Int64
+	fetchTime         atomic.Int64
+	bytesDecompressed atomic.Int64
+	framesDecoded     atomic.Int64
+	decodeTime        atomic.Int64
+	connections       atomic.Int64
+}
+
+func (a *archiveCounters) snapshot() ArchiveStats {
+	return ArchiveStats{
+		Reads:             a.reads.Load(),
+		BytesRead:         a.bytesRead.Load(),
+		Hits:              a.hits.Load(),
+		BytesFetched:      a.bytesFetched.Load(),
+		FetchTime:         time.Duration(a.fetchTime.Load()),
+		BytesDecompressed: a.bytesDecompressed.Load(),
+		FramesDecoded:     a.framesDecoded.Load(),
+		DecodeTime:        time.Duration(a.decodeTime.Load()),
+		Connections:       a.connections.Load(),
+	}
+}
+
+// read counts a read of size bytes, a hit when nothing was fetched for it.
+func (a *archiveCounters) read(size int, hit bool) {
+	if a != nil {
+		a.reads.Add(1)
+		a.bytesRead.Add(int64(size))
+
+		if hit {
+			a.hits.Add(1)
+		}
+
+		a.archive.read(size, hit)
+	}
+}
+
+// fetched counts size bytes read from the archive since started.
+func (a *archiveCounters) fetched(size int, started time.Time) {
+	if a != nil {
+		a.bytesFetched.Add(int64(size))
+		a.fetchTime.Add(int64(time.Since(started)))
+		a.archive.fetched(size, started)
+	}
+}
+
+// decoded counts a frame of size bytes, decompressed since started.
+func (a *archiveCounters) decoded(size int, started time.Time) {
+	if a != nil {
+		a.framesDecoded.Add(1)
+		a.bytesDecompressed.Add(int64(size))
+		a.decodeTime.Add(int64(time.Since(started)))
+		a.archive.decoded(size, started)
+	}
+}
+
+// opened counts a connection opening the archive, or closing it when
+// negative.
+func (a *archiveCounters) opened(count int64) {
+	if a != nil {
+		a.connections.Add(count)
+		a.archive.opened(count)
+	}
+}
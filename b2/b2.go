@@ -0,0 +1,246 @@
+// Package b2 reads sqlitezstd archives from Backblaze B2 with its native API.
+// Importing it registers a backend for b2://bucket/key names:
+//
+//	import _ "github.com/jtarchie/sqlitezstd/b2"
+//
+//	db, err := sql.Open("sqlite3", "b2://bucket/path/to/db.sqlite.zst?vfs=zstd")
+//
+// The application key is read from B2_APPLICATION_KEY_ID and
+// B2_APPLICATION_KEY, as with the b2 command line tool.
+package b2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+)
+
+const defaultAuthURL = "https://api.backblazeb2.com"
+
+var (
+	ErrInvalidURL         = errors.New("invalid b2 url")
+	ErrUnauthorized       = errors.New("b2 authorization failed")
+	ErrUnexpectedResponse = errors.New("unexpected b2 response")
+)
+
+//nolint: gochecknoinits
+func init() {
+	sqlitezstd.RegisterBackend("b2", &Backend{})
+}
+
+// Backend opens b2://bucket/key names. Authorization tokens expire after a
+// day, so a long-running session authorizes again whenever B2 rejects one.
+type Backend struct {
+	// KeyID and ApplicationKey authorize the account. If empty, they are
+	// read from B2_APPLICATION_KEY_ID and B2_APPLICATION_KEY.
+	KeyID          string
+	ApplicationKey string
+	// AuthURL is where accounts are authorized, defaulting to Backblaze.
+	AuthURL string
+	// Client makes every request, defaulting to http.DefaultClient.
+	Client *http.Client
+
+	mutex         sync.Mutex
+	authorization *authorization
+}
+
+var _ sqlitezstd.Backend = &Backend{}
+
+type authorization struct {
+	Token       string `json:"authorizationToken"`
+	DownloadURL string `json:"downloadUrl"`
+}
+
+func (b *Backend) client() *http.Client {
+	if b.Client != nil {
+		return b.Client
+	}
+
+	return http.DefaultClient
+}
+
+// authorize returns the current authorization, or a new one
+// if there is none yet or stale was rejected.
+func (b *Backend) authorize(stale *authorization) (*authorization, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.authorization != nil && b.authorization != stale {
+		return b.authorization, nil
+	}
+
+	keyID, key := b.KeyID, b.ApplicationKey
+	if keyID == "" && key == "" {
+		keyID, key = os.Getenv("B2_APPLICATION_KEY_ID"), os.Getenv("B2_APPLICATION_KEY")
+	}
+
+	authURL := b.AuthURL
+	if authURL == "" {
+		authURL = defaultAuthURL
+	}
+
+	request, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(authURL, "/")+"/b2api/v2/b2_authorize_account", nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not authorize: %w", err)
+	}
+
+	request.SetBasicAuth(keyID, key)
+
+	response, err := b.client().Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("could not authorize: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrUnauthorized, response.Status)
+	}
+
+	current := &authorization{}
+
+	err = json.NewDecoder(response.Body).Decode(current)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode authorization: %w", err)
+	}
+
+	b.authorization = current
+
+	return current, nil
+}
+
+// do sends the request built by build, authorizing again once if the
+// token it was sent with has expired.
+func (b *Backend) do(build func(current *authorization) (*http.Request, error)) (*http.Response, error) {
+	var stale *authorization
+
+	for attempt := 0; ; attempt++ {
+		current, err := b.authorize(stale)
+		if err != nil {
+			return nil, err
+		}
+
+		request, err := build(current)
+		if err != nil {
+			return nil, err
+		}
+
+		request.Header.Set("Authorization", current.Token)
+
+		response, err := b.client().Do(request)
+		if err != nil {
+			return nil, fmt.Errorf("could not request %s: %w", request.URL.Path, err)
+		}
+
+		if response.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return response, nil
+		}
+
+		_ = response.Body.Close()
+		stale = current
+	}
+}
+
+func (b *Backend) Open(uri *url.URL) (sqlitezstd.Object, error) {
+	bucket := uri.Host
+	key := strings.TrimPrefix(uri.Path, "/")
+
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("%w: %s needs a bucket and a key", ErrInvalidURL, uri.Redacted())
+	}
+
+	response, err := b.do(func(current *authorization) (*http.Request, error) {
+		location := fmt.Sprintf("%s/file/%s/%s", current.DownloadURL, url.PathEscape(bucket), (&url.URL{Path: key}).EscapedPath())
+
+		request, err := http.NewRequest(http.MethodHead, location, nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not stat file: %w", err)
+		}
+
+		return request, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: could not stat %s: %s", ErrUnexpectedResponse, uri.Redacted(), response.Status)
+	}
+
+	size, err := strconv.ParseInt(response.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("could not stat file: invalid size: %w", err)
+	}
+
+	fileID := response.Header.Get("X-Bz-File-Id")
+	if fileID == "" {
+		return nil, fmt.Errorf("%w: %s has no file id", ErrUnexpectedResponse, uri.Redacted())
+	}
+
+	return &object{backend: b, fileID: fileID, size: size}, nil
+}
+
+// object is a B2 file, read by its id so that uploading a new
+// version under the same name does not change what is read.
+type object struct {
+	backend *Backend
+	fileID  string
+	size    int64
+}
+
+func (o *object) ReadAt(p []byte, off int64) (int, error) {
+	if off >= o.size {
+		return 0, io.EOF
+	}
+
+	end := min(off+int64(len(p)), o.size)
+
+	response, err := o.backend.do(func(current *authorization) (*http.Request, error) {
+		location := fmt.Sprintf("%s/b2api/v2/b2_download_file_by_id?fileId=%s", current.DownloadURL, url.QueryEscape(o.fileID))
+
+		request, err := http.NewRequest(http.MethodGet, location, nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not read file: %w", err)
+		}
+
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, end-1))
+
+		return request, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("%w: could not read file: %s", ErrUnexpectedResponse, response.Status)
+	}
+
+	count, err := io.ReadFull(response.Body, p[:end-off])
+	if err != nil {
+		return count, fmt.Errorf("could not read file: %w", err)
+	}
+
+	if count < len(p) {
+		return count, io.EOF
+	}
+
+	return count, nil
+}
+
+func (o *object) Size() int64 {
+	return o.size
+}
+
+func (o *object) Close() error {
+	return nil
+}
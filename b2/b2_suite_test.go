@@ -0,0 +1,198 @@
+package b2_test
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	"github.com/jtarchie/sqlitezstd/b2"
+	"github.com/jtarchie/sqlitezstd/testhelper"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestB2(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "B2 Suite")
+}
+
+// account is a fake B2 account with a single bucket.
+type account struct {
+	mutex          sync.Mutex
+	url            string
+	token          string
+	authorizations int
+	files          map[string][]byte
+	ids            map[string]string
+}
+
+func (a *account) put(name string, contents []byte) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	id := fmt.Sprintf("file-%d", len(a.files))
+	a.files[id] = contents
+	a.ids[name] = id
+}
+
+// expire rejects the current token, as B2 does after a day.
+func (a *account) expire() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.token = ""
+}
+
+func (a *account) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if r.URL.Path == "/b2api/v2/b2_authorize_account" {
+		keyID, key, ok := r.BasicAuth()
+		if !ok || keyID != "id" || key != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		a.authorizations++
+		a.token = fmt.Sprintf("token-%d", a.authorizations)
+
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"authorizationToken": a.token,
+			"apiUrl":             a.url,
+			"downloadUrl":        a.url,
+		})
+
+		return
+	}
+
+	if a.token == "" || r.Header.Get("Authorization") != a.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"code": "expired_auth_token"}`))
+
+		return
+	}
+
+	id := r.URL.Query().Get("fileId")
+	if name, ok := strings.CutPrefix(r.URL.Path, "/file/bucket/"); ok {
+		id = a.ids[name]
+	}
+
+	contents, ok := a.files[id]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	w.Header().Set("X-Bz-File-Id", id)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(contents))
+}
+
+func countEntries(client *sql.DB) (int64, error) {
+	var count int64
+	err := client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)
+
+	return count, err
+}
+
+var _ = Describe("Backend", func() {
+	var files *account
+
+	BeforeEach(func() {
+		Expect(sqlitezstd.Init()).To(Succeed())
+
+		files = &account{files: map[string][]byte{}, ids: map[string]string{}}
+		server := httptest.NewServer(files)
+		DeferCleanup(server.Close)
+
+		files.url = server.URL
+
+		sqlitezstd.RegisterBackend("b2", &b2.Backend{
+			KeyID:          "id",
+			ApplicationKey: "secret",
+			AuthURL:        server.URL,
+		})
+	})
+
+	upload := func(name string, opts ...sqlitezstd.Option) {
+		zstPath := testhelper.CreateEntriesDB(GinkgoT(), 1000, opts...)
+
+		contents, err := os.ReadFile(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		files.put(name, contents)
+	}
+
+	open := func(name string) *sql.DB {
+		client, err := sql.Open("sqlite3", name+"?vfs=zstd")
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(client.Close)
+
+		return client
+	}
+
+	It("reads archives from a bucket", func() {
+		upload("path/db.sqlite.zst")
+
+		count, err := countEntries(open("b2://bucket/path/db.sqlite.zst"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+	})
+
+	It("authorizes again when the token expires", func() {
+		upload("db.sqlite.zst", sqlitezstd.WithFrameSize(4096))
+
+		client := open("b2://bucket/db.sqlite.zst")
+		client.SetMaxOpenConns(1)
+
+		_, err := client.Exec("PRAGMA cache_size = 0")
+		Expect(err).ToNot(HaveOccurred())
+
+		for range 3 {
+			files.expire()
+
+			var id int64
+			err := client.QueryRow("SELECT id FROM entries ORDER BY RANDOM() LIMIT 1;").Scan(&id)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		Expect(files.authorizations).To(BeNumerically(">", 1))
+	})
+
+	It("keeps reading the version it opened", func() {
+		upload("db.sqlite.zst", sqlitezstd.WithFrameSize(4096))
+
+		client := open("b2://bucket/db.sqlite.zst")
+		client.SetMaxOpenConns(1)
+
+		count, err := countEntries(client)
+		Expect(err).ToNot(HaveOccurred())
+
+		files.put("db.sqlite.zst", []byte("replaced"))
+
+		_, err = client.Exec("PRAGMA cache_size = 0; SELECT * FROM entries WHERE id = 999;")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+	})
+
+	It("errors for missing files and invalid keys", func() {
+		_, err := countEntries(open("b2://bucket/missing.zst"))
+		Expect(err).To(HaveOccurred())
+
+		sqlitezstd.RegisterBackend("b2", &b2.Backend{KeyID: "id", ApplicationKey: "wrong", AuthURL: files.url})
+
+		_, err = sqlitezstd.Inspect("b2://bucket/missing.zst")
+		Expect(err).To(MatchError(b2.ErrUnauthorized))
+	})
+})
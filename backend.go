@@ -0,0 +1,131 @@
+package sqlitezstd
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Object is an archive, or a part of one, opened by a Backend.
+type Object interface {
+	io.ReaderAt
+	io.Closer
+	// Size is the size of the object in bytes.
+	Size() int64
+}
+
+// Versioned is an Object that knows the version of its contents, such as
+// an ETag. Archives opened again with the same version share the seek
+// table parsed the first time.
+type Versioned interface {
+	Version() string
+}
+
+// Backend opens archives stored somewhere other than a local file, such as an
+// HTTP server or an object store. Backends are registered by URL scheme, as
+// in s3://bucket/key, with RegisterBackend. Settings are passed in the query
+// of the URL. When the database is opened with a file: URI, its parameters
+// are added to the query as well, as in
+// file:s3://bucket/key?vfs=zstd&endpoint=http://localhost:9000.
+// Names without a registered scheme are opened as local files.
+type Backend interface {
+	Open(uri *url.URL) (Object, error)
+}
+
+// OpenerFunc adapts a function to a Backend.
+type OpenerFunc func(uri *url.URL) (Object, error)
+
+func (f OpenerFunc) Open(uri *url.URL) (Object, error) {
+	return f(uri)
+}
+
+//nolint: gochecknoglobals
+var (
+	backends = map[string]Backend{
+		"http":  &HTTPBackend{},
+		"https": &HTTPBackend{},
+	}
+	backendsMutex sync.RWMutex
+)
+
+// RegisterBackend opens archives whose name starts with scheme:// with
+// backend, replacing any backend previously registered for scheme,
+// including the built-in http and https ones.
+func RegisterBackend(scheme string, backend Backend) {
+	backendsMutex.Lock()
+	defer backendsMutex.Unlock()
+
+	backends[strings.ToLower(scheme)] = backend
+}
+
+// RegisterScheme opens archives whose name starts with scheme:// with opener.
+func RegisterScheme(scheme string, opener OpenerFunc) {
+	RegisterBackend(scheme, opener)
+}
+
+// backendFor returns the backend registered for the scheme of name.
+func backendFor(name string) (Backend, bool) {
+	scheme, _, ok := strings.Cut(name, "://")
+	if !ok {
+		return nil, false
+	}
+
+	backendsMutex.RLock()
+	defer backendsMutex.RUnlock()
+
+	backend, ok := backends[strings.ToLower(scheme)]
+
+	return backend, ok
+}
+
+// withParameters adds the parameters of the DSN to the query of a backend
+// name, unless the name already sets them.
+func withParameters(name string, params url.Values) string {
+	if len(params) == 0 {
+		return name
+	}
+
+	uri, err := url.Parse(name)
+	if err != nil {
+		return name
+	}
+
+	query := uri.Query()
+	added := url.Values{}
+
+	for key, values := range params {
+		if !query.Has(key) {
+			added[key] = values
+		}
+	}
+
+	if len(added) == 0 {
+		return name
+	}
+
+	// the query of the name is kept as is, as a signature covers it
+	if uri.RawQuery == "" {
+		uri.RawQuery = added.Encode()
+	} else {
+		uri.RawQuery += "&" + added.Encode()
+	}
+
+	return uri.String()
+}
+
+// openBackend opens name with the backend registered for its scheme.
+func openBackend(backend Backend, name string) (io.ReaderAt, int64, io.Closer, error) {
+	uri, err := url.Parse(name)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("could not parse url: %w", err)
+	}
+
+	object, err := backend.Open(uri)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("could not open %s: %w", uri.Redacted(), err)
+	}
+
+	return object, object.Size(), object, nil
+}
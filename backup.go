@@ -0,0 +1,98 @@
+package sqlitezstd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+const (
+	backupStepPages = 256
+	backupBusyDelay = 10 * time.Millisecond
+)
+
+var ErrNotSQLite = errors.New("connection is not a go-sqlite3 connection")
+
+// BackupTo snapshots the main database of src to dst using the SQLite online
+// backup API. Pages are copied a few at a time and compressed as they arrive,
+// so writers on other connections are only blocked briefly. If the source
+// changes between steps, SQLite restarts the backup, so the archive is
+// always a consistent snapshot. Frames are always aligned to the page size.
+func BackupTo(src *sql.Conn, dst string, opts ...Option) error {
+	ctx := context.Background()
+
+	var pageSize int
+
+	err := src.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize)
+	if err != nil {
+		return fmt.Errorf("could not read page size: %w", err)
+	}
+
+	return streamInto(dst, pageSize, opts, func(dsn string) error {
+		client, err := sql.Open("sqlite3", dsn)
+		if err != nil {
+			return fmt.Errorf("could not open destination: %w", err)
+		}
+		defer client.Close()
+
+		conn, err := client.Conn(ctx)
+		if err != nil {
+			return fmt.Errorf("could not open destination: %w", err)
+		}
+		defer conn.Close()
+
+		return conn.Raw(func(destination any) error {
+			return src.Raw(func(source any) error {
+				return backup(destination, source)
+			})
+		})
+	})
+}
+
+func backup(destination, source any) error {
+	destinationConn, ok := destination.(*sqlite3.SQLiteConn)
+	if !ok {
+		return ErrNotSQLite
+	}
+
+	sourceConn, ok := source.(*sqlite3.SQLiteConn)
+	if !ok {
+		return ErrNotSQLite
+	}
+
+	progress, err := destinationConn.Backup("main", sourceConn, "main")
+	if err != nil {
+		return fmt.Errorf("could not start backup: %w", err)
+	}
+	//nolint: errcheck
+	defer progress.Finish()
+
+	for {
+		remaining := progress.Remaining()
+
+		done, err := progress.Step(backupStepPages)
+		if err != nil {
+			return fmt.Errorf("could not backup source: %w", err)
+		}
+
+		if done {
+			break
+		}
+
+		// the source is locked by a writer, give it a moment
+		if progress.Remaining() == remaining && remaining > 0 {
+			time.Sleep(backupBusyDelay)
+		}
+	}
+
+	err = progress.Finish()
+	if err != nil {
+		return fmt.Errorf("could not finish backup: %w", err)
+	}
+
+	return nil
+}
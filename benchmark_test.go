@@ -14,9 +14,11 @@ import (
 	"time"
 
 	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	"github.com/jtarchie/sqlitezstd/testhelper"
 	_ "github.com/mattn/go-sqlite3" // ensure you import the SQLite3 driver
 	"github.com/onsi/gomega/gexec"
 	"github.com/pioz/faker"
+	"github.com/psanford/sqlite3vfs"
 )
 
 //nolint: gosec
@@ -488,3 +490,167 @@ func BenchmarkReadCompressedRtreeSQLite(b *testing.B) {
 		}
 	})
 }
+
+// BenchmarkReadAtCacheHit reads pages whose frames are already
+// decompressed, which should not allocate.
+func BenchmarkReadAtCacheHit(b *testing.B) {
+	zstPath := testhelper.CreateEntriesDB(b, 1000, sqlitezstd.WithFrameSize(16384))
+
+	vfs := &sqlitezstd.ZstdVFS{CacheSize: 64 << 20}
+
+	file, _, err := vfs.Open(zstPath, sqlite3vfs.OpenMainDB|sqlite3vfs.OpenReadOnly)
+	if err != nil {
+		b.Fatalf("could not open archive: %v", err)
+	}
+	defer file.Close()
+
+	size, err := file.FileSize()
+	if err != nil {
+		b.Fatalf("could not read size: %v", err)
+	}
+
+	page := make([]byte, 4096)
+	pages := size / int64(len(page))
+
+	for index := range pages {
+		_, err = file.ReadAt(page, index*int64(len(page)))
+		if err != nil {
+			b.Fatalf("could not read page: %v", err)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for index := range b.N {
+		_, err = file.ReadAt(page, int64(index)%pages*int64(len(page)))
+		if err != nil {
+			b.Fatalf("could not read page: %v", err)
+		}
+	}
+}
+
+// BenchmarkReadAtSmallFrames reads pages at random from an archive of
+// about a hundred thousand small frames, where finding the frame of a
+// read is a large part of it.
+func BenchmarkReadAtSmallFrames(b *testing.B) {
+	rows := make([]string, 0, 20_000)
+	for id := 1; id <= 20_000; id++ {
+		rows = append(rows, fmt.Sprintf("INSERT INTO entries (id, body) VALUES (%d, hex(randomblob(128)))", id))
+	}
+
+	dbPath := testhelper.CreateSQLite(b, "CREATE TABLE entries (id INTEGER PRIMARY KEY, body TEXT);", rows)
+	zstPath := dbPath + ".zst"
+
+	err := sqlitezstd.CompressFile(dbPath, zstPath, sqlitezstd.WithFrameSize(64), sqlitezstd.WithPageAlignment(false))
+	if err != nil {
+		b.Fatalf("could not compress database: %v", err)
+	}
+
+	vfs := &sqlitezstd.ZstdVFS{CacheSize: 64 << 20}
+
+	file, _, err := vfs.Open(zstPath, sqlite3vfs.OpenMainDB|sqlite3vfs.OpenReadOnly)
+	if err != nil {
+		b.Fatalf("could not open archive: %v", err)
+	}
+	defer file.Close()
+
+	size, err := file.FileSize()
+	if err != nil {
+		b.Fatalf("could not read size: %v", err)
+	}
+
+	page := make([]byte, 64)
+	pages := size / int64(len(page))
+
+	for index := range pages {
+		_, err = file.ReadAt(page, index*int64(len(page)))
+		if err != nil {
+			b.Fatalf("could not read page: %v", err)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for index := range b.N {
+		//nolint: gosec
+		_, err = file.ReadAt(page, int64(index*7919)%pages*int64(len(page)))
+		if err != nil {
+			b.Fatalf("could not read page: %v", err)
+		}
+	}
+}
+
+// BenchmarkScanCoalesced scans a table through regions of frames,
+// decompressed by one worker or by several.
+func BenchmarkScanCoalesced(b *testing.B) {
+	_ = sqlitezstd.Init()
+
+	rows := make([]string, 0, 20_000)
+	for id := 1; id <= 20_000; id++ {
+		rows = append(rows, fmt.Sprintf("INSERT INTO entries (id, body) VALUES (%d, hex(randomblob(128)))", id))
+	}
+
+	zstPath := testhelper.CreateCompressedDB(b, "CREATE TABLE entries (id INTEGER PRIMARY KEY, body TEXT);", rows)
+
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			dsn := fmt.Sprintf("file:%s?vfs=zstd&coalesce_size=4MiB&decompress_workers=%d", zstPath, workers)
+
+			for range b.N {
+				client, err := sql.Open("sqlite3", dsn)
+				if err != nil {
+					b.Fatalf("could not open database: %v", err)
+				}
+
+				var length int64
+
+				err = client.QueryRow("SELECT SUM(LENGTH(body)) FROM entries;").Scan(&length)
+				if err != nil {
+					b.Fatalf("could not scan: %v", err)
+				}
+
+				_ = client.Close()
+			}
+		})
+	}
+}
+
+// BenchmarkReadAtPartialFrames reads pages at random, keeping no frames,
+// so every read reads and decompresses its frame.
+func BenchmarkReadAtPartialFrames(b *testing.B) {
+	rows := make([]string, 0, 20_000)
+	for id := 1; id <= 20_000; id++ {
+		rows = append(rows, fmt.Sprintf("INSERT INTO entries (id, body) VALUES (%d, hex(randomblob(128)))", id))
+	}
+
+	zstPath := testhelper.CreateCompressedDB(b, "CREATE TABLE entries (id INTEGER PRIMARY KEY, body TEXT);", rows)
+
+	vfs := &sqlitezstd.ZstdVFS{PartialFrames: true}
+
+	file, _, err := vfs.Open(zstPath, sqlite3vfs.OpenMainDB|sqlite3vfs.OpenReadOnly)
+	if err != nil {
+		b.Fatalf("could not open archive: %v", err)
+	}
+	defer file.Close()
+
+	size, err := file.FileSize()
+	if err != nil {
+		b.Fatalf("could not read size: %v", err)
+	}
+
+	page := make([]byte, 4096)
+	pages := size / int64(len(page))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for index := range b.N {
+		//nolint: gosec
+		_, err = file.ReadAt(page, int64(index*7919)%pages*int64(len(page)))
+		if err != nil {
+			b.Fatalf("could not read page: %v", err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package sqlitezstd
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// maxPooledBuffer is the largest buffer kept for reuse; larger ones are
+// left to the garbage collector.
+const maxPooledBuffer = 64 << 20
+
+// bufferPool keeps the buffers that live for one read, such as the
+// compressed bytes of a frame on their way to the decoder, so sustained
+// reads reuse them rather than allocating them for every frame. Buffers
+// are kept by size, rounded up to a power of two, so a small frame does
+// not hold on to the buffer of a large one.
+type bufferPool struct {
+	classes [bits.UintSize]sync.Pool
+}
+
+//nolint: gochecknoglobals
+var buffers = &bufferPool{}
+
+// get returns a buffer of size bytes, with stale contents.
+func (b *bufferPool) get(size int) *[]byte {
+	if size == 0 || size > maxPooledBuffer {
+		buffer := make([]byte, size)
+
+		return &buffer
+	}
+
+	class := bits.Len(uint(size - 1))
+
+	buffer, ok := b.classes[class].Get().(*[]byte)
+	if !ok {
+		created := make([]byte, 1<<class)
+		buffer = &created
+	}
+
+	*buffer = (*buffer)[:size]
+
+	return buffer
+}
+
+// put keeps buffer for the next get of its size. It must not be used
+// afterwards.
+func (b *bufferPool) put(buffer *[]byte) {
+	size := cap(*buffer)
+	if size == 0 || size > maxPooledBuffer || size&(size-1) != 0 {
+		return
+	}
+
+	b.classes[bits.Len(uint(size-1))].Put(buffer)
+}
@@ -0,0 +1,119 @@
+package sqlitezstd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrFrameNotFound    = errors.New("archive has no such frame")
+	ErrFrameKeyMismatch = errors.New("key does not name a frame of the archive")
+)
+
+// Cache keeps decompressed frames outside the process, such as in Redis or
+// memcached, so a fleet of stateless services reading the same remote
+// archives shares one warm cache rather than each fetching from the origin.
+// Keys name a frame of an archive and are at most 64 bytes of letters,
+// digits, dashes and dots. A cache that can not be reached, or does not
+// hold a key, returns false; frames are checked against the seek table
+// before they are used, and removed when they are not valid.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Put(key string, data []byte)
+	Remove(key string)
+}
+
+// FrameLoader is a Cache that fetches the frames it does not hold itself,
+// such as with ReadFrame on the instance of a fleet owning them, rather
+// than being given them with Put. Load is called instead of Get with the
+// name the archive was opened with, which must not leave the process, as
+// it may hold credentials.
+type FrameLoader interface {
+	Cache
+	Load(key string, archive string) ([]byte, bool)
+}
+
+// ReadFrame returns the frame of the archive name that key names in the
+// caches, decompressed and checked against the size and checksum the seek
+// table records for it. A key that does not name a frame of the archive as
+// it is now, by its location or its contents, is ErrFrameKeyMismatch, so a
+// frame is never read for another archive, or for one since replaced.
+func ReadFrame(name string, key string) ([]byte, error) {
+	prefix, index, ok := splitFrameKey(key)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrFrameKeyMismatch, key)
+	}
+
+	reader, err := openSharedArchive(name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	table, err := loadSeekTable(reader.identity, reader, reader.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	if prefix != framesKey(name, reader.Size(), table, false) && prefix != framesKey(name, reader.Size(), table, true) {
+		return nil, fmt.Errorf("%w: %q", ErrFrameKeyMismatch, key)
+	}
+
+	// the header and metadata frames hold no contents
+	if index >= len(table.frames) || table.frames[index].decompressedSize == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrFrameNotFound, index)
+	}
+
+	return decompressFrame(reader, decoderOptions{}, table.frames[index], table.checksums, nil, nil)
+}
+
+// splitFrameKey returns the archive key and frame index of a key named
+// by cachedFrames.name.
+func splitFrameKey(key string) (string, int, bool) {
+	trimmed, ok := strings.CutSuffix(key, ".frame")
+	if !ok {
+		return "", 0, false
+	}
+
+	prefix, suffix, ok := strings.Cut(trimmed, "-")
+	if !ok {
+		return "", 0, false
+	}
+
+	index, err := strconv.Atoi(suffix)
+	if err != nil || index < 0 {
+		return "", 0, false
+	}
+
+	return prefix, index, true
+}
+
+var _ frameStore = externalCache{}
+
+// externalCache stores the frames of archive in a Cache.
+type externalCache struct {
+	cache   Cache
+	archive string
+}
+
+func (e externalCache) get(name string) ([]byte, bool) {
+	if loader, ok := e.cache.(FrameLoader); ok {
+		return loader.Load(name, e.archive)
+	}
+
+	return e.cache.Get(name)
+}
+
+func (e externalCache) put(name string, data []byte) {
+	e.cache.Put(name, data)
+}
+
+func (e externalCache) remove(name string) {
+	e.cache.Remove(name)
+}
+
+func (e externalCache) trusted() bool {
+	return false
+}
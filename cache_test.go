@@ -0,0 +1,116 @@
+package sqlitezstd_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	"github.com/jtarchie/sqlitezstd/testhelper"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// keptFrames is a Cache keeping every frame put, to learn their keys.
+type keptFrames struct {
+	mutex  sync.Mutex
+	frames map[string][]byte
+}
+
+var _ sqlitezstd.Cache = &keptFrames{}
+
+func (k *keptFrames) Get(key string) ([]byte, bool) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	data, ok := k.frames[key]
+
+	return data, ok
+}
+
+func (k *keptFrames) Put(key string, data []byte) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.frames == nil {
+		k.frames = map[string][]byte{}
+	}
+
+	k.frames[key] = data
+}
+
+func (k *keptFrames) Remove(key string) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	delete(k.frames, key)
+}
+
+var _ = Describe("ReadFrame", func() {
+	BeforeEach(func() {
+		Expect(sqlitezstd.Init()).To(Succeed())
+	})
+
+	It("reads the frame a key names, only for the archive it names", func() {
+		zstPath := testhelper.CreateEntriesDB(GinkgoT(), 1000, sqlitezstd.WithFrameSize(4096))
+		otherPath := testhelper.CreateEntriesDB(GinkgoT(), 1000, sqlitezstd.WithFrameSize(4096))
+		Expect(os.Rename(otherPath, filepath.Join(filepath.Dir(zstPath), "other.sqlite.zst"))).To(Succeed())
+
+		contents, err := os.ReadFile(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		info, err := sqlitezstd.Inspect(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		// a copy with a corrupt frame has the same seek table
+		frame := info.Frames[1]
+		contents[frame.CompressedOffset+frame.CompressedSize/2] ^= 0xFF
+		Expect(os.WriteFile(filepath.Join(filepath.Dir(zstPath), "corrupt.sqlite.zst"), contents, 0o600)).To(Succeed())
+
+		_, serverURL := serveOrigin(filepath.Dir(zstPath))
+		archive := fmt.Sprintf("%s/%s", serverURL, filepath.Base(zstPath))
+
+		for _, byContent := range []bool{false, true} {
+			cache := &keptFrames{}
+
+			name := fmt.Sprintf("zstd-read-frame-%t", byContent)
+			Expect(sqlitezstd.Register(name, &sqlitezstd.ZstdVFS{Cache: cache, DiskCacheByContent: byContent})).To(Succeed())
+			Expect(countEntries(fmt.Sprintf("file:%s?vfs=%s", archive, name))).To(BeEquivalentTo(1000))
+			Expect(cache.frames).ToNot(BeEmpty())
+
+			for key, expected := range cache.frames {
+				data, err := sqlitezstd.ReadFrame(archive, key)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(data).To(Equal(expected))
+
+				_, err = sqlitezstd.ReadFrame(serverURL+"/other.sqlite.zst", key)
+				Expect(err).To(MatchError(sqlitezstd.ErrFrameKeyMismatch))
+			}
+
+			key := fmt.Sprintf("%s-%d.frame", firstKey(cache.frames)[:32], 2)
+
+			_, err = sqlitezstd.ReadFrame(serverURL+"/corrupt.sqlite.zst", key)
+			if byContent {
+				Expect(err).To(MatchError(sqlitezstd.ErrCorruptFrame))
+			} else {
+				Expect(err).To(MatchError(sqlitezstd.ErrFrameKeyMismatch))
+			}
+
+			_, err = sqlitezstd.ReadFrame(archive, fmt.Sprintf("%s-%d.frame", firstKey(cache.frames)[:32], 0))
+			Expect(err).To(MatchError(sqlitezstd.ErrFrameNotFound))
+		}
+
+		_, err = sqlitezstd.ReadFrame(archive, "not-a.frame")
+		Expect(err).To(MatchError(sqlitezstd.ErrFrameKeyMismatch))
+	})
+})
+
+// firstKey returns any key of frames.
+func firstKey(frames map[string][]byte) string {
+	for key := range frames {
+		return key
+	}
+
+	return ""
+}
@@ -0,0 +1,165 @@
+package sqlitezstd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	seekable "github.com/SaveTheRbtz/zstd-seekable-format-go"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Report is the outcome of Check, filled in as far as the checks got.
+type Report struct {
+	// Location is the archive checked, without credentials or query.
+	Location string
+	// Reachable reports whether the archive was opened, and Size is its
+	// compressed size.
+	Reachable bool
+	Size      int64
+	// Ranges reports whether the archive was read at an offset, which
+	// for remote archives takes range requests.
+	Ranges bool
+	// SeekTable reports whether the seek table is valid, and Frames is
+	// the number of frames holding contents.
+	SeekTable bool
+	Frames    int
+	// Database reports whether the contents start with a valid SQLite
+	// header and are made up of whole pages of PageSize bytes.
+	Database bool
+	PageSize int
+	// Duration is how long the checks took.
+	Duration time.Duration
+}
+
+// Healthy reports whether every check passed.
+func (r Report) Healthy() bool {
+	return r.Reachable && r.Ranges && r.SeekTable && r.Database
+}
+
+// Check makes sure the archive of a DSN, such as
+// file:https://example.com/db.sqlite.zst?vfs=zstd&range_size=-1, can be
+// read through the VFS, without opening a connection: that it can be
+// reached, read with range requests, has a valid seek table, and holds a
+// SQLite database. Only the seek table and the first frame are read, so it
+// suits readiness probes. The report says which checks passed, and the
+// error why the first one failed.
+func Check(dsn string) (Report, error) {
+	start := time.Now()
+
+	report, err := check(dsn)
+	report.Duration = time.Since(start)
+
+	return report, err
+}
+
+func check(dsn string) (Report, error) {
+	report := Report{}
+
+	name, err := checkedName(dsn)
+	if err != nil {
+		return report, err
+	}
+
+	report.Location = redact(name)
+
+	file, err := openArchive(name)
+	if err != nil {
+		return report, err
+	}
+	defer file.Close()
+
+	report.Reachable = true
+	report.Size = file.Size()
+
+	table, err := decodeSeekTable(file, file.Size())
+	if err != nil {
+		// the seek table was read, but is not valid
+		report.Ranges = errors.Is(err, ErrInvalidSeekTable)
+
+		return report, err
+	}
+
+	report.Ranges = true
+	report.SeekTable = true
+
+	for _, frame := range table.frames {
+		if frame.decompressedSize > 0 {
+			report.Frames++
+		}
+	}
+
+	report.PageSize, err = checkDatabase(file, table.decompressedSize)
+	if err != nil {
+		return report, err
+	}
+
+	report.Database = true
+
+	return report, nil
+}
+
+// checkedName returns the name the VFS opens for dsn, with the
+// parameters of the DSN for remote archives.
+func checkedName(dsn string) (string, error) {
+	name := strings.TrimPrefix(dsn, "file:")
+	location, query, _ := strings.Cut(name, "?")
+
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return "", fmt.Errorf("%w: could not parse %q: %w", ErrInvalidOption, redact(dsn), err)
+	}
+
+	if remote := params.Get("url"); remote != "" {
+		params.Del("url")
+
+		return withParameters(remote, params), nil
+	}
+
+	if isRemote(location) {
+		return name, nil
+	}
+
+	return location, nil
+}
+
+// checkDatabase returns the page size of the database in reader, after
+// checking its header and that it is made up of whole pages.
+func checkDatabase(reader io.ReadSeeker, size int64) (int, error) {
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return 0, fmt.Errorf("could not create decoder: %w", err)
+	}
+	defer decoder.Close()
+
+	contents, err := seekable.NewReader(reader, decoder)
+	if err != nil {
+		return 0, fmt.Errorf("could not read archive: %w", err)
+	}
+	defer contents.Close()
+
+	header := make([]byte, sqliteHeaderSize)
+
+	_, err = contents.ReadAt(header, 0)
+	if err != nil {
+		return 0, fmt.Errorf("%w: could not read header: %w", ErrInvalidDatabase, err)
+	}
+
+	if string(header[:len(sqliteHeaderMagic)]) != sqliteHeaderMagic {
+		return 0, fmt.Errorf("%w: header magic mismatch", ErrInvalidDatabase)
+	}
+
+	pageSize, err := parsePageSize(header)
+	if err != nil {
+		return 0, err
+	}
+
+	if size%int64(pageSize) != 0 {
+		return 0, fmt.Errorf("%w: size %d is not a multiple of page size %d", ErrInvalidDatabase, size, pageSize)
+	}
+
+	return pageSize, nil
+}
@@ -0,0 +1,114 @@
+package sqlitezstd
+
+import (
+	"math/bits"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const (
+	// chunkSpread bounds content-defined frames to a quarter
+	// and four times the average frame size.
+	chunkSpread = 4
+	gearSeed    = 0x9E3779B97F4A7C15
+)
+
+//nolint: gochecknoglobals
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+
+	// splitmix64, so the table and with it every boundary is stable
+	state := uint64(gearSeed)
+	for index := range table {
+		state += gearSeed
+		value := state
+		value = (value ^ (value >> 30)) * 0xBF58476D1CE4E5B9
+		value = (value ^ (value >> 27)) * 0x94D049BB133111EB
+		table[index] = value ^ (value >> 31)
+	}
+
+	return table
+}()
+
+// chunker finds content-defined frame boundaries, so inserting or removing
+// data only changes the frames around the edit. Aligned databases are cut
+// between pages, based on a hash of the whole page, otherwise a rolling
+// gear hash cuts at any byte.
+type chunker struct {
+	unit    int
+	min     int
+	max     int
+	average uint64
+	mask    uint64
+	hash    uint64
+	digest  *xxhash.Digest
+}
+
+func newChunker(header *Header) *chunker {
+	unit := 1
+	if header.PageAligned {
+		unit = header.PageSize
+	}
+
+	average := max(unit, header.FrameSize)
+
+	chunks := &chunker{
+		unit:   unit,
+		min:    max(unit, average/chunkSpread/unit*unit),
+		max:    max(unit, min(average*chunkSpread, maxFrameSize)/unit*unit),
+		digest: xxhash.New(),
+	}
+
+	// cuts are only looked for past the minimum,
+	// so they are spaced by what is left of the average
+	spacing := max(1, average-chunks.min)
+
+	if unit > 1 {
+		chunks.average = uint64(max(1, spacing/unit))
+	} else {
+		chunks.mask = 1<<(bits.Len(uint(spacing))-1) - 1
+	}
+
+	return chunks
+}
+
+// boundary returns how many bytes of p belong to the frame that already
+// holds frame, and whether the frame ends after them.
+func (c *chunker) boundary(frame []byte, p []byte) (int, bool) {
+	if c.unit > 1 {
+		return c.pageBoundary(frame, p)
+	}
+
+	for index, value := range p {
+		c.hash = c.hash<<1 + gearTable[value]
+		size := len(frame) + index + 1
+
+		if size >= c.max || (size >= c.min && c.hash&c.mask == 0) {
+			c.hash = 0
+
+			return index + 1, true
+		}
+	}
+
+	return len(p), false
+}
+
+func (c *chunker) pageBoundary(frame []byte, p []byte) (int, bool) {
+	partial := len(frame) % c.unit
+	need := c.unit - partial
+
+	if len(p) < need {
+		return len(p), false
+	}
+
+	c.digest.Reset()
+	_, _ = c.digest.Write(frame[len(frame)-partial:])
+	_, _ = c.digest.Write(p[:need])
+
+	size := len(frame) + need
+	if size >= c.max || (size >= c.min && c.digest.Sum64()%c.average == 0) {
+		return need, true
+	}
+
+	return need, false
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+)
+
+const benchVFSName = "zstd-bench"
+
+func benchCommand(args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+
+	queriesPath := flags.String("queries", "", "file of queries, each ending with a semicolon")
+	concurrency := flags.Int("concurrency", 1, "queries run at the same time")
+	iterations := flags.Int("iterations", 1, "times every query is run")
+	heatmapPath := flags.String("heatmap", "", "file the frames and pages read are written to, as CSV if it ends with .csv, else JSON")
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if len(positional) != 1 || *queriesPath == "" {
+		return fmt.Errorf("%w: bench needs an archive and --queries", errUsage)
+	}
+
+	if *concurrency < 1 || *iterations < 1 {
+		return fmt.Errorf("%w: --concurrency and --iterations must be positive", errUsage)
+	}
+
+	queries, err := readQueries(*queriesPath)
+	if err != nil {
+		return err
+	}
+
+	stats := &sqlitezstd.Stats{}
+	heatmap := &sqlitezstd.Heatmap{}
+
+	err = sqlitezstd.Register(benchVFSName, &sqlitezstd.ZstdVFS{Stats: stats, Heatmap: heatmap})
+	if err != nil {
+		return err
+	}
+
+	client, err := sql.Open("sqlite3", archiveDSN(positional[0], benchVFSName))
+	if err != nil {
+		return fmt.Errorf("could not open database: %w", err)
+	}
+	defer client.Close()
+
+	client.SetMaxOpenConns(*concurrency)
+	client.SetMaxIdleConns(*concurrency)
+
+	jobs := make(chan string)
+	latencies := make(chan time.Duration, *concurrency)
+	failures := make(chan error, *concurrency)
+
+	var workers sync.WaitGroup
+
+	for range *concurrency {
+		workers.Add(1)
+
+		go func() {
+			defer workers.Done()
+
+			for query := range jobs {
+				started := time.Now()
+
+				err := runQuery(client, query)
+				if err != nil {
+					failures <- fmt.Errorf("%q: %w", query, err)
+
+					continue
+				}
+
+				latencies <- time.Since(started)
+			}
+		}()
+	}
+
+	started := time.Now()
+
+	go func() {
+		for range *iterations {
+			for _, query := range queries {
+				jobs <- query
+			}
+		}
+
+		close(jobs)
+		workers.Wait()
+		close(latencies)
+		close(failures)
+	}()
+
+	var (
+		durations []time.Duration
+		errs      []error
+	)
+
+	for latencies != nil || failures != nil {
+		select {
+		case latency, ok := <-latencies:
+			if !ok {
+				latencies = nil
+
+				continue
+			}
+
+			durations = append(durations, latency)
+		case err, ok := <-failures:
+			if !ok {
+				failures = nil
+
+				continue
+			}
+
+			errs = append(errs, err)
+		}
+	}
+
+	printBench(time.Since(started), durations, errs, stats)
+
+	if *heatmapPath != "" {
+		err = writeHeatmap(*heatmapPath, heatmap)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d queries failed, first: %w", len(errs), errs[0])
+	}
+
+	return nil
+}
+
+// writeHeatmap writes heatmap to path, as CSV or JSON by its extension.
+func writeHeatmap(path string, heatmap *sqlitezstd.Heatmap) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create heatmap: %w", err)
+	}
+	defer file.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		err = heatmap.WriteCSV(file)
+	} else {
+		err = heatmap.WriteJSON(file)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("could not write heatmap: %w", err)
+	}
+
+	return nil
+}
+
+// readQueries splits a file into queries at lines ending with a semicolon,
+// skipping blank lines and comments.
+func readQueries(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open queries: %w", err)
+	}
+	defer file.Close()
+
+	var (
+		queries []string
+		current []string
+	)
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "--") {
+			continue
+		}
+
+		current = append(current, line)
+
+		if strings.HasSuffix(line, ";") {
+			queries = append(queries, strings.Join(current, " "))
+			current = nil
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("could not read queries: %w", err)
+	}
+
+	if len(current) > 0 {
+		queries = append(queries, strings.Join(current, " "))
+	}
+
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("%w: %s has no queries", errUsage, path)
+	}
+
+	return queries, nil
+}
+
+// runQuery runs query and reads every row, as the cost is mostly in the rows.
+func runQuery(client *sql.DB, query string) error {
+	rows, err := client.Query(query)
+	if err != nil {
+		return fmt.Errorf("could not query: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("could not query: %w", err)
+	}
+
+	values := make([]any, len(columns))
+	for index := range values {
+		values[index] = new(sql.RawBytes)
+	}
+
+	for rows.Next() {
+		err = rows.Scan(values...)
+		if err != nil {
+			return fmt.Errorf("could not read row: %w", err)
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return fmt.Errorf("could not query: %w", err)
+	}
+
+	return nil
+}
+
+func printBench(elapsed time.Duration, durations []time.Duration, errs []error, stats *sqlitezstd.Stats) {
+	sort.Slice(durations, func(i, j int) bool {
+		return durations[i] < durations[j]
+	})
+
+	percentile := func(p float64) time.Duration {
+		if len(durations) == 0 {
+			return 0
+		}
+
+		return durations[int(p*float64(len(durations)-1))]
+	}
+
+	fmt.Printf("queries:        %d (%d failed)\n", len(durations)+len(errs), len(errs))
+	fmt.Printf("elapsed:        %s (%.1f queries/s)\n", elapsed.Round(time.Millisecond), float64(len(durations))/elapsed.Seconds())
+	fmt.Printf("latency:        p50 %s, p90 %s, p99 %s, max %s\n", percentile(0.5), percentile(0.9), percentile(0.99), percentile(1))
+	fmt.Printf("reads:          %d\n", stats.Reads())
+	fmt.Printf("bytes read:     %d\n", stats.BytesRead())
+	fmt.Printf("bytes fetched:  %d\n", stats.BytesFetched())
+	fmt.Printf("cache hit rate: %.1f%%\n", stats.HitRate()*100)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+)
+
+func compressCommand(args []string) error {
+	flags := flag.NewFlagSet("compress", flag.ContinueOnError)
+
+	output := flags.String("o", "", "path of the compressed archive")
+	level := flags.Int("level", 3, "zstd compression level, from 1 to 22")
+	workers := flags.Int("workers", runtime.GOMAXPROCS(0), "frames compressed concurrently")
+	deterministic := flags.Bool("deterministic", false, "produce byte-identical output for identical input")
+	contentDefined := flags.Bool("content-defined", false, "cut frames by content instead of at a fixed size")
+	pageAlign := flags.Bool("page-align", true, "align frames to the database page size")
+
+	frameSize := sizeFlag(64 << 10)
+	flags.Var(&frameSize, "frame-size", "uncompressed size of each frame, such as 64KiB or 1MiB")
+
+	var partSize sizeFlag
+
+	flags.Var(&partSize, "part-size", "split the archive into parts of at most this size")
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if len(positional) != 1 || *output == "" {
+		return fmt.Errorf("%w: compress needs a database and -o", errUsage)
+	}
+
+	err = sqlitezstd.CompressFile(
+		positional[0], *output,
+		sqlitezstd.WithLevel(*level),
+		sqlitezstd.WithFrameSize(int(frameSize)),
+		sqlitezstd.WithWorkers(*workers),
+		sqlitezstd.WithPageAlignment(*pageAlign),
+		sqlitezstd.WithDeterministic(*deterministic),
+		sqlitezstd.WithContentDefinedChunking(*contentDefined),
+		sqlitezstd.WithPartSize(int64(partSize)),
+	)
+	if err != nil {
+		return fmt.Errorf("could not compress: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openDatabase opens a local or remote archive through the VFS.
+func openDatabase(path string) (*sql.DB, error) {
+	err := sqlitezstd.Init()
+	if err != nil {
+		return nil, fmt.Errorf("could not register vfs: %w", err)
+	}
+
+	client, err := sql.Open("sqlite3", archiveDSN(path, "zstd"))
+	if err != nil {
+		return nil, fmt.Errorf("could not open database: %w", err)
+	}
+
+	return client, nil
+}
+
+// archiveDSN returns the DSN opening the archive at path with the VFS
+// registered as vfs. The path is given escaped in the url parameter, so
+// SQLite does not parse the query of a URL, such as a signed one, as its
+// own.
+func archiveDSN(path string, vfs string) string {
+	return fmt.Sprintf("file:archive?vfs=%s&url=%s", vfs, url.QueryEscape(path))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+)
+
+// parseFlags parses flags that may come before or after the positional
+// arguments, as in `compress in.sqlite -o out.zst`, returning the latter.
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	flags.SetOutput(io.Discard)
+
+	var positional []string
+
+	for {
+		err := flags.Parse(args)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errUsage, err)
+		}
+
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// sizeFlag is a byte size such as 65536, 64KiB, or 1MB.
+type sizeFlag int64
+
+var _ flag.Value = new(sizeFlag)
+
+func (s *sizeFlag) String() string {
+	return strconv.FormatInt(int64(*s), 10)
+}
+
+func (s *sizeFlag) Set(value string) error {
+	size, err := sqlitezstd.ParseSize(value)
+	if err != nil {
+		return fmt.Errorf("invalid size %q", value)
+	}
+
+	*s = sizeFlag(size)
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+)
+
+func inspectCommand(args []string) error {
+	flags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+
+	frames := flags.Bool("frames", false, "list every frame")
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if len(positional) != 1 {
+		return fmt.Errorf("%w: inspect needs an archive", errUsage)
+	}
+
+	info, err := sqlitezstd.Inspect(positional[0])
+	if err != nil {
+		return fmt.Errorf("could not inspect: %w", err)
+	}
+
+	printInfo(os.Stdout, info, *frames)
+
+	return nil
+}
+
+func printInfo(output io.Writer, info *sqlitezstd.ArchiveInfo, frames bool) {
+	var smallest, largest int64
+
+	for index, frame := range info.Frames {
+		if index == 0 || frame.DecompressedSize < smallest {
+			smallest = frame.DecompressedSize
+		}
+
+		largest = max(largest, frame.DecompressedSize)
+	}
+
+	var average int64
+	if len(info.Frames) > 0 {
+		average = info.DecompressedSize / int64(len(info.Frames))
+	}
+
+	fmt.Fprintf(output, "frames:            %d (+%d skippable)\n", len(info.Frames), info.SkippableFrames)
+	fmt.Fprintf(output, "frame size:        min %d, avg %d, max %d\n", smallest, average, largest)
+	fmt.Fprintf(output, "seek table size:   %d\n", info.SeekTableSize)
+	fmt.Fprintf(output, "checksums:         %s\n", yesNo(info.Checksums))
+	fmt.Fprintf(output, "compressed size:   %d\n", info.CompressedSize)
+	fmt.Fprintf(output, "decompressed size: %d\n", info.DecompressedSize)
+	fmt.Fprintf(output, "compression ratio: %.2f\n", info.Ratio())
+	fmt.Fprintf(output, "page size:         %d\n", info.PageSize)
+	fmt.Fprintf(output, "page aligned:      %s\n", yesNo(info.PageAligned))
+
+	if info.Header != nil {
+		fmt.Fprintf(output, "content defined:   %s\n", yesNo(info.Header.ContentDefined))
+		fmt.Fprintf(output, "deterministic:     %s\n", yesNo(info.Header.Deterministic))
+	}
+
+	if frames {
+		fmt.Fprintln(output)
+		fmt.Fprintln(output, "index\toffset\tcompressed\tdecompressed")
+
+		for index, frame := range info.Frames {
+			fmt.Fprintf(output, "%d\t%d\t%d\t%d\n", index, frame.DecompressedOffset, frame.CompressedSize, frame.DecompressedSize)
+		}
+	}
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+
+	return "no"
+}
@@ -0,0 +1,96 @@
+// Command sqlitezstd produces, inspects, verifies, serves, queries, and benchmarks archives for the sqlitezstd VFS.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	// archives may be read from b2://, ftp://, ipfs://, nats://, rclone://, s3://, sftp://, and torrent:// URLs
+	_ "github.com/jtarchie/sqlitezstd/b2"
+	_ "github.com/jtarchie/sqlitezstd/ftp"
+	_ "github.com/jtarchie/sqlitezstd/ipfs"
+	_ "github.com/jtarchie/sqlitezstd/nats"
+	_ "github.com/jtarchie/sqlitezstd/rclone"
+	_ "github.com/jtarchie/sqlitezstd/s3"
+	_ "github.com/jtarchie/sqlitezstd/sftp"
+	_ "github.com/jtarchie/sqlitezstd/torrent"
+)
+
+var errUsage = errors.New("usage")
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+//nolint: gochecknoglobals
+var commands = map[string]command{
+	"bench": {
+		usage: "bench <out.zst> --queries <q.sql> [--concurrency n] [--iterations n] [--heatmap out.json|out.csv]",
+		run:   benchCommand,
+	},
+	"compress": {
+		usage: "compress <db> -o <out.zst> [--level n] [--frame-size size]",
+		run:   compressCommand,
+	},
+	"inspect": {
+		usage: "inspect <out.zst> [--frames]",
+		run:   inspectCommand,
+	},
+	"query": {
+		usage: "query <out.zst> <sql> [--format json|csv|table]",
+		run:   queryCommand,
+	},
+	"serve": {
+		usage: "serve <dir> [--addr :8080] [--max-age 1h]",
+		run:   serveCommand,
+	},
+	"verify": {
+		usage: "verify <out.zst> [--integrity-check]",
+		run:   verifyCommand,
+	},
+}
+
+func main() {
+	err := run(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sqlitezstd: %v\n", err)
+
+		if errors.Is(err, errUsage) {
+			printUsage()
+			os.Exit(2)
+		}
+
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: missing command", errUsage)
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("%w: unknown command %q", errUsage, args[0])
+	}
+
+	return cmd.run(args[1:])
+}
+
+func printUsage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "usage:")
+
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  sqlitezstd %s\n", commands[name].usage)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+func queryCommand(args []string) error {
+	flags := flag.NewFlagSet("query", flag.ContinueOnError)
+
+	format := flags.String("format", "table", "output format, one of json, csv, or table")
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if len(positional) != 2 {
+		return fmt.Errorf("%w: query needs an archive and a query", errUsage)
+	}
+
+	write, ok := formats[*format]
+	if !ok {
+		return fmt.Errorf("%w: unknown format %q", errUsage, *format)
+	}
+
+	client, err := openDatabase(positional[0])
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	rows, err := client.Query(positional[1])
+	if err != nil {
+		return fmt.Errorf("could not query: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("could not query: %w", err)
+	}
+
+	var results [][]any
+
+	for rows.Next() {
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+
+		for index := range values {
+			pointers[index] = &values[index]
+		}
+
+		err = rows.Scan(pointers...)
+		if err != nil {
+			return fmt.Errorf("could not read row: %w", err)
+		}
+
+		for index, value := range values {
+			// TEXT is scanned as bytes, which would be encoded as base64
+			if contents, ok := value.([]byte); ok {
+				values[index] = string(contents)
+			}
+		}
+
+		results = append(results, values)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return fmt.Errorf("could not query: %w", err)
+	}
+
+	return write(os.Stdout, columns, results)
+}
+
+//nolint: gochecknoglobals
+var formats = map[string]func(output io.Writer, columns []string, rows [][]any) error{
+	"json":  writeJSON,
+	"csv":   writeCSV,
+	"table": writeTable,
+}
+
+func writeJSON(output io.Writer, columns []string, rows [][]any) error {
+	objects := make([]map[string]any, 0, len(rows))
+
+	for _, row := range rows {
+		object := make(map[string]any, len(columns))
+		for index, column := range columns {
+			object[column] = row[index]
+		}
+
+		objects = append(objects, object)
+	}
+
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+
+	err := encoder.Encode(objects)
+	if err != nil {
+		return fmt.Errorf("could not write json: %w", err)
+	}
+
+	return nil
+}
+
+func writeCSV(output io.Writer, columns []string, rows [][]any) error {
+	writer := csv.NewWriter(output)
+
+	err := writer.Write(columns)
+	if err != nil {
+		return fmt.Errorf("could not write csv: %w", err)
+	}
+
+	for _, row := range rows {
+		err = writer.Write(formatRow(row, ""))
+		if err != nil {
+			return fmt.Errorf("could not write csv: %w", err)
+		}
+	}
+
+	writer.Flush()
+
+	err = writer.Error()
+	if err != nil {
+		return fmt.Errorf("could not write csv: %w", err)
+	}
+
+	return nil
+}
+
+func writeTable(output io.Writer, columns []string, rows [][]any) error {
+	writer := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(writer, strings.Join(columns, "\t"))
+
+	for _, row := range rows {
+		fmt.Fprintln(writer, strings.Join(formatRow(row, "NULL"), "\t"))
+	}
+
+	err := writer.Flush()
+	if err != nil {
+		return fmt.Errorf("could not write table: %w", err)
+	}
+
+	return nil
+}
+
+func formatRow(row []any, null string) []string {
+	fields := make([]string, len(row))
+
+	for index, value := range row {
+		if value == nil {
+			fields[index] = null
+
+			continue
+		}
+
+		fields[index] = fmt.Sprint(value)
+	}
+
+	return fields
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+)
+
+func serveCommand(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+
+	addr := flags.String("addr", ":8080", "address to listen on")
+	maxAge := flags.Duration("max-age", time.Hour, "how long responses may be cached")
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if len(positional) != 1 {
+		return fmt.Errorf("%w: serve needs a directory", errUsage)
+	}
+
+	info, err := os.Stat(positional[0])
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("%w: %s is not a directory", errUsage, positional[0])
+	}
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           sqlitezstd.FileServer(positional[0], *maxAge),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	fmt.Printf("serving %s on %s\n", positional[0], *addr)
+
+	err = server.ListenAndServe()
+	if err != nil {
+		return fmt.Errorf("could not serve: %w", err)
+	}
+
+	return nil
+}
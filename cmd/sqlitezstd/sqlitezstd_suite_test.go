@@ -0,0 +1,355 @@
+package main_test
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	"github.com/jtarchie/sqlitezstd/testhelper"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+)
+
+func TestSqliteZstdCLI(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "sqlitezstd CLI Suite")
+}
+
+//nolint: gochecknoglobals
+var binaryPath string
+
+var _ = BeforeSuite(func() {
+	var err error
+
+	binaryPath, err = gexec.Build("github.com/jtarchie/sqlitezstd/cmd/sqlitezstd")
+	Expect(err).ToNot(HaveOccurred())
+
+	Expect(sqlitezstd.Init()).To(Succeed())
+})
+
+var _ = AfterSuite(func() {
+	gexec.CleanupBuildArtifacts()
+})
+
+func createSQLite() string {
+	return testhelper.CreateSQLite(GinkgoT(), testhelper.EntriesSchema, testhelper.Entries(1000))
+}
+
+func cli(args ...string) *gexec.Session {
+	session, err := gexec.Start(exec.Command(binaryPath, args...), GinkgoWriter, GinkgoWriter)
+	Expect(err).ToNot(HaveOccurred())
+	Eventually(session).Should(gexec.Exit())
+
+	return session
+}
+
+func countEntries(zstPath string) int64 {
+	client, err := sql.Open("sqlite3", fmt.Sprintf("%s?vfs=zstd", zstPath))
+	Expect(err).ToNot(HaveOccurred())
+	defer client.Close()
+
+	var count int64
+	err = client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)
+	Expect(err).ToNot(HaveOccurred())
+
+	return count
+}
+
+var _ = Describe("compress", func() {
+	It("compresses a database for the VFS", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		session := cli("compress", dbPath, "-o", zstPath, "--level", "19", "--frame-size", "8KiB")
+		Expect(session.ExitCode()).To(Equal(0))
+
+		header, err := sqlitezstd.ReadHeader(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(header.FrameSize).To(Equal(8 * 1024))
+
+		metadata, err := sqlitezstd.ReadMetadata(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(metadata.Level).To(Equal(19))
+
+		Expect(countEntries(zstPath)).To(BeEquivalentTo(1000))
+	})
+
+	It("fails without an output", func() {
+		session := cli("compress", createSQLite())
+		Expect(session.ExitCode()).To(Equal(2))
+		Expect(session.Err).To(gbytes.Say("usage"))
+	})
+
+	It("fails for sizes too large for an int64", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		session := cli("compress", dbPath, "-o", zstPath, "--frame-size", "18014398509481988KiB")
+		Expect(session.ExitCode()).To(Equal(2))
+		Expect(session.Err).To(gbytes.Say("invalid size"))
+		Expect(zstPath).ToNot(BeAnExistingFile())
+	})
+
+	It("fails for unknown commands", func() {
+		session := cli("unknown")
+		Expect(session.ExitCode()).To(Equal(2))
+	})
+})
+
+var _ = Describe("inspect", func() {
+	It("describes the layout of an archive", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		Expect(sqlitezstd.CompressFile(dbPath, zstPath, sqlitezstd.WithFrameSize(4096))).To(Succeed())
+
+		session := cli("inspect", zstPath, "--frames")
+		Expect(session.ExitCode()).To(Equal(0))
+		Expect(session.Out).To(gbytes.Say(`frames:\s+\d+ \(\+2 skippable\)`))
+		Expect(session.Out).To(gbytes.Say(`frame size:\s+min 4096, avg 4096, max 4096`))
+		Expect(session.Out).To(gbytes.Say(`page size:\s+4096`))
+		Expect(session.Out).To(gbytes.Say(`page aligned:\s+yes`))
+		Expect(session.Out).To(gbytes.Say(`0\t0\t\d+\t4096`))
+	})
+
+	It("detects unaligned frames", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		Expect(sqlitezstd.CompressFile(dbPath, zstPath, sqlitezstd.WithFrameSize(1000), sqlitezstd.WithPageAlignment(false))).To(Succeed())
+
+		session := cli("inspect", zstPath)
+		Expect(session.ExitCode()).To(Equal(0))
+		Expect(session.Out).To(gbytes.Say(`page aligned:\s+no`))
+	})
+})
+
+var _ = Describe("verify", func() {
+	It("accepts an intact archive", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		Expect(sqlitezstd.CompressFile(dbPath, zstPath)).To(Succeed())
+
+		session := cli("verify", zstPath, "--integrity-check")
+		Expect(session.ExitCode()).To(Equal(0))
+		Expect(session.Out).To(gbytes.Say("ok"))
+	})
+
+	It("fails for a corrupt frame", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		Expect(sqlitezstd.CompressFile(dbPath, zstPath, sqlitezstd.WithFrameSize(4096))).To(Succeed())
+
+		info, err := sqlitezstd.Inspect(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		contents, err := os.ReadFile(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		frame := info.Frames[1]
+		contents[frame.CompressedOffset+frame.CompressedSize/2] ^= 0xFF
+		Expect(os.WriteFile(zstPath, contents, 0o600)).To(Succeed())
+
+		session := cli("verify", zstPath)
+		Expect(session.ExitCode()).To(Equal(1))
+		Expect(session.Err).To(gbytes.Say(`frame 2 at offset %d: corrupt frame`, frame.CompressedOffset))
+	})
+
+	It("fails the integrity check for a corrupt database", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		contents, err := os.ReadFile(dbPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		// point the cells of the table root page past its end
+		for offset := 4096 + 12; offset < 4096+200; offset++ {
+			contents[offset] = 0x7F
+		}
+
+		Expect(os.WriteFile(dbPath, contents, 0o600)).To(Succeed())
+		Expect(sqlitezstd.CompressFile(dbPath, zstPath)).To(Succeed())
+
+		session := cli("verify", zstPath)
+		Expect(session.ExitCode()).To(Equal(0))
+
+		session = cli("verify", zstPath, "--integrity-check")
+		Expect(session.ExitCode()).To(Equal(1))
+	})
+})
+
+var _ = Describe("serve", func() {
+	It("serves archives to the VFS", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		Expect(sqlitezstd.CompressFile(dbPath, zstPath)).To(Succeed())
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		addr := listener.Addr().String()
+		Expect(listener.Close()).To(Succeed())
+
+		session, err := gexec.Start(exec.Command(binaryPath, "serve", filepath.Dir(zstPath), "--addr", addr), GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+		defer session.Kill()
+
+		Eventually(session.Out).Should(gbytes.Say("serving"))
+
+		url := fmt.Sprintf("http://%s/%s", addr, filepath.Base(zstPath))
+		Eventually(func() error {
+			response, err := http.Head(url)
+			if err != nil {
+				return err
+			}
+
+			return response.Body.Close()
+		}).Should(Succeed())
+
+		Expect(countEntries(url)).To(BeEquivalentTo(1000))
+
+		response, err := http.Get(fmt.Sprintf("http://%s/%s", addr, filepath.Base(dbPath)))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.Body.Close()).To(Succeed())
+		Expect(response.StatusCode).To(Equal(http.StatusNotFound))
+	})
+})
+
+var _ = Describe("query", func() {
+	var zstPath string
+
+	BeforeEach(func() {
+		dbPath := testhelper.CreateSQLite(GinkgoT(), "CREATE TABLE people (id INTEGER PRIMARY KEY, name TEXT, nickname TEXT);", []string{
+			"INSERT INTO people (name, nickname) VALUES ('Ada', NULL)",
+			"INSERT INTO people (name, nickname) VALUES ('Grace, Hopper', 'Amazing')",
+		})
+		zstPath = dbPath + ".zst"
+
+		Expect(sqlitezstd.CompressFile(dbPath, zstPath)).To(Succeed())
+	})
+
+	It("prints a table by default", func() {
+		session := cli("query", zstPath, "SELECT name, nickname FROM people ORDER BY id")
+		Expect(session.ExitCode()).To(Equal(0))
+		Expect(session.Out).To(gbytes.Say(`name\s+nickname\n`))
+		Expect(session.Out).To(gbytes.Say(`Ada\s+NULL\n`))
+		Expect(session.Out).To(gbytes.Say(`Grace, Hopper\s+Amazing\n`))
+	})
+
+	It("prints json", func() {
+		session := cli("query", zstPath, "SELECT id, name, nickname FROM people ORDER BY id", "--format", "json")
+		Expect(session.ExitCode()).To(Equal(0))
+		Expect(session.Out.Contents()).To(MatchJSON(`[
+			{"id": 1, "name": "Ada", "nickname": null},
+			{"id": 2, "name": "Grace, Hopper", "nickname": "Amazing"}
+		]`))
+	})
+
+	It("prints csv", func() {
+		session := cli("query", "--format", "csv", zstPath, "SELECT name, nickname FROM people ORDER BY id")
+		Expect(session.ExitCode()).To(Equal(0))
+		Expect(string(session.Out.Contents())).To(Equal("name,nickname\nAda,\n\"Grace, Hopper\",Amazing\n"))
+	})
+
+	It("reads archives at URLs with a query of their own", func() {
+		files := http.FileServer(http.Dir(filepath.Dir(zstPath)))
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("token") != "abc" {
+				w.WriteHeader(http.StatusForbidden)
+
+				return
+			}
+
+			files.ServeHTTP(w, r)
+		}))
+		DeferCleanup(origin.Close)
+
+		signedURL := fmt.Sprintf("%s/%s?token=abc", origin.URL, filepath.Base(zstPath))
+
+		session := cli("query", "--format", "csv", signedURL, "SELECT name FROM people ORDER BY id")
+		Expect(session.ExitCode()).To(Equal(0))
+		Expect(string(session.Out.Contents())).To(Equal("name\nAda\n\"Grace, Hopper\"\n"))
+
+		session = cli("verify", signedURL, "--integrity-check")
+		Expect(session.ExitCode()).To(Equal(0))
+
+		queriesPath := filepath.Join(GinkgoT().TempDir(), "q.sql")
+		Expect(os.WriteFile(queriesPath, []byte("SELECT COUNT(*) FROM people;\n"), 0o600)).To(Succeed())
+
+		session = cli("bench", signedURL, "--queries", queriesPath)
+		Expect(session.ExitCode()).To(Equal(0))
+		Expect(session.Out).To(gbytes.Say(`queries:\s+1 \(0 failed\)`))
+	})
+
+	It("errors for invalid queries and formats", func() {
+		session := cli("query", zstPath, "SELECT * FROM missing")
+		Expect(session.ExitCode()).To(Equal(1))
+		Expect(session.Err).To(gbytes.Say("no such table"))
+
+		session = cli("query", zstPath, "SELECT 1", "--format", "xml")
+		Expect(session.ExitCode()).To(Equal(2))
+	})
+})
+
+var _ = Describe("bench", func() {
+	It("replays queries and reports what they fetched", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		Expect(sqlitezstd.CompressFile(dbPath, zstPath, sqlitezstd.WithFrameSize(4096))).To(Succeed())
+
+		queriesPath := filepath.Join(GinkgoT().TempDir(), "q.sql")
+		Expect(os.WriteFile(queriesPath, []byte(`-- counts
+SELECT COUNT(*) FROM entries;
+
+SELECT id
+FROM entries
+WHERE id > 500;
+`), 0o600)).To(Succeed())
+
+		session := cli("bench", zstPath, "--queries", queriesPath, "--concurrency", "4", "--iterations", "5")
+		Expect(session.ExitCode()).To(Equal(0))
+		Expect(session.Out).To(gbytes.Say(`queries:\s+10 \(0 failed\)`))
+		Expect(session.Out).To(gbytes.Say(`latency:\s+p50 \S+, p90 \S+, p99 \S+, max \S+`))
+		Expect(session.Out).To(gbytes.Say(`reads:\s+[1-9]\d*`))
+		Expect(session.Out).To(gbytes.Say(`bytes fetched:\s+[1-9]\d*`))
+		Expect(session.Out).To(gbytes.Say(`cache hit rate:\s+\d+\.\d%`))
+
+		heatmapPath := filepath.Join(GinkgoT().TempDir(), "heatmap.csv")
+
+		session = cli("bench", zstPath, "--queries", queriesPath, "--heatmap", heatmapPath)
+		Expect(session.ExitCode()).To(Equal(0))
+
+		contents, err := os.ReadFile(heatmapPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(contents)).To(HavePrefix("archive,kind,index,offset,size,reads\n"))
+		Expect(string(contents)).To(ContainSubstring(zstPath + ",frame,"))
+		Expect(string(contents)).To(ContainSubstring(zstPath + ",page,1,0,"))
+	})
+
+	It("fails when queries fail", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		Expect(sqlitezstd.CompressFile(dbPath, zstPath)).To(Succeed())
+
+		queriesPath := filepath.Join(GinkgoT().TempDir(), "q.sql")
+		Expect(os.WriteFile(queriesPath, []byte("SELECT * FROM missing;\n"), 0o600)).To(Succeed())
+
+		session := cli("bench", zstPath, "--queries", queriesPath)
+		Expect(session.ExitCode()).To(Equal(1))
+		Expect(session.Out).To(gbytes.Say(`queries:\s+1 \(1 failed\)`))
+		Expect(session.Err).To(gbytes.Say("no such table"))
+	})
+})
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+)
+
+func verifyCommand(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+
+	integrity := flags.Bool("integrity-check", false, "also run PRAGMA integrity_check through the VFS")
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if len(positional) != 1 {
+		return fmt.Errorf("%w: verify needs an archive", errUsage)
+	}
+
+	err = sqlitezstd.Verify(positional[0])
+	if err != nil {
+		return fmt.Errorf("archive is corrupt: %w", err)
+	}
+
+	if *integrity {
+		err = integrityCheck(positional[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("ok")
+
+	return nil
+}
+
+func integrityCheck(path string) error {
+	client, err := openDatabase(path)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	rows, err := client.Query("PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("could not check integrity: %w", err)
+	}
+	defer rows.Close()
+
+	var problems []string
+
+	for rows.Next() {
+		var problem string
+
+		err = rows.Scan(&problem)
+		if err != nil {
+			return fmt.Errorf("could not check integrity: %w", err)
+		}
+
+		if problem != "ok" {
+			problems = append(problems, problem)
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return fmt.Errorf("could not check integrity: %w", err)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("database is corrupt:\n%s", strings.Join(problems, "\n"))
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package sqlitezstd
+
+import (
+	"bytes"
+	"sync/atomic"
+	"time"
+)
+
+// region holds adjacent frames decompressed together, from start, by up
+// to workers at once. Every frame is ready once its channel is closed,
+// and failed if it could not be decompressed, so reads only wait for
+// the frames they read.
+type region struct {
+	start  int64
+	data   []byte
+	first  int
+	ready  []chan struct{}
+	failed []bool
+}
+
+// fromRegion copies p from the region of frames decompressed together,
+// if it holds all of it, as the next read of a run.
+func (c *cachedFrames) fromRegion(p []byte, off int64) bool {
+	c.mutex.Lock()
+	current := c.region
+	c.mutex.Unlock()
+
+	if current == nil || off < current.start || off+int64(len(p)) > current.start+int64(len(current.data)) {
+		return false
+	}
+
+	for index := c.table.frameFor(off); index <= c.table.frameFor(off+int64(len(p))-1); index++ {
+		<-current.ready[index-current.first]
+
+		if current.failed[index-current.first] {
+			return false
+		}
+	}
+
+	copy(p, current.data[off-current.start:])
+
+	c.mutex.Lock()
+	c.next = off + int64(len(p))
+	c.run++
+	c.mutex.Unlock()
+
+	return true
+}
+
+// sequential records that read bytes were read at off and, once reads
+// run sequentially, decompresses the frames after them into a region.
+func (c *cachedFrames) sequential(off int64, read int64) {
+	c.mutex.Lock()
+
+	if off == c.next {
+		c.run++
+	} else {
+		c.run = 0
+	}
+
+	c.next = off + read
+	next, run, last := c.next, c.run, c.last
+
+	c.mutex.Unlock()
+
+	// the rest of the frame read last is read from it
+	if run >= sequentialReads && next < c.table.decompressedSize && c.table.frameFor(next) != last {
+		c.coalesceFrom(next)
+	}
+}
+
+// coalesceFrom decompresses the frames from position on, up to the
+// coalesce size, into a region: their compressed bytes are read at once,
+// then decompressed next to each other by the workers in the background.
+// Frames already stored are read from the stores instead, and errors are
+// left to the reads of the frames.
+func (c *cachedFrames) coalesceFrom(position int64) {
+	first := c.table.frameFor(position)
+
+	c.mutex.Lock()
+	name := c.name(first)
+	c.mutex.Unlock()
+
+	if _, ok := c.stored(name, c.table.frames[first]); ok {
+		return
+	}
+
+	last, size := first, int64(0)
+	for last < len(c.table.frames) && (last == first || size+int64(c.table.frames[last].decompressedSize) <= c.coalesce) {
+		size += int64(c.table.frames[last].decompressedSize)
+		last++
+	}
+
+	frames := c.table.frames[first:last]
+	start := frames[0].compressedOffset
+	compressed := buffers.get(int(frames[len(frames)-1].compressedOffset + int64(frames[len(frames)-1].compressedSize) - start))
+
+	_, err := c.reader.ReadAt(*compressed, start)
+	if err != nil {
+		buffers.put(compressed)
+
+		return
+	}
+
+	next := &region{
+		start:  frames[0].decompressedOffset,
+		data:   make([]byte, size),
+		first:  first,
+		ready:  make([]chan struct{}, len(frames)),
+		failed: make([]bool, len(frames)),
+	}
+
+	names := make([]string, len(frames))
+
+	c.mutex.Lock()
+	for position, frame := range frames {
+		next.ready[position] = make(chan struct{})
+		names[position] = c.name(frame.index)
+	}
+
+	c.region = next
+	c.mutex.Unlock()
+
+	// workers take the frames in order, so the first are ready first
+	queue := make(chan int, len(frames))
+	for position := range frames {
+		queue <- position
+	}
+	close(queue)
+
+	// the last worker to finish returns the compressed frames to the pool
+	workers := min(max(c.workers, 1), len(frames))
+	remaining := atomic.Int32{}
+	remaining.Store(int32(workers))
+
+	for range workers {
+		c.pending.Add(1)
+
+		go func() {
+			defer c.pending.Done()
+
+			c.decompressRegion(next, frames, names, *compressed, start, queue)
+
+			if remaining.Add(-1) == 0 {
+				buffers.put(compressed)
+			}
+		}()
+	}
+}
+
+// decompressRegion decompresses the frames of the queue into the region,
+// storing them as they would be when read one at a time.
+func (c *cachedFrames) decompressRegion(next *region, frames []frameInfo, names []string, compressed []byte, start int64, queue <-chan int) {
+	decoder, failed := decoders.get(c.decoding)
+	if failed == nil {
+		defer decoders.put(c.decoding, decoder)
+	}
+
+	for position := range queue {
+		frame := frames[position]
+		offset := frame.compressedOffset - start
+		from := frame.decompressedOffset - next.start
+		to := from + int64(frame.decompressedSize)
+
+		started := time.Now()
+
+		err := failed
+		if err == nil {
+			_, err = verifyData(decoder, compressed[offset:offset+int64(frame.compressedSize)], next.data[from:from:to], frame, c.table.checksums)
+		}
+
+		if err != nil {
+			next.failed[position] = true
+			close(next.ready[position])
+
+			continue
+		}
+
+		close(next.ready[position])
+		c.metrics.decompressed(int(frame.decompressedSize))
+		c.counters.decoded(int(frame.decompressedSize), started)
+
+		// a frame kept by the caches must not keep the whole region alive,
+		// which they do not count
+		data := bytes.Clone(next.data[from:to])
+
+		for _, store := range c.stores {
+			store.put(names[position], data)
+		}
+	}
+}
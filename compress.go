@@ -0,0 +1,168 @@
+package sqlitezstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	sqliteHeaderSize  = 100
+	sqliteHeaderMagic = "SQLite format 3\x00"
+
+	// archiveMode replaces the private mode of temporary files,
+	// as archives are usually served to others.
+	archiveMode = 0o644
+)
+
+var ErrInvalidDatabase = errors.New("not a valid sqlite database")
+
+// CompressFile compresses the SQLite database at src into the seekable zstd
+// format at dst, which can then be opened with the zstd VFS.
+func CompressFile(src, dst string, opts ...Option) error {
+	input, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("could not open source: %w", err)
+	}
+	defer input.Close()
+
+	return compressDatabase(input, dst, opts)
+}
+
+// compressDatabase compresses the database in input to dst.
+func compressDatabase(input *os.File, dst string, opts []Option) error {
+	err := validateDatabase(input)
+	if err != nil {
+		return err
+	}
+
+	return createArchive(dst, opts, func(output io.Writer) error {
+		return compress(input, output, opts)
+	})
+}
+
+func compress(input io.Reader, output io.Writer, opts []Option) error {
+	writer := NewWriter(output, opts...)
+
+	_, err := io.Copy(writer, input)
+	if err != nil {
+		return fmt.Errorf("could not compress source: %w", err)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return fmt.Errorf("could not finish compression: %w", err)
+	}
+
+	return nil
+}
+
+// createArchive writes an archive to dst with write,
+// split into parts if WithPartSize is set.
+func createArchive(dst string, opts []Option, write func(output io.Writer) error) error {
+	config, err := newOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	if config.partSize == 0 {
+		return writeFile(dst, write)
+	}
+
+	parts := &partWriter{dst: dst, size: config.partSize}
+	defer parts.remove()
+
+	err = write(parts)
+	if err != nil {
+		return err
+	}
+
+	return parts.finish()
+}
+
+// writeFile writes to a temporary file next to dst,
+// then atomically moves it to dst.
+func writeFile(dst string, write func(output io.Writer) error) error {
+	output, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create destination: %w", err)
+	}
+	defer os.Remove(output.Name())
+	defer output.Close()
+
+	err = write(output)
+	if err != nil {
+		return err
+	}
+
+	err = output.Chmod(archiveMode)
+	if err != nil {
+		return fmt.Errorf("could not change destination mode: %w", err)
+	}
+
+	err = output.Close()
+	if err != nil {
+		return fmt.Errorf("could not close destination: %w", err)
+	}
+
+	err = os.Rename(output.Name(), dst)
+	if err != nil {
+		return fmt.Errorf("could not move destination: %w", err)
+	}
+
+	return nil
+}
+
+// validateDatabase checks the header of a SQLite database
+// and that the file is made up of whole pages.
+func validateDatabase(file *os.File) error {
+	header := make([]byte, sqliteHeaderSize)
+
+	_, err := io.ReadFull(file, header)
+	if err != nil {
+		return fmt.Errorf("%w: could not read header: %w", ErrInvalidDatabase, err)
+	}
+
+	if string(header[:len(sqliteHeaderMagic)]) != sqliteHeaderMagic {
+		return fmt.Errorf("%w: header magic mismatch", ErrInvalidDatabase)
+	}
+
+	pageSize, err := parsePageSize(header)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat source: %w", err)
+	}
+
+	if info.Size()%int64(pageSize) != 0 {
+		return fmt.Errorf("%w: size %d is not a multiple of page size %d", ErrInvalidDatabase, info.Size(), pageSize)
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("could not rewind source: %w", err)
+	}
+
+	return nil
+}
+
+// parsePageSize reads the page size from a SQLite database header.
+func parsePageSize(header []byte) (int, error) {
+	//nolint: mnd
+	pageSize := int(binary.BigEndian.Uint16(header[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return 0, fmt.Errorf("%w: invalid page size %d", ErrInvalidDatabase, pageSize)
+	}
+
+	return pageSize, nil
+}
@@ -0,0 +1,760 @@
+package sqlitezstd_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CompressFile", func() {
+	BeforeEach(func() {
+		err := sqlitezstd.Init()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("produces a file readable by the VFS", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		err := sqlitezstd.CompressFile(dbPath, zstPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		client, err := sql.Open("sqlite3", fmt.Sprintf("%s?vfs=zstd", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		var count int64
+		err = client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+	})
+
+	When("the source is not a sqlite database", func() {
+		It("returns an error", func() {
+			buildPath, err := os.MkdirTemp("", "")
+			Expect(err).ToNot(HaveOccurred())
+
+			dbPath := filepath.Join(buildPath, "test.sqlite")
+			err = os.WriteFile(dbPath, []byte("not a database at all, just some text that is long enough to pass the header read......................."), 0o600)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = sqlitezstd.CompressFile(dbPath, dbPath+".zst")
+			Expect(err).To(MatchError(sqlitezstd.ErrInvalidDatabase))
+
+			_, err = os.Stat(dbPath + ".zst")
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+})
+
+var _ = Describe("NewWriter", func() {
+	BeforeEach(func() {
+		err := sqlitezstd.Init()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("streams a database into the seekable format", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		contents, err := os.ReadFile(dbPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		output, err := os.Create(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		writer := sqlitezstd.NewWriter(output)
+
+		for len(contents) > 0 {
+			size := min(len(contents), 1000)
+
+			_, err = writer.Write(contents[:size])
+			Expect(err).ToNot(HaveOccurred())
+
+			contents = contents[size:]
+		}
+
+		Expect(writer.Close()).To(Succeed())
+		Expect(output.Close()).To(Succeed())
+
+		client, err := sql.Open("sqlite3", fmt.Sprintf("%s?vfs=zstd", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		var count int64
+		err = client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+	})
+
+	It("rejects writes after close", func() {
+		writer := sqlitezstd.NewWriter(io.Discard)
+		Expect(writer.Close()).To(Succeed())
+
+		_, err := writer.Write([]byte("data"))
+		Expect(err).To(MatchError(sqlitezstd.ErrWriterClosed))
+	})
+})
+
+var _ = Describe("Options", func() {
+	BeforeEach(func() {
+		err := sqlitezstd.Init()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("compresses with a custom level, frame size, and workers", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		err := sqlitezstd.CompressFile(
+			dbPath, zstPath,
+			sqlitezstd.WithLevel(19),
+			sqlitezstd.WithFrameSize(4096),
+			sqlitezstd.WithWorkers(4),
+		)
+		Expect(err).ToNot(HaveOccurred())
+
+		client, err := sql.Open("sqlite3", fmt.Sprintf("%s?vfs=zstd", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		var count int64
+		err = client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+	})
+
+	It("produces identical output when deterministic", func() {
+		dbPath := createSQLite()
+
+		var archives [][]byte
+
+		for _, workers := range []int{1, 4} {
+			zstPath := fmt.Sprintf("%s.%d.zst", dbPath, workers)
+
+			err := sqlitezstd.CompressFile(
+				dbPath, zstPath,
+				sqlitezstd.WithFrameSize(4096),
+				sqlitezstd.WithWorkers(workers),
+				sqlitezstd.WithDeterministic(true),
+			)
+			Expect(err).ToNot(HaveOccurred())
+
+			contents, err := os.ReadFile(zstPath)
+			Expect(err).ToNot(HaveOccurred())
+
+			archives = append(archives, contents)
+
+			header, err := sqlitezstd.ReadHeader(zstPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(header.Deterministic).To(BeTrue())
+		}
+
+		Expect(archives[0]).To(Equal(archives[1]))
+	})
+
+	It("reports progress as frames are written", func() {
+		dbPath := createSQLite()
+
+		info, err := os.Stat(dbPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		var reports []sqlitezstd.Progress
+
+		err = sqlitezstd.CompressFile(
+			dbPath, dbPath+".zst",
+			sqlitezstd.WithFrameSize(4096),
+			sqlitezstd.WithProgress(func(progress sqlitezstd.Progress) {
+				reports = append(reports, progress)
+			}),
+		)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(reports).To(HaveLen(int(info.Size() / 4096)))
+
+		last := reports[len(reports)-1]
+		Expect(last.BytesRead).To(Equal(info.Size()))
+		Expect(last.FramesWritten).To(Equal(len(reports)))
+		Expect(last.Ratio()).To(BeNumerically(">", 1))
+	})
+
+	DescribeTable("rejects invalid values",
+		func(opt sqlitezstd.Option) {
+			dbPath := createSQLite()
+
+			err := sqlitezstd.CompressFile(dbPath, dbPath+".zst", opt)
+			Expect(err).To(MatchError(sqlitezstd.ErrInvalidOption))
+		},
+		Entry("level", sqlitezstd.WithLevel(0)),
+		Entry("frame size", sqlitezstd.WithFrameSize(0)),
+		Entry("workers", sqlitezstd.WithWorkers(-1)),
+	)
+})
+
+var _ = Describe("Header", func() {
+	It("aligns frames to the page size", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		err := sqlitezstd.CompressFile(dbPath, zstPath, sqlitezstd.WithFrameSize(10_000))
+		Expect(err).ToNot(HaveOccurred())
+
+		header, err := sqlitezstd.ReadHeader(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(header.PageSize).To(Equal(4096))
+		Expect(header.FrameSize).To(Equal(8192))
+		Expect(header.PageAligned).To(BeTrue())
+	})
+
+	It("can skip alignment", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		err := sqlitezstd.CompressFile(dbPath, zstPath, sqlitezstd.WithFrameSize(10_000), sqlitezstd.WithPageAlignment(false))
+		Expect(err).ToNot(HaveOccurred())
+
+		header, err := sqlitezstd.ReadHeader(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(header.FrameSize).To(Equal(10_000))
+		Expect(header.PageAligned).To(BeFalse())
+	})
+
+	It("errors for files without a header", func() {
+		zstPath := createForeignDatabase()
+
+		_, err := sqlitezstd.ReadHeader(zstPath)
+		Expect(err).To(MatchError(sqlitezstd.ErrNoHeader))
+	})
+})
+
+var _ = Describe("Metadata", func() {
+	BeforeEach(func() {
+		err := sqlitezstd.Init()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("records the source of the archive", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		client, err := sql.Open("sqlite3", dbPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = client.Exec("PRAGMA user_version = 7")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(client.Close()).To(Succeed())
+
+		err = sqlitezstd.CompressFile(dbPath, zstPath, sqlitezstd.WithLevel(5))
+		Expect(err).ToNot(HaveOccurred())
+
+		contents, err := os.ReadFile(dbPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		digest := sha256.Sum256(contents)
+
+		metadata, err := sqlitezstd.ReadMetadata(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(metadata.SourceSHA256).To(Equal(hex.EncodeToString(digest[:])))
+		Expect(metadata.SourceSize).To(BeEquivalentTo(len(contents)))
+		Expect(metadata.UserVersion).To(BeEquivalentTo(7))
+		Expect(metadata.SchemaVersion).To(BeNumerically(">", 0))
+		Expect(metadata.Level).To(Equal(5))
+		Expect(metadata.CreatedAt).To(BeTemporally("~", time.Now(), time.Minute))
+
+		verified, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?vfs=zstd&source_sha256=%x", zstPath, digest))
+		Expect(err).ToNot(HaveOccurred())
+		defer verified.Close()
+
+		var count int64
+		err = verified.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+
+		mismatched, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?vfs=zstd&source_sha256=%064x", zstPath, 0))
+		Expect(err).ToNot(HaveOccurred())
+		defer mismatched.Close()
+
+		_, err = mismatched.Exec("SELECT COUNT(*) FROM entries;")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("errors for files without metadata", func() {
+		zstPath := createForeignDatabase()
+
+		_, err := sqlitezstd.ReadMetadata(zstPath)
+		Expect(err).To(MatchError(sqlitezstd.ErrNoMetadata))
+	})
+})
+
+var _ = Describe("Inspect", func() {
+	It("describes the frames of an archive", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		err := sqlitezstd.CompressFile(dbPath, zstPath, sqlitezstd.WithFrameSize(8192))
+		Expect(err).ToNot(HaveOccurred())
+
+		contents, err := os.ReadFile(dbPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		info, err := sqlitezstd.Inspect(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Frames).To(HaveLen((len(contents) + 8191) / 8192))
+		Expect(info.SkippableFrames).To(Equal(2))
+		Expect(info.DecompressedSize).To(BeEquivalentTo(len(contents)))
+		Expect(info.PageSize).To(Equal(4096))
+		Expect(info.PageAligned).To(BeTrue())
+		Expect(info.Checksums).To(BeTrue())
+		Expect(info.Header).ToNot(BeNil())
+		Expect(info.Ratio()).To(BeNumerically(">", 1))
+	})
+
+	It("reads the page size of foreign archives", func() {
+		zstPath := createForeignDatabase()
+
+		info, err := sqlitezstd.Inspect(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Header).To(BeNil())
+		Expect(info.SkippableFrames).To(Equal(0))
+		Expect(info.PageSize).To(Equal(4096))
+	})
+})
+
+var _ = Describe("Verify", func() {
+	It("accepts intact archives", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		err := sqlitezstd.CompressFile(dbPath, zstPath, sqlitezstd.WithFrameSize(8192))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(sqlitezstd.Verify(zstPath)).To(Succeed())
+		Expect(sqlitezstd.Verify(createForeignDatabase())).To(Succeed())
+	})
+
+	It("reports every corrupt frame", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		err := sqlitezstd.CompressFile(dbPath, zstPath, sqlitezstd.WithFrameSize(4096))
+		Expect(err).ToNot(HaveOccurred())
+
+		info, err := sqlitezstd.Inspect(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		contents, err := os.ReadFile(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, frame := range []sqlitezstd.ArchiveFrame{info.Frames[0], info.Frames[2]} {
+			contents[frame.CompressedOffset+frame.CompressedSize/2] ^= 0xFF
+		}
+
+		err = os.WriteFile(zstPath, contents, 0o600)
+		Expect(err).ToNot(HaveOccurred())
+
+		err = sqlitezstd.Verify(zstPath)
+		Expect(err).To(MatchError(sqlitezstd.ErrCorruptFrame))
+		Expect(err.Error()).To(ContainSubstring("frame 1 "))
+		Expect(err.Error()).To(ContainSubstring("frame 3 "))
+	})
+
+	It("errors for truncated archives", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		err := sqlitezstd.CompressFile(dbPath, zstPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		contents, err := os.ReadFile(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		err = os.WriteFile(zstPath, append(contents[:100:100], contents[200:]...), 0o600)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(sqlitezstd.Verify(zstPath)).To(MatchError(sqlitezstd.ErrInvalidSeekTable))
+	})
+})
+
+var _ = Describe("Check", func() {
+	BeforeEach(func() {
+		Expect(sqlitezstd.Init()).To(Succeed())
+	})
+
+	It("reports healthy local and remote archives", func() {
+		zstPath := createDatabase()
+
+		report, err := sqlitezstd.Check(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.Healthy()).To(BeTrue())
+		Expect(report.Frames).To(BeNumerically(">", 0))
+		Expect(report.PageSize).To(Equal(4096))
+
+		_, serverURL := serveOrigin(filepath.Dir(zstPath))
+
+		report, err = sqlitezstd.Check(fmt.Sprintf("file:%s/%s?vfs=zstd&range_size=-1", serverURL, filepath.Base(zstPath)))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.Healthy()).To(BeTrue())
+		Expect(report.Location).To(Equal(fmt.Sprintf("%s/%s", serverURL, filepath.Base(zstPath))))
+		Expect(report.Duration).To(BeNumerically(">", 0))
+	})
+
+	It("reports how far unhealthy archives got", func() {
+		zstPath := createDatabase()
+
+		report, err := sqlitezstd.Check(zstPath + ".missing")
+		Expect(err).To(HaveOccurred())
+		Expect(report.Reachable).To(BeFalse())
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Del("Range")
+			http.ServeFile(w, r, zstPath)
+		}))
+		DeferCleanup(server.Close)
+
+		report, err = sqlitezstd.Check(server.URL + "/db.zst")
+		Expect(err).To(MatchError(sqlitezstd.ErrRangesUnsupported))
+		Expect(report.Reachable).To(BeTrue())
+		Expect(report.Ranges).To(BeFalse())
+
+		contents, err := os.ReadFile(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.WriteFile(zstPath, contents[:len(contents)-4], 0o600)).To(Succeed())
+
+		report, err = sqlitezstd.Check("file:" + zstPath + "?vfs=zstd")
+		Expect(err).To(MatchError(sqlitezstd.ErrInvalidSeekTable))
+		Expect(report.Ranges).To(BeTrue())
+		Expect(report.SeekTable).To(BeFalse())
+		Expect(report.Healthy()).To(BeFalse())
+	})
+})
+
+var _ = Describe("Parts", func() {
+	BeforeEach(func() {
+		err := sqlitezstd.Init()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("splits the archive and opens the parts as one file", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		err := sqlitezstd.CompressFile(dbPath, zstPath, sqlitezstd.WithFrameSize(4096), sqlitezstd.WithPartSize(1024))
+		Expect(err).ToNot(HaveOccurred())
+
+		parts, err := filepath.Glob(zstPath + ".*")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(parts)).To(BeNumerically(">", 1))
+
+		for _, part := range parts {
+			info, err := os.Stat(part)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Size()).To(BeNumerically("<=", 1024))
+		}
+
+		header, err := sqlitezstd.ReadHeader(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(header.FrameSize).To(Equal(4096))
+
+		server := httptest.NewServer(http.FileServer(http.Dir(filepath.Dir(zstPath))))
+		defer server.Close()
+
+		for _, dsn := range []string{
+			fmt.Sprintf("%s?vfs=zstd", zstPath),
+			fmt.Sprintf("%s/%s?vfs=zstd", server.URL, filepath.Base(zstPath)),
+		} {
+			client, err := sql.Open("sqlite3", dsn)
+			Expect(err).ToNot(HaveOccurred())
+			defer client.Close()
+
+			var count int64
+			err = client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(BeEquivalentTo(1000))
+		}
+	})
+
+	It("fails to open when a part is missing", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		err := sqlitezstd.CompressFile(dbPath, zstPath, sqlitezstd.WithPartSize(1024))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.Remove(zstPath + ".001")).To(Succeed())
+
+		client, err := sql.Open("sqlite3", fmt.Sprintf("%s?vfs=zstd", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		_, err = client.Exec("SELECT COUNT(*) FROM entries;")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Optimize", func() {
+	BeforeEach(func() {
+		err := sqlitezstd.Init()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("vacuums and compresses the database", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		err := sqlitezstd.Optimize(dbPath, zstPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		client, err := sql.Open("sqlite3", fmt.Sprintf("%s?vfs=zstd", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		var count int64
+		err = client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+	})
+
+	When("the source does not exist", func() {
+		It("returns an error", func() {
+			buildPath, err := os.MkdirTemp("", "")
+			Expect(err).ToNot(HaveOccurred())
+
+			err = sqlitezstd.Optimize(filepath.Join(buildPath, "missing.sqlite"), filepath.Join(buildPath, "out.zst"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+var _ = Describe("VacuumInto", func() {
+	BeforeEach(func() {
+		err := sqlitezstd.Init()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("compresses the vacuumed database while it is written", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		client, err := sql.Open("sqlite3", dbPath)
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		_, err = client.Exec("CREATE TABLE blobs AS SELECT id, randomblob(1000) AS data FROM entries")
+		Expect(err).ToNot(HaveOccurred())
+
+		err = sqlitezstd.VacuumInto(client, zstPath, sqlitezstd.WithFrameSize(16*1024))
+		Expect(err).ToNot(HaveOccurred())
+
+		header, err := sqlitezstd.ReadHeader(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(header.PageAligned).To(BeTrue())
+		Expect(header.FrameSize).To(Equal(16 * 1024))
+
+		archive, err := sql.Open("sqlite3", fmt.Sprintf("%s?vfs=zstd", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		defer archive.Close()
+
+		var count int64
+		err = archive.QueryRow("SELECT COUNT(*) FROM entries JOIN blobs USING (id);").Scan(&count)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+
+		var check string
+		err = archive.QueryRow("PRAGMA integrity_check").Scan(&check)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(check).To(Equal("ok"))
+	})
+})
+
+var _ = Describe("BackupTo", func() {
+	BeforeEach(func() {
+		err := sqlitezstd.Init()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("snapshots a live database", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		client, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL")
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		_, err = client.Exec("CREATE TABLE blobs AS SELECT id, randomblob(1000) AS data FROM entries")
+		Expect(err).ToNot(HaveOccurred())
+
+		conn, err := client.Conn(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		err = sqlitezstd.BackupTo(conn, zstPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		archive, err := sql.Open("sqlite3", fmt.Sprintf("%s?vfs=zstd", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		defer archive.Close()
+
+		var count int64
+		err = archive.QueryRow("SELECT COUNT(*) FROM blobs;").Scan(&count)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+
+		var check string
+		err = archive.QueryRow("PRAGMA integrity_check").Scan(&check)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(check).To(Equal("ok"))
+	})
+})
+
+var _ = Describe("CompressLive", func() {
+	BeforeEach(func() {
+		err := sqlitezstd.Init()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("includes pages still in the write-ahead log", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		client, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL")
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		client.SetMaxOpenConns(1)
+
+		_, err = client.Exec("PRAGMA wal_autocheckpoint = 0; INSERT INTO entries (id) VALUES (1001), (1002)")
+		Expect(err).ToNot(HaveOccurred())
+
+		info, err := os.Stat(dbPath + "-wal")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Size()).To(BeNumerically(">", 0))
+
+		err = sqlitezstd.CompressLive(dbPath, zstPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		archive, err := sql.Open("sqlite3", fmt.Sprintf("%s?vfs=zstd", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		defer archive.Close()
+
+		var count int64
+		err = archive.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1002))
+
+		_, err = client.Exec("INSERT INTO entries (id) VALUES (1003)")
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
+
+var _ = Describe("RecompressFile", func() {
+	BeforeEach(func() {
+		err := sqlitezstd.Init()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("only recompresses the frames that changed", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		client, err := sql.Open("sqlite3", dbPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = client.Exec("CREATE TABLE blobs AS SELECT id, randomblob(1000) AS data FROM entries")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(client.Close()).To(Succeed())
+
+		err = sqlitezstd.CompressFile(dbPath, zstPath, sqlitezstd.WithFrameSize(4096))
+		Expect(err).ToNot(HaveOccurred())
+
+		client, err = sql.Open("sqlite3", dbPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = client.Exec("INSERT INTO entries (id) VALUES (1001)")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(client.Close()).To(Succeed())
+
+		stats, err := sqlitezstd.RecompressFile(dbPath, zstPath, sqlitezstd.WithFrameSize(4096))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats.Reused).To(BeNumerically(">", 0))
+		Expect(stats.Recompressed).To(BeNumerically(">", 0))
+		Expect(stats.Recompressed).To(BeNumerically("<", stats.Reused))
+
+		client, err = sql.Open("sqlite3", fmt.Sprintf("%s?vfs=zstd", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		var count int64
+		err = client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1001))
+	})
+
+	It("reuses moved frames with content-defined chunking", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		client, err := sql.Open("sqlite3", dbPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		// rowids only survive VACUUM with an explicit primary key
+		_, err = client.Exec("CREATE TABLE blobs (id INTEGER PRIMARY KEY, data BLOB); INSERT INTO blobs SELECT id, randomblob(1000) FROM entries")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(client.Close()).To(Succeed())
+
+		opts := []sqlitezstd.Option{
+			sqlitezstd.WithFrameSize(16 * 1024),
+			sqlitezstd.WithContentDefinedChunking(true),
+		}
+
+		err = sqlitezstd.CompressFile(dbPath, zstPath, opts...)
+		Expect(err).ToNot(HaveOccurred())
+
+		header, err := sqlitezstd.ReadHeader(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(header.ContentDefined).To(BeTrue())
+
+		// vacuuming after the delete moves every later page
+		client, err = sql.Open("sqlite3", dbPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = client.Exec("DELETE FROM blobs WHERE id <= 100; VACUUM")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(client.Close()).To(Succeed())
+
+		stats, err := sqlitezstd.RecompressFile(dbPath, zstPath, opts...)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats.Recompressed).To(BeNumerically("<", stats.Reused))
+
+		client, err = sql.Open("sqlite3", fmt.Sprintf("%s?vfs=zstd", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		var count int64
+		err = client.QueryRow("SELECT COUNT(*) FROM blobs;").Scan(&count)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(900))
+	})
+
+	It("compresses from scratch when there is no archive", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		stats, err := sqlitezstd.RecompressFile(dbPath, zstPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats.Reused).To(Equal(0))
+
+		_, err = os.Stat(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
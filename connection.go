@@ -0,0 +1,92 @@
+package sqlitezstd
+
+/*
+typedef struct sqlite3 sqlite3;
+
+// Provided by the SQLite library that go-sqlite3 links in.
+extern const char *sqlite3_db_name(sqlite3 *db, int N);
+extern const char *sqlite3_db_filename(sqlite3 *db, const char *zDbName);
+*/
+import "C"
+
+import (
+	"reflect"
+	"sync"
+	"unsafe"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+//nolint: gochecknoglobals
+var (
+	connectionFilesMutex sync.Mutex
+	connectionFiles      = map[uintptr]*ZstdFile{}
+)
+
+// connectionFile is an archive a connection has open, as one of its
+// schemas, such as main or one attached.
+type connectionFile struct {
+	schema string
+	file   *ZstdFile
+}
+
+// trackConnection keeps file as the archive of the connection opening it,
+// until it is closed.
+func trackConnection(file *ZstdFile) {
+	file.handle = openHandle()
+	if file.handle == 0 {
+		return
+	}
+
+	connectionFilesMutex.Lock()
+	defer connectionFilesMutex.Unlock()
+
+	connectionFiles[file.handle] = file
+}
+
+// untrackConnection forgets file, as it is closed.
+func untrackConnection(file *ZstdFile) {
+	if file.handle == 0 {
+		return
+	}
+
+	connectionFilesMutex.Lock()
+	defer connectionFilesMutex.Unlock()
+
+	if connectionFiles[file.handle] == file {
+		delete(connectionFiles, file.handle)
+	}
+
+	file.handle = 0
+}
+
+// connectionFilesOf returns the archives conn has open, in the order of
+// its schemas. SQLite names every schema by the name its file was opened
+// with, so they are found by that name rather than by their path.
+func connectionFilesOf(conn *sqlite3.SQLiteConn) []connectionFile {
+	// go-sqlite3 keeps the handle of the connection to itself
+	db := (*C.sqlite3)(reflect.ValueOf(conn).Elem().FieldByName("db").UnsafePointer())
+	if db == nil {
+		return nil
+	}
+
+	files := []connectionFile{}
+
+	connectionFilesMutex.Lock()
+	defer connectionFilesMutex.Unlock()
+
+	for index := C.int(0); ; index++ {
+		schema := C.sqlite3_db_name(db, index)
+		if schema == nil {
+			break
+		}
+
+		name := C.sqlite3_db_filename(db, schema)
+
+		if file, ok := connectionFiles[uintptr(unsafe.Pointer(name))]; ok {
+			files = append(files, connectionFile{schema: C.GoString(schema), file: file})
+		}
+	}
+
+	return files
+}
@@ -0,0 +1,128 @@
+package sqlitezstd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+var (
+	ErrMemberNotFound  = errors.New("member not found in container")
+	ErrMemberNotStored = errors.New("member is compressed in its container")
+)
+
+// containerExtensions are the containers members can be opened from.
+//
+//nolint: gochecknoglobals
+var containerExtensions = []string{".zip", ".tar"}
+
+// splitMember splits names like bundle.zip#data/db.sqlite.zst
+// into the container and the member inside of it.
+func splitMember(name string) (string, string, bool) {
+	lower := strings.ToLower(name)
+
+	for _, extension := range containerExtensions {
+		index := strings.LastIndex(lower, extension+"#")
+		if index >= 0 {
+			return name[:index+len(extension)], name[index+len(extension)+1:], true
+		}
+	}
+
+	return "", "", false
+}
+
+// openMember opens a member of a zip or tar file, reading it in place
+// from the container.
+func openMember(container string, member string) (io.ReaderAt, int64, io.Closer, error) {
+	reader, size, closer, err := openPart(container)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	member = path.Clean(strings.TrimPrefix(member, "/"))
+
+	var (
+		offset int64
+		length int64
+	)
+
+	if strings.HasSuffix(strings.ToLower(container), ".tar") {
+		offset, length, err = locateTarMember(reader, size, member)
+	} else {
+		offset, length, err = locateZipMember(reader, size, member)
+	}
+
+	if err != nil {
+		_ = closer.Close()
+
+		return nil, 0, nil, err
+	}
+
+	return io.NewSectionReader(reader, offset, length), length, closer, nil
+}
+
+// locateZipMember finds a member stored, rather than deflated, in a zip file.
+func locateZipMember(reader io.ReaderAt, size int64, member string) (int64, int64, error) {
+	zipped, err := zip.NewReader(reader, size)
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not read zip: %w", err)
+	}
+
+	for _, file := range zipped.File {
+		if file.Name != member {
+			continue
+		}
+
+		if file.Method != zip.Store {
+			return 0, 0, fmt.Errorf("%w: %s, add it with zip -0", ErrMemberNotStored, member)
+		}
+
+		offset, err := file.DataOffset()
+		if err != nil {
+			return 0, 0, fmt.Errorf("could not locate member: %w", err)
+		}
+
+		//nolint: gosec
+		return offset, int64(file.UncompressedSize64), nil
+	}
+
+	return 0, 0, fmt.Errorf("%w: %s", ErrMemberNotFound, member)
+}
+
+// locateTarMember finds a member of an uncompressed tar file. Only the headers
+// are read, as the contents of other members are skipped by seeking.
+func locateTarMember(reader io.ReaderAt, size int64, member string) (int64, int64, error) {
+	section := io.NewSectionReader(reader, 0, size)
+	members := tar.NewReader(section)
+
+	for {
+		header, err := members.Next()
+		if errors.Is(err, io.EOF) {
+			return 0, 0, fmt.Errorf("%w: %s", ErrMemberNotFound, member)
+		}
+
+		if err != nil {
+			return 0, 0, fmt.Errorf("could not read tar: %w", err)
+		}
+
+		if path.Clean(header.Name) != member {
+			continue
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			return 0, 0, fmt.Errorf("%w: %s is not a regular file", ErrMemberNotFound, member)
+		}
+
+		// the contents start right after the header that was just read
+		offset, err := section.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, 0, fmt.Errorf("could not locate member: %w", err)
+		}
+
+		return offset, header.Size, nil
+	}
+}
@@ -0,0 +1,203 @@
+package sqlitezstd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/psanford/sqlite3vfs"
+)
+
+// DebugVFS records every call SQLite makes to VFS, and to the files it
+// opens, to Output, a line each, with its arguments, results, and how
+// long it took, so what SQLite does against an archive can be seen:
+//
+//	open name="db.sqlite.zst" flags=0x100 result=0x101 took=1.2ms
+//	read name="db.sqlite.zst" offset=0 length=100 n=100 took=45µs
+//
+// It is registered with RegisterDebug.
+type DebugVFS struct {
+	// VFS is the VFS the calls are made to, such as a *ZstdVFS.
+	VFS sqlite3vfs.VFS
+	// Output is where the calls are recorded. Calls from every connection
+	// are written one at a time.
+	Output io.Writer
+
+	mutex sync.Mutex
+}
+
+var _ sqlite3vfs.VFS = &DebugVFS{}
+
+// RegisterDebug makes vfs available to SQLite under name, like Register.
+func RegisterDebug(name string, vfs *DebugVFS) error {
+	return register(name, vfs)
+}
+
+func (d *DebugVFS) Open(name string, flags sqlite3vfs.OpenFlag) (sqlite3vfs.File, sqlite3vfs.OpenFlag, error) {
+	started := time.Now()
+	file, result, err := d.VFS.Open(name, flags)
+	d.record("open", started, err, "name", name, "flags", flags, "result", result)
+
+	if err != nil {
+		return nil, result, err
+	}
+
+	return &debugFile{vfs: d, name: name, file: file}, result, nil
+}
+
+func (d *DebugVFS) Delete(name string, dirSync bool) error {
+	started := time.Now()
+	err := d.VFS.Delete(name, dirSync)
+	d.record("delete", started, err, "name", name, "dirsync", dirSync)
+
+	return err
+}
+
+func (d *DebugVFS) Access(name string, flags sqlite3vfs.AccessFlag) (bool, error) {
+	started := time.Now()
+	ok, err := d.VFS.Access(name, flags)
+	d.record("access", started, err, "name", name, "flags", flags, "result", ok)
+
+	return ok, err
+}
+
+func (d *DebugVFS) FullPathname(name string) string {
+	started := time.Now()
+	path := d.VFS.FullPathname(name)
+	d.record("fullpathname", started, nil, "name", name, "result", path)
+
+	return path
+}
+
+// record writes a line for call, with its arguments and results as pairs
+// of keys and values.
+func (d *DebugVFS) record(call string, started time.Time, err error, pairs ...any) {
+	took := time.Since(started)
+
+	var line strings.Builder
+
+	line.WriteString(call)
+
+	for index := 0; index+1 < len(pairs); index += 2 {
+		switch value := pairs[index+1].(type) {
+		case string:
+			fmt.Fprintf(&line, " %s=%q", pairs[index], value)
+		case sqlite3vfs.OpenFlag, sqlite3vfs.AccessFlag, sqlite3vfs.DeviceCharacteristic:
+			fmt.Fprintf(&line, " %s=%#x", pairs[index], value)
+		default:
+			fmt.Fprintf(&line, " %s=%v", pairs[index], value)
+		}
+	}
+
+	fmt.Fprintf(&line, " took=%s", took)
+
+	if err != nil {
+		fmt.Fprintf(&line, " error=%q", err.Error())
+	}
+
+	line.WriteByte('\n')
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	_, _ = io.WriteString(d.Output, line.String())
+}
+
+// debugFile records the calls made to a file opened by a DebugVFS.
+type debugFile struct {
+	vfs  *DebugVFS
+	name string
+	file sqlite3vfs.File
+}
+
+var _ sqlite3vfs.File = &debugFile{}
+
+func (d *debugFile) Close() error {
+	started := time.Now()
+	err := d.file.Close()
+	d.vfs.record("close", started, err, "name", d.name)
+
+	return err
+}
+
+func (d *debugFile) ReadAt(p []byte, off int64) (int, error) {
+	started := time.Now()
+	count, err := d.file.ReadAt(p, off)
+	d.vfs.record("read", started, err, "name", d.name, "offset", off, "length", len(p), "n", count)
+
+	return count, err
+}
+
+func (d *debugFile) WriteAt(p []byte, off int64) (int, error) {
+	started := time.Now()
+	count, err := d.file.WriteAt(p, off)
+	d.vfs.record("write", started, err, "name", d.name, "offset", off, "length", len(p), "n", count)
+
+	return count, err
+}
+
+func (d *debugFile) Truncate(size int64) error {
+	started := time.Now()
+	err := d.file.Truncate(size)
+	d.vfs.record("truncate", started, err, "name", d.name, "size", size)
+
+	return err
+}
+
+func (d *debugFile) Sync(flag sqlite3vfs.SyncType) error {
+	started := time.Now()
+	err := d.file.Sync(flag)
+	d.vfs.record("sync", started, err, "name", d.name, "flags", int(flag))
+
+	return err
+}
+
+func (d *debugFile) FileSize() (int64, error) {
+	started := time.Now()
+	size, err := d.file.FileSize()
+	d.vfs.record("filesize", started, err, "name", d.name, "result", size)
+
+	return size, err
+}
+
+func (d *debugFile) Lock(elock sqlite3vfs.LockType) error {
+	started := time.Now()
+	err := d.file.Lock(elock)
+	d.vfs.record("lock", started, err, "name", d.name, "lock", int(elock))
+
+	return err
+}
+
+func (d *debugFile) Unlock(elock sqlite3vfs.LockType) error {
+	started := time.Now()
+	err := d.file.Unlock(elock)
+	d.vfs.record("unlock", started, err, "name", d.name, "lock", int(elock))
+
+	return err
+}
+
+func (d *debugFile) CheckReservedLock() (bool, error) {
+	started := time.Now()
+	reserved, err := d.file.CheckReservedLock()
+	d.vfs.record("checkreservedlock", started, err, "name", d.name, "result", reserved)
+
+	return reserved, err
+}
+
+func (d *debugFile) SectorSize() int64 {
+	started := time.Now()
+	size := d.file.SectorSize()
+	d.vfs.record("sectorsize", started, nil, "name", d.name, "result", size)
+
+	return size
+}
+
+func (d *debugFile) DeviceCharacteristics() sqlite3vfs.DeviceCharacteristic {
+	started := time.Now()
+	characteristics := d.file.DeviceCharacteristics()
+	d.vfs.record("devicecharacteristics", started, nil, "name", d.name, "result", characteristics)
+
+	return characteristics
+}
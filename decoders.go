@@ -0,0 +1,129 @@
+package sqlitezstd
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// defaultDecoderPoolSize is how many idle decoders are kept when
+// SetDecoderPoolSize is not called.
+const defaultDecoderPoolSize = 16
+
+// decoderOptions tune the decoders of a VFS. The zero value is a decoder
+// that decodes one frame at a time, with the defaults of zstd otherwise.
+type decoderOptions struct {
+	concurrency int
+	lowMemory   bool
+	maxMemory   uint64
+}
+
+func (o decoderOptions) newDecoder() (*zstd.Decoder, error) {
+	concurrency := o.concurrency
+	if concurrency == 0 {
+		concurrency = 1
+	}
+
+	options := []zstd.DOption{
+		zstd.WithDecoderConcurrency(concurrency),
+		zstd.WithDecoderLowmem(o.lowMemory),
+	}
+
+	if o.maxMemory > 0 {
+		options = append(options, zstd.WithDecoderMaxMemory(o.maxMemory))
+	}
+
+	decoder, err := zstd.NewReader(nil, options...)
+	if err != nil {
+		return nil, fmt.Errorf("could not create decoder: %w", err)
+	}
+
+	return decoder, nil
+}
+
+// decoderPool keeps the decoders of closed files for the next files to
+// open with the same options, so opening a file under connection churn
+// does not allocate a decoder and its buffers every time. At most size
+// decoders are kept, the others are closed.
+type decoderPool struct {
+	mutex sync.Mutex
+	size  int
+	count int
+	idle  map[decoderOptions][]*zstd.Decoder
+}
+
+//nolint: gochecknoglobals
+var decoders = &decoderPool{
+	size: defaultDecoderPoolSize,
+	idle: map[decoderOptions][]*zstd.Decoder{},
+}
+
+// SetDecoderPoolSize sets how many idle decoders are kept for reuse across
+// files and connections, 16 by default. Decoders over the size are closed,
+// and 0 creates a decoder for every file.
+func SetDecoderPoolSize(size int) {
+	decoders.resize(max(size, 0))
+}
+
+// get returns an idle decoder with options, or a new one when there is
+// none. Files are read by one connection at a time, so by default a
+// decoder decodes one frame at a time.
+func (d *decoderPool) get(options decoderOptions) (*zstd.Decoder, error) {
+	d.mutex.Lock()
+
+	if idle := d.idle[options]; len(idle) > 0 {
+		decoder := idle[len(idle)-1]
+		d.idle[options] = idle[:len(idle)-1]
+		d.count--
+		d.mutex.Unlock()
+
+		return decoder, nil
+	}
+
+	d.mutex.Unlock()
+
+	return options.newDecoder()
+}
+
+// put keeps decoder for reuse, or closes it when the pool is full.
+func (d *decoderPool) put(options decoderOptions, decoder *zstd.Decoder) {
+	d.mutex.Lock()
+
+	if d.count < d.size {
+		d.idle[options] = append(d.idle[options], decoder)
+		d.count++
+		d.mutex.Unlock()
+
+		return
+	}
+
+	d.mutex.Unlock()
+
+	decoder.Close()
+}
+
+// resize changes how many decoders are kept, closing those over it.
+func (d *decoderPool) resize(size int) {
+	d.mutex.Lock()
+
+	d.size = size
+
+	var closed []*zstd.Decoder
+
+	for options, idle := range d.idle {
+		for len(idle) > 0 && d.count > size {
+			closed = append(closed, idle[len(idle)-1])
+			idle = idle[:len(idle)-1]
+			d.count--
+		}
+
+		d.idle[options] = idle
+	}
+
+	d.mutex.Unlock()
+
+	for _, decoder := range closed {
+		decoder.Close()
+	}
+}
@@ -0,0 +1,206 @@
+package sqlitezstd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultMemoryLimit is the largest database DecompressMemory holds when
+// no limit is given.
+const defaultMemoryLimit = 128 << 20
+
+var ErrDatabaseTooLarge = errors.New("database is too large to decompress")
+
+// Decompression selects when the frames of an archive are decompressed.
+type Decompression string
+
+const (
+	// DecompressFrames decompresses the frames read, as they are read.
+	DecompressFrames Decompression = ""
+	// DecompressMemory decompresses the whole database into memory when
+	// it is opened, and serves every read from there, shared by the
+	// connections to the same archive. Databases over the memory limit
+	// are refused.
+	DecompressMemory Decompression = "memory"
+	// DecompressTempfile decompresses the whole database into a temporary
+	// file when it is opened, and serves every read from there, shared by
+	// the connections to the same archive and removed once the last one
+	// closes. It suits databases read over and over when memory is scarce.
+	DecompressTempfile Decompression = "tempfile"
+)
+
+func parseDecompression(value string) (Decompression, error) {
+	switch decompression := Decompression(value); decompression {
+	case DecompressFrames, DecompressMemory, DecompressTempfile:
+		return decompression, nil
+	default:
+		if value == "frames" {
+			return DecompressFrames, nil
+		}
+
+		return DecompressFrames, fmt.Errorf("%w: decompress=%q", ErrInvalidOption, value)
+	}
+}
+
+// decompressedDatabase is a database decompressed in full, in memory or
+// in a temporary file, shared by the files opened to the same archive
+// while any of them is open.
+type decompressedDatabase struct {
+	io.ReaderAt
+
+	key string
+	// file, if set, is the temporary file holding the database.
+	file  *os.File
+	users int
+}
+
+//nolint: gochecknoglobals
+var (
+	decompressedDatabases      = map[string]*decompressedDatabase{}
+	decompressedDatabasesMutex sync.Mutex
+)
+
+// openDecompressed returns the database of base decompressed as asked,
+// from another file open to the same archive, if any. Archives that can
+// not be told apart are not shared.
+func openDecompressed(base *ZstdFile, decompression Decompression, limit int64) (*decompressedDatabase, error) {
+	if decompression == DecompressMemory && base.table.decompressedSize > limit {
+		return nil, fmt.Errorf("%w: %d bytes over the limit of %d", ErrDatabaseTooLarge, base.table.decompressedSize, limit)
+	}
+
+	reader, _ := base.reader.(*archive)
+
+	key := ""
+	if reader.identity != "" {
+		key = string(decompression) + "\x00" + reader.identity
+	}
+
+	if key != "" {
+		decompressedDatabasesMutex.Lock()
+		if database, ok := decompressedDatabases[key]; ok {
+			database.users++
+			decompressedDatabasesMutex.Unlock()
+
+			return database, nil
+		}
+		decompressedDatabasesMutex.Unlock()
+	}
+
+	database := &decompressedDatabase{key: key, users: 1}
+
+	if decompression == DecompressTempfile {
+		file, err := os.CreateTemp("", "sqlitezstd-*.sqlite")
+		if err != nil {
+			return nil, fmt.Errorf("could not create temporary file: %w", err)
+		}
+
+		database.ReaderAt, database.file = file, file
+
+		err = decompressAll(base, file)
+		if err != nil {
+			database.remove()
+
+			return nil, err
+		}
+	} else {
+		buffer := bytes.NewBuffer(make([]byte, 0, base.table.decompressedSize))
+
+		err := decompressAll(base, buffer)
+		if err != nil {
+			return nil, err
+		}
+
+		database.ReaderAt = bytes.NewReader(buffer.Bytes())
+	}
+
+	if key == "" {
+		return database, nil
+	}
+
+	decompressedDatabasesMutex.Lock()
+	defer decompressedDatabasesMutex.Unlock()
+
+	// another file may have decompressed it meanwhile
+	if existing, ok := decompressedDatabases[key]; ok {
+		existing.users++
+		database.remove()
+
+		return existing, nil
+	}
+
+	decompressedDatabases[key] = database
+
+	return database, nil
+}
+
+// release lets go of the database once the last file using it closes.
+func (d *decompressedDatabase) release() {
+	decompressedDatabasesMutex.Lock()
+	defer decompressedDatabasesMutex.Unlock()
+
+	d.users--
+	if d.users > 0 {
+		return
+	}
+
+	if d.key != "" {
+		delete(decompressedDatabases, d.key)
+	}
+
+	d.remove()
+}
+
+// remove removes the temporary file of the database, if any.
+func (d *decompressedDatabase) remove() {
+	if d.file == nil {
+		return
+	}
+
+	_ = d.file.Close()
+	_ = os.Remove(d.file.Name())
+}
+
+// decompressAll decompresses every frame of base to output, in order,
+// checking them against their checksums, if any.
+func decompressAll(base *ZstdFile, output io.Writer) error {
+	decoder, err := base.zstdDecoder()
+	if err != nil {
+		return err
+	}
+
+	compressed, raw := []byte{}, []byte{}
+
+	for _, frame := range base.table.frames {
+		if cap(compressed) < int(frame.compressedSize) {
+			compressed = make([]byte, frame.compressedSize)
+		}
+
+		compressed = compressed[:frame.compressedSize]
+
+		_, err := base.counter.ReadAt(compressed, frame.compressedOffset)
+		if err != nil {
+			return fmt.Errorf("could not read frame %d: %w", frame.index, err)
+		}
+
+		started := time.Now()
+
+		raw, err = verifyData(decoder, compressed, raw[:0], frame, base.table.checksums)
+		if err != nil {
+			return err
+		}
+
+		base.counters.decoded(len(raw), started)
+
+		_, err = output.Write(raw)
+		if err != nil {
+			return fmt.Errorf("could not write frame %d: %w", frame.index, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,252 @@
+package sqlitezstd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// The delta sidecar is an append-only log. It starts with a header holding
+// the block size, followed by records that each end with a CRC32 of the
+// record, so a torn write at the end is detected and dropped on replay.
+//
+//	header:   magic (8 bytes) | block size (8 bytes)
+//	block:    kind (1 byte) | index (8 bytes) | size (8 bytes) | data | crc (4 bytes)
+//	truncate: kind (1 byte) | size (8 bytes) | crc (4 bytes)
+const (
+	deltaMagic      = "SQLZDLT1"
+	deltaHeaderSize = 16
+
+	deltaKindBlock    = 1
+	deltaKindTruncate = 2
+
+	deltaCRCSize = 4
+)
+
+var ErrInvalidDelta = errors.New("invalid delta file")
+
+type deltaStore struct {
+	file      *os.File
+	blockSize int64
+	end       int64
+	// blocks maps a block index to the offset of its data in the file
+	blocks  map[int64]int64
+	logical int64
+	limit   int64
+	// temporary stores are removed on close
+	temporary bool
+}
+
+var _ pageStore = &deltaStore{}
+
+// openDeltaStore opens the sidecar at path, replaying any existing records.
+func openDeltaStore(path string, blockSize int64) (*deltaStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open delta: %w", err)
+	}
+
+	store := &deltaStore{
+		file:      file,
+		blockSize: blockSize,
+		blocks:    map[int64]int64{},
+		logical:   -1,
+		limit:     -1,
+	}
+
+	err = store.replay()
+	if err != nil {
+		_ = file.Close()
+
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (d *deltaStore) replay() error {
+	info, err := d.file.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat delta: %w", err)
+	}
+
+	if info.Size() < deltaHeaderSize {
+		header := make([]byte, deltaHeaderSize)
+		copy(header, deltaMagic)
+		putInt64(header[8:], d.blockSize)
+
+		_, err = d.file.WriteAt(header, 0)
+		if err != nil {
+			return fmt.Errorf("could not write delta header: %w", err)
+		}
+
+		d.end = deltaHeaderSize
+
+		return d.file.Truncate(d.end)
+	}
+
+	contents, err := io.ReadAll(d.file)
+	if err != nil {
+		return fmt.Errorf("could not read delta: %w", err)
+	}
+
+	if !bytes.Equal(contents[:8], []byte(deltaMagic)) {
+		return fmt.Errorf("%w: magic mismatch", ErrInvalidDelta)
+	}
+
+	d.blockSize = getInt64(contents[8:])
+	d.end = deltaHeaderSize
+
+	for {
+		length := d.recordAt(contents, d.end)
+		if length == 0 {
+			break
+		}
+
+		d.end += length
+	}
+
+	// drop anything after the last complete record
+	return d.file.Truncate(d.end)
+}
+
+// recordAt applies the record at offset and returns its length,
+// or 0 if there is no complete record there.
+func (d *deltaStore) recordAt(contents []byte, offset int64) int64 {
+	if offset >= int64(len(contents)) {
+		return 0
+	}
+
+	var length int64
+
+	switch contents[offset] {
+	case deltaKindBlock:
+		length = 1 + 8 + 8 + d.blockSize + deltaCRCSize
+	case deltaKindTruncate:
+		length = 1 + 8 + deltaCRCSize
+	default:
+		return 0
+	}
+
+	if offset+length > int64(len(contents)) {
+		return 0
+	}
+
+	record := contents[offset : offset+length]
+	body := record[:length-deltaCRCSize]
+
+	if crc32.ChecksumIEEE(body) != getUint32(record[length-deltaCRCSize:]) {
+		return 0
+	}
+
+	switch record[0] {
+	case deltaKindBlock:
+		d.blocks[getInt64(record[1:])] = offset + 1 + 8 + 8
+		d.logical = getInt64(record[9:])
+	case deltaKindTruncate:
+		d.applyTruncate(getInt64(record[1:]))
+	}
+
+	return length
+}
+
+func (d *deltaStore) block(index int64) ([]byte, error) {
+	offset, ok := d.blocks[index]
+	if !ok {
+		return nil, nil
+	}
+
+	data := make([]byte, d.blockSize)
+
+	_, err := d.file.ReadAt(data, offset)
+	if err != nil {
+		return nil, fmt.Errorf("could not read delta: %w", err)
+	}
+
+	return data, nil
+}
+
+func (d *deltaStore) putBlock(index int64, data []byte, size int64) error {
+	record := make([]byte, 1+8+8, 1+8+8+d.blockSize+deltaCRCSize)
+	record[0] = deltaKindBlock
+	putInt64(record[1:], index)
+	putInt64(record[9:], size)
+	record = append(record, data...)
+	record = appendUint32(record, crc32.ChecksumIEEE(record))
+
+	err := d.append(record)
+	if err != nil {
+		return err
+	}
+
+	d.blocks[index] = d.end - int64(len(record)) + 1 + 8 + 8
+	d.logical = size
+
+	return nil
+}
+
+func (d *deltaStore) truncate(size int64) error {
+	record := make([]byte, 1+8)
+	record[0] = deltaKindTruncate
+	putInt64(record[1:], size)
+	record = appendUint32(record, crc32.ChecksumIEEE(record))
+
+	err := d.append(record)
+	if err != nil {
+		return err
+	}
+
+	d.applyTruncate(size)
+
+	return nil
+}
+
+func (d *deltaStore) applyTruncate(size int64) {
+	d.logical = size
+
+	if d.limit < 0 || size < d.limit {
+		d.limit = size
+	}
+
+	for index := range d.blocks {
+		if index*d.blockSize >= size {
+			delete(d.blocks, index)
+		}
+	}
+}
+
+func (d *deltaStore) append(record []byte) error {
+	_, err := d.file.WriteAt(record, d.end)
+	if err != nil {
+		return fmt.Errorf("could not write delta: %w", err)
+	}
+
+	d.end += int64(len(record))
+
+	return nil
+}
+
+func (d *deltaStore) size() int64 {
+	return d.logical
+}
+
+func (d *deltaStore) baseLimit() int64 {
+	return d.limit
+}
+
+func (d *deltaStore) sync() error {
+	return d.file.Sync()
+}
+
+func (d *deltaStore) close() error {
+	err := d.file.Close()
+
+	if d.temporary {
+		_ = os.Remove(d.file.Name())
+	}
+
+	return err
+}
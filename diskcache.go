@@ -0,0 +1,492 @@
+package sqlitezstd
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	seekable "github.com/SaveTheRbtz/zstd-seekable-format-go"
+)
+
+// defaultDiskCacheSize is the size of a disk cache when none is given.
+const defaultDiskCacheSize = 1 << 30
+
+// diskCache keeps decompressed frames of remote archives as files in a
+// directory, so they outlive the process. Once the files add up to more
+// than size bytes, the least recently used are removed.
+type diskCache struct {
+	dir  string
+	size int64
+
+	mutex sync.Mutex
+	// used holds the names of the files, least recently used first.
+	used    *list.List
+	entries map[string]*list.Element
+	sizes   map[string]int64
+	total   int64
+}
+
+//nolint: gochecknoglobals
+var (
+	diskCaches      = map[string]*diskCache{}
+	diskCachesMutex sync.Mutex
+)
+
+// openDiskCache returns the cache of dir, shared by every archive using it,
+// indexing the frames already in it by their modification time.
+func openDiskCache(dir string, size int64) (*diskCache, error) {
+	diskCachesMutex.Lock()
+	defer diskCachesMutex.Unlock()
+
+	if cache, ok := diskCaches[dir]; ok {
+		cache.mutex.Lock()
+		cache.size = size
+		cache.evict()
+		cache.mutex.Unlock()
+
+		return cache, nil
+	}
+
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return nil, fmt.Errorf("could not create disk cache: %w", err)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read disk cache: %w", err)
+	}
+
+	type existing struct {
+		name     string
+		size     int64
+		modified time.Time
+	}
+
+	frames := make([]existing, 0, len(files))
+
+	for _, file := range files {
+		info, err := file.Info()
+		if err != nil || !info.Mode().IsRegular() || !strings.HasSuffix(file.Name(), ".frame") {
+			continue
+		}
+
+		frames = append(frames, existing{name: file.Name(), size: info.Size(), modified: info.ModTime()})
+	}
+
+	sort.Slice(frames, func(i, j int) bool {
+		return frames[i].modified.Before(frames[j].modified)
+	})
+
+	cache := &diskCache{
+		dir:     dir,
+		size:    size,
+		used:    list.New(),
+		entries: map[string]*list.Element{},
+		sizes:   map[string]int64{},
+	}
+
+	for _, frame := range frames {
+		cache.entries[frame.name] = cache.used.PushBack(frame.name)
+		cache.sizes[frame.name] = frame.size
+		cache.total += frame.size
+	}
+
+	cache.evict()
+	diskCaches[dir] = cache
+
+	return cache, nil
+}
+
+// trusted is false, as files on disk may be changed or corrupted.
+func (d *diskCache) trusted() bool {
+	return false
+}
+
+// get returns the frame stored under name, if any.
+func (d *diskCache) get(name string) ([]byte, bool) {
+	d.mutex.Lock()
+	element, ok := d.entries[name]
+
+	if ok {
+		d.used.MoveToBack(element)
+	}
+	d.mutex.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	path := filepath.Join(d.dir, name)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		d.remove(name)
+
+		return nil, false
+	}
+
+	// the modification time orders frames once the process restarts
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+
+	return data, true
+}
+
+// put stores a frame under name, then removes the least recently
+// used frames over the size of the cache.
+func (d *diskCache) put(name string, data []byte) {
+	if int64(len(data)) > d.size {
+		return
+	}
+
+	err := writeFile(filepath.Join(d.dir, name), func(output io.Writer) error {
+		_, err := output.Write(data)
+		if err != nil {
+			return fmt.Errorf("could not write frame: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if element, ok := d.entries[name]; ok {
+		d.used.MoveToBack(element)
+		d.total -= d.sizes[name]
+	} else {
+		d.entries[name] = d.used.PushBack(name)
+	}
+
+	d.sizes[name] = int64(len(data))
+	d.total += int64(len(data))
+
+	d.evict()
+}
+
+// remove forgets a frame that can no longer be read.
+func (d *diskCache) remove(name string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if element, ok := d.entries[name]; ok {
+		d.used.Remove(element)
+		d.drop(name)
+	}
+}
+
+// evict removes frames, least recently used first, until the
+// cache fits. The mutex is held.
+func (d *diskCache) evict() {
+	for d.total > d.size && d.used.Len() > 0 {
+		name, _ := d.used.Remove(d.used.Front()).(string)
+		d.drop(name)
+	}
+}
+
+func (d *diskCache) drop(name string) {
+	_ = os.Remove(filepath.Join(d.dir, name))
+
+	d.total -= d.sizes[name]
+	delete(d.entries, name)
+	delete(d.sizes, name)
+}
+
+// cachedFrames reads an archive a frame at a time, from the first of
+// its stores holding a frame, and from the archive otherwise.
+type cachedFrames struct {
+	stores []frameStore
+	// key identifies the archive in the names of its frames.
+	key   string
+	table *seekTable
+	// ahead, if set, decompresses the frames after sequential scans.
+	ahead *readahead
+	// partial, if set, decompresses the frames of every read from reader,
+	// keeping none of them.
+	partial  bool
+	reader   io.ReaderAt
+	decoding decoderOptions
+	// coalesce, if set, is the most bytes of the frames after a run of
+	// sequential reads decompressed together, into one region, by up to
+	// workers at once.
+	coalesce int64
+	workers  int
+	// metrics, if set, counts how the frames of reads were found, and
+	// counters the frames decompressed for the archive.
+	metrics  *cacheMetrics
+	counters *archiveCounters
+	// logger, if set, logs the frames of the stores found corrupt.
+	logger *slog.Logger
+
+	mutex     sync.Mutex
+	last      int
+	lastFrame []byte
+	// names are the names of the frames in the stores, made once, so
+	// reads of cached frames do not allocate.
+	names []string
+	// region holds the frames decompressed together last, and next and
+	// run where the reads of the current run end and how many there were.
+	region  *region
+	next    int64
+	run     int
+	pending sync.WaitGroup
+}
+
+// newCachedFrames identifies an archive by its name, without its query,
+// and its seek table, so a replaced archive does not read stale frames.
+// Archives identified by their contents leave out the name, so copies of
+// an archive at other locations, or after a redeploy under a new name,
+// share their frames. The seek table lists the size and checksum of every
+// frame, so only archives with checksums are identified by their contents.
+func newCachedFrames(stores []frameStore, name string, size int64, table *parsedTable, byContent bool) *cachedFrames {
+	return &cachedFrames{
+		stores: stores,
+		key:    framesKey(name, size, table, byContent),
+		table:  table.seekTable,
+		last:   -1,
+		names:  make([]string, len(table.frames)),
+	}
+}
+
+// framesKey identifies the archive name of size bytes in the names of
+// its frames, as newCachedFrames does.
+func framesKey(name string, size int64, table *parsedTable, byContent bool) string {
+	location, _, _ := strings.Cut(name, "?")
+	if byContent && table.checksums {
+		location = ""
+	}
+
+	hash := sha256.New()
+	_, _ = fmt.Fprintf(hash, "%s\x00%d\x00", location, size)
+	_, _ = hash.Write(table.raw)
+
+	return hex.EncodeToString(hash.Sum(nil)[:16])
+}
+
+func (c *cachedFrames) ReadAt(reader seekable.Reader, p []byte, off int64) (int, error) {
+	if c.coalesce > 0 && c.fromRegion(p, off) {
+		c.metrics.hit()
+
+		return len(p), nil
+	}
+
+	read := 0
+
+	for read < len(p) && off+int64(read) < c.table.decompressedSize {
+		position := off + int64(read)
+
+		index := c.table.frameFor(position)
+
+		if c.partial {
+			count, err := c.partialFrame(p[read:], position, index)
+			if err != nil {
+				return read, err
+			}
+
+			read += count
+
+			continue
+		}
+
+		data, err := c.frame(reader, index)
+		if err != nil {
+			return read, err
+		}
+
+		read += copy(p[read:], data[position-c.table.frames[index].decompressedOffset:])
+	}
+
+	switch {
+	case c.ahead != nil:
+		c.ahead.observe(off, p[:read])
+	case c.coalesce > 0 && read > 0:
+		c.sequential(off, int64(read))
+	}
+
+	if read < len(p) {
+		return read, io.EOF
+	}
+
+	return read, nil
+}
+
+// frame returns the decompressed frame at index, decompressing
+// and storing it if the cache does not hold it.
+func (c *cachedFrames) frame(reader seekable.Reader, index int) ([]byte, error) {
+	c.mutex.Lock()
+	if c.last == index {
+		data := c.lastFrame
+		c.mutex.Unlock()
+		c.metrics.hit()
+
+		return data, nil
+	}
+
+	name := c.name(index)
+	c.mutex.Unlock()
+
+	frame := c.table.frames[index]
+
+	var (
+		data []byte
+		ok   bool
+	)
+
+	// a frame read ahead is stored as if it was just read
+	if c.ahead != nil {
+		data, ok = c.ahead.take(index)
+		if ok {
+			for _, store := range c.stores {
+				store.put(name, data)
+			}
+		}
+	}
+
+	if !ok {
+		data, ok = c.stored(name, frame)
+	}
+
+	if ok {
+		c.metrics.hit()
+	} else {
+		c.metrics.miss()
+
+		var err error
+
+		// connections reading the same frame at once read it once
+		data, err = frameFlights.do(name, func() ([]byte, error) {
+			data := make([]byte, frame.decompressedSize)
+
+			_, err := reader.ReadAt(data, frame.decompressedOffset)
+			if err != nil && !errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("could not read frame %d: %w", index, err)
+			}
+
+			c.metrics.decompressed(len(data))
+
+			for _, store := range c.stores {
+				store.put(name, data)
+			}
+
+			return data, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c.mutex.Lock()
+	c.last, c.lastFrame = index, data
+	c.mutex.Unlock()
+
+	return data, nil
+}
+
+// name returns the name of frame index in the stores. The mutex is held.
+func (c *cachedFrames) name(index int) string {
+	name := c.names[index]
+	if name == "" {
+		name = fmt.Sprintf("%s-%d.frame", c.key, index)
+		c.names[index] = name
+	}
+
+	return name
+}
+
+// partialFrame copies frame index, from position, into p. The frame is
+// kept by no store, so it is decompressed into a buffer of the pool.
+func (c *cachedFrames) partialFrame(p []byte, position int64, index int) (int, error) {
+	frame := c.table.frames[index]
+
+	buffer := buffers.get(int(frame.decompressedSize))
+	defer buffers.put(buffer)
+
+	data, err := decompressFrame(c.reader, c.decoding, frame, c.table.checksums, (*buffer)[:0], c.counters)
+	if err != nil {
+		return 0, err
+	}
+
+	c.metrics.miss()
+	c.metrics.decompressed(len(data))
+
+	return copy(p, data[position-frame.decompressedOffset:]), nil
+}
+
+// stored returns the frame stored under name by the first store holding
+// a valid copy. Only frames from stores outside the process are checked.
+func (c *cachedFrames) stored(name string, frame frameInfo) ([]byte, bool) {
+	for level, store := range c.stores {
+		data, ok := store.get(name)
+		if ok && !store.trusted() && (len(data) != int(frame.decompressedSize) || (c.table.checksums && frameChecksum(data) != frame.checksum)) {
+			logTo(c.logger, slog.LevelWarn, "discarding corrupt cached frame", "frame", frame.index, "key", name)
+			store.remove(name)
+
+			continue
+		}
+
+		if ok {
+			// stores before this one are faster, such as memory before disk
+			for _, faster := range c.stores[:level] {
+				faster.put(name, data)
+			}
+
+			return data, true
+		}
+	}
+
+	return nil, false
+}
+
+// decompressFrame reads and decompresses frame from reader, appending it
+// to raw with a decoder of the pool, checking it against its checksum, if
+// any. The decoding, not the read, is counted to counters.
+func decompressFrame(reader io.ReaderAt, decoding decoderOptions, frame frameInfo, checksums bool, raw []byte, counters *archiveCounters) ([]byte, error) {
+	compressed := buffers.get(int(frame.compressedSize))
+	defer buffers.put(compressed)
+
+	_, err := reader.ReadAt(*compressed, frame.compressedOffset)
+	if err != nil {
+		return nil, fmt.Errorf("could not read frame %d: %w", frame.index, err)
+	}
+
+	decoder, err := decoders.get(decoding)
+	if err != nil {
+		return nil, err
+	}
+	defer decoders.put(decoding, decoder)
+
+	started := time.Now()
+
+	raw, err = verifyData(decoder, *compressed, raw, frame, checksums)
+	if err != nil {
+		return raw, err
+	}
+
+	counters.decoded(len(raw), started)
+
+	return raw, nil
+}
+
+// close waits for the frames being read ahead, or decompressed into a
+// region, before the archive is closed.
+func (c *cachedFrames) close() {
+	if c.ahead != nil {
+		c.ahead.close()
+	}
+
+	c.pending.Wait()
+}
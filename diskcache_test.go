@@ -0,0 +1,164 @@
+package sqlitezstd_test
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	"github.com/jtarchie/sqlitezstd/testhelper"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// cachedBytes adds up the sizes of the frames in a disk cache.
+func cachedBytes(dir string) int64 {
+	entries, err := os.ReadDir(dir)
+	Expect(err).ToNot(HaveOccurred())
+
+	total := int64(0)
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		Expect(err).ToNot(HaveOccurred())
+
+		total += info.Size()
+	}
+
+	return total
+}
+
+var _ = Describe("Disk cache", func() {
+	BeforeEach(func() {
+		Expect(sqlitezstd.Init()).To(Succeed())
+	})
+
+	It("serves frames of remote archives from disk", func() {
+		zstPath := createDatabase()
+		origin, serverURL := serveOrigin(filepath.Dir(zstPath))
+		cacheDir := GinkgoT().TempDir()
+
+		dsn := fmt.Sprintf("file:%s/%s?vfs=zstd&range_size=-1&disk_cache_dir=%s", serverURL, filepath.Base(zstPath), cacheDir)
+
+		Expect(countEntries(dsn)).To(BeEquivalentTo(1000))
+		Expect(cachedBytes(cacheDir)).To(BeNumerically(">", 0))
+
+		uncached := origin.ranges.Swap(0)
+
+		Expect(countEntries(dsn)).To(BeEquivalentTo(1000))
+		Expect(origin.ranges.Load()).To(BeNumerically("<", uncached))
+	})
+
+	It("discards frames changed on disk", func() {
+		zstPath := createDatabase()
+		origin, serverURL := serveOrigin(filepath.Dir(zstPath))
+		cacheDir := GinkgoT().TempDir()
+
+		dsn := fmt.Sprintf("file:%s/%s?vfs=zstd&range_size=-1&cache_size=0&disk_cache_dir=%s", serverURL, filepath.Base(zstPath), cacheDir)
+
+		Expect(countEntries(dsn)).To(BeEquivalentTo(1000))
+
+		entries, err := os.ReadDir(cacheDir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).ToNot(BeEmpty())
+
+		for _, entry := range entries {
+			Expect(os.Truncate(filepath.Join(cacheDir, entry.Name()), 1)).To(Succeed())
+		}
+
+		origin.ranges.Store(0)
+
+		Expect(countEntries(dsn)).To(BeEquivalentTo(1000))
+		Expect(origin.ranges.Load()).To(BeNumerically(">", 0))
+	})
+
+	It("shares frames between copies of an archive when keyed by content", func() {
+		zstPath := createDatabase()
+		copyPath := filepath.Join(filepath.Dir(zstPath), "copy.sqlite.zst")
+
+		contents, err := os.ReadFile(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.WriteFile(copyPath, contents, 0o600)).To(Succeed())
+
+		origin, serverURL := serveOrigin(filepath.Dir(zstPath))
+
+		dsn := func(path string, cacheDir string, key string) string {
+			return fmt.Sprintf("file:%s/%s?vfs=zstd&range_size=-1&disk_cache_dir=%s&disk_cache_key=%s", serverURL, filepath.Base(path), cacheDir, key)
+		}
+
+		// each location has its own frames
+		cacheDir := GinkgoT().TempDir()
+
+		Expect(countEntries(dsn(zstPath, cacheDir, "location"))).To(BeEquivalentTo(1000))
+		uncached := origin.ranges.Swap(0)
+
+		Expect(countEntries(dsn(copyPath, cacheDir, "location"))).To(BeEquivalentTo(1000))
+		Expect(origin.ranges.Swap(0)).To(Equal(uncached))
+
+		// copies of the contents share them
+		cacheDir = GinkgoT().TempDir()
+
+		Expect(countEntries(dsn(zstPath, cacheDir, "content"))).To(BeEquivalentTo(1000))
+		origin.ranges.Store(0)
+
+		Expect(countEntries(dsn(copyPath, cacheDir, "content"))).To(BeEquivalentTo(1000))
+		Expect(origin.ranges.Load()).To(BeNumerically("<", uncached))
+
+		client, err := sql.Open("sqlite3", dsn(zstPath, cacheDir, "hash"))
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(client.Close)
+
+		Expect(client.Ping()).ToNot(Succeed())
+	})
+
+	It("removes the least recently used frames", func() {
+		zstPath := testhelper.CreateEntriesDB(GinkgoT(), 2000, sqlitezstd.WithFrameSize(4096))
+		_, serverURL := serveOrigin(filepath.Dir(zstPath))
+		cacheDir := GinkgoT().TempDir()
+
+		dsn := fmt.Sprintf("file:%s/%s?vfs=zstd&disk_cache_dir=%s&disk_cache_size=%d", serverURL, filepath.Base(zstPath), cacheDir, 4*4096)
+
+		Expect(countEntries(dsn)).To(BeEquivalentTo(2000))
+		Expect(cachedBytes(cacheDir)).To(BeNumerically(">", 0))
+		Expect(cachedBytes(cacheDir)).To(BeNumerically("<=", 4*4096))
+	})
+
+	It("serves cached frames while the circuit breaker is open", func() {
+		zstPath := testhelper.CreateEntriesDB(GinkgoT(), 2000, sqlitezstd.WithFrameSize(4096))
+		origin, serverURL := serveOrigin(filepath.Dir(zstPath))
+		cacheDir := GinkgoT().TempDir()
+
+		client, err := sql.Open("sqlite3", fmt.Sprintf("file:%s/%s?vfs=zstd&range_size=-1&disk_cache_dir=%s&breaker_failures=1&breaker_cooldown=1h", serverURL, filepath.Base(zstPath), cacheDir))
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(client.Close)
+
+		// pages are read through the VFS on every query, not from SQLite
+		client.SetMaxOpenConns(1)
+		_, err = client.Exec("PRAGMA cache_size = 10")
+		Expect(err).ToNot(HaveOccurred())
+
+		length := func() (int64, error) {
+			var total int64
+			err := client.QueryRow("SELECT SUM(LENGTH(body)) FROM entries").Scan(&total)
+
+			return total, err
+		}
+
+		Expect(length()).To(BeEquivalentTo(2000 * 64))
+
+		origin.down.Store(true)
+
+		Expect(length()).To(BeEquivalentTo(2000 * 64))
+
+		entries, err := os.ReadDir(cacheDir)
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, entry := range entries {
+			Expect(os.Remove(filepath.Join(cacheDir, entry.Name()))).To(Succeed())
+		}
+
+		_, err = length()
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,39 @@
+package sqlitezstd
+
+import (
+	"database/sql"
+	"fmt"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// DriverName is the database/sql driver that opens connections able to
+// query the archives they have open, such as with zstd_vfs_source() or,
+// built with the sqlite_vtable tag, zstd_vfs_stats:
+//
+//	client, err := sql.Open(sqlitezstd.DriverName, "file:db.sqlite.zst?vfs=zstd")
+//
+// It is the go-sqlite3 driver otherwise.
+const DriverName = "sqlitezstd"
+
+//nolint: gochecknoglobals
+var (
+	// connectHooks set up every connection opened with DriverName.
+	connectHooks []func(*sqlite3.SQLiteConn) error
+)
+
+//nolint: gochecknoinits
+func init() {
+	sql.Register(DriverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			for _, hook := range connectHooks {
+				err := hook(conn)
+				if err != nil {
+					return fmt.Errorf("could not set up connection: %w", err)
+				}
+			}
+
+			return nil
+		},
+	})
+}
@@ -0,0 +1,70 @@
+package sqlitezstd
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sync"
+)
+
+const embeddedScheme = "embedded"
+
+//nolint: gochecknoglobals
+var (
+	embeddedSchemes      = map[embed.FS]string{}
+	embeddedSchemesMutex sync.Mutex
+)
+
+// OpenEmbedded opens the archive name, compiled into the binary with
+// go:embed, with the default VFS:
+//
+//	//go:embed data/lookup.sqlite.zst
+//	var data embed.FS
+//
+//	db, err := sqlitezstd.OpenEmbedded(data, "data/lookup.sqlite.zst")
+//
+// The archive is opened right away, so a missing or invalid file fails
+// here rather than on the first query.
+func OpenEmbedded(fsys embed.FS, name string) (*sql.DB, error) {
+	err := Init()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = fs.Stat(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("could not open embedded archive: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("%s://%s?vfs=zstd", registerEmbedded(fsys), name))
+	if err != nil {
+		return nil, fmt.Errorf("could not open embedded archive: %w", err)
+	}
+
+	err = db.Ping()
+	if err != nil {
+		_ = db.Close()
+
+		return nil, fmt.Errorf("could not open embedded archive: %w", err)
+	}
+
+	return db, nil
+}
+
+// registerEmbedded returns the scheme fsys is registered under,
+// registering it the first time it is opened.
+func registerEmbedded(fsys embed.FS) string {
+	embeddedSchemesMutex.Lock()
+	defer embeddedSchemesMutex.Unlock()
+
+	scheme, ok := embeddedSchemes[fsys]
+	if !ok {
+		scheme = fmt.Sprintf("%s-%d", embeddedScheme, len(embeddedSchemes))
+		embeddedSchemes[fsys] = scheme
+
+		RegisterFS(scheme, fsys)
+	}
+
+	return scheme
+}
@@ -0,0 +1,43 @@
+// Package expvar publishes the stats of sqlitezstd archives with the
+// standard library's expvar, for services that scrape /debug/vars rather
+// than run Prometheus. It is a package of its own as importing expvar
+// serves /debug/vars on http.DefaultServeMux:
+//
+//	backend := &sqlitezstd.HTTPBackend{}
+//	sqlitezstd.RegisterBackend("https", backend)
+//
+//	sqlitezstdexpvar.Publish("sqlitezstd", backend)
+package expvar
+
+import (
+	"expvar"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+)
+
+// Publish publishes the stats of every archive as the variable name, with
+// those of sqlitezstd.AllStats under "archives" and the range requests of
+// backends, from their Stats, under "http". They are gathered when read.
+// Like expvar.Publish, it panics if name is already published.
+func Publish(name string, backends ...*sqlitezstd.HTTPBackend) {
+	expvar.Publish(name, Func(backends...))
+}
+
+// Func returns the stats Publish publishes, to publish them under a map
+// of the application's own.
+func Func(backends ...*sqlitezstd.HTTPBackend) expvar.Func {
+	return func() any {
+		remote := map[string]sqlitezstd.HTTPStats{}
+
+		for _, backend := range backends {
+			for archive, stats := range backend.Stats() {
+				remote[archive] = remote[archive].Add(stats)
+			}
+		}
+
+		return map[string]any{
+			"archives": sqlitezstd.AllStats(),
+			"http":     remote,
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package expvar_test
+
+import (
+	"database/sql"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	sqlitezstdexpvar "github.com/jtarchie/sqlitezstd/expvar"
+	"github.com/jtarchie/sqlitezstd/testhelper"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestExpvar(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Expvar Suite")
+}
+
+func sumBodies(dsn string) int64 {
+	client, err := sql.Open("sqlite3", dsn)
+	Expect(err).ToNot(HaveOccurred())
+	defer client.Close()
+
+	var length int64
+	Expect(client.QueryRow("SELECT SUM(LENGTH(body)) FROM entries;").Scan(&length)).To(Succeed())
+
+	return length
+}
+
+var _ = Describe("Publish", func() {
+	It("serves the stats of archives and backends on /debug/vars", func() {
+		zstPath := testhelper.CreateEntriesDB(GinkgoT(), 1000, sqlitezstd.WithFrameSize(4096))
+
+		origin := httptest.NewServer(http.FileServer(http.Dir(filepath.Dir(zstPath))))
+		DeferCleanup(origin.Close)
+
+		backend := &sqlitezstd.HTTPBackend{}
+		sqlitezstd.RegisterBackend("http", backend)
+		DeferCleanup(sqlitezstd.RegisterBackend, "http", &sqlitezstd.HTTPBackend{})
+
+		sqlitezstdexpvar.Publish("sqlitezstd", backend)
+		Expect(func() { sqlitezstdexpvar.Publish("sqlitezstd") }).To(Panic())
+
+		archiveURL := fmt.Sprintf("%s/%s", origin.URL, filepath.Base(zstPath))
+
+		Expect(sqlitezstd.Register("zstd-expvar", &sqlitezstd.ZstdVFS{})).To(Succeed())
+		Expect(sumBodies(fmt.Sprintf("file:%s?vfs=zstd-expvar", zstPath))).To(BeEquivalentTo(1000 * 64))
+		Expect(sumBodies(fmt.Sprintf("file:%s?vfs=zstd-expvar", archiveURL))).To(BeEquivalentTo(1000 * 64))
+
+		recorder := httptest.NewRecorder()
+		expvar.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+
+		var vars struct {
+			Sqlitezstd struct {
+				Archives map[string]sqlitezstd.ArchiveStats `json:"archives"`
+				HTTP     map[string]sqlitezstd.HTTPStats    `json:"http"`
+			} `json:"sqlitezstd"`
+		}
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &vars)).To(Succeed())
+
+		archives := vars.Sqlitezstd.Archives
+		Expect(archives).To(HaveKey(zstPath))
+		Expect(archives).To(HaveKey(archiveURL))
+		Expect(archives[zstPath].Reads).To(BeNumerically(">", 0))
+		Expect(archives[zstPath].FramesDecoded).To(BeNumerically(">", 0))
+		Expect(archives[archiveURL].BytesFetched).To(BeNumerically(">", 0))
+
+		// only the remote archive made range requests
+		Expect(vars.Sqlitezstd.HTTP).To(HaveLen(1))
+		Expect(vars.Sqlitezstd.HTTP[archiveURL].Requests).To(BeNumerically(">", 0))
+		Expect(vars.Sqlitezstd.HTTP[archiveURL].Latency).To(HaveLen(len(sqlitezstd.LatencyBuckets()) + 1))
+	})
+})
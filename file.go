@@ -1,7 +1,11 @@
 package sqlitezstd
 
 import (
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"time"
 
 	seekable "github.com/SaveTheRbtz/zstd-seekable-format-go"
 	"github.com/klauspost/compress/zstd"
@@ -10,8 +14,28 @@ import (
 
 type ZstdFile struct {
 	decoder  *zstd.Decoder
+	decoding decoderOptions
 	reader   io.ReadSeeker
 	seekable seekable.Reader
+	counter  *countingReader
+	table    *parsedTable
+	// counters counts what is done for the archive, once it is open.
+	counters *archiveCounters
+	// frames, if set, reads a frame at a time, through the caches of
+	// decompressed frames.
+	frames *cachedFrames
+	// decompressed, if set, holds the whole database, decompressed at open.
+	decompressed *decompressedDatabase
+	// name and logger, once it is open, log reads failing and closing,
+	// and reads taking longer than slowRead, if set.
+	name     string
+	logger   *slog.Logger
+	slowRead time.Duration
+	// heat, if set, records the frames and pages read.
+	heat *archiveHeat
+	// handle is the name SQLite opened the archive with, by which its
+	// connection finds it, or 0.
+	handle uintptr
 }
 
 var _ sqlite3vfs.File = &ZstdFile{}
@@ -21,15 +45,81 @@ func (z *ZstdFile) CheckReservedLock() (bool, error) {
 }
 
 func (z *ZstdFile) Close() error {
-	_ = z.seekable.Close()
+	if z.frames != nil {
+		z.frames.close()
+	}
+
+	if z.decompressed != nil {
+		z.decompressed.release()
+		z.decompressed = nil
+	}
+
+	// files failing to open have no seekable reader yet
+	if z.seekable != nil {
+		_ = z.seekable.Close()
+	}
+
+	if z.decoder != nil {
+		decoders.put(z.decoding, z.decoder)
+		z.decoder = nil
+	}
 
 	if closer, ok := z.reader.(io.Closer); ok {
 		_ = closer.Close()
 	}
 
+	untrackConnection(z)
+
+	z.counters.opened(-1)
+	z.counters = nil
+
+	if z.logger != nil {
+		logTo(z.logger, slog.LevelDebug, "closed archive", "name", logName(z.name))
+		z.logger = nil
+	}
+
 	return nil
 }
 
+// zstdDecoder returns the decoder of the file, taken from the pool on first
+// use when it was opened without one.
+func (z *ZstdFile) zstdDecoder() (*zstd.Decoder, error) {
+	if z.decoder == nil {
+		decoder, err := decoders.get(z.decoding)
+		if err != nil {
+			return nil, err
+		}
+
+		z.decoder = decoder
+	}
+
+	return z.decoder, nil
+}
+
+// lazyDecoder decodes the frames of a file opened with ColdStart, which
+// takes its decoder from the pool on the first frame rather than at open.
+type lazyDecoder struct {
+	file *ZstdFile
+}
+
+func (l lazyDecoder) DecodeAll(input []byte, dst []byte) ([]byte, error) {
+	decoder, err := l.file.zstdDecoder()
+	if err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+
+	data, err := decoder.DecodeAll(input, dst)
+	if err != nil {
+		return data, fmt.Errorf("%w: %w", ErrCorruptFrame, err)
+	}
+
+	l.file.counters.decoded(len(data)-len(dst), started)
+
+	return data, nil
+}
+
 func (z *ZstdFile) DeviceCharacteristics() sqlite3vfs.DeviceCharacteristic {
 	return sqlite3vfs.IocapImmutable
 }
@@ -43,6 +133,70 @@ func (z *ZstdFile) Lock(elock sqlite3vfs.LockType) error {
 }
 
 func (z *ZstdFile) ReadAt(p []byte, off int64) (int, error) {
+	if z.slowRead > 0 && z.logger != nil {
+		defer z.logSlowRead(len(p), off, time.Now(), z.counters.snapshot())
+	}
+
+	fetched := z.counter.fetched.Load()
+	count, err := z.readAt(p, off)
+	hit := z.counter.fetched.Load() == fetched
+
+	// short reads past the end of the database are expected
+	if err != nil && !errors.Is(err, io.EOF) {
+		logTo(z.logger, slog.LevelError, "could not read archive", "name", logName(z.name), "offset", off, "length", len(p), "error", err)
+	}
+
+	z.counters.read(count, hit)
+	z.heat.read(p[:count], off)
+
+	stats := z.counter.stats
+	if stats == nil {
+		return count, err
+	}
+
+	stats.reads.Add(1)
+	stats.bytesRead.Add(int64(count))
+
+	if hit {
+		stats.hits.Add(1)
+	}
+
+	return count, err
+}
+
+// logSlowRead logs a read of length bytes at off, from started, if it
+// took longer than slowRead, with what the connection fetched and
+// decompressed since before, including any frames read ahead meanwhile.
+func (z *ZstdFile) logSlowRead(length int, off int64, started time.Time, before ArchiveStats) {
+	elapsed := time.Since(started)
+	if elapsed < z.slowRead {
+		return
+	}
+
+	after := z.counters.snapshot()
+
+	logTo(z.logger, slog.LevelWarn, "slow read",
+		"name", logName(z.name),
+		"offset", off,
+		"length", length,
+		"frame", z.table.frameFor(off),
+		"elapsed", elapsed,
+		"fetch", after.FetchTime-before.FetchTime,
+		"fetched", after.BytesFetched-before.BytesFetched,
+		"decode", after.DecodeTime-before.DecodeTime,
+		"frames_decoded", after.FramesDecoded-before.FramesDecoded,
+	)
+}
+
+func (z *ZstdFile) readAt(p []byte, off int64) (int, error) {
+	if z.decompressed != nil {
+		return z.decompressed.ReadAt(p, off)
+	}
+
+	if z.frames != nil {
+		return z.frames.ReadAt(z.seekable, p, off)
+	}
+
 	return z.seekable.ReadAt(p, off)
 }
 
@@ -0,0 +1,49 @@
+package sqlitezstd
+
+import "sync"
+
+// flightGroup collapses concurrent reads of the same frame, such as by
+// connections running the same query, into one fetch and decompression,
+// whose result every reader shares.
+type flightGroup struct {
+	mutex   sync.Mutex
+	flights map[string]*flight
+}
+
+type flight struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+//nolint: gochecknoglobals
+var frameFlights = &flightGroup{flights: map[string]*flight{}}
+
+// do returns the result of read for name, calling it unless a read of
+// name is already in flight, in which case it waits for that one.
+func (g *flightGroup) do(name string, read func() ([]byte, error)) ([]byte, error) {
+	g.mutex.Lock()
+
+	if current, ok := g.flights[name]; ok {
+		g.mutex.Unlock()
+
+		<-current.done
+
+		return current.data, current.err
+	}
+
+	current := &flight{done: make(chan struct{})}
+	g.flights[name] = current
+
+	g.mutex.Unlock()
+
+	current.data, current.err = read()
+
+	g.mutex.Lock()
+	delete(g.flights, name)
+	g.mutex.Unlock()
+
+	close(current.done)
+
+	return current.data, current.err
+}
@@ -0,0 +1,148 @@
+package sqlitezstd
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+)
+
+// frameStore keeps decompressed frames by name, such as in memory or
+// on disk.
+type frameStore interface {
+	get(name string) ([]byte, bool)
+	put(name string, data []byte)
+	remove(name string)
+	// trusted reports whether the frames kept never left the process,
+	// so they are not checked against the seek table again.
+	trusted() bool
+}
+
+var (
+	_ frameStore = &frameCache{}
+	_ frameStore = &diskCache{}
+)
+
+// frameCache keeps decompressed frames in memory, shared by every
+// connection of a VFS, so hot frames are decompressed once. Once the
+// frames add up to more than size bytes, the least recently used are
+// dropped.
+type frameCache struct {
+	size int64
+
+	mutex sync.Mutex
+	// used holds the frames, least recently used first.
+	used    *list.List
+	entries map[string]*list.Element
+	total   int64
+	// owners are the metrics of the archives with stats, by the key
+	// their frames are named with.
+	owners map[string]*cacheMetrics
+}
+
+type cachedFrame struct {
+	name    string
+	data    []byte
+	metrics *cacheMetrics
+}
+
+func newFrameCache(size int64) *frameCache {
+	return &frameCache{
+		size:    size,
+		used:    list.New(),
+		entries: map[string]*list.Element{},
+		owners:  map[string]*cacheMetrics{},
+	}
+}
+
+// track counts the frames of the archive named with key in metrics.
+func (f *frameCache) track(key string, metrics *cacheMetrics) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.owners[key] = metrics
+}
+
+// get returns the frame stored under name, if any. It must not be changed.
+func (f *frameCache) get(name string) ([]byte, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	element, ok := f.entries[name]
+	if !ok {
+		return nil, false
+	}
+
+	f.used.MoveToBack(element)
+
+	frame, _ := element.Value.(*cachedFrame)
+
+	return frame.data, true
+}
+
+// trusted is true, as only frames already checked are kept in memory.
+func (f *frameCache) trusted() bool {
+	return true
+}
+
+// put stores a frame under name, then drops the least recently used
+// frames over the size of the cache.
+func (f *frameCache) put(name string, data []byte) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if int64(len(data)) > f.size {
+		return
+	}
+
+	if element, ok := f.entries[name]; ok {
+		frame, _ := element.Value.(*cachedFrame)
+		f.total -= int64(len(frame.data))
+		frame.metrics.cached(-len(frame.data))
+		frame.data = data
+		frame.metrics.cached(len(data))
+		f.used.MoveToBack(element)
+	} else {
+		// frames are named after the key of their archive
+		key, _, _ := strings.Cut(name, "-")
+		frame := &cachedFrame{name: name, data: data, metrics: f.owners[key]}
+		frame.metrics.cached(len(data))
+		f.entries[name] = f.used.PushBack(frame)
+	}
+
+	f.total += int64(len(data))
+
+	f.evict()
+}
+
+// resize changes the size of the cache, dropping frames to fit.
+func (f *frameCache) resize(size int64) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.size = size
+	f.evict()
+}
+
+// evict drops frames, least recently used first, until the cache
+// fits. The mutex is held.
+func (f *frameCache) evict() {
+	for f.total > f.size && f.used.Len() > 0 {
+		frame, _ := f.used.Remove(f.used.Front()).(*cachedFrame)
+		f.total -= int64(len(frame.data))
+		frame.metrics.evict(len(frame.data))
+		delete(f.entries, frame.name)
+	}
+}
+
+// remove drops a frame that turned out to be invalid.
+func (f *frameCache) remove(name string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if element, ok := f.entries[name]; ok {
+		frame, _ := f.used.Remove(element).(*cachedFrame)
+		f.total -= int64(len(frame.data))
+		frame.metrics.cached(-len(frame.data))
+		delete(f.entries, name)
+	}
+}
@@ -0,0 +1,99 @@
+package sqlitezstd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+var ErrNotSeekable = errors.New("file can not be read at an offset")
+
+// RegisterFS opens archives named scheme://path from fsys, such as an
+// embed.FS compiled into the binary:
+//
+//	//go:embed data/reference.sqlite.zst
+//	var data embed.FS
+//
+//	sqlitezstd.RegisterFS("embed", data)
+//	db, err := sql.Open("sqlite3", "embed://data/reference.sqlite.zst?vfs=zstd")
+//
+// Files must implement io.ReaderAt or io.Seeker, as those of embed.FS,
+// os.DirFS, and fstest.MapFS do.
+func RegisterFS(scheme string, fsys fs.FS) {
+	RegisterBackend(scheme, &fsBackend{fsys: fsys})
+}
+
+type fsBackend struct {
+	fsys fs.FS
+}
+
+func (f *fsBackend) Open(uri *url.URL) (Object, error) {
+	name := strings.Trim(uri.Host+uri.Path, "/")
+
+	file, err := f.fsys.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not open file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+
+		return nil, fmt.Errorf("could not stat file: %w", err)
+	}
+
+	object := &fsObject{file: file, size: info.Size()}
+	object.reader, _ = file.(io.ReaderAt)
+	object.seeker, _ = file.(io.ReadSeeker)
+
+	if object.reader == nil && object.seeker == nil {
+		_ = file.Close()
+
+		return nil, fmt.Errorf("%w: %s", ErrNotSeekable, name)
+	}
+
+	return object, nil
+}
+
+// fsObject is a file of an fs.FS, read at an offset by seeking
+// if it does not implement io.ReaderAt.
+type fsObject struct {
+	mutex  sync.Mutex
+	file   fs.File
+	reader io.ReaderAt
+	seeker io.ReadSeeker
+	size   int64
+}
+
+func (f *fsObject) ReadAt(p []byte, off int64) (int, error) {
+	if f.reader != nil {
+		return f.reader.ReadAt(p, off)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	_, err := f.seeker.Seek(off, io.SeekStart)
+	if err != nil {
+		return 0, fmt.Errorf("could not seek: %w", err)
+	}
+
+	count, err := io.ReadFull(f.seeker, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return count, io.EOF
+	}
+
+	return count, err
+}
+
+func (f *fsObject) Size() int64 {
+	return f.size
+}
+
+func (f *fsObject) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,212 @@
+// Package ftp reads sqlitezstd archives from FTP servers, where many
+// scientific datasets are still published. Importing it registers backends
+// for ftp://host/path and ftps://host/path names:
+//
+//	import _ "github.com/jtarchie/sqlitezstd/ftp"
+//
+//	db, err := sql.Open("sqlite3", "ftp://ftp.example.org/pub/db.sqlite.zst?vfs=zstd")
+//
+// Ranges are read by restarting the transfer at an offset with REST, and
+// stopping it once enough has been read. Without credentials in the name,
+// the anonymous account is used. ftps:// names use implicit TLS, on port 990
+// by default, while ftp:// names with tls=true upgrade the connection with
+// AUTH TLS.
+package ftp
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+)
+
+const (
+	defaultPort    = "21"
+	defaultTLSPort = "990"
+	dialTimeout    = 30 * time.Second
+	shutTimeout    = 10 * time.Second
+)
+
+var ErrInvalidURL = errors.New("invalid ftp url")
+
+//nolint: gochecknoinits
+func init() {
+	sqlitezstd.RegisterBackend("ftp", &Backend{})
+	sqlitezstd.RegisterBackend("ftps", &Backend{})
+}
+
+// Backend opens ftp:// and ftps:// names, with a control connection per archive.
+type Backend struct {
+	// TLSConfig is used for ftps:// names and tls=true, defaulting
+	// to verifying the certificate of the host.
+	TLSConfig *tls.Config
+}
+
+var _ sqlitezstd.Backend = &Backend{}
+
+func (b *Backend) Open(uri *url.URL) (sqlitezstd.Object, error) {
+	if uri.Host == "" || uri.Path == "" || uri.Path == "/" {
+		return nil, fmt.Errorf("%w: %s needs a host and a path", ErrInvalidURL, uri.Redacted())
+	}
+
+	target := &object{
+		address: uri.Host,
+		path:    uri.Path,
+		user:    "anonymous",
+		pass:    "anonymous",
+	}
+
+	if uri.User != nil {
+		target.user = uri.User.Username()
+		target.pass, _ = uri.User.Password()
+	}
+
+	implicit := uri.Scheme == "ftps"
+
+	explicit, _ := strconv.ParseBool(uri.Query().Get("tls"))
+	if implicit || explicit {
+		config := &tls.Config{MinVersion: tls.VersionTLS12}
+		if b.TLSConfig != nil {
+			config = b.TLSConfig.Clone()
+		}
+
+		if config.ServerName == "" {
+			config.ServerName = uri.Hostname()
+		}
+
+		if implicit {
+			target.options = append(target.options, ftp.DialWithTLS(config))
+		} else {
+			target.options = append(target.options, ftp.DialWithExplicitTLS(config))
+		}
+	}
+
+	if uri.Port() == "" {
+		port := defaultPort
+		if implicit {
+			port = defaultTLSPort
+		}
+
+		target.address = net.JoinHostPort(uri.Hostname(), port)
+	}
+
+	target.options = append(target.options, ftp.DialWithTimeout(dialTimeout), ftp.DialWithShutTimeout(shutTimeout))
+
+	err := target.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	target.size, err = target.conn.FileSize(target.path)
+	if err != nil {
+		_ = target.Close()
+
+		return nil, fmt.Errorf("could not stat %s: %w", target.path, err)
+	}
+
+	return target, nil
+}
+
+// object is a file on an FTP server. The control connection handles one
+// transfer at a time, so reads are serialized, and a connection left in an
+// unknown state by an interrupted transfer is replaced.
+type object struct {
+	mutex   sync.Mutex
+	address string
+	path    string
+	user    string
+	pass    string
+	options []ftp.DialOption
+	conn    *ftp.ServerConn
+	size    int64
+}
+
+func (o *object) connect() error {
+	conn, err := ftp.Dial(o.address, o.options...)
+	if err != nil {
+		return fmt.Errorf("could not connect to %s: %w", o.address, err)
+	}
+
+	err = conn.Login(o.user, o.pass)
+	if err != nil {
+		_ = conn.Quit()
+
+		return fmt.Errorf("could not log in to %s: %w", o.address, err)
+	}
+
+	o.conn = conn
+
+	return nil
+}
+
+func (o *object) ReadAt(p []byte, off int64) (int, error) {
+	if off >= o.size {
+		return 0, io.EOF
+	}
+
+	end := min(off+int64(len(p)), o.size)
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.conn == nil {
+		err := o.connect()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	//nolint: gosec
+	response, err := o.conn.RetrFrom(o.path, uint64(off))
+	if err != nil {
+		o.disconnect()
+
+		return 0, fmt.Errorf("could not read %s: %w", o.path, err)
+	}
+
+	count, err := io.ReadFull(response, p[:end-off])
+
+	// stopping a transfer early may leave replies the next command
+	// would read, so the connection is only kept after a clean close
+	if response.Close() != nil {
+		o.disconnect()
+	}
+
+	if err != nil {
+		return count, fmt.Errorf("could not read %s: %w", o.path, err)
+	}
+
+	if count < len(p) {
+		return count, io.EOF
+	}
+
+	return count, nil
+}
+
+func (o *object) disconnect() {
+	_ = o.conn.Quit()
+	o.conn = nil
+}
+
+func (o *object) Size() int64 {
+	return o.size
+}
+
+func (o *object) Close() error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.conn != nil {
+		o.disconnect()
+	}
+
+	return nil
+}
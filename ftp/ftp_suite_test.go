@@ -0,0 +1,195 @@
+package ftp_test
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	_ "github.com/jtarchie/sqlitezstd/ftp"
+	"github.com/jtarchie/sqlitezstd/testhelper"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestFTP(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "FTP Suite")
+}
+
+// server is a fake FTP server with passive mode and REST, serving files
+// to user and password, or to anonymous users.
+type server struct {
+	files    map[string][]byte
+	restarts atomic.Int64
+}
+
+// serve listens on a local port, returning its address.
+func (s *server) serve() string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		Skip(fmt.Sprintf("FTP is unavailable: %v", err))
+	}
+	DeferCleanup(listener.Close)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go s.handle(conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func (s *server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(format string, args ...any) {
+		_, _ = fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+
+	var (
+		user    string
+		offset  int64
+		passive net.Listener
+	)
+
+	reply("220 ready")
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		command, argument, _ := strings.Cut(strings.TrimSpace(line), " ")
+
+		switch strings.ToUpper(command) {
+		case "USER":
+			user = argument
+			reply("331 password required")
+		case "PASS":
+			if user == "anonymous" || (user == "user" && argument == "password") {
+				reply("230 logged in")
+			} else {
+				reply("530 login incorrect")
+			}
+		case "FEAT":
+			reply("211-Features:\r\n SIZE\r\n REST STREAM\r\n211 End")
+		case "TYPE":
+			reply("200 type set")
+		case "SIZE":
+			contents, ok := s.files[argument]
+			if !ok {
+				reply("550 no such file")
+
+				continue
+			}
+
+			reply("213 %d", len(contents))
+		case "EPSV":
+			passive, err = net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				reply("425 can not open data connection")
+
+				continue
+			}
+
+			//nolint: forcetypeassert
+			reply("229 Entering Extended Passive Mode (|||%d|)", passive.Addr().(*net.TCPAddr).Port)
+		case "REST":
+			offset, _ = strconv.ParseInt(argument, 10, 64)
+			s.restarts.Add(1)
+			reply("350 restarting at %d", offset)
+		case "RETR":
+			contents, ok := s.files[argument]
+			if !ok || passive == nil {
+				reply("550 no such file")
+
+				continue
+			}
+
+			reply("150 opening data connection")
+
+			data, err := passive.Accept()
+			if err == nil {
+				_, _ = data.Write(contents[min(offset, int64(len(contents))):])
+				_ = data.Close()
+			}
+
+			_ = passive.Close()
+			passive, offset = nil, 0
+
+			reply("226 transfer complete")
+		case "QUIT":
+			reply("221 bye")
+
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func countEntries(dsn string) (int64, error) {
+	client, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	var count int64
+	err = client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)
+
+	return count, err
+}
+
+var _ = Describe("Backend", func() {
+	var (
+		fake    *server
+		address string
+	)
+
+	BeforeEach(func() {
+		Expect(sqlitezstd.Init()).To(Succeed())
+
+		contents, err := os.ReadFile(testhelper.CreateEntriesDB(GinkgoT(), 1000))
+		Expect(err).ToNot(HaveOccurred())
+
+		fake = &server{files: map[string][]byte{"/pub/db.zst": contents}}
+		address = fake.serve()
+	})
+
+	It("reads archives with ranged retrievals", func() {
+		count, err := countEntries(fmt.Sprintf("ftp://user:password@%s/pub/db.zst?vfs=zstd", address))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+		Expect(fake.restarts.Load()).To(BeNumerically(">", 0))
+	})
+
+	It("logs in anonymously without credentials", func() {
+		count, err := countEntries(fmt.Sprintf("ftp://%s/pub/db.zst?vfs=zstd", address))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+	})
+
+	It("errors for wrong credentials and missing files", func() {
+		_, err := sqlitezstd.Inspect(fmt.Sprintf("ftp://user:wrong@%s/pub/db.zst", address))
+		Expect(err).To(MatchError(ContainSubstring("could not log in")))
+
+		_, err = sqlitezstd.Inspect(fmt.Sprintf("ftp://%s/pub/missing.zst", address))
+		Expect(err).To(MatchError(ContainSubstring("could not stat")))
+	})
+})
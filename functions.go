@@ -0,0 +1,102 @@
+package sqlitezstd
+
+import (
+	"runtime/debug"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// modulePath is the module of this package, as built into binaries.
+const modulePath = "github.com/jtarchie/sqlitezstd"
+
+//nolint: gochecknoinits
+func init() {
+	connectHooks = append(connectHooks, registerFunctions)
+}
+
+// registerFunctions makes the functions describing the archives of conn
+// available to it. Each takes the schema of an archive, main by default,
+// and returns NULL for schemas that are not archives:
+//
+//	SELECT zstd_vfs_version(), zstd_vfs_source(), zstd_vfs_ratio('other')
+func registerFunctions(conn *sqlite3.SQLiteConn) error {
+	err := conn.RegisterFunc("zstd_vfs_version", version, true)
+	if err != nil {
+		return err
+	}
+
+	err = conn.RegisterFunc("zstd_vfs_source", func(schema ...string) any {
+		file := schemaFile(conn, schema)
+		if file == nil {
+			return nil
+		}
+
+		return logName(file.name)
+	}, false)
+	if err != nil {
+		return err
+	}
+
+	return conn.RegisterFunc("zstd_vfs_ratio", func(schema ...string) any {
+		file := schemaFile(conn, schema)
+		if file == nil {
+			return nil
+		}
+
+		reader, _ := file.reader.(*archive)
+		if reader == nil || reader.Size() == 0 {
+			return nil
+		}
+
+		return float64(file.table.decompressedSize) / float64(reader.Size())
+	}, false)
+}
+
+// schemaFile returns the archive conn has open as the schema given, or
+// main, or nil.
+func schemaFile(conn *sqlite3.SQLiteConn, schema []string) *ZstdFile {
+	name := "main"
+	if len(schema) > 0 {
+		name = schema[0]
+	}
+
+	for _, open := range connectionFilesOf(conn) {
+		if open.schema == name {
+			return open.file
+		}
+	}
+
+	return nil
+}
+
+// version is the version of this package built into the binary, or
+// (devel) when it is not known, such as when built from a checkout.
+func version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+
+	var module *debug.Module
+
+	if info.Main.Path == modulePath {
+		module = &info.Main
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			module = dep
+		}
+	}
+
+	// a module replaced by a local directory has no version
+	if module != nil && module.Replace != nil {
+		module = module.Replace
+	}
+
+	if module == nil || module.Version == "" {
+		return "(devel)"
+	}
+
+	return module.Version
+}
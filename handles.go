@@ -0,0 +1,184 @@
+package sqlitezstd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// sharedArchive is an archive opened once for every connection reading
+// it at the same time, such as the connections database/sql opens to a
+// DSN, so they share its HTTP session and ranges, or its mapping, rather
+// than opening it again each. It is closed once the last of them closes.
+type sharedArchive struct {
+	*archive
+
+	name  string
+	users int
+	// version is that of a local file when it was opened, so a file
+	// replaced since is opened again rather than shared.
+	version localFileVersion
+}
+
+// localFileVersion identifies the contents of a local archive, or of a
+// member of a local container, by the size and modification time of the
+// file, and where the member starts in it.
+type localFileVersion struct {
+	size     int64
+	modified time.Time
+	offset   int64
+}
+
+//nolint: gochecknoglobals
+var (
+	sharedArchivesMutex sync.Mutex
+	sharedArchives      = map[string]*sharedArchive{}
+)
+
+// openSharedArchive opens name, or shares it with the connections that
+// already have it open. Every connection reads through an archive of
+// its own, whose Close releases the shared one.
+func openSharedArchive(name string) (*archive, error) {
+	version, local, err := localVersion(name)
+	if err != nil {
+		return nil, err
+	}
+
+	sharedArchivesMutex.Lock()
+
+	shared, ok := sharedArchives[name]
+	if ok && (!local || shared.version.equal(version)) {
+		shared.users++
+		sharedArchivesMutex.Unlock()
+
+		return shared.view(), nil
+	}
+
+	sharedArchivesMutex.Unlock()
+
+	opened, err := openArchive(name)
+	if err != nil {
+		return nil, err
+	}
+
+	shared = &sharedArchive{archive: opened, name: name, users: 1, version: version}
+
+	// connections opening the archive at once share the first one stored
+	sharedArchivesMutex.Lock()
+
+	if current, ok := sharedArchives[name]; ok && current.identity == opened.identity && opened.identity != "" {
+		current.users++
+		sharedArchivesMutex.Unlock()
+
+		_ = opened.Close()
+
+		return current.view(), nil
+	}
+
+	sharedArchives[name] = shared
+	sharedArchivesMutex.Unlock()
+
+	return shared.view(), nil
+}
+
+// localVersion returns the version of name, if it is a local file or a
+// member of one. A local file that can not be found is an error, rather
+// than shared with the connections that opened it before.
+func localVersion(name string) (localFileVersion, bool, error) {
+	path := name
+
+	container, member, isMember := splitMember(name)
+	if isMember {
+		path = container
+	}
+
+	if isRemote(path) {
+		return localFileVersion{}, false, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return localFileVersion{}, true, fmt.Errorf("could not stat file: %w", err)
+	}
+
+	version := localFileVersion{size: info.Size(), modified: info.ModTime()}
+
+	if isMember {
+		reader, _, closer, err := openMember(container, member)
+		if err != nil {
+			return localFileVersion{}, true, err
+		}
+		defer closer.Close()
+
+		if section, ok := reader.(*io.SectionReader); ok {
+			_, version.offset, _ = section.Outer()
+		}
+	}
+
+	return version, true, nil
+}
+
+func (v localFileVersion) equal(other localFileVersion) bool {
+	return v.size == other.size && v.modified.Equal(other.modified) && v.offset == other.offset
+}
+
+// view returns an archive reading s, with an offset of its own for the
+// reads and seeks of one connection.
+func (s *sharedArchive) view() *archive {
+	return &archive{
+		SectionReader: io.NewSectionReader(s, 0, s.Size()),
+		closers:       []io.Closer{&releaser{shared: s}},
+		identity:      s.identity,
+	}
+}
+
+// ReadAt reads the shared archive, no longer sharing it once it changed
+// remotely, so the connections opened after read the new version.
+func (s *sharedArchive) ReadAt(p []byte, off int64) (int, error) {
+	count, err := s.archive.ReadAt(p, off)
+	if errors.Is(err, ErrArchiveChanged) || errors.Is(err, ErrSizeChanged) {
+		sharedArchivesMutex.Lock()
+		if sharedArchives[s.name] == s {
+			delete(sharedArchives, s.name)
+		}
+		sharedArchivesMutex.Unlock()
+	}
+
+	return count, err
+}
+
+// release closes the shared archive once the last connection to it
+// closes.
+func (s *sharedArchive) release() {
+	sharedArchivesMutex.Lock()
+
+	s.users--
+	if s.users > 0 {
+		sharedArchivesMutex.Unlock()
+
+		return
+	}
+
+	if sharedArchives[s.name] == s {
+		delete(sharedArchives, s.name)
+	}
+
+	sharedArchivesMutex.Unlock()
+
+	_ = s.archive.Close()
+}
+
+// releaser releases its shared archive once, however often it is closed.
+type releaser struct {
+	shared *sharedArchive
+	once   sync.Once
+}
+
+func (r *releaser) Close() error {
+	r.once.Do(r.shared.release)
+
+	return nil
+}
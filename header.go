@@ -0,0 +1,88 @@
+package sqlitezstd
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	headerTag     = 0x5
+	maxHeaderSize = 64 * 1024
+)
+
+var ErrNoHeader = errors.New("file has no sqlitezstd header")
+
+// Header describes how a file was laid out by NewWriter. It is stored in a
+// skippable frame at the very start of the file, so other zstd tools ignore it.
+type Header struct {
+	// PageSize is the SQLite page size found in the source, or 0 if the source
+	// was not recognized as a SQLite database.
+	PageSize int `json:"page_size"`
+	// FrameSize is the uncompressed size of every frame but the last,
+	// or their average size if ContentDefined is set.
+	FrameSize int `json:"frame_size"`
+	// PageAligned reports whether every frame starts on a page boundary.
+	PageAligned bool `json:"page_aligned"`
+	// Deterministic reports whether the file was written with WithDeterministic.
+	Deterministic bool `json:"deterministic,omitempty"`
+	// ContentDefined reports whether frames were cut by content, see
+	// WithContentDefinedChunking.
+	ContentDefined bool `json:"content_defined,omitempty"`
+}
+
+func (h *Header) frame() ([]byte, error) {
+	payload, err := json.Marshal(h)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode header: %w", err)
+	}
+
+	return skippableFrame(headerTag, payload), nil
+}
+
+// ReadHeader returns the header written at the start of a compressed file.
+func ReadHeader(path string) (*Header, error) {
+	file, err := openArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return readHeader(file)
+}
+
+func readHeader(reader io.ReaderAt) (*Header, error) {
+	prefix := make([]byte, skippableHeaderSize)
+
+	_, err := reader.ReadAt(prefix, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not read header: %w", err)
+	}
+
+	if binary.LittleEndian.Uint32(prefix[0:4]) != skippableFrameMagic+headerTag {
+		return nil, ErrNoHeader
+	}
+
+	size := binary.LittleEndian.Uint32(prefix[4:8])
+	if size > maxHeaderSize {
+		return nil, fmt.Errorf("%w: size %d is too large", ErrNoHeader, size)
+	}
+
+	payload := make([]byte, size)
+
+	_, err = reader.ReadAt(payload, skippableHeaderSize)
+	if err != nil {
+		return nil, fmt.Errorf("could not read header: %w", err)
+	}
+
+	header := &Header{}
+
+	err = json.Unmarshal(payload, header)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode header: %w", err)
+	}
+
+	return header, nil
+}
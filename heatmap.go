@@ -0,0 +1,233 @@
+package sqlitezstd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Heatmap records which frames and pages of archives SQLite reads through
+// a ZstdVFS, and how often. Set ZstdVFS.Heatmap for a session, such as a
+// replay of production queries, then export it with WriteJSON or WriteCSV
+// to see which tables to keep together and which frame size suits them.
+type Heatmap struct {
+	mutex    sync.Mutex
+	archives map[string]*archiveHeat
+}
+
+// ArchiveHeat is how often the frames and pages of an archive were read.
+type ArchiveHeat struct {
+	// PageSize is the page size of the database, 0 until its header is read.
+	PageSize int `json:"page_size"`
+	// Frames are the frames read, in order, numbered as in the seek table.
+	Frames []FrameHeat `json:"frames"`
+	// Pages are the pages read, in order, numbered from 1 as SQLite does.
+	Pages []PageHeat `json:"pages"`
+}
+
+// FrameHeat is how often a frame was read.
+type FrameHeat struct {
+	Frame              int   `json:"frame"`
+	DecompressedOffset int64 `json:"decompressed_offset"`
+	DecompressedSize   int64 `json:"decompressed_size"`
+	Reads              int64 `json:"reads"`
+}
+
+// PageHeat is how often a page was read.
+type PageHeat struct {
+	Page  int64 `json:"page"`
+	Reads int64 `json:"reads"`
+}
+
+// Archives returns the heat of every archive read, by its name without
+// its query.
+func (h *Heatmap) Archives() map[string]ArchiveHeat {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	archives := make(map[string]ArchiveHeat, len(h.archives))
+
+	for name, heat := range h.archives {
+		archive := ArchiveHeat{
+			PageSize: heat.pageSize,
+			Frames:   make([]FrameHeat, 0, len(heat.frames)),
+			Pages:    make([]PageHeat, 0, len(heat.pages)),
+		}
+
+		for index, reads := range heat.frames {
+			frame := heat.table.frames[index]
+
+			archive.Frames = append(archive.Frames, FrameHeat{
+				Frame:              index,
+				DecompressedOffset: frame.decompressedOffset,
+				DecompressedSize:   int64(frame.decompressedSize),
+				Reads:              reads,
+			})
+		}
+
+		for page, reads := range heat.pages {
+			archive.Pages = append(archive.Pages, PageHeat{Page: page, Reads: reads})
+		}
+
+		sort.Slice(archive.Frames, func(i, j int) bool { return archive.Frames[i].Frame < archive.Frames[j].Frame })
+		sort.Slice(archive.Pages, func(i, j int) bool { return archive.Pages[i].Page < archive.Pages[j].Page })
+
+		archives[name] = archive
+	}
+
+	return archives
+}
+
+// Reset forgets every read, to start another session.
+func (h *Heatmap) Reset() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, heat := range h.archives {
+		heat.frames = map[int]int64{}
+		heat.pages = map[int64]int64{}
+	}
+}
+
+// WriteJSON writes the heat of every archive as a JSON object by name.
+func (h *Heatmap) WriteJSON(w io.Writer) error {
+	err := json.NewEncoder(w).Encode(h.Archives())
+	if err != nil {
+		return fmt.Errorf("could not write heatmap: %w", err)
+	}
+
+	return nil
+}
+
+// WriteCSV writes the heat of every archive as CSV, a row for every
+// frame and page read, with the decompressed bytes it covers:
+//
+//	archive,kind,index,offset,size,reads
+//	db.sqlite.zst,frame,1,0,65536,12
+//	db.sqlite.zst,page,1,0,4096,3
+func (h *Heatmap) WriteCSV(w io.Writer) error {
+	archives := h.Archives()
+
+	names := make([]string, 0, len(archives))
+	for name := range archives {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	writer := csv.NewWriter(w)
+	_ = writer.Write([]string{"archive", "kind", "index", "offset", "size", "reads"})
+
+	for _, name := range names {
+		archive := archives[name]
+
+		for _, frame := range archive.Frames {
+			_ = writer.Write([]string{
+				name, "frame", strconv.Itoa(frame.Frame),
+				strconv.FormatInt(frame.DecompressedOffset, 10),
+				strconv.FormatInt(frame.DecompressedSize, 10),
+				strconv.FormatInt(frame.Reads, 10),
+			})
+		}
+
+		pageSize := int64(archive.PageSize)
+
+		for _, page := range archive.Pages {
+			_ = writer.Write([]string{
+				name, "page", strconv.FormatInt(page.Page, 10),
+				strconv.FormatInt((page.Page-1)*pageSize, 10),
+				strconv.FormatInt(pageSize, 10),
+				strconv.FormatInt(page.Reads, 10),
+			})
+		}
+	}
+
+	writer.Flush()
+
+	err := writer.Error()
+	if err != nil {
+		return fmt.Errorf("could not write heatmap: %w", err)
+	}
+
+	return nil
+}
+
+// archive returns the heat shared by every connection to the archive
+// name, or nil without a heatmap.
+func (h *Heatmap) archive(name string, table *parsedTable) *archiveHeat {
+	if h == nil {
+		return nil
+	}
+
+	location, _, _ := strings.Cut(name, "?")
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.archives == nil {
+		h.archives = map[string]*archiveHeat{}
+	}
+
+	heat, ok := h.archives[location]
+	if !ok || !bytes.Equal(heat.table.raw, table.raw) {
+		// an archive changed since is counted afresh
+		heat = &archiveHeat{heatmap: h, table: table, frames: map[int]int64{}, pages: map[int64]int64{}}
+		h.archives[location] = heat
+	}
+
+	return heat
+}
+
+// archiveHeat counts the reads of the frames and pages of an archive. Its
+// methods do nothing on nil, for archives read without a heatmap.
+type archiveHeat struct {
+	heatmap *Heatmap
+	table   *parsedTable
+	// pageSize is learnt from the database header.
+	pageSize int
+	frames   map[int]int64
+	pages    map[int64]int64
+}
+
+// read counts the bytes p read at off, in every frame and page they
+// cover.
+func (a *archiveHeat) read(p []byte, off int64) {
+	if a == nil || len(p) == 0 {
+		return
+	}
+
+	a.heatmap.mutex.Lock()
+	defer a.heatmap.mutex.Unlock()
+
+	if off == 0 && len(p) >= sqliteHeaderSize && a.pageSize == 0 {
+		pageSize, err := parsePageSize(p)
+		if err == nil {
+			a.pageSize = pageSize
+		}
+	}
+
+	last := off + int64(len(p)) - 1
+
+	for index, end := a.table.frameFor(off), a.table.frameFor(last); index >= 0 && index <= end; index++ {
+		// the header and metadata frames hold no contents
+		if a.table.frames[index].decompressedSize > 0 {
+			a.frames[index]++
+		}
+	}
+
+	if a.pageSize == 0 {
+		return
+	}
+
+	pageSize := int64(a.pageSize)
+
+	for page := off/pageSize + 1; page <= last/pageSize+1; page++ {
+		a.pages[page]++
+	}
+}
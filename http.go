@@ -0,0 +1,2065 @@
+package sqlitezstd
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// defaultRangeSize is the smallest range requested by default, so reads of
+// neighbouring frames share a round trip.
+const defaultRangeSize = 64 * 1024
+
+// coldStartTail is how many of the last bytes of an archive are requested
+// at open with ColdStart, holding the seek tables of most archives.
+const coldStartTail = 256 * 1024
+
+var (
+	ErrUnexpectedResponse = errors.New("unexpected http response")
+	ErrMirrorMismatch     = errors.New("mirror does not hold the same archive")
+	ErrMissingEnv         = errors.New("environment variable is not set")
+	ErrArchiveChanged     = errors.New("remote archive changed while open")
+	ErrRangesUnsupported  = errors.New("http server does not support range requests")
+	ErrDownloadTooLarge   = errors.New("archive is larger than the download limit")
+	ErrPinMismatch        = errors.New("tls certificate does not match a pinned key")
+	ErrSizeChanged        = errors.New("remote archive size changed while open")
+	ErrCircuitOpen        = errors.New("remote archive is failing, circuit breaker is open")
+	ErrTooManyRedirects   = errors.New("too many http redirects")
+)
+
+// httpParameters are the parameters of a file: URI read by SQLite, the VFS,
+// or HTTPBackend. They are removed from the URL before it is requested, along
+// with any starting with an underscore, as go-sqlite3 uses.
+//
+//nolint: gochecknoglobals
+var httpParameters = map[string]bool{
+	"cache": true, "immutable": true, "mode": true, "modeof": true, "nolock": true, "psow": true, "vfs": true,
+	"overlay": true, "source_sha256": true,
+	"mirror": true, "mirror_mode": true,
+	"auth_bearer_env": true, "auth_basic_env": true, "header_env": true,
+	"connect_timeout": true, "header_timeout": true, "request_timeout": true,
+	"range_size": true, "prefetch": true, "download_limit": true,
+	"proxy": true, "proxy_env": true,
+	"tls_ca": true, "tls_cert": true, "tls_key": true, "tls_pin": true, "tls_min_version": true,
+	"hedge_delay": true, "rate_bytes": true, "rate_requests": true,
+	"disk_cache_dir": true, "disk_cache_size": true, "disk_cache_key": true, "background_download": true, "cache_size": true,
+	"breaker_failures": true, "breaker_cooldown": true, "range_align": true, "readahead": true,
+	"decoder_concurrency": true, "decoder_low_memory": true, "decoder_max_memory": true, "frame_policy": true,
+	"decompress": true, "memory_limit": true, "coalesce_size": true, "decompress_workers": true,
+	"max_redirects": true, "redirect_auth": true, "redirect_pin": true,
+	"max_idle_conns_per_host": true, "idle_timeout": true, "http2": true, "keep_alive": true,
+	"cold_start": true, "slow_read": true,
+}
+
+// HTTPBackend reads archives from HTTP servers that support Range requests.
+// It is registered for the http and https schemes, and can be registered
+// again with other settings:
+//
+//	sqlitezstd.RegisterBackend("https", &sqlitezstd.HTTPBackend{...})
+//
+// The same archive may be served by mirrors, given as mirror parameters of a
+// file: URI. Every mirror must hold a file of the same size and, if both
+// report one, the same ETag. Reads fail over to the next mirror on errors,
+// or take turns between all of them with mirror_mode=round-robin.
+//
+// Every range request is conditional on the ETag, or else the Last-Modified
+// time, seen when the archive was opened. Reads of a file replaced while open
+// fail with ErrArchiveChanged instead of mixing two versions. Servers that
+// send no validator are still caught once a response is 416 Range Not
+// Satisfiable, or reports a size other than the one seen at open, which
+// fails with ErrSizeChanged.
+//
+// Credentials are never written in the DSN itself. Instead, parameters name
+// the environment variables holding them: auth_bearer_env=VAR sends the token
+// in VAR, auth_basic_env=VAR the user:password in VAR, and header_env=Name:VAR
+// a header with the value of VAR. Like the fields of HTTPBackend, they are sent
+// to every mirror.
+//
+// The connect_timeout, header_timeout, and request_timeout parameters, such
+// as request_timeout=30s, override the timeouts of the backend. The proxy
+// parameter overrides its proxy, or proxy_env=VAR, for a proxy URL holding
+// credentials.
+//
+// Servers protected by mutual TLS are read with TLSConfig, or with the tls_ca
+// parameter naming a PEM bundle of trusted CAs, tls_cert and tls_key naming a
+// client certificate and its key, tls_min_version=1.3, and tls_pin=sha256/...
+// for each SHA-256 hash of a public key the server must present.
+//
+// The transport of the client is cloned for these settings, and for those of
+// the connection pool: MaxIdleConnsPerHost, IdleConnTimeout, DisableHTTP2, and
+// DisableKeepAlives, or the max_idle_conns_per_host, idle_timeout, http2, and
+// keep_alive parameters. Archives opened with the same settings share the
+// clone, and its connections.
+//
+// Redirects are followed as the client does, up to MaxRedirects, or the
+// max_redirects parameter, and not at all when it is negative. The
+// Authorization header is dropped when redirected to another host, such as
+// storage serving the files of a dataset host, unless RedirectAuth, or
+// redirect_auth=true, forwards it. With PinRedirects, or redirect_pin=true,
+// later requests go straight to the URL redirected to, until it expires with
+// 401 or 403 and is resolved again.
+//
+// SQLite reads a page at a time, which would make a round trip of every frame.
+// Smaller reads are made into ranges of at least RangeSize bytes instead, or
+// the range_size parameter, and reads of the rest of the range are served
+// from memory. Once reads run from one range into the next, as in a scan, the
+// next Prefetch ranges, or the prefetch parameter, are requested concurrently
+// so the network is busy while frames are decompressed.
+//
+// With RangeAlignment, or the range_align parameter, every range starts and
+// ends on a multiple of that many bytes, such as 1 MiB, so different queries
+// and clients request the same ranges, which CDNs and S3 can then cache.
+//
+// A range request taking longer than HedgeDelay, or the hedge_delay parameter,
+// is sent again to the next mirror, or to the same server without mirrors, and
+// the first response is used, so a single slow request does not stall a query.
+//
+// BytesPerSecond and RequestsPerSecond, or the rate_bytes and rate_requests
+// parameters, limit the range requests made for an archive, shared by every
+// connection to it, so a background job can not saturate the origin.
+//
+// Servers that ignore Range and send the whole file fail with
+// ErrRangesUnsupported, unless DownloadLimit, or the download_limit parameter,
+// allows downloading archives of up to that many bytes to a temporary file,
+// which is read from then on.
+//
+// With BackgroundDownload, or background_download=true, queries are answered
+// with range requests right away while the whole archive is downloaded to a
+// temporary file, which reads switch to once it is complete.
+//
+// After BreakerFailures, or the breaker_failures parameter, reads in a row
+// fail, the circuit breaker of the archive opens, shared by every connection
+// to it. Reads the origin would serve then fail right away with ErrCircuitOpen,
+// while frames in memory or a disk cache are still read. After
+// BreakerCooldown, or breaker_cooldown, a single read is sent to the origin,
+// closing the breaker again once it succeeds.
+type HTTPBackend struct {
+	// Client makes every request, defaulting to http.DefaultClient. Its
+	// Transport applies proxy, tracing, and connection pool policies.
+	Client *http.Client
+	// Header is sent with every request, such as an API key.
+	Header http.Header
+	// BearerToken, if set, is sent in the Authorization header.
+	BearerToken string
+	// Username and Password, if set, are sent with basic authentication.
+	Username string
+	Password string
+	// MaxIdleConnsPerHost, if set, is the number of idle connections kept
+	// per host, for many concurrent range requests.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout, if set, is how long an idle connection is kept.
+	IdleConnTimeout time.Duration
+	// DisableHTTP2 makes requests over HTTP/1.1 only.
+	DisableHTTP2 bool
+	// DisableKeepAlives makes a new connection for every request.
+	DisableKeepAlives bool
+	// MaxRedirects, if set, is the number of redirects followed, or none
+	// when it is negative.
+	MaxRedirects int
+	// RedirectAuth forwards the Authorization header to other hosts
+	// when redirected.
+	RedirectAuth bool
+	// PinRedirects makes requests to the URL a location redirected to.
+	PinRedirects bool
+	// ConnectTimeout bounds establishing a connection, including
+	// the TLS handshake.
+	ConnectTimeout time.Duration
+	// ResponseHeaderTimeout bounds waiting for the headers of a
+	// response once the request is sent.
+	ResponseHeaderTimeout time.Duration
+	// RequestTimeout bounds a whole request, including reading the
+	// range, so a hung origin fails the query instead of stalling it.
+	RequestTimeout time.Duration
+	// Proxy, if set, is the http, https, or socks5 proxy of every request,
+	// with its credentials, if any, in the user info. Otherwise, the proxy
+	// comes from the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY variables.
+	Proxy *url.URL
+	// TLSConfig, if set, replaces the TLS configuration of the transport,
+	// for custom CAs, client certificates, or minimum versions.
+	TLSConfig *tls.Config
+	// PinnedKeys are the base64 SHA-256 hashes of public keys, as in
+	// "sha256/...", one of which the certificates of the server must hold.
+	PinnedKeys []string
+	// RangeSize is the smallest range requested, defaulting to 64 KiB.
+	// It is negative to request exactly what is read.
+	RangeSize int64
+	// RangeAlignment, if set, rounds every range out to multiples of it,
+	// including reads larger than RangeSize.
+	RangeAlignment int64
+	// Prefetch is the number of ranges requested ahead of sequential
+	// reads. They are off by default.
+	Prefetch int
+	// HedgeDelay, if set, is how long a range request may take before
+	// a second one is made.
+	HedgeDelay time.Duration
+	// BytesPerSecond, if set, limits the bytes requested per second.
+	BytesPerSecond int64
+	// RequestsPerSecond, if set, limits the range requests per second.
+	RequestsPerSecond float64
+	// DownloadLimit is the largest archive downloaded whole from servers
+	// that do not support range requests. Downloads are off when zero.
+	DownloadLimit int64
+	// BackgroundDownload downloads the whole archive of every connection
+	// while it is read, then reads the local copy. DownloadLimit, if set,
+	// still applies.
+	BackgroundDownload bool
+	// BreakerFailures, if set, is the number of reads in a row that fail
+	// before the circuit breaker of the archive opens.
+	BreakerFailures int
+	// BreakerCooldown is how long the breaker stays open before a read is
+	// tried again, defaulting to 30 seconds.
+	BreakerCooldown time.Duration
+	// ColdStart, if set, opens an archive with one request for its last
+	// 256 KiB, rather than a HEAD request and reads of its seek table, and
+	// serves the reads that fall in them from memory, for serverless
+	// functions whose cold starts wait on every round trip.
+	ColdStart bool
+	// Refresh, if set, returns a new URL for expired, such as a freshly
+	// presigned S3 or GCS URL, when the server rejects it with 401 or 403.
+	// The request is retried once with the new URL.
+	Refresh func(expired *url.URL) (*url.URL, error)
+	// Trace, if set, is called as every range request starts, and returns
+	// the context of the request, such as one holding a span or an
+	// httptrace.ClientTrace, along with the function called with the
+	// outcome of the request once it ends.
+	Trace func(ctx context.Context, request RangeRequest) (context.Context, func(err error))
+	// Logger, if set, logs what requests do past a single range request:
+	// mirrors left out or failed over from, expired URLs retried, circuit
+	// breakers opening, and archives downloaded whole.
+	Logger *slog.Logger
+
+	metricsMutex sync.Mutex
+	metrics      map[string]*httpMetrics
+
+	transportsMutex sync.Mutex
+	transports      map[string]*http.Transport
+}
+
+var _ Backend = &HTTPBackend{}
+
+// RangeRequest describes a range request for HTTPBackend.Trace.
+type RangeRequest struct {
+	// URL is requested, without its query or credentials.
+	URL string
+	// Offset and Length are the range of compressed bytes requested.
+	Offset int64
+	Length int64
+	// Frame is the index of the frame the range starts in, or -1 if
+	// the file is not a whole archive, such as a part of one.
+	Frame int
+}
+
+func (h *HTTPBackend) Open(uri *url.URL) (Object, error) {
+	target, params := splitHTTPParameters(uri)
+
+	client, timeout, err := h.client(params)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := h.header(params)
+	if err != nil {
+		return nil, err
+	}
+
+	rangeSize := h.RangeSize
+	if rangeSize == 0 {
+		rangeSize = defaultRangeSize
+	}
+
+	if params.Has("range_size") {
+		rangeSize, err = strconv.ParseInt(params.Get("range_size"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: range_size=%q", ErrInvalidOption, params.Get("range_size"))
+		}
+	}
+
+	rangeAlignment := h.RangeAlignment
+	if params.Has("range_align") {
+		rangeAlignment, err = strconv.ParseInt(params.Get("range_align"), 10, 64)
+		if err != nil || rangeAlignment < 0 {
+			return nil, fmt.Errorf("%w: range_align=%q", ErrInvalidOption, params.Get("range_align"))
+		}
+	}
+
+	// aligned ranges are whole blocks, of at least the range size
+	if rangeAlignment > 0 {
+		rangeSize = (max(rangeSize, 1) + rangeAlignment - 1) / rangeAlignment * rangeAlignment
+	}
+
+	prefetch := h.Prefetch
+	if params.Has("prefetch") {
+		prefetch, err = strconv.Atoi(params.Get("prefetch"))
+		if err != nil || prefetch < 0 {
+			return nil, fmt.Errorf("%w: prefetch=%q", ErrInvalidOption, params.Get("prefetch"))
+		}
+	}
+
+	hedgeDelay := h.HedgeDelay
+	if params.Has("hedge_delay") {
+		hedgeDelay, err = time.ParseDuration(params.Get("hedge_delay"))
+		if err != nil || hedgeDelay < 0 {
+			return nil, fmt.Errorf("%w: hedge_delay=%q", ErrInvalidOption, params.Get("hedge_delay"))
+		}
+	}
+
+	bytesPerSecond := h.BytesPerSecond
+	if params.Has("rate_bytes") {
+		bytesPerSecond, err = strconv.ParseInt(params.Get("rate_bytes"), 10, 64)
+		if err != nil || bytesPerSecond < 0 {
+			return nil, fmt.Errorf("%w: rate_bytes=%q", ErrInvalidOption, params.Get("rate_bytes"))
+		}
+	}
+
+	requestsPerSecond := h.RequestsPerSecond
+	if params.Has("rate_requests") {
+		requestsPerSecond, err = strconv.ParseFloat(params.Get("rate_requests"), 64)
+		if err != nil || requestsPerSecond < 0 {
+			return nil, fmt.Errorf("%w: rate_requests=%q", ErrInvalidOption, params.Get("rate_requests"))
+		}
+	}
+
+	downloadLimit := h.DownloadLimit
+	if params.Has("download_limit") {
+		downloadLimit, err = strconv.ParseInt(params.Get("download_limit"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: download_limit=%q", ErrInvalidOption, params.Get("download_limit"))
+		}
+	}
+
+	pin := h.PinRedirects
+	if params.Has("redirect_pin") {
+		pin, err = strconv.ParseBool(params.Get("redirect_pin"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: redirect_pin=%q", ErrInvalidOption, params.Get("redirect_pin"))
+		}
+	}
+
+	background := h.BackgroundDownload
+	if params.Has("background_download") {
+		background, err = strconv.ParseBool(params.Get("background_download"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: background_download=%q", ErrInvalidOption, params.Get("background_download"))
+		}
+	}
+
+	breakerFailures := h.BreakerFailures
+	if params.Has("breaker_failures") {
+		breakerFailures, err = strconv.Atoi(params.Get("breaker_failures"))
+		if err != nil || breakerFailures < 0 {
+			return nil, fmt.Errorf("%w: breaker_failures=%q", ErrInvalidOption, params.Get("breaker_failures"))
+		}
+	}
+
+	breakerCooldown := h.BreakerCooldown
+	if params.Has("breaker_cooldown") {
+		breakerCooldown, err = time.ParseDuration(params.Get("breaker_cooldown"))
+		if err != nil || breakerCooldown < 0 {
+			return nil, fmt.Errorf("%w: breaker_cooldown=%q", ErrInvalidOption, params.Get("breaker_cooldown"))
+		}
+	}
+
+	coldStart := h.ColdStart
+	if params.Has("cold_start") {
+		coldStart, err = strconv.ParseBool(params.Get("cold_start"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: cold_start=%q", ErrInvalidOption, params.Get("cold_start"))
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	object := &httpObject{
+		ctx:        ctx,
+		cancel:     cancel,
+		client:     client,
+		pin:        pin,
+		header:     header,
+		timeout:    timeout,
+		rangeSize:  rangeSize,
+		alignment:  rangeAlignment,
+		prefetch:   prefetch,
+		fetching:   make(chan struct{}, max(prefetch, 1)),
+		download:   downloadLimit,
+		background: background,
+		hedgeDelay: hedgeDelay,
+		limiter:    limiterFor(target, bytesPerSecond, requestsPerSecond),
+		breaker:    breakerFor(target, breakerFailures, breakerCooldown),
+		metrics:    h.metricsFor(target),
+		refresh:    h.Refresh,
+		roundRobin: params.Get("mirror_mode") == "round-robin",
+		coldStart:  coldStart,
+		logger:     h.Logger,
+	}
+
+	locations := append([]string{target.String()}, params["mirror"]...)
+
+	var (
+		reference *httpLocation
+		lastErr   error
+	)
+
+	// mirrors that can not be reached now are left out, rather than
+	// failing the whole archive
+	for _, location := range locations {
+		current := &httpLocation{location: location}
+
+		size, err := object.stat(current)
+		if err != nil {
+			logTo(h.Logger, slog.LevelWarn, "leaving out unreachable mirror", "url", redact(location), "error", err)
+
+			lastErr = err
+
+			continue
+		}
+
+		if reference == nil {
+			reference = current
+			object.size = size
+		} else if size != object.size || (reference.etag != "" && current.etag != "" && reference.etag != current.etag) {
+			_ = object.Close()
+
+			return nil, fmt.Errorf("%w: %s", ErrMirrorMismatch, redact(location))
+		}
+
+		object.locations = append(object.locations, current)
+	}
+
+	if reference == nil {
+		_ = object.Close()
+
+		return nil, lastErr
+	}
+
+	// frames are found in the seek table, read before requests are traced
+	if h.Trace != nil {
+		object.frames, _ = decodeSeekTable(object, object.size)
+		object.trace = h.Trace
+	}
+
+	if object.background && object.local.Load() == nil {
+		go object.downloadInBackground()
+	}
+
+	return object, nil
+}
+
+// client returns the client used for an archive, along with the timeout
+// of every request, applying the transport settings of the backend and of
+// the name.
+func (h *HTTPBackend) client(params url.Values) (*http.Client, time.Duration, error) {
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	timeouts := map[string]time.Duration{
+		"connect_timeout": h.ConnectTimeout,
+		"header_timeout":  h.ResponseHeaderTimeout,
+		"request_timeout": h.RequestTimeout,
+	}
+
+	for key := range timeouts {
+		if !params.Has(key) {
+			continue
+		}
+
+		timeout, err := time.ParseDuration(params.Get(key))
+		if err != nil || timeout < 0 {
+			return nil, 0, fmt.Errorf("%w: %s=%q", ErrInvalidOption, key, params.Get(key))
+		}
+
+		timeouts[key] = timeout
+	}
+
+	proxy, err := h.proxy(params)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	secure := h.TLSConfig != nil || len(h.PinnedKeys) > 0
+	for key := range params {
+		secure = secure || strings.HasPrefix(key, "tls_")
+	}
+
+	checkRedirect, err := h.redirectPolicy(params)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pool, err := h.pool(params)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	connect, header := timeouts["connect_timeout"], timeouts["header_timeout"]
+	if connect == 0 && header == 0 && proxy == nil && !secure && pool == (httpPool{}) {
+		if checkRedirect == nil {
+			return client, timeouts["request_timeout"], nil
+		}
+
+		configured := *client
+		configured.CheckRedirect = checkRedirect
+
+		return &configured, timeouts["request_timeout"], nil
+	}
+
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	transport, ok := base.(*http.Transport)
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: timeouts, proxies, tls, and pools need an *http.Transport, not %T", ErrInvalidOption, base)
+	}
+
+	// archives with the same settings share a transport, and its connections
+	key := fmt.Sprintf("%v %v %v %+v", connect, header, proxy, pool)
+	if secure {
+		for _, name := range []string{"tls_ca", "tls_cert", "tls_key", "tls_pin", "tls_min_version"} {
+			key += fmt.Sprintf(" %q", params[name])
+		}
+	}
+
+	h.transportsMutex.Lock()
+	defer h.transportsMutex.Unlock()
+
+	shared, ok := h.transports[key]
+	if !ok {
+		transport = transport.Clone()
+
+		if connect > 0 {
+			transport.DialContext = (&net.Dialer{Timeout: connect, KeepAlive: 30 * time.Second}).DialContext
+			transport.TLSHandshakeTimeout = connect
+		}
+
+		if header > 0 {
+			transport.ResponseHeaderTimeout = header
+		}
+
+		if proxy != nil {
+			transport.Proxy = http.ProxyURL(proxy)
+		}
+
+		if secure {
+			transport.TLSClientConfig, err = h.tlsConfig(transport.TLSClientConfig, params)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+
+		pool.apply(transport)
+
+		if h.transports == nil {
+			h.transports = map[string]*http.Transport{}
+		}
+
+		h.transports[key] = transport
+		shared = transport
+	}
+
+	configured := *client
+	configured.Transport = shared
+
+	if checkRedirect != nil {
+		configured.CheckRedirect = checkRedirect
+	}
+
+	return &configured, timeouts["request_timeout"], nil
+}
+
+// httpPool holds the connection pool settings of a transport.
+type httpPool struct {
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+	disableHTTP2        bool
+	disableKeepAlives   bool
+}
+
+// pool returns the connection pool settings of the backend and the parameters.
+func (h *HTTPBackend) pool(params url.Values) (httpPool, error) {
+	var err error
+
+	pool := httpPool{
+		maxIdleConnsPerHost: h.MaxIdleConnsPerHost,
+		idleConnTimeout:     h.IdleConnTimeout,
+		disableHTTP2:        h.DisableHTTP2,
+		disableKeepAlives:   h.DisableKeepAlives,
+	}
+
+	if params.Has("max_idle_conns_per_host") {
+		pool.maxIdleConnsPerHost, err = strconv.Atoi(params.Get("max_idle_conns_per_host"))
+		if err != nil || pool.maxIdleConnsPerHost < 0 {
+			return httpPool{}, fmt.Errorf("%w: max_idle_conns_per_host=%q", ErrInvalidOption, params.Get("max_idle_conns_per_host"))
+		}
+	}
+
+	if params.Has("idle_timeout") {
+		pool.idleConnTimeout, err = time.ParseDuration(params.Get("idle_timeout"))
+		if err != nil || pool.idleConnTimeout < 0 {
+			return httpPool{}, fmt.Errorf("%w: idle_timeout=%q", ErrInvalidOption, params.Get("idle_timeout"))
+		}
+	}
+
+	if params.Has("http2") {
+		enabled, err := strconv.ParseBool(params.Get("http2"))
+		if err != nil {
+			return httpPool{}, fmt.Errorf("%w: http2=%q", ErrInvalidOption, params.Get("http2"))
+		}
+
+		pool.disableHTTP2 = !enabled
+	}
+
+	if params.Has("keep_alive") {
+		enabled, err := strconv.ParseBool(params.Get("keep_alive"))
+		if err != nil {
+			return httpPool{}, fmt.Errorf("%w: keep_alive=%q", ErrInvalidOption, params.Get("keep_alive"))
+		}
+
+		pool.disableKeepAlives = !enabled
+	}
+
+	return pool, nil
+}
+
+func (p httpPool) apply(transport *http.Transport) {
+	if p.maxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = p.maxIdleConnsPerHost
+		transport.MaxIdleConns = max(transport.MaxIdleConns, p.maxIdleConnsPerHost)
+	}
+
+	if p.idleConnTimeout > 0 {
+		transport.IdleConnTimeout = p.idleConnTimeout
+	}
+
+	// a non-nil, empty TLSNextProto turns HTTP/2 off
+	if p.disableHTTP2 {
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+
+		if transport.TLSClientConfig != nil {
+			transport.TLSClientConfig = transport.TLSClientConfig.Clone()
+			transport.TLSClientConfig.NextProtos = nil
+		}
+	}
+
+	transport.DisableKeepAlives = transport.DisableKeepAlives || p.disableKeepAlives
+}
+
+// defaultMaxRedirects is the number of redirects http.Client follows.
+const defaultMaxRedirects = 10
+
+// redirectPolicy returns the CheckRedirect of the client from the
+// backend and the parameters, or nil to keep the one of the client.
+func (h *HTTPBackend) redirectPolicy(params url.Values) (func(*http.Request, []*http.Request) error, error) {
+	var err error
+
+	maxRedirects := h.MaxRedirects
+	if params.Has("max_redirects") {
+		maxRedirects, err = strconv.Atoi(params.Get("max_redirects"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: max_redirects=%q", ErrInvalidOption, params.Get("max_redirects"))
+		}
+	}
+
+	forwardAuth := h.RedirectAuth
+	if params.Has("redirect_auth") {
+		forwardAuth, err = strconv.ParseBool(params.Get("redirect_auth"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: redirect_auth=%q", ErrInvalidOption, params.Get("redirect_auth"))
+		}
+	}
+
+	if maxRedirects == 0 && !forwardAuth {
+		return nil, nil
+	}
+
+	if maxRedirects == 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+
+	return func(request *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return fmt.Errorf("%w: more than %d", ErrTooManyRedirects, max(maxRedirects, 0))
+		}
+
+		// the client drops it when the host changes
+		if forwardAuth && request.Header.Get("Authorization") == "" {
+			if authorization := via[0].Header.Get("Authorization"); authorization != "" {
+				request.Header.Set("Authorization", authorization)
+			}
+		}
+
+		return nil
+	}, nil
+}
+
+// proxy returns the proxy of the backend or of the name, if any.
+func (h *HTTPBackend) proxy(params url.Values) (*url.URL, error) {
+	location := ""
+
+	switch {
+	case params.Has("proxy_env"):
+		value, err := lookupEnv(params.Get("proxy_env"))
+		if err != nil {
+			return nil, err
+		}
+
+		location = value
+	case params.Has("proxy"):
+		location = params.Get("proxy")
+	default:
+		return h.Proxy, nil
+	}
+
+	proxy, err := url.Parse(location)
+	if err != nil || proxy.Host == "" {
+		return nil, fmt.Errorf("%w: proxy %q", ErrInvalidOption, redact(location))
+	}
+
+	switch proxy.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return proxy, nil
+	default:
+		return nil, fmt.Errorf("%w: proxy scheme %q", ErrInvalidOption, proxy.Scheme)
+	}
+}
+
+// header returns the headers sent with every request, from the
+// fields of the backend and the parameters of the name.
+func (h *HTTPBackend) header(params url.Values) (http.Header, error) {
+	header := h.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+
+	if h.BearerToken != "" {
+		header.Set("Authorization", "Bearer "+h.BearerToken)
+	}
+
+	if h.Username != "" || h.Password != "" {
+		request := &http.Request{Header: header}
+		request.SetBasicAuth(h.Username, h.Password)
+	}
+
+	if name := params.Get("auth_bearer_env"); name != "" {
+		token, err := lookupEnv(name)
+		if err != nil {
+			return nil, err
+		}
+
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	if name := params.Get("auth_basic_env"); name != "" {
+		credentials, err := lookupEnv(name)
+		if err != nil {
+			return nil, err
+		}
+
+		username, password, _ := strings.Cut(credentials, ":")
+		request := &http.Request{Header: header}
+		request.SetBasicAuth(username, password)
+	}
+
+	for _, entry := range params["header_env"] {
+		key, name, _ := strings.Cut(entry, ":")
+
+		value, err := lookupEnv(name)
+		if err != nil {
+			return nil, err
+		}
+
+		header.Set(key, value)
+	}
+
+	return header, nil
+}
+
+// tlsConfig returns the TLS configuration of the backend and of the
+// name, starting from that of the transport.
+func (h *HTTPBackend) tlsConfig(base *tls.Config, params url.Values) (*tls.Config, error) {
+	config := base.Clone()
+	if h.TLSConfig != nil {
+		config = h.TLSConfig.Clone()
+	}
+
+	if config == nil {
+		config = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	if path := params.Get("tls_ca"); path != "" {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read tls_ca: %w", err)
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(contents) {
+			return nil, fmt.Errorf("%w: tls_ca %s holds no certificates", ErrInvalidOption, path)
+		}
+	}
+
+	if params.Has("tls_cert") || params.Has("tls_key") {
+		certificate, err := tls.LoadX509KeyPair(params.Get("tls_cert"), params.Get("tls_key"))
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %w", err)
+		}
+
+		config.Certificates = append(config.Certificates, certificate)
+	}
+
+	if params.Has("tls_min_version") {
+		versions := map[string]uint16{"1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+
+		version, ok := versions[params.Get("tls_min_version")]
+		if !ok {
+			return nil, fmt.Errorf("%w: tls_min_version=%q", ErrInvalidOption, params.Get("tls_min_version"))
+		}
+
+		config.MinVersion = version
+	}
+
+	pins := append(append([]string{}, h.PinnedKeys...), params["tls_pin"]...)
+	if len(pins) == 0 {
+		return config, nil
+	}
+
+	pinned := map[[sha256.Size]byte]bool{}
+
+	for _, pin := range pins {
+		hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/"))
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("%w: tls_pin %q", ErrInvalidOption, pin)
+		}
+
+		pinned[[sha256.Size]byte(hash)] = true
+	}
+
+	verify := config.VerifyConnection
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if verify != nil {
+			err := verify(state)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, certificate := range state.PeerCertificates {
+			if pinned[sha256.Sum256(certificate.RawSubjectPublicKeyInfo)] {
+				return nil
+			}
+		}
+
+		return ErrPinMismatch
+	}
+
+	return config, nil
+}
+
+// lookupEnv returns the value of a variable named by a parameter.
+func lookupEnv(name string) (string, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return "", fmt.Errorf("%w: %s", ErrMissingEnv, name)
+	}
+
+	return value, nil
+}
+
+// splitHTTPParameters separates the parameters meant for this package
+// from the URL requested from the server.
+func splitHTTPParameters(uri *url.URL) (*url.URL, url.Values) {
+	target := *uri
+	params := url.Values{}
+	kept := []string{}
+
+	// the rest of the query is kept as is, as a signature may cover it
+	for _, pair := range strings.Split(uri.RawQuery, "&") {
+		rawKey, rawValue, _ := strings.Cut(pair, "=")
+
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil || !(httpParameters[key] || strings.HasPrefix(key, "_")) {
+			if pair != "" {
+				kept = append(kept, pair)
+			}
+
+			continue
+		}
+
+		value, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			value = rawValue
+		}
+
+		params.Add(key, value)
+	}
+
+	target.RawQuery = strings.Join(kept, "&")
+
+	return &target, params
+}
+
+// redact hides the credentials and the query, which may hold a
+// signature, of a URL in errors.
+func redact(location string) string {
+	uri, err := url.Parse(location)
+	if err != nil {
+		return "invalid url"
+	}
+
+	uri.RawQuery = ""
+
+	return uri.Redacted()
+}
+
+// httpLocation is a URL an archive is read from.
+type httpLocation struct {
+	mutex    sync.Mutex
+	location string
+	// resolved is the URL given for the location, once it is pinned
+	// to the URL it redirected to.
+	resolved string
+	// etag is the strong ETag of the file, if any, sent with If-Match.
+	etag string
+	// validator is the ETag or Last-Modified time of the file when it was
+	// opened, so a file that changed is never read in part.
+	validator string
+}
+
+// changed reports whether response shows the file is no longer the
+// one opened, rather than failing for another reason.
+func (h *httpLocation) changed(response *http.Response) bool {
+	if h.validator == "" {
+		return false
+	}
+
+	switch response.StatusCode {
+	case http.StatusPreconditionFailed:
+		return true
+	case http.StatusOK:
+		if h.etag != "" {
+			return response.Header.Get("ETag") != h.etag
+		}
+
+		return response.Header.Get("Last-Modified") != h.validator
+	default:
+		return false
+	}
+}
+
+// validate keeps the validator of the archive sent in response, so later
+// ranges are checked against the version first seen.
+func (h *httpLocation) validate(response *http.Response) {
+	if etag := response.Header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		h.etag = etag
+		h.validator = etag
+	} else {
+		h.validator = response.Header.Get("Last-Modified")
+	}
+}
+
+func (h *httpLocation) url() string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.location
+}
+
+// pin makes requests to final, the URL that target redirected to.
+func (h *httpLocation) pin(target string, final string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.location != target {
+		return
+	}
+
+	if h.resolved == "" {
+		h.resolved = target
+	}
+
+	h.location = final
+}
+
+// unpin goes back to the URL given for the location, once the URL it
+// redirected to, target, expires. It reports whether it was pinned.
+func (h *httpLocation) unpin(target string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.resolved == "" || h.location != target {
+		return false
+	}
+
+	h.location, h.resolved = h.resolved, ""
+
+	return true
+}
+
+// httpObject is an archive read with range requests from one or more mirrors.
+type httpObject struct {
+	// ctx ends the requests of the archive, including prefetches, once closed.
+	ctx        context.Context
+	cancel     context.CancelFunc
+	client     *http.Client
+	pin        bool
+	header     http.Header
+	timeout    time.Duration
+	refresh    func(expired *url.URL) (*url.URL, error)
+	locations  []*httpLocation
+	roundRobin bool
+	next       atomic.Uint64
+	size       int64
+	rangeSize  int64
+	alignment  int64
+	prefetch   int
+	hedgeDelay time.Duration
+	limiter    *httpLimiter
+	breaker    *httpBreaker
+	metrics    *httpMetrics
+	trace      func(ctx context.Context, request RangeRequest) (context.Context, func(err error))
+	logger     *slog.Logger
+	// frames is the seek table of the archive, if it is traced.
+	frames *seekTable
+	// fetching bounds the prefetches in flight.
+	fetching chan struct{}
+	// tail, with coldStart, holds the last bytes of the archive from
+	// tailOffset, requested at open in place of a HEAD request.
+	coldStart  bool
+	tail       []byte
+	tailOffset int64
+
+	// ranges are the ranges last requested, oldest first.
+	rangesMutex sync.Mutex
+	ranges      []*httpRange
+
+	// download is the largest archive spooled to local when a server
+	// ignores Range.
+	download      int64
+	background    bool
+	downloadMutex sync.Mutex
+	local         atomic.Pointer[os.File]
+}
+
+// httpRange is a range requested from an archive, kept to serve
+// later reads that fall inside it.
+type httpRange struct {
+	offset     int64
+	data       []byte
+	prefetched bool
+	err        error
+	done       chan struct{}
+}
+
+func (r *httpRange) end() int64 {
+	return r.offset + int64(len(r.data))
+}
+
+// do sends the request built by build for location, replacing its URL
+// once with a refreshed one if the server rejects it as expired.
+func (h *httpObject) do(location *httpLocation, build func(target string) (*http.Request, error)) (*http.Response, error) {
+	return h.doWithTimeout(location, h.timeout, build)
+}
+
+// doWithTimeout is do with a timeout other than that of range requests,
+// or none when it is zero.
+func (h *httpObject) doWithTimeout(location *httpLocation, timeout time.Duration, build func(target string) (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		target := location.url()
+
+		request, err := build(target)
+		if err != nil {
+			return nil, fmt.Errorf("could not request %s: %w", redact(target), err)
+		}
+
+		for key, values := range h.header {
+			request.Header[key] = values
+		}
+
+		cancel := context.CancelFunc(func() {})
+		if timeout > 0 {
+			var ctx context.Context
+
+			ctx, cancel = context.WithTimeout(request.Context(), timeout)
+			request = request.WithContext(ctx)
+		}
+
+		response, err := h.client.Do(request)
+		if err != nil {
+			cancel()
+
+			return nil, fmt.Errorf("could not request %s: %w", redact(target), err)
+		}
+
+		// the timeout covers reading the body as well
+		response.Body = &cancelBody{ReadCloser: response.Body, cancel: cancel}
+
+		expired := response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden
+
+		if h.pin && !expired && response.Request.URL.String() != target {
+			location.pin(target, response.Request.URL.String())
+		}
+
+		// a URL redirected to expires before the one given
+		if expired && attempt == 0 && location.unpin(target) {
+			_ = response.Body.Close()
+
+			logTo(h.logger, slog.LevelInfo, "retrying expired redirect", "url", redact(location.url()), "status", response.StatusCode)
+
+			continue
+		}
+
+		if !expired || h.refresh == nil || attempt > 0 {
+			return response, nil
+		}
+
+		_ = response.Body.Close()
+
+		logTo(h.logger, slog.LevelInfo, "refreshing expired url", "url", redact(target), "status", response.StatusCode)
+
+		err = h.refreshLocation(location, target)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// refreshLocation replaces the URL of location, unless another
+// request already replaced stale.
+func (h *httpObject) refreshLocation(location *httpLocation, stale string) error {
+	location.mutex.Lock()
+	defer location.mutex.Unlock()
+
+	if location.location != stale {
+		return nil
+	}
+
+	expired, err := url.Parse(stale)
+	if err != nil {
+		return fmt.Errorf("could not refresh %s: %w", redact(stale), err)
+	}
+
+	fresh, err := h.refresh(expired)
+	if err != nil {
+		return fmt.Errorf("could not refresh %s: %w", redact(stale), err)
+	}
+
+	location.location = fresh.String()
+
+	return nil
+}
+
+func (h *httpObject) stat(location *httpLocation) (int64, error) {
+	// mirrors are compared with the first location, which has the tail
+	if h.coldStart && h.tail == nil {
+		return h.statTail(location)
+	}
+
+	response, err := h.do(location, func(target string) (*http.Request, error) {
+		return http.NewRequestWithContext(h.ctx, http.MethodHead, target, nil)
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	size := response.ContentLength
+
+	// some origins, such as signed URLs and CDNs, only allow GET
+	if response.StatusCode != http.StatusOK || size < 0 {
+		response, size, err = h.statRange(location)
+		if err != nil {
+			return 0, err
+		}
+		defer response.Body.Close()
+	}
+
+	location.validate(response)
+
+	return size, nil
+}
+
+// statTail finds the size of a file by requesting its last
+// coldStartTail bytes, kept to serve the reads of its seek table.
+func (h *httpObject) statTail(location *httpLocation) (int64, error) {
+	response, err := h.do(location, func(target string) (*http.Request, error) {
+		request, err := http.NewRequestWithContext(h.ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		request.Header.Set("Range", fmt.Sprintf("bytes=-%d", coldStartTail))
+
+		return request, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+		start, end, size, err := parseContentRange(response.Header.Get("Content-Range"))
+		if err != nil || size < 0 {
+			return 0, fmt.Errorf("%w: could not stat %s: %q", ErrUnexpectedResponse, redact(location.url()), response.Header.Get("Content-Range"))
+		}
+
+		tail := make([]byte, end-start+1)
+
+		_, err = io.ReadFull(response.Body, tail)
+		if err != nil {
+			return 0, fmt.Errorf("could not read %s: %w", redact(location.url()), err)
+		}
+
+		h.tail, h.tailOffset = tail, start
+		location.validate(response)
+
+		return size, nil
+	case http.StatusOK:
+		size, err := h.spool(location, response)
+		if err != nil {
+			return 0, err
+		}
+
+		location.validate(response)
+
+		return size, nil
+	}
+
+	return 0, fmt.Errorf("%w: could not stat %s: %s", ErrUnexpectedResponse, redact(location.url()), response.Status)
+}
+
+// statRange finds the size of a file by requesting its first byte.
+func (h *httpObject) statRange(location *httpLocation) (*http.Response, int64, error) {
+	response, err := h.do(location, func(target string) (*http.Request, error) {
+		request, err := http.NewRequestWithContext(h.ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		request.Header.Set("Range", "bytes=0-0")
+
+		return request, nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+		_, _, size, err := parseContentRange(response.Header.Get("Content-Range"))
+		if err == nil && size >= 0 {
+			return response, size, nil
+		}
+	case http.StatusOK:
+		size, err := h.spool(location, response)
+		if err != nil {
+			_ = response.Body.Close()
+
+			return nil, 0, err
+		}
+
+		return response, size, nil
+	}
+
+	_ = response.Body.Close()
+
+	return nil, 0, fmt.Errorf("%w: could not stat %s: %s", ErrUnexpectedResponse, redact(location.url()), response.Status)
+}
+
+func (h *httpObject) ReadAt(p []byte, off int64) (int, error) {
+	if local := h.local.Load(); local != nil {
+		return local.ReadAt(p, off)
+	}
+
+	if off >= h.size {
+		return 0, io.EOF
+	}
+
+	end := min(off+int64(len(p)), h.size)
+
+	switch {
+	case h.tail != nil && off >= h.tailOffset && end <= h.tailOffset+int64(len(h.tail)):
+		copy(p, h.tail[off-h.tailOffset:end-h.tailOffset])
+	case int64(len(p)) < h.rangeSize || h.alignment > 0:
+		err := h.readRanges(p[:end-off], off)
+		if err != nil {
+			return 0, err
+		}
+	default:
+		err := h.fetch(p[:end-off], off)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if end-off < int64(len(p)) {
+		return int(end - off), io.EOF
+	}
+
+	return len(p), nil
+}
+
+// readRanges fills p from the ranges last requested, requesting
+// ranges of rangeSize bytes for the parts they do not hold.
+func (h *httpObject) readRanges(p []byte, off int64) error {
+	for read := 0; read < len(p); {
+		position := off + int64(read)
+		current := h.rangeAt(position)
+
+		<-current.done
+
+		if current.err != nil {
+			h.forget(current)
+
+			// a failed prefetch is retried by the read that needs it
+			if current.prefetched {
+				continue
+			}
+
+			return current.err
+		}
+
+		read += copy(p[read:], current.data[position-current.offset:])
+	}
+
+	return nil
+}
+
+// rangeAt returns the range holding off, requesting it if needed,
+// and requests the ranges after it once reads are sequential.
+func (h *httpObject) rangeAt(off int64) *httpRange {
+	h.rangesMutex.Lock()
+	defer h.rangesMutex.Unlock()
+
+	sequential := false
+
+	for _, existing := range h.ranges {
+		if off >= existing.offset && off < existing.end() {
+			if existing.prefetched {
+				h.ahead(existing.end())
+			}
+
+			return existing
+		}
+
+		sequential = sequential || off == existing.end()
+	}
+
+	current := h.request(off, false)
+
+	if sequential {
+		h.ahead(current.end())
+	}
+
+	return current
+}
+
+// ahead requests up to prefetch ranges starting at off, skipping
+// those already requested. The mutex of the ranges is held.
+func (h *httpObject) ahead(off int64) {
+	for range h.prefetch {
+		if off >= h.size {
+			return
+		}
+
+		next := off
+
+		for _, existing := range h.ranges {
+			if existing.offset == off {
+				next = existing.end()
+
+				break
+			}
+		}
+
+		if next == off {
+			next = h.request(off, true).end()
+		}
+
+		off = next
+	}
+}
+
+// request starts requesting the range at off, or the aligned range holding
+// it. The mutex of the ranges is held.
+func (h *httpObject) request(off int64, prefetched bool) *httpRange {
+	if h.alignment > 0 {
+		off -= off % h.alignment
+	}
+
+	requested := &httpRange{
+		offset:     off,
+		data:       make([]byte, min(h.rangeSize, h.size-off)),
+		prefetched: prefetched,
+		done:       make(chan struct{}),
+	}
+
+	h.ranges = append(h.ranges, requested)
+
+	// keep the ranges being read and prefetched, and the one before them
+	if limit := h.prefetch + 2; len(h.ranges) > limit {
+		h.ranges = append(h.ranges[:0:0], h.ranges[len(h.ranges)-limit:]...)
+	}
+
+	go func() {
+		defer close(requested.done)
+
+		if prefetched {
+			select {
+			case h.fetching <- struct{}{}:
+				defer func() { <-h.fetching }()
+			case <-h.ctx.Done():
+				requested.err = h.ctx.Err()
+
+				return
+			}
+		}
+
+		requested.err = h.fetch(requested.data, off)
+	}()
+
+	return requested
+}
+
+// forget drops a range that failed, so it is requested again.
+func (h *httpObject) forget(failed *httpRange) {
+	h.rangesMutex.Lock()
+	defer h.rangesMutex.Unlock()
+
+	for index, existing := range h.ranges {
+		if existing == failed {
+			h.ranges = append(h.ranges[:index:index], h.ranges[index+1:]...)
+
+			return
+		}
+	}
+}
+
+// fetch fills p with a range request, unless the circuit breaker of the
+// archive is open.
+func (h *httpObject) fetch(p []byte, off int64) error {
+	if h.breaker == nil {
+		return h.failover(p, off)
+	}
+
+	err := h.breaker.allow()
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", redact(h.locations[0].url()), err)
+	}
+
+	err = h.failover(p, off)
+
+	// an archive that changed was still answered by a working origin
+	opened := h.breaker.record(err == nil || h.ctx.Err() != nil ||
+		errors.Is(err, ErrArchiveChanged) || errors.Is(err, ErrSizeChanged))
+	if opened {
+		logTo(h.logger, slog.LevelError, "circuit breaker opened", "url", redact(h.locations[0].url()), "error", err)
+	}
+
+	return err
+}
+
+// failover fills p with a range request, failing over between mirrors.
+func (h *httpObject) failover(p []byte, off int64) error {
+	// fail over from the mirror that last worked, or take turns
+	first := h.next.Load()
+	if h.roundRobin {
+		first = h.next.Add(1)
+	}
+
+	if h.hedgeDelay > 0 {
+		return h.hedge(p, off, first)
+	}
+
+	var err error
+
+	for attempt := range uint64(len(h.locations)) {
+		index := (first + attempt) % uint64(len(h.locations))
+
+		err = h.readRange(h.ctx, h.locations[index], p, off)
+		if err == nil {
+			if !h.roundRobin {
+				h.next.Store(index)
+			}
+
+			return nil
+		}
+
+		if attempt+1 < uint64(len(h.locations)) && h.ctx.Err() == nil {
+			logTo(h.logger, slog.LevelWarn, "failing over to next mirror", "url", redact(h.locations[index].url()), "offset", off, "error", err)
+		}
+	}
+
+	return err
+}
+
+// hedge fills p from the first of two range requests, the second made to
+// the next location once the first fails or takes longer than hedgeDelay.
+func (h *httpObject) hedge(p []byte, off int64, first uint64) error {
+	ctx, cancel := context.WithCancel(h.ctx)
+	defer cancel()
+
+	type result struct {
+		index uint64
+		data  []byte
+		err   error
+	}
+
+	// both requests have their own buffer, as the slower one is still
+	// writing when the faster one is used
+	results := make(chan result, 2)
+	start := func(index uint64) {
+		go func() {
+			data := make([]byte, len(p))
+			err := h.readRange(ctx, h.locations[index], data, off)
+			results <- result{index: index, data: data, err: err}
+		}()
+	}
+
+	timer := time.NewTimer(h.hedgeDelay)
+	defer timer.Stop()
+
+	start(first % uint64(len(h.locations)))
+
+	pending, hedged := 1, false
+
+	var err error
+
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if !hedged {
+				start((first + 1) % uint64(len(h.locations)))
+				pending, hedged = pending+1, true
+			}
+		case current := <-results:
+			pending--
+
+			if current.err == nil {
+				copy(p, current.data)
+
+				if !h.roundRobin {
+					h.next.Store(current.index)
+				}
+
+				return nil
+			}
+
+			err = current.err
+
+			if !hedged && ctx.Err() == nil {
+				logTo(h.logger, slog.LevelWarn, "failing over to next mirror", "url", redact(h.locations[current.index].url()), "offset", off, "error", err)
+			}
+
+			if !hedged {
+				start((first + 1) % uint64(len(h.locations)))
+				pending, hedged = pending+1, true
+			}
+		}
+	}
+
+	return err
+}
+
+// readRange fills p from a single location.
+func (h *httpObject) readRange(ctx context.Context, location *httpLocation, p []byte, off int64) error {
+	if h.limiter != nil {
+		err := h.limiter.wait(ctx, len(p))
+		if err != nil {
+			return fmt.Errorf("could not read %s: %w", redact(location.url()), err)
+		}
+	}
+
+	end := func(error) {}
+	if h.trace != nil {
+		ctx, end = h.trace(ctx, RangeRequest{
+			URL:    redact(location.url()),
+			Offset: off,
+			Length: int64(len(p)),
+			Frame:  h.frameAt(off),
+		})
+	}
+
+	start := time.Now()
+	err := h.requestRange(ctx, location, p, off)
+	h.metrics.record(time.Since(start), len(p), err)
+	end(err)
+
+	return err
+}
+
+// frameAt returns the index of the frame holding the compressed byte
+// at off, or -1 if it is not known.
+func (h *httpObject) frameAt(off int64) int {
+	if h.frames == nil || off >= h.frames.compressedSize {
+		return -1
+	}
+
+	return sort.Search(len(h.frames.frames), func(i int) bool {
+		return h.frames.frames[i].compressedOffset > off
+	}) - 1
+}
+
+func (h *httpObject) requestRange(ctx context.Context, location *httpLocation, p []byte, off int64) error {
+	response, err := h.do(location, func(target string) (*http.Request, error) {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+
+		// servers ignoring If-Match still answer If-Range with the whole file
+		if location.validator != "" {
+			request.Header.Set("If-Range", location.validator)
+		}
+
+		if location.etag != "" {
+			request.Header.Set("If-Match", location.etag)
+		} else if location.validator != "" {
+			request.Header.Set("If-Unmodified-Since", location.validator)
+		}
+
+		return request, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if location.changed(response) {
+		return fmt.Errorf("%w: %s", ErrArchiveChanged, redact(location.url()))
+	}
+
+	if response.StatusCode == http.StatusOK {
+		_, err = h.spool(location, response)
+		if err != nil {
+			return err
+		}
+
+		_, err = h.local.Load().ReadAt(p, off)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("could not read download: %w", err)
+		}
+
+		return nil
+	}
+
+	if response.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		h.invalidate()
+
+		return fmt.Errorf("%w: could not read %s: %s", ErrSizeChanged, redact(location.url()), response.Status)
+	}
+
+	if response.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("%w: could not read %s: %s", ErrUnexpectedResponse, redact(location.url()), response.Status)
+	}
+
+	start, end, total, err := parseContentRange(response.Header.Get("Content-Range"))
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", redact(location.url()), err)
+	}
+
+	// an unknown total, sent as *, is trusted
+	if total >= 0 && total != h.size {
+		h.invalidate()
+
+		return fmt.Errorf("%w: %s is %d bytes, not %d", ErrSizeChanged, redact(location.url()), total, h.size)
+	}
+
+	if start != off || end != off+int64(len(p))-1 {
+		return fmt.Errorf("%w: could not read %s: range %d-%d instead of %d-%d", ErrUnexpectedResponse, redact(location.url()), start, end, off, off+int64(len(p))-1)
+	}
+
+	_, err = io.ReadFull(response.Body, p)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", redact(location.url()), err)
+	}
+
+	return nil
+}
+
+// invalidate drops the idle connections of the client, once a server
+// answers for another file than the one opened, so later requests are
+// not made on connections to a proxy or cache holding the stale file.
+// The connection of the response itself is dropped as its body is
+// closed before it is read.
+func (h *httpObject) invalidate() {
+	h.client.CloseIdleConnections()
+}
+
+// parseContentRange parses a Content-Range header of the form
+// bytes start-end/total, where total is -1 when sent as *.
+func parseContentRange(header string) (int64, int64, int64, error) {
+	unit, spec, _ := strings.Cut(header, " ")
+	span, length, found := strings.Cut(spec, "/")
+	first, last, ranged := strings.Cut(span, "-")
+
+	if unit != "bytes" || !found || !ranged {
+		return 0, 0, 0, fmt.Errorf("%w: content range %q", ErrUnexpectedResponse, header)
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("%w: content range %q", ErrUnexpectedResponse, header)
+	}
+
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return 0, 0, 0, fmt.Errorf("%w: content range %q", ErrUnexpectedResponse, header)
+	}
+
+	if length == "*" {
+		return start, end, -1, nil
+	}
+
+	total, err := strconv.ParseInt(length, 10, 64)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("%w: content range %q", ErrUnexpectedResponse, header)
+	}
+
+	return start, end, total, nil
+}
+
+// downloadInBackground downloads the whole archive from the first
+// location that sends it, while reads are made with range requests.
+// Reads switch to the download once it is complete.
+func (h *httpObject) downloadInBackground() {
+	for _, location := range h.locations {
+		// the download takes as long as it takes, until the archive is closed
+		response, err := h.doWithTimeout(location, 0, func(target string) (*http.Request, error) {
+			request, err := http.NewRequestWithContext(h.ctx, http.MethodGet, target, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			if location.etag != "" {
+				request.Header.Set("If-Match", location.etag)
+			} else if location.validator != "" {
+				request.Header.Set("If-Unmodified-Since", location.validator)
+			}
+
+			return request, nil
+		})
+		if err != nil {
+			continue
+		}
+
+		if response.StatusCode == http.StatusOK && !location.changed(response) {
+			_, _ = h.spool(location, response)
+		}
+
+		_ = response.Body.Close()
+
+		if h.local.Load() != nil || h.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// spool downloads the whole archive from a server that ignored Range,
+// so it is read from a temporary file from then on.
+func (h *httpObject) spool(location *httpLocation, response *http.Response) (int64, error) {
+	if h.download <= 0 && !h.background {
+		return 0, fmt.Errorf("%w: %s", ErrRangesUnsupported, redact(location.url()))
+	}
+
+	// background downloads are only limited if a limit is set
+	limit := h.download
+	if limit <= 0 {
+		limit = math.MaxInt64 - 1
+	}
+
+	h.downloadMutex.Lock()
+	defer h.downloadMutex.Unlock()
+
+	if local := h.local.Load(); local != nil {
+		info, err := local.Stat()
+		if err != nil {
+			return 0, fmt.Errorf("could not stat download: %w", err)
+		}
+
+		return info.Size(), nil
+	}
+
+	if response.ContentLength > limit {
+		return 0, fmt.Errorf("%w: %s is %d bytes", ErrDownloadTooLarge, redact(location.url()), response.ContentLength)
+	}
+
+	// a download in the background is asked for, unlike one in place of
+	// range requests
+	level := slog.LevelWarn
+	if h.background {
+		level = slog.LevelDebug
+	}
+
+	logTo(h.logger, level, "downloading whole archive", "url", redact(location.url()), "size", response.ContentLength)
+
+	file, err := os.CreateTemp("", "sqlitezstd-*.download")
+	if err != nil {
+		return 0, fmt.Errorf("could not create download: %w", err)
+	}
+
+	size, err := io.Copy(file, io.LimitReader(response.Body, limit+1))
+
+	switch {
+	case err != nil:
+		err = fmt.Errorf("could not download %s: %w", redact(location.url()), err)
+	case size > limit:
+		err = fmt.Errorf("%w: %s", ErrDownloadTooLarge, redact(location.url()))
+	case h.size > 0 && size != h.size:
+		err = fmt.Errorf("%w: %s", ErrArchiveChanged, redact(location.url()))
+	}
+
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+
+		return 0, err
+	}
+
+	h.local.Store(file)
+	h.metrics.bytesTransferred.Add(size)
+
+	return size, nil
+}
+
+// httpLimiter limits the range requests for an archive.
+type httpLimiter struct {
+	bytes    *rate.Limiter
+	requests *rate.Limiter
+}
+
+//nolint: gochecknoglobals
+var (
+	httpLimiters      = map[string]*httpLimiter{}
+	httpLimitersMutex sync.Mutex
+)
+
+// limiterFor returns the limiter shared by every connection to the archive
+// at target, with the latest limits, or nil when it is not limited.
+func limiterFor(target *url.URL, bytesPerSecond int64, requestsPerSecond float64) *httpLimiter {
+	if bytesPerSecond == 0 && requestsPerSecond == 0 {
+		return nil
+	}
+
+	key := archiveKey(target)
+
+	httpLimitersMutex.Lock()
+	defer httpLimitersMutex.Unlock()
+
+	limiter, ok := httpLimiters[key]
+	if !ok {
+		limiter = &httpLimiter{
+			bytes:    rate.NewLimiter(rate.Inf, 0),
+			requests: rate.NewLimiter(rate.Inf, 0),
+		}
+		httpLimiters[key] = limiter
+	}
+
+	if bytesPerSecond > 0 {
+		limiter.bytes.SetLimit(rate.Limit(bytesPerSecond))
+		limiter.bytes.SetBurst(int(bytesPerSecond))
+	}
+
+	if requestsPerSecond > 0 {
+		limiter.requests.SetLimit(rate.Limit(requestsPerSecond))
+		limiter.requests.SetBurst(max(int(requestsPerSecond), 1))
+	}
+
+	return limiter
+}
+
+// defaultBreakerCooldown is how long a circuit breaker stays open
+// when no cooldown is given.
+const defaultBreakerCooldown = 30 * time.Second
+
+// httpBreaker counts the reads of an archive failing in a row, and
+// refuses reads for a cooldown once there are too many.
+type httpBreaker struct {
+	mutex    sync.Mutex
+	failures int
+	cooldown time.Duration
+	// failed is the number of reads that failed in a row.
+	failed int
+	// openUntil is when a read may be tried again, while open.
+	openUntil time.Time
+	// probing is set while the single read after the cooldown is made.
+	probing bool
+}
+
+//nolint: gochecknoglobals
+var (
+	httpBreakers      = map[string]*httpBreaker{}
+	httpBreakersMutex sync.Mutex
+)
+
+// breakerFor returns the circuit breaker shared by every connection to the
+// archive at target, with the latest settings, or nil when it has none.
+func breakerFor(target *url.URL, failures int, cooldown time.Duration) *httpBreaker {
+	if failures == 0 {
+		return nil
+	}
+
+	if cooldown == 0 {
+		cooldown = defaultBreakerCooldown
+	}
+
+	key := archiveKey(target)
+
+	httpBreakersMutex.Lock()
+	defer httpBreakersMutex.Unlock()
+
+	breaker, ok := httpBreakers[key]
+	if !ok {
+		breaker = &httpBreaker{}
+		httpBreakers[key] = breaker
+	}
+
+	breaker.mutex.Lock()
+	breaker.failures, breaker.cooldown = failures, cooldown
+	breaker.mutex.Unlock()
+
+	return breaker
+}
+
+// allow returns ErrCircuitOpen while the breaker is open, letting a
+// single read through once the cooldown is over.
+func (b *httpBreaker) allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.failed < b.failures {
+		return nil
+	}
+
+	if b.probing || time.Now().Before(b.openUntil) {
+		return ErrCircuitOpen
+	}
+
+	b.probing = true
+
+	return nil
+}
+
+// record counts the outcome of a read, opening the breaker after too
+// many failures in a row, or closing it after a success, and reports
+// whether it opened.
+func (b *httpBreaker) record(succeeded bool) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.probing = false
+
+	if succeeded {
+		b.failed = 0
+
+		return false
+	}
+
+	b.failed++
+	if b.failed >= b.failures {
+		b.openUntil = time.Now().Add(b.cooldown)
+
+		return true
+	}
+
+	return false
+}
+
+// archiveKey identifies the archive at target, without its query, as
+// signatures in the query change and the archive does not.
+func archiveKey(target *url.URL) string {
+	return target.Scheme + "://" + target.Host + target.Path
+}
+
+// wait blocks until a request for size bytes is allowed.
+func (l *httpLimiter) wait(ctx context.Context, size int) error {
+	err := l.requests.Wait(ctx)
+	if err != nil {
+		return fmt.Errorf("could not wait for rate limit: %w", err)
+	}
+
+	// ranges larger than a second of bytes wait a second at a time
+	for size > 0 {
+		tokens := size
+		if l.bytes.Limit() != rate.Inf {
+			tokens = min(size, l.bytes.Burst())
+		}
+
+		err := l.bytes.WaitN(ctx, tokens)
+		if err != nil {
+			return fmt.Errorf("could not wait for rate limit: %w", err)
+		}
+
+		size -= tokens
+	}
+
+	return nil
+}
+
+// cancelBody ends the context of a request once its body is closed.
+type cancelBody struct {
+	io.ReadCloser
+
+	cancel context.CancelFunc
+}
+
+func (c *cancelBody) Close() error {
+	defer c.cancel()
+
+	return c.ReadCloser.Close()
+}
+
+func (h *httpObject) Size() int64 {
+	return h.size
+}
+
+// Version is the ETag, or else the Last-Modified time, of the archive.
+func (h *httpObject) Version() string {
+	return h.locations[0].validator
+}
+
+func (h *httpObject) Close() error {
+	h.cancel()
+
+	// a download in progress is stopped, and removed, before this
+	h.downloadMutex.Lock()
+	defer h.downloadMutex.Unlock()
+
+	if local := h.local.Load(); local != nil {
+		_ = local.Close()
+		_ = os.Remove(local.Name())
+	}
+
+	return nil
+}
+
+// isHTTP reports whether name is an http or https URL.
+func isHTTP(name string) bool {
+	lower := strings.ToLower(name)
+
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
@@ -0,0 +1,1065 @@
+package sqlitezstd_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	"github.com/jtarchie/sqlitezstd/testhelper"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+// origin serves the files of a directory, counting range requests, whole
+// downloads and HEAD requests, failing every request while down is set,
+// and answering range requests after delay.
+type origin struct {
+	handler   http.Handler
+	ranges    atomic.Int64
+	downloads atomic.Int64
+	heads     atomic.Int64
+	down      atomic.Bool
+	delay     atomic.Int64
+}
+
+func (o *origin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if o.down.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+
+		return
+	}
+
+	switch {
+	case r.Header.Get("Range") != "":
+		o.ranges.Add(1)
+		time.Sleep(time.Duration(o.delay.Load()))
+	case r.Method == http.MethodGet:
+		o.downloads.Add(1)
+	case r.Method == http.MethodHead:
+		o.heads.Add(1)
+	}
+
+	o.handler.ServeHTTP(w, r)
+}
+
+// countingTransport counts the requests made through it.
+type countingTransport struct {
+	requests atomic.Int64
+}
+
+func (c *countingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	c.requests.Add(1)
+
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+// writeCertificate writes a self-signed client certificate and its key
+// as PEM files to dir.
+func writeCertificate(dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "reader"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+
+	certificate, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).ToNot(HaveOccurred())
+
+	certPath := filepath.Join(dir, "client.pem")
+	keyPath := filepath.Join(dir, "client.key")
+
+	Expect(os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)).To(Succeed())
+	Expect(os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)).To(Succeed())
+
+	return certificate, certPath, keyPath
+}
+
+// serveOrigin serves dir until the end of the test.
+func serveOrigin(dir string) (*origin, string) {
+	handler := &origin{handler: http.FileServer(http.Dir(dir))}
+
+	server := httptest.NewServer(handler)
+	DeferCleanup(server.Close)
+
+	return handler, server.URL
+}
+
+var _ = Describe("HTTPBackend", func() {
+	var (
+		zstPath string
+		zstName string
+	)
+
+	BeforeEach(func() {
+		err := sqlitezstd.Init()
+		Expect(err).ToNot(HaveOccurred())
+
+		zstPath = createDatabase()
+		zstName = filepath.Base(zstPath)
+	})
+
+	Describe("mirrors", func() {
+		It("fails over to a mirror when the origin fails", func() {
+			primary, primaryURL := serveOrigin(filepath.Dir(zstPath))
+			mirror, mirrorURL := serveOrigin(filepath.Dir(zstPath))
+
+			dsn := fmt.Sprintf("file:%s/%s?vfs=zstd&mirror=%s/%s", primaryURL, zstName, mirrorURL, zstName)
+			Expect(countEntries(dsn)).To(BeEquivalentTo(1000))
+			Expect(mirror.ranges.Load()).To(BeZero())
+
+			primary.down.Store(true)
+
+			Expect(countEntries(dsn)).To(BeEquivalentTo(1000))
+			Expect(mirror.ranges.Load()).To(BeNumerically(">", 0))
+		})
+
+		It("logs the mirrors left out", func() {
+			primary, primaryURL := serveOrigin(filepath.Dir(zstPath))
+			_, mirrorURL := serveOrigin(filepath.Dir(zstPath))
+
+			output := gbytes.NewBuffer()
+			sqlitezstd.RegisterBackend("http", &sqlitezstd.HTTPBackend{
+				Logger: slog.New(slog.NewTextHandler(output, nil)),
+			})
+			DeferCleanup(sqlitezstd.RegisterBackend, "http", &sqlitezstd.HTTPBackend{})
+
+			dsn := fmt.Sprintf("file:%s/%s?vfs=zstd&mirror=%s/%s", primaryURL, zstName, mirrorURL, zstName)
+			Expect(countEntries(dsn)).To(BeEquivalentTo(1000))
+			Expect(output.Contents()).To(BeEmpty())
+
+			primary.down.Store(true)
+
+			Expect(countEntries(dsn)).To(BeEquivalentTo(1000))
+			Expect(output).To(gbytes.Say(`level=WARN msg="leaving out unreachable mirror" url=%s/%s error=`, primaryURL, zstName))
+		})
+
+		It("takes turns between mirrors", func() {
+			primary, primaryURL := serveOrigin(filepath.Dir(zstPath))
+			mirror, mirrorURL := serveOrigin(filepath.Dir(zstPath))
+
+			dsn := fmt.Sprintf("file:%s/%s?vfs=zstd&mirror=%s/%s&mirror_mode=round-robin&range_size=-1", primaryURL, zstName, mirrorURL, zstName)
+			Expect(countEntries(dsn)).To(BeEquivalentTo(1000))
+			Expect(primary.ranges.Load()).To(BeNumerically(">", 0))
+			Expect(mirror.ranges.Load()).To(BeNumerically(">", 0))
+		})
+
+		It("refuses mirrors holding a different archive", func() {
+			_, primaryURL := serveOrigin(filepath.Dir(zstPath))
+
+			otherDir := GinkgoT().TempDir()
+			Expect(os.WriteFile(filepath.Join(otherDir, zstName), []byte("not the same archive"), 0o600)).To(Succeed())
+
+			_, mirrorURL := serveOrigin(otherDir)
+
+			_, err := sqlitezstd.Inspect(fmt.Sprintf("%s/%s?mirror=%s/%s", primaryURL, zstName, mirrorURL, zstName))
+			Expect(err).To(MatchError(sqlitezstd.ErrMirrorMismatch))
+		})
+	})
+
+	It("refreshes expired signed URLs", func() {
+		files := http.FileServer(http.Dir(filepath.Dir(zstPath)))
+
+		var (
+			signature atomic.Int64
+			ranges    atomic.Int64
+		)
+
+		signature.Store(1)
+
+		// signatures expire after a few range requests
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("sig") != fmt.Sprint(signature.Load()) {
+				w.WriteHeader(http.StatusForbidden)
+
+				return
+			}
+
+			if r.Header.Get("Range") != "" && ranges.Add(1)%3 == 0 {
+				signature.Add(1)
+			}
+
+			files.ServeHTTP(w, r)
+		}))
+		DeferCleanup(server.Close)
+
+		refreshes := 0
+
+		sqlitezstd.RegisterBackend("http", &sqlitezstd.HTTPBackend{
+			RangeSize: -1,
+			Refresh: func(expired *url.URL) (*url.URL, error) {
+				refreshes++
+
+				query := expired.Query()
+				query.Set("sig", fmt.Sprint(signature.Load()))
+				expired.RawQuery = query.Encode()
+
+				return expired, nil
+			},
+		})
+		DeferCleanup(sqlitezstd.RegisterBackend, "http", &sqlitezstd.HTTPBackend{})
+
+		Expect(countEntries(fmt.Sprintf("file:%s/%s?sig=1&vfs=zstd", server.URL, zstName))).To(BeEquivalentTo(1000))
+		Expect(refreshes).To(BeNumerically(">", 0))
+
+		err := sqlitezstd.Verify(fmt.Sprintf("%s/%s?sig=%d", server.URL, zstName, signature.Load()))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("reads signed URLs given in the url parameter as they are", func() {
+		files := http.FileServer(http.Dir(filepath.Dir(zstPath)))
+
+		// the order and escaping of the query are part of the signature
+		signed := "X-Amz-Signature=ab%2bcd%3d&X-Amz-Credential=AKIA%2F20260101%2Fus-east-1&X-Amz-Expires=3600"
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.RawQuery != signed {
+				w.WriteHeader(http.StatusForbidden)
+
+				return
+			}
+
+			files.ServeHTTP(w, r)
+		}))
+		DeferCleanup(server.Close)
+
+		location := fmt.Sprintf("%s/%s?%s", server.URL, zstName, signed)
+
+		Expect(countEntries("file:remote.db?vfs=zstd&range_size=-1&url=" + url.QueryEscape(location))).To(BeEquivalentTo(1000))
+		Expect(sqlitezstd.Verify(location)).To(Succeed())
+	})
+
+	It("logs reads slower than a threshold with where their time went", func() {
+		server, serverURL := serveOrigin(filepath.Dir(zstPath))
+
+		output := gbytes.NewBuffer()
+		logger := slog.New(slog.NewTextHandler(output, nil))
+
+		Expect(sqlitezstd.Register("zstd-slow-read", &sqlitezstd.ZstdVFS{Logger: logger, SlowRead: time.Hour})).To(Succeed())
+
+		dsn := fmt.Sprintf("file:%s/%s?vfs=zstd-slow-read&range_size=-1", serverURL, zstName)
+		Expect(countEntries(dsn)).To(BeEquivalentTo(1000))
+		Expect(output.Contents()).To(BeEmpty())
+
+		server.delay.Store(int64(50 * time.Millisecond))
+
+		Expect(countEntries(dsn + "&slow_read=25ms")).To(BeEquivalentTo(1000))
+		Expect(output).To(gbytes.Say(`level=WARN msg="slow read" name=%s/%s offset=\d+ length=\d+ frame=\d+ elapsed=\d+\.?\d*ms fetch=[1-9]\d*\.?\d*ms fetched=[1-9]\d* decode=\S+ frames_decoded=[1-9]`, serverURL, zstName))
+
+		client, err := sql.Open("sqlite3", dsn+"&slow_read=soon")
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		Expect(client.Ping()).ToNot(Succeed())
+	})
+
+	Describe("redirects", func() {
+		var (
+			storageURL string
+			redirects  atomic.Int64
+		)
+
+		// redirect sends every request to storage, through hops redirects
+		redirect := func(hops int) string {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				redirects.Add(1)
+
+				hop := 0
+				_, _ = fmt.Sscanf(r.URL.Query().Get("hop"), "%d", &hop)
+
+				if hop+1 < hops {
+					http.Redirect(w, r, fmt.Sprintf("%s?hop=%d", r.URL.Path, hop+1), http.StatusFound)
+
+					return
+				}
+
+				http.Redirect(w, r, storageURL+r.URL.Path, http.StatusFound)
+			}))
+			DeferCleanup(server.Close)
+
+			return server.URL
+		}
+
+		BeforeEach(func() {
+			redirects.Store(0)
+
+			files := http.FileServer(http.Dir(filepath.Dir(zstPath)))
+
+			// storage is on another host than the redirects, requiring the token
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer token" {
+					w.WriteHeader(http.StatusUnauthorized)
+
+					return
+				}
+
+				files.ServeHTTP(w, r)
+			}))
+			DeferCleanup(server.Close)
+
+			storageURL = strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+			sqlitezstd.RegisterBackend("http", &sqlitezstd.HTTPBackend{BearerToken: "token"})
+			DeferCleanup(sqlitezstd.RegisterBackend, "http", &sqlitezstd.HTTPBackend{})
+		})
+
+		It("forwards the Authorization header to other hosts only when asked", func() {
+			location := fmt.Sprintf("%s/%s", redirect(1), zstName)
+
+			_, err := sqlitezstd.Inspect(location)
+			Expect(err).To(MatchError(sqlitezstd.ErrUnexpectedResponse))
+
+			Expect(countEntries(fmt.Sprintf("file:%s?vfs=zstd&redirect_auth=true", location))).To(BeEquivalentTo(1000))
+		})
+
+		It("limits the redirects followed", func() {
+			location := fmt.Sprintf("%s/%s", redirect(3), zstName)
+
+			_, err := sqlitezstd.Inspect(location + "?redirect_auth=true&max_redirects=2")
+			Expect(err).To(MatchError(sqlitezstd.ErrTooManyRedirects))
+
+			_, err = sqlitezstd.Inspect(location + "?redirect_auth=true&max_redirects=-1")
+			Expect(err).To(MatchError(sqlitezstd.ErrTooManyRedirects))
+
+			_, err = sqlitezstd.Inspect(location + "?redirect_auth=true&max_redirects=3")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("pins requests to the URL redirected to", func() {
+			location := fmt.Sprintf("%s/%s", redirect(1), zstName)
+
+			Expect(countEntries(fmt.Sprintf("file:%s?vfs=zstd&redirect_auth=true&range_size=-1", location))).To(BeEquivalentTo(1000))
+			Expect(redirects.Load()).To(BeNumerically(">", 1))
+
+			redirects.Store(0)
+
+			Expect(countEntries(fmt.Sprintf("file:%s?vfs=zstd&redirect_auth=true&range_size=-1&redirect_pin=true", location))).To(BeEquivalentTo(1000))
+			Expect(redirects.Load()).To(BeEquivalentTo(1))
+		})
+	})
+
+	It("makes requests with a custom client", func() {
+		_, serverURL := serveOrigin(filepath.Dir(zstPath))
+
+		transport := &countingTransport{}
+
+		sqlitezstd.RegisterBackend("http", &sqlitezstd.HTTPBackend{
+			Client: &http.Client{Transport: transport},
+		})
+		DeferCleanup(sqlitezstd.RegisterBackend, "http", &sqlitezstd.HTTPBackend{})
+
+		Expect(countEntries(fmt.Sprintf("%s/%s?vfs=zstd", serverURL, zstName))).To(BeEquivalentTo(1000))
+		Expect(transport.requests.Load()).To(BeNumerically(">", 1))
+	})
+
+	It("authenticates with headers from the backend or the environment", func() {
+		files := http.FileServer(http.Dir(filepath.Dir(zstPath)))
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, password, _ := r.BasicAuth()
+
+			switch {
+			case r.Header.Get("Authorization") == "Bearer secret-token",
+				user == "reader" && password == "secret",
+				r.Header.Get("X-Api-Key") == "secret-key":
+				files.ServeHTTP(w, r)
+			default:
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		}))
+		DeferCleanup(server.Close)
+
+		_, err := sqlitezstd.Inspect(fmt.Sprintf("%s/%s", server.URL, zstName))
+		Expect(err).To(MatchError(sqlitezstd.ErrUnexpectedResponse))
+
+		GinkgoT().Setenv("DATASET_TOKEN", "secret-token")
+		GinkgoT().Setenv("DATASET_CREDENTIALS", "reader:secret")
+		GinkgoT().Setenv("DATASET_KEY", "secret-key")
+
+		Expect(countEntries(fmt.Sprintf("file:%s/%s?vfs=zstd&auth_bearer_env=DATASET_TOKEN", server.URL, zstName))).To(BeEquivalentTo(1000))
+		Expect(countEntries(fmt.Sprintf("file:%s/%s?vfs=zstd&auth_basic_env=DATASET_CREDENTIALS", server.URL, zstName))).To(BeEquivalentTo(1000))
+		Expect(countEntries(fmt.Sprintf("file:%s/%s?vfs=zstd&header_env=X-Api-Key:DATASET_KEY", server.URL, zstName))).To(BeEquivalentTo(1000))
+
+		_, err = sqlitezstd.Inspect(fmt.Sprintf("%s/%s?auth_bearer_env=MISSING_TOKEN", server.URL, zstName))
+		Expect(err).To(MatchError(sqlitezstd.ErrMissingEnv))
+
+		sqlitezstd.RegisterBackend("http", &sqlitezstd.HTTPBackend{BearerToken: "secret-token"})
+		DeferCleanup(sqlitezstd.RegisterBackend, "http", &sqlitezstd.HTTPBackend{})
+
+		Expect(countEntries(fmt.Sprintf("%s/%s?vfs=zstd", server.URL, zstName))).To(BeEquivalentTo(1000))
+	})
+
+	It("gives up on requests that take too long", func() {
+		files := http.FileServer(http.Dir(filepath.Dir(zstPath)))
+		release := make(chan struct{})
+
+		// range requests hang until the end of the test
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Range") != "" {
+				<-release
+			}
+
+			files.ServeHTTP(w, r)
+		}))
+		DeferCleanup(server.Close)
+		DeferCleanup(func() { close(release) })
+
+		start := time.Now()
+
+		_, err := sqlitezstd.Inspect(fmt.Sprintf("%s/%s?request_timeout=100ms", server.URL, zstName))
+		Expect(err).To(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+
+		start = time.Now()
+
+		_, err = sqlitezstd.Inspect(fmt.Sprintf("%s/%s?header_timeout=100ms", server.URL, zstName))
+		Expect(err).To(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+
+		_, err = sqlitezstd.Inspect(fmt.Sprintf("%s/%s?connect_timeout=soon", server.URL, zstName))
+		Expect(err).To(MatchError(sqlitezstd.ErrInvalidOption))
+	})
+
+	It("makes requests through an authenticated proxy", func() {
+		files := http.FileServer(http.Dir(filepath.Dir(zstPath)))
+
+		var proxied atomic.Int64
+
+		// the proxy serves every host it is asked for from the same files
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Proxy-Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte("user:pass")) {
+				w.WriteHeader(http.StatusProxyAuthRequired)
+
+				return
+			}
+
+			proxied.Add(1)
+			files.ServeHTTP(w, r)
+		}))
+		DeferCleanup(proxy.Close)
+
+		proxyURL, err := url.Parse(proxy.URL)
+		Expect(err).ToNot(HaveOccurred())
+
+		proxyURL.User = url.UserPassword("user", "pass")
+		GinkgoT().Setenv("DATASET_PROXY", proxyURL.String())
+
+		Expect(countEntries(fmt.Sprintf("file:http://archive.invalid/%s?vfs=zstd&proxy_env=DATASET_PROXY", zstName))).To(BeEquivalentTo(1000))
+		Expect(proxied.Load()).To(BeNumerically(">", 1))
+
+		_, err = sqlitezstd.Inspect(fmt.Sprintf("http://archive.invalid/%s?proxy=%s", zstName, url.QueryEscape(proxy.URL)))
+		Expect(err).To(MatchError(sqlitezstd.ErrUnexpectedResponse))
+
+		_, err = sqlitezstd.Inspect(fmt.Sprintf("http://archive.invalid/%s?proxy=ftp://proxy.invalid", zstName))
+		Expect(err).To(MatchError(sqlitezstd.ErrInvalidOption))
+	})
+
+	It("reads from servers requiring client certificates", func() {
+		dir := GinkgoT().TempDir()
+		client, certPath, keyPath := writeCertificate(dir)
+
+		clients := x509.NewCertPool()
+		clients.AddCert(client)
+
+		server := httptest.NewUnstartedServer(http.FileServer(http.Dir(filepath.Dir(zstPath))))
+		server.TLS = &tls.Config{
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  clients,
+			MaxVersion: tls.VersionTLS12,
+		}
+		server.StartTLS()
+		DeferCleanup(server.Close)
+
+		caPath := filepath.Join(dir, "ca.pem")
+		Expect(os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600)).To(Succeed())
+
+		name := fmt.Sprintf("%s/%s?tls_ca=%s", server.URL, zstName, caPath)
+
+		_, err := sqlitezstd.Inspect(name)
+		Expect(err).To(HaveOccurred())
+
+		name += fmt.Sprintf("&tls_cert=%s&tls_key=%s", certPath, keyPath)
+		Expect(countEntries("file:" + name + "&vfs=zstd")).To(BeEquivalentTo(1000))
+
+		hash := sha256.Sum256(server.Certificate().RawSubjectPublicKeyInfo)
+		pin := "sha256/" + base64.StdEncoding.EncodeToString(hash[:])
+
+		_, err = sqlitezstd.Inspect(name + "&tls_pin=" + url.QueryEscape(pin))
+		Expect(err).ToNot(HaveOccurred())
+
+		otherHash := sha256.Sum256(client.RawSubjectPublicKeyInfo)
+		_, err = sqlitezstd.Inspect(name + "&tls_pin=" + url.QueryEscape(base64.StdEncoding.EncodeToString(otherHash[:])))
+		Expect(err).To(MatchError(sqlitezstd.ErrPinMismatch))
+
+		_, err = sqlitezstd.Inspect(name + "&tls_min_version=1.3")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("tunes the connection pool shared by archives", func() {
+		files := http.FileServer(http.Dir(filepath.Dir(zstPath)))
+
+		var (
+			connections atomic.Int64
+			http2       atomic.Int64
+		)
+
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ProtoMajor == 2 {
+				http2.Add(1)
+			}
+
+			files.ServeHTTP(w, r)
+		}))
+		server.EnableHTTP2 = true
+		server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				connections.Add(1)
+			}
+		}
+		server.StartTLS()
+		DeferCleanup(server.Close)
+
+		caPath := filepath.Join(GinkgoT().TempDir(), "ca.pem")
+		Expect(os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600)).To(Succeed())
+
+		dsn := fmt.Sprintf("file:%s/%s?vfs=zstd&tls_ca=%s&range_size=-1&max_idle_conns_per_host=16&idle_timeout=1m", server.URL, zstName, caPath)
+
+		Expect(countEntries(dsn)).To(BeEquivalentTo(1000))
+		Expect(http2.Load()).To(BeNumerically(">", 0))
+
+		opened := connections.Load()
+
+		Expect(countEntries(dsn)).To(BeEquivalentTo(1000))
+		Expect(connections.Load()).To(Equal(opened))
+
+		http2.Store(0)
+		connections.Store(0)
+
+		Expect(countEntries(dsn + "&http2=false&keep_alive=false")).To(BeEquivalentTo(1000))
+		Expect(http2.Load()).To(BeZero())
+		Expect(connections.Load()).To(BeNumerically(">", 1))
+
+		_, err := sqlitezstd.Inspect(fmt.Sprintf("%s/%s?tls_ca=%s&max_idle_conns_per_host=many", server.URL, zstName, caPath))
+		Expect(err).To(MatchError(sqlitezstd.ErrInvalidOption))
+	})
+
+	It("hedges range requests that take too long", func() {
+		files := http.FileServer(http.Dir(filepath.Dir(zstPath)))
+
+		var (
+			ranges    atomic.Int64
+			abandoned atomic.Bool
+		)
+
+		// the first range request hangs until it is given up on
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Range") != "" && ranges.Add(1) == 1 {
+				<-r.Context().Done()
+				abandoned.Store(true)
+
+				return
+			}
+
+			files.ServeHTTP(w, r)
+		}))
+		DeferCleanup(server.Close)
+
+		Expect(countEntries(fmt.Sprintf("file:%s/%s?vfs=zstd&hedge_delay=50ms", server.URL, zstName))).To(BeEquivalentTo(1000))
+		Eventually(abandoned.Load).Should(BeTrue())
+	})
+
+	It("limits the request rate across connections to an archive", func() {
+		origin, serverURL := serveOrigin(filepath.Dir(zstPath))
+		dsn := fmt.Sprintf("file:%s/%s?vfs=zstd&range_size=-1&rate_requests=5", serverURL, zstName)
+
+		start := time.Now()
+
+		var wg sync.WaitGroup
+
+		for range 2 {
+			wg.Add(1)
+
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				Expect(countEntries(dsn)).To(BeEquivalentTo(1000))
+			}()
+		}
+
+		wg.Wait()
+
+		// the connections share a bucket of 5 requests a second
+		Expect(origin.ranges.Load()).To(BeNumerically(">", 5))
+		Expect(time.Since(start)).To(BeNumerically(">=", time.Duration(origin.ranges.Load()-5)*time.Second/5))
+
+		_, err := sqlitezstd.Inspect(fmt.Sprintf("%s/%s?rate_bytes=fast", serverURL, zstName))
+		Expect(err).To(MatchError(sqlitezstd.ErrInvalidOption))
+	})
+
+	It("opens archives on servers that reject HEAD", func() {
+		files := http.FileServer(http.Dir(filepath.Dir(zstPath)))
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+
+				return
+			}
+
+			files.ServeHTTP(w, r)
+		}))
+		DeferCleanup(server.Close)
+
+		Expect(countEntries(fmt.Sprintf("%s/%s?vfs=zstd", server.URL, zstName))).To(BeEquivalentTo(1000))
+
+		_, err := sqlitezstd.Inspect(fmt.Sprintf("%s/missing.zst", server.URL))
+		Expect(err).To(MatchError(sqlitezstd.ErrUnexpectedResponse))
+	})
+
+	It("downloads archives from servers that ignore Range when allowed", func() {
+		files := http.FileServer(http.Dir(filepath.Dir(zstPath)))
+
+		var requests atomic.Int64
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			r.Header.Del("Range")
+			files.ServeHTTP(w, r)
+		}))
+		DeferCleanup(server.Close)
+
+		_, err := sqlitezstd.Inspect(fmt.Sprintf("%s/%s", server.URL, zstName))
+		Expect(err).To(MatchError(sqlitezstd.ErrRangesUnsupported))
+
+		_, err = sqlitezstd.Inspect(fmt.Sprintf("%s/%s?download_limit=100", server.URL, zstName))
+		Expect(err).To(MatchError(sqlitezstd.ErrDownloadTooLarge))
+
+		requests.Store(0)
+
+		Expect(countEntries(fmt.Sprintf("file:%s/%s?vfs=zstd&download_limit=10000000", server.URL, zstName))).To(BeEquivalentTo(1000))
+		Expect(requests.Load()).To(BeEquivalentTo(2))
+	})
+
+	It("shares one archive between the connections open to it", func() {
+		files := http.FileServer(http.Dir(filepath.Dir(zstPath)))
+
+		var stats atomic.Int64
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				stats.Add(1)
+			}
+
+			files.ServeHTTP(w, r)
+		}))
+		DeferCleanup(server.Close)
+
+		client, err := sql.Open("sqlite3", fmt.Sprintf("file:%s/%s?vfs=zstd", server.URL, zstName))
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(client.Close)
+
+		connections := []*sql.Conn{}
+
+		for range 4 {
+			connection, err := client.Conn(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+
+			var count int64
+			Expect(connection.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM entries;").Scan(&count)).To(Succeed())
+			Expect(count).To(BeEquivalentTo(1000))
+
+			connections = append(connections, connection)
+		}
+
+		Expect(stats.Load()).To(BeEquivalentTo(1))
+
+		for _, connection := range connections {
+			Expect(connection.Close()).To(Succeed())
+		}
+
+		// the archive is opened again once every connection closed
+		Expect(client.Close()).To(Succeed())
+		Expect(countEntries(fmt.Sprintf("file:%s/%s?vfs=zstd", server.URL, zstName))).To(BeEquivalentTo(1000))
+		Expect(stats.Load()).To(BeEquivalentTo(2))
+	})
+
+	It("switches to a download of the archive once it completes", func() {
+		origin, serverURL := serveOrigin(filepath.Dir(zstPath))
+
+		uri, err := url.Parse(fmt.Sprintf("%s/%s?background_download=true", serverURL, zstName))
+		Expect(err).ToNot(HaveOccurred())
+
+		object, err := (&sqlitezstd.HTTPBackend{RangeSize: -1}).Open(uri)
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(object.Close)
+
+		Eventually(origin.downloads.Load).Should(BeEquivalentTo(1))
+
+		// reads keep working once the origin is gone
+		origin.down.Store(true)
+
+		Eventually(func() error {
+			_, err := object.ReadAt(make([]byte, 100), object.Size()-100)
+
+			return err
+		}).Should(Succeed())
+		Expect(origin.downloads.Load()).To(BeEquivalentTo(1))
+	})
+
+	It("counts the requests, bytes, and latency of every archive", func() {
+		origin, serverURL := serveOrigin(filepath.Dir(zstPath))
+
+		backend := &sqlitezstd.HTTPBackend{RangeSize: -1}
+		sqlitezstd.RegisterBackend("http", backend)
+		DeferCleanup(sqlitezstd.RegisterBackend, "http", &sqlitezstd.HTTPBackend{})
+
+		Expect(countEntries(fmt.Sprintf("%s/%s?vfs=zstd", serverURL, zstName))).To(BeEquivalentTo(1000))
+		Expect(countEntries(fmt.Sprintf("file:%s/%s?vfs=zstd&sig=rotated", serverURL, zstName))).To(BeEquivalentTo(1000))
+
+		stats := backend.Stats()
+		Expect(stats).To(HaveLen(1))
+
+		archive := stats[fmt.Sprintf("%s/%s", serverURL, zstName)]
+		Expect(archive.Requests).To(Equal(origin.ranges.Load()))
+		Expect(archive.Failures).To(BeZero())
+		Expect(archive.BytesTransferred).To(BeNumerically(">", 0))
+		Expect(archive.MeanLatency()).To(BeNumerically(">", 0))
+		Expect(archive.Latency).To(HaveLen(len(sqlitezstd.LatencyBuckets()) + 1))
+
+		total := int64(0)
+		for _, count := range archive.Latency {
+			total += count
+		}
+
+		Expect(total).To(Equal(archive.Requests))
+	})
+
+	It("stops reading from a failing origin until the cooldown is over", func() {
+		origin, serverURL := serveOrigin(filepath.Dir(zstPath))
+
+		uri, err := url.Parse(fmt.Sprintf("%s/%s?breaker_failures=2&breaker_cooldown=500ms", serverURL, zstName))
+		Expect(err).ToNot(HaveOccurred())
+
+		object, err := (&sqlitezstd.HTTPBackend{RangeSize: -1}).Open(uri)
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(object.Close)
+
+		buffer := make([]byte, 100)
+
+		origin.down.Store(true)
+
+		for range 2 {
+			_, err = object.ReadAt(buffer, 0)
+			Expect(err).To(MatchError(sqlitezstd.ErrUnexpectedResponse))
+		}
+
+		origin.down.Store(false)
+
+		_, err = object.ReadAt(buffer, 0)
+		Expect(err).To(MatchError(sqlitezstd.ErrCircuitOpen))
+		Expect(origin.ranges.Load()).To(BeZero())
+
+		Eventually(func() error {
+			_, err := object.ReadAt(buffer, 0)
+
+			return err
+		}).WithTimeout(5 * time.Second).Should(Succeed())
+		Expect(origin.ranges.Load()).To(BeEquivalentTo(1))
+
+		_, err = sqlitezstd.Inspect(fmt.Sprintf("%s/%s?breaker_failures=-1", serverURL, zstName))
+		Expect(err).To(MatchError(sqlitezstd.ErrInvalidOption))
+	})
+
+	It("traces every range request", func() {
+		_, serverURL := serveOrigin(filepath.Dir(zstPath))
+
+		var (
+			mutex     sync.Mutex
+			requests  []sqlitezstd.RangeRequest
+			ended     atomic.Int64
+			connected atomic.Int64
+		)
+
+		sqlitezstd.RegisterBackend("http", &sqlitezstd.HTTPBackend{
+			RangeSize: -1,
+			Trace: func(ctx context.Context, request sqlitezstd.RangeRequest) (context.Context, func(error)) {
+				mutex.Lock()
+				requests = append(requests, request)
+				mutex.Unlock()
+
+				ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+					GotConn: func(httptrace.GotConnInfo) { connected.Add(1) },
+				})
+
+				return ctx, func(err error) {
+					Expect(err).ToNot(HaveOccurred())
+					ended.Add(1)
+				}
+			},
+		})
+		DeferCleanup(sqlitezstd.RegisterBackend, "http", &sqlitezstd.HTTPBackend{})
+
+		Expect(countEntries(fmt.Sprintf("%s/%s?vfs=zstd", serverURL, zstName))).To(BeEquivalentTo(1000))
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		Expect(requests).ToNot(BeEmpty())
+		Expect(ended.Load()).To(BeEquivalentTo(len(requests)))
+		Expect(connected.Load()).To(BeEquivalentTo(len(requests)))
+		Expect(requests).To(ContainElement(And(
+			HaveField("URL", fmt.Sprintf("%s/%s", serverURL, zstName)),
+			HaveField("Frame", 0),
+			HaveField("Offset", BeZero()),
+		)))
+	})
+
+	It("coalesces small reads into larger ranges", func() {
+		origin, serverURL := serveOrigin(filepath.Dir(zstPath))
+
+		Expect(countEntries(fmt.Sprintf("file:%s/%s?vfs=zstd&range_size=-1", serverURL, zstName))).To(BeEquivalentTo(1000))
+
+		uncoalesced := origin.ranges.Swap(0)
+
+		Expect(countEntries(fmt.Sprintf("%s/%s?vfs=zstd", serverURL, zstName))).To(BeEquivalentTo(1000))
+		Expect(origin.ranges.Load()).To(BeNumerically("<", uncoalesced))
+
+		_, err := sqlitezstd.Inspect(fmt.Sprintf("%s/%s?range_size=big", serverURL, zstName))
+		Expect(err).To(MatchError(sqlitezstd.ErrInvalidOption))
+	})
+
+	It("aligns ranges to blocks", func() {
+		files := http.FileServer(http.Dir(filepath.Dir(zstPath)))
+
+		info, err := os.Stat(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		var (
+			mutex  sync.Mutex
+			ranges []string
+		)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if header := r.Header.Get("Range"); header != "" {
+				mutex.Lock()
+				ranges = append(ranges, header)
+				mutex.Unlock()
+			}
+
+			files.ServeHTTP(w, r)
+		}))
+		DeferCleanup(server.Close)
+
+		Expect(countEntries(fmt.Sprintf("file:%s/%s?vfs=zstd&range_size=100&range_align=4096", server.URL, zstName))).To(BeEquivalentTo(1000))
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		Expect(ranges).ToNot(BeEmpty())
+
+		for _, header := range ranges {
+			var start, end int64
+
+			_, err := fmt.Sscanf(header, "bytes=%d-%d", &start, &end)
+			Expect(err).ToNot(HaveOccurred())
+
+			// the first byte is requested alone to find the size
+			if header == "bytes=0-0" {
+				continue
+			}
+
+			Expect(start % 4096).To(BeZero(), header)
+			Expect((end+1)%4096 == 0 || end == info.Size()-1).To(BeTrue(), header)
+		}
+
+		_, err = sqlitezstd.Inspect(fmt.Sprintf("%s/%s?range_align=-1", server.URL, zstName))
+		Expect(err).To(MatchError(sqlitezstd.ErrInvalidOption))
+	})
+
+	It("prefetches ranges concurrently during scans", func() {
+		rows := make([]string, 0, 5000)
+		for id := 1; id <= 5000; id++ {
+			rows = append(rows, fmt.Sprintf("INSERT INTO entries (id, body) VALUES (%d, hex(randomblob(64)))", id))
+		}
+
+		largePath := testhelper.CreateCompressedDB(GinkgoT(), "CREATE TABLE entries (id INTEGER PRIMARY KEY, body TEXT);", rows, sqlitezstd.WithFrameSize(4096))
+		files := http.FileServer(http.Dir(filepath.Dir(largePath)))
+
+		var inFlight, mostInFlight atomic.Int64
+
+		// slow range requests overlap when they are made concurrently
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Range") != "" {
+				current := inFlight.Add(1)
+				defer inFlight.Add(-1)
+
+				for {
+					most := mostInFlight.Load()
+					if current <= most || mostInFlight.CompareAndSwap(most, current) {
+						break
+					}
+				}
+
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			files.ServeHTTP(w, r)
+		}))
+		DeferCleanup(server.Close)
+
+		dsn := fmt.Sprintf("file:%s/%s?vfs=zstd&range_size=16384&prefetch=4", server.URL, filepath.Base(largePath))
+		Expect(countEntries(dsn)).To(BeEquivalentTo(5000))
+		Expect(mostInFlight.Load()).To(BeNumerically(">", 1))
+
+		_, err := sqlitezstd.Inspect(fmt.Sprintf("%s/%s?prefetch=-1", server.URL, zstName))
+		Expect(err).To(MatchError(sqlitezstd.ErrInvalidOption))
+	})
+
+	It("opens archives with one request for their tail in cold start mode", func() {
+		rows := make([]string, 0, 2000)
+		for id := 1; id <= 2000; id++ {
+			rows = append(rows, fmt.Sprintf("INSERT INTO entries (id, body) VALUES (%d, hex(randomblob(256)))", id))
+		}
+
+		largePath := testhelper.CreateCompressedDB(GinkgoT(), "CREATE TABLE entries (id INTEGER PRIMARY KEY, body TEXT);", rows, sqlitezstd.WithFrameSize(4096))
+		DeferCleanup(os.Remove, largePath)
+
+		// a copy, so neither finds the seek table of the other cached
+		contents, err := os.ReadFile(largePath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(contents)).To(BeNumerically(">", 256*1024))
+		Expect(os.WriteFile(largePath+".cold", contents, 0o600)).To(Succeed())
+
+		handler, serverURL := serveOrigin(filepath.Dir(largePath))
+
+		Expect(countEntries(fmt.Sprintf("file:%s/%s?vfs=zstd&range_size=-1", serverURL, filepath.Base(largePath)))).To(BeEquivalentTo(2000))
+		Expect(handler.heads.Load()).To(BeEquivalentTo(1))
+
+		warm := handler.ranges.Load()
+		handler.heads.Store(0)
+		handler.ranges.Store(0)
+
+		Expect(countEntries(fmt.Sprintf("file:%s/%s.cold?vfs=zstd&range_size=-1&cold_start=true", serverURL, filepath.Base(largePath)))).To(BeEquivalentTo(2000))
+		Expect(handler.heads.Load()).To(BeZero())
+		Expect(handler.ranges.Load()).To(BeNumerically("<", warm))
+
+		client, err := sql.Open("sqlite3", fmt.Sprintf("file:%s/%s?vfs=zstd&cold_start=maybe", serverURL, filepath.Base(largePath)))
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(client.Close)
+
+		Expect(client.Ping()).ToNot(Succeed())
+	})
+
+	Describe("archives replaced while open", func() {
+		read := func(name string) (sqlitezstd.Object, error) {
+			uri, err := url.Parse(name)
+			Expect(err).ToNot(HaveOccurred())
+
+			object, err := (&sqlitezstd.HTTPBackend{RangeSize: -1}).Open(uri)
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(object.Close)
+
+			_, err = object.ReadAt(make([]byte, 100), 0)
+
+			return object, err
+		}
+
+		It("fails reads once the ETag changes", func() {
+			contents, err := os.ReadFile(zstPath)
+			Expect(err).ToNot(HaveOccurred())
+
+			var version atomic.Int64
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", fmt.Sprintf(`"v%d"`, version.Load()))
+				http.ServeContent(w, r, zstName, time.Time{}, bytes.NewReader(contents))
+			}))
+			DeferCleanup(server.Close)
+
+			object, err := read(fmt.Sprintf("%s/%s", server.URL, zstName))
+			Expect(err).ToNot(HaveOccurred())
+
+			version.Add(1)
+
+			_, err = object.ReadAt(make([]byte, 100), 0)
+			Expect(err).To(MatchError(sqlitezstd.ErrArchiveChanged))
+		})
+
+		It("fails reads once the modification time changes", func() {
+			_, serverURL := serveOrigin(filepath.Dir(zstPath))
+
+			object, err := read(fmt.Sprintf("%s/%s", serverURL, zstName))
+			Expect(err).ToNot(HaveOccurred())
+
+			later := time.Now().Add(time.Hour)
+			Expect(os.Chtimes(zstPath, later, later)).To(Succeed())
+
+			_, err = object.ReadAt(make([]byte, 100), 0)
+			Expect(err).To(MatchError(sqlitezstd.ErrArchiveChanged))
+		})
+
+		It("fails reads once the size changes on servers without validators", func() {
+			contents, err := os.ReadFile(zstPath)
+			Expect(err).ToNot(HaveOccurred())
+
+			var current atomic.Pointer[[]byte]
+			current.Store(&contents)
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.ServeContent(w, r, zstName, time.Time{}, bytes.NewReader(*current.Load()))
+			}))
+			DeferCleanup(server.Close)
+
+			object, err := read(fmt.Sprintf("%s/%s", server.URL, zstName))
+			Expect(err).ToNot(HaveOccurred())
+
+			grown := append(bytes.Clone(contents), make([]byte, 100)...)
+			current.Store(&grown)
+
+			_, err = object.ReadAt(make([]byte, 100), 0)
+			Expect(err).To(MatchError(sqlitezstd.ErrSizeChanged))
+
+			shrunk := contents[:100]
+			current.Store(&shrunk)
+
+			_, err = object.ReadAt(make([]byte, 100), 200)
+			Expect(err).To(MatchError(sqlitezstd.ErrSizeChanged))
+		})
+	})
+})
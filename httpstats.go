@@ -0,0 +1,156 @@
+package sqlitezstd
+
+import (
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+//nolint: gochecknoglobals
+var latencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// LatencyBuckets returns the upper bounds of the buckets of HTTPStats.Latency.
+// The last bucket counts the requests slower than all of them.
+func LatencyBuckets() []time.Duration {
+	return append([]time.Duration{}, latencyBuckets...)
+}
+
+// HTTPStats is what the connections to an archive cost in network terms.
+type HTTPStats struct {
+	// Requests is the number of range requests made, hedges included.
+	Requests int64
+	// Failures is the number of range requests that failed.
+	Failures int64
+	// BytesTransferred is the number of compressed bytes received,
+	// including whole downloads.
+	BytesTransferred int64
+	// Latency counts the range requests by how long they took, in the
+	// buckets of LatencyBuckets, with one more for slower requests.
+	Latency []int64
+	// TotalLatency is the time all range requests took.
+	TotalLatency time.Duration
+}
+
+// MeanLatency is the average time a range request took.
+func (s HTTPStats) MeanLatency() time.Duration {
+	if s.Requests == 0 {
+		return 0
+	}
+
+	return s.TotalLatency / time.Duration(s.Requests)
+}
+
+// Add returns the sum of both stats, such as for an archive opened with
+// more than one backend.
+func (s HTTPStats) Add(other HTTPStats) HTTPStats {
+	sum := s
+	sum.Requests += other.Requests
+	sum.Failures += other.Failures
+	sum.BytesTransferred += other.BytesTransferred
+	sum.TotalLatency += other.TotalLatency
+	sum.Latency = make([]int64, max(len(s.Latency), len(other.Latency)))
+
+	copy(sum.Latency, s.Latency)
+
+	for index, count := range other.Latency {
+		sum.Latency[index] += count
+	}
+
+	return sum
+}
+
+// Stats returns the stats of every archive opened with this backend,
+// by their URL without its query.
+func (h *HTTPBackend) Stats() map[string]HTTPStats {
+	h.metricsMutex.Lock()
+	defer h.metricsMutex.Unlock()
+
+	stats := make(map[string]HTTPStats, len(h.metrics))
+
+	for key, metrics := range h.metrics {
+		stats[key] = metrics.snapshot()
+	}
+
+	return stats
+}
+
+// metricsFor returns the metrics shared by every connection to the
+// archive at target.
+func (h *HTTPBackend) metricsFor(target *url.URL) *httpMetrics {
+	h.metricsMutex.Lock()
+	defer h.metricsMutex.Unlock()
+
+	if h.metrics == nil {
+		h.metrics = map[string]*httpMetrics{}
+	}
+
+	key := archiveKey(target)
+
+	metrics, ok := h.metrics[key]
+	if !ok {
+		metrics = &httpMetrics{latency: make([]atomic.Int64, len(latencyBuckets)+1)}
+		h.metrics[key] = metrics
+	}
+
+	return metrics
+}
+
+// httpMetrics counts the range requests for an archive.
+type httpMetrics struct {
+	requests         atomic.Int64
+	failures         atomic.Int64
+	bytesTransferred atomic.Int64
+	latency          []atomic.Int64
+	totalLatency     atomic.Int64
+}
+
+func (m *httpMetrics) record(latency time.Duration, size int, err error) {
+	m.requests.Add(1)
+	m.totalLatency.Add(int64(latency))
+
+	if err != nil {
+		m.failures.Add(1)
+	} else {
+		m.bytesTransferred.Add(int64(size))
+	}
+
+	bucket := len(latencyBuckets)
+
+	for index, bound := range latencyBuckets {
+		if latency <= bound {
+			bucket = index
+
+			break
+		}
+	}
+
+	m.latency[bucket].Add(1)
+}
+
+func (m *httpMetrics) snapshot() HTTPStats {
+	stats := HTTPStats{
+		Requests:         m.requests.Load(),
+		Failures:         m.failures.Load(),
+		BytesTransferred: m.bytesTransferred.Load(),
+		Latency:          make([]int64, len(m.latency)),
+		TotalLatency:     time.Duration(m.totalLatency.Load()),
+	}
+
+	for index := range m.latency {
+		stats.Latency[index] = m.latency[index].Load()
+	}
+
+	return stats
+}
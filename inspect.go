@@ -0,0 +1,161 @@
+package sqlitezstd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ArchiveInfo describes the layout of a compressed file.
+type ArchiveInfo struct {
+	// Frames are the frames holding database contents, in order.
+	Frames []ArchiveFrame
+	// SkippableFrames is the number of frames without contents, like the
+	// header and metadata frames, not counting the seek table itself.
+	SkippableFrames int
+	// SeekTableSize is the size of the seek table at the end of the file.
+	SeekTableSize int64
+	// CompressedSize is the size of the whole file.
+	CompressedSize int64
+	// DecompressedSize is the size of the database.
+	DecompressedSize int64
+	// Checksums reports whether the seek table has frame checksums.
+	Checksums bool
+	// PageSize is the SQLite page size, or 0 if the contents are not a database.
+	PageSize int
+	// PageAligned reports whether every frame starts on a page boundary
+	// and holds whole pages, as found in the frames rather than the header.
+	PageAligned bool
+	// Header is the header written by this package, or nil.
+	Header *Header
+}
+
+// ArchiveFrame is a frame of a compressed file.
+type ArchiveFrame struct {
+	CompressedOffset   int64
+	CompressedSize     int64
+	DecompressedOffset int64
+	DecompressedSize   int64
+}
+
+// Ratio is the compression ratio of the whole file.
+func (a *ArchiveInfo) Ratio() float64 {
+	if a.CompressedSize == 0 {
+		return 0
+	}
+
+	return float64(a.DecompressedSize) / float64(a.CompressedSize)
+}
+
+// Inspect describes the layout of a compressed file, which may be local,
+// remote, or split into parts. Slow reads through the VFS can often be
+// explained by large or unaligned frames.
+func Inspect(path string) (*ArchiveInfo, error) {
+	file, err := openArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return inspect(file, file.Size())
+}
+
+func inspect(reader io.ReaderAt, size int64) (*ArchiveInfo, error) {
+	table, err := decodeSeekTable(reader, size)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ArchiveInfo{
+		SeekTableSize:    table.size,
+		CompressedSize:   size,
+		DecompressedSize: table.decompressedSize,
+		Checksums:        table.checksums,
+	}
+
+	for _, frame := range table.frames {
+		if frame.decompressedSize == 0 {
+			info.SkippableFrames++
+
+			continue
+		}
+
+		info.Frames = append(info.Frames, ArchiveFrame{
+			CompressedOffset:   frame.compressedOffset,
+			CompressedSize:     int64(frame.compressedSize),
+			DecompressedOffset: frame.decompressedOffset,
+			DecompressedSize:   int64(frame.decompressedSize),
+		})
+	}
+
+	info.Header, err = readHeader(reader)
+	if err != nil && !errors.Is(err, ErrNoHeader) {
+		return nil, err
+	}
+
+	info.PageSize, err = contentsPageSize(reader, info)
+	if err != nil {
+		return nil, err
+	}
+
+	info.PageAligned = info.PageSize > 0
+
+	for index, frame := range info.Frames {
+		if !info.PageAligned {
+			break
+		}
+
+		last := index == len(info.Frames)-1
+		pageSize := int64(info.PageSize)
+
+		if frame.DecompressedOffset%pageSize != 0 || (!last && frame.DecompressedSize%pageSize != 0) {
+			info.PageAligned = false
+		}
+	}
+
+	return info, nil
+}
+
+// contentsPageSize returns the page size from the header, or else from
+// the SQLite header in the first frame.
+func contentsPageSize(reader io.ReaderAt, info *ArchiveInfo) (int, error) {
+	if info.Header != nil {
+		return info.Header.PageSize, nil
+	}
+
+	if len(info.Frames) == 0 {
+		return 0, nil
+	}
+
+	first := info.Frames[0]
+	compressed := make([]byte, first.CompressedSize)
+
+	_, err := reader.ReadAt(compressed, first.CompressedOffset)
+	if err != nil {
+		return 0, fmt.Errorf("could not read frame: %w", err)
+	}
+
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not create decoder: %w", err)
+	}
+	defer decoder.Close()
+
+	contents, err := decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not decompress frame: %w", err)
+	}
+
+	if len(contents) < sqliteHeaderSize || string(contents[:len(sqliteHeaderMagic)]) != sqliteHeaderMagic {
+		return 0, nil
+	}
+
+	pageSize, err := parsePageSize(contents)
+	if err != nil {
+		return 0, nil //nolint: nilerr
+	}
+
+	return pageSize, nil
+}
@@ -0,0 +1,29 @@
+package sqlitezstd
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+var ErrIOUringUnavailable = errors.New("io_uring is unavailable")
+
+//nolint: gochecknoglobals
+var useIOUring atomic.Bool
+
+// UseIOUring reads the local archives opened afterwards through io_uring,
+// on Linux, rather than memory mapping them, so reads under high
+// parallelism wait without holding a thread each in a system call or a
+// page fault. It returns ErrIOUringUnavailable where the kernel does not
+// support it, and reading stays as it was.
+func UseIOUring(enabled bool) error {
+	if enabled {
+		_, err := sharedRing()
+		if err != nil {
+			return err
+		}
+	}
+
+	useIOUring.Store(enabled)
+
+	return nil
+}
@@ -0,0 +1,311 @@
+//go:build linux
+
+package sqlitezstd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// The io_uring interface is described in io_uring(7) and
+// include/uapi/linux/io_uring.h.
+const (
+	sysIOURingSetup    = 425
+	sysIOURingEnter    = 426
+	sysIOURingRegister = 427
+
+	ringEntries = 256
+
+	ringOffSQEs       = 0x10000000
+	ringFeatSingleMap = 1 << 0
+	ringEnterGetEvent = 1 << 0
+	ringRegisterProbe = 8
+	ringOpRead        = 22
+	ringOpSupported   = 1 << 0
+
+	sqeSize = 64
+	cqeSize = 16
+	// maxRingRead is the most bytes read by a single request.
+	maxRingRead = 1 << 30
+)
+
+type ringOffsets struct {
+	head, tail, mask, entries, flags, dropped, array, resv uint32
+	userAddr                                               uint64
+}
+
+type ringCompletionOffsets struct {
+	head, tail, mask, entries, overflow, cqes, flags, resv uint32
+	userAddr                                               uint64
+}
+
+type ringParams struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features uint32
+	wqFd                                                             uint32
+	resv                                                             [3]uint32
+	sqOff                                                            ringOffsets
+	cqOff                                                            ringCompletionOffsets
+}
+
+type ringSubmission struct {
+	opcode   uint8
+	flags    uint8
+	ioprio   uint16
+	fd       int32
+	off      uint64
+	addr     uint64
+	len      uint32
+	rwFlags  uint32
+	userData uint64
+	_        [3]uint64
+}
+
+type ringCompletion struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// ring submits reads to io_uring without waiting for them. One goroutine
+// waits for every completion, in a single system call, and hands each one
+// to the goroutine waiting for it, so many reads in flight hold a single
+// thread between them.
+type ring struct {
+	fd      int
+	rings   []byte
+	entries []byte
+
+	sqHead, sqTail, sqMask *uint32
+	sqArray                unsafe.Pointer
+	cqHead, cqTail, cqMask *uint32
+	cqes                   unsafe.Pointer
+
+	// slots bounds the reads in flight to the size of the rings.
+	slots chan struct{}
+
+	mutex   sync.Mutex
+	next    uint64
+	waiting map[uint64]chan int32
+}
+
+//nolint: gochecknoglobals
+var sharedRing = sync.OnceValues(newRing)
+
+func newRing() (*ring, error) {
+	params := ringParams{}
+
+	fd, _, errno := syscall.Syscall(sysIOURingSetup, ringEntries, uintptr(unsafe.Pointer(&params)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("%w: %w", ErrIOUringUnavailable, errno)
+	}
+
+	r := &ring{
+		fd:      int(fd),
+		slots:   make(chan struct{}, params.cqEntries),
+		waiting: map[uint64]chan int32{},
+	}
+
+	err := r.setup(&params)
+	if err != nil {
+		_ = syscall.Close(r.fd)
+
+		return nil, err
+	}
+
+	go r.complete()
+
+	return r, nil
+}
+
+// setup maps the rings of params, once the kernel reads with them.
+func (r *ring) setup(params *ringParams) error {
+	if params.features&ringFeatSingleMap == 0 || !r.supports(ringOpRead) {
+		return fmt.Errorf("%w: reads are not supported", ErrIOUringUnavailable)
+	}
+
+	size := max(params.sqOff.array+params.sqEntries*4, params.cqOff.cqes+params.cqEntries*cqeSize)
+
+	var err error
+
+	r.rings, err = syscall.Mmap(r.fd, 0, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		return fmt.Errorf("could not map rings: %w", err)
+	}
+
+	r.entries, err = syscall.Mmap(r.fd, ringOffSQEs, int(params.sqEntries*sqeSize), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		_ = syscall.Munmap(r.rings)
+
+		return fmt.Errorf("could not map submissions: %w", err)
+	}
+
+	r.sqHead = r.field(params.sqOff.head)
+	r.sqTail = r.field(params.sqOff.tail)
+	r.sqMask = r.field(params.sqOff.mask)
+	r.sqArray = unsafe.Pointer(&r.rings[params.sqOff.array])
+	r.cqHead = r.field(params.cqOff.head)
+	r.cqTail = r.field(params.cqOff.tail)
+	r.cqMask = r.field(params.cqOff.mask)
+	r.cqes = unsafe.Pointer(&r.rings[params.cqOff.cqes])
+
+	return nil
+}
+
+func (r *ring) field(offset uint32) *uint32 {
+	return (*uint32)(unsafe.Pointer(&r.rings[offset]))
+}
+
+// supports reports whether the kernel supports the operation.
+func (r *ring) supports(operation int) bool {
+	// a probe header of 16 bytes is followed by 8 bytes for every operation
+	probe := make([]byte, 16+256*8)
+
+	_, _, errno := syscall.Syscall6(sysIOURingRegister, uintptr(r.fd), ringRegisterProbe, uintptr(unsafe.Pointer(&probe[0])), 256, 0, 0)
+	if errno != 0 || int(probe[0]) < operation {
+		return false
+	}
+
+	flags := uint16(probe[16+operation*8+2]) | uint16(probe[16+operation*8+3])<<8
+
+	return flags&ringOpSupported != 0
+}
+
+// read reads into p from fd at off, in one request, returning how many
+// bytes were read.
+func (r *ring) read(fd int, p []byte, off int64) (int, error) {
+	r.slots <- struct{}{}
+	defer func() { <-r.slots }()
+
+	// the kernel writes into p after the request is submitted
+	pinner := runtime.Pinner{}
+	pinner.Pin(&p[0])
+	defer pinner.Unpin()
+
+	done := make(chan int32, 1)
+
+	r.mutex.Lock()
+
+	id := r.next
+	r.next++
+	r.waiting[id] = done
+
+	tail := *r.sqTail
+	index := tail & *r.sqMask
+
+	//nolint: gosec
+	*(*ringSubmission)(unsafe.Pointer(&r.entries[index*sqeSize])) = ringSubmission{
+		opcode:   ringOpRead,
+		fd:       int32(fd),
+		off:      uint64(off),
+		addr:     uint64(uintptr(unsafe.Pointer(&p[0]))),
+		len:      uint32(len(p)),
+		userData: id,
+	}
+	*(*uint32)(unsafe.Add(r.sqArray, uintptr(index)*4)) = index
+	atomic.StoreUint32(r.sqTail, tail+1)
+
+	err := r.enter(1, 0, 0)
+
+	r.mutex.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+
+	res := <-done
+	if res < 0 {
+		return 0, fmt.Errorf("could not read: %w", syscall.Errno(-res))
+	}
+
+	return int(res), nil
+}
+
+// enter submits requests and waits for completions, again when it is
+// interrupted.
+func (r *ring) enter(submit uint, complete uint, flags uint) error {
+	for {
+		_, _, errno := syscall.Syscall6(sysIOURingEnter, uintptr(r.fd), uintptr(submit), uintptr(complete), uintptr(flags), 0, 0)
+
+		switch {
+		case errno == 0:
+			return nil
+		case errors.Is(errno, syscall.EINTR), errors.Is(errno, syscall.EAGAIN), errors.Is(errno, syscall.EBUSY):
+			continue
+		default:
+			return fmt.Errorf("could not submit read: %w", errno)
+		}
+	}
+}
+
+// complete hands the completions to the reads waiting for them, for as
+// long as the process runs.
+func (r *ring) complete() {
+	for {
+		_ = r.enter(0, 1, ringEnterGetEvent)
+
+		head := atomic.LoadUint32(r.cqHead)
+		tail := atomic.LoadUint32(r.cqTail)
+
+		for ; head != tail; head++ {
+			completion := *(*ringCompletion)(unsafe.Add(r.cqes, uintptr(head&*r.cqMask)*cqeSize))
+
+			r.mutex.Lock()
+			done := r.waiting[completion.userData]
+			delete(r.waiting, completion.userData)
+			r.mutex.Unlock()
+
+			if done != nil {
+				done <- completion.res
+			}
+		}
+
+		atomic.StoreUint32(r.cqHead, head)
+	}
+}
+
+// ringFile reads a local file through the shared ring.
+type ringFile struct {
+	file *os.File
+	ring *ring
+}
+
+func openRingFile(file *os.File) (io.ReaderAt, io.Closer, error) {
+	shared, err := sharedRing()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader := &ringFile{file: file, ring: shared}
+
+	return reader, reader, nil
+}
+
+func (f *ringFile) ReadAt(p []byte, off int64) (int, error) {
+	read := 0
+
+	for read < len(p) {
+		count, err := f.ring.read(int(f.file.Fd()), p[read:min(len(p), read+maxRingRead)], off+int64(read))
+		if err != nil {
+			return read, err
+		}
+
+		if count == 0 {
+			return read, io.EOF
+		}
+
+		read += count
+	}
+
+	return read, nil
+}
+
+func (f *ringFile) Close() error {
+	return f.file.Close()
+}
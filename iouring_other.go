@@ -0,0 +1,18 @@
+//go:build !linux
+
+package sqlitezstd
+
+import (
+	"io"
+	"os"
+)
+
+type ring struct{}
+
+func sharedRing() (*ring, error) {
+	return nil, ErrIOUringUnavailable
+}
+
+func openRingFile(file *os.File) (io.ReaderAt, io.Closer, error) {
+	return nil, nil, ErrIOUringUnavailable
+}
@@ -0,0 +1,210 @@
+// Package ipfs reads sqlitezstd archives from IPFS. Content is addressed by
+// its hash and never changes, which suits the read-only VFS. Importing it
+// registers a backend for ipfs://CID and ipfs://CID/path names:
+//
+//	import _ "github.com/jtarchie/sqlitezstd/ipfs"
+//
+//	db, err := sql.Open("sqlite3", "ipfs://bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi?vfs=zstd")
+//
+// Ranges are read from the gateway in IPFS_GATEWAY, defaulting to
+// https://ipfs.io, or through the RPC API of a local node, such as
+// http://127.0.0.1:5001. Either can be set per name with the gateway and
+// api query parameters.
+package ipfs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+)
+
+const defaultGateway = "https://ipfs.io"
+
+var (
+	ErrInvalidURL         = errors.New("invalid ipfs url")
+	ErrUnexpectedResponse = errors.New("unexpected ipfs response")
+)
+
+//nolint: gochecknoinits
+func init() {
+	sqlitezstd.RegisterBackend("ipfs", &Backend{})
+}
+
+// Backend opens ipfs:// names through a gateway or the RPC API of a node.
+type Backend struct {
+	// Gateway is the HTTP gateway ranges are read from. If empty,
+	// IPFS_GATEWAY is used, and then https://ipfs.io.
+	Gateway string
+	// API is the RPC API of a node, such as http://127.0.0.1:5001,
+	// used instead of the gateway if set.
+	API string
+	// Client makes every request, defaulting to http.DefaultClient.
+	Client *http.Client
+}
+
+var _ sqlitezstd.Backend = &Backend{}
+
+func (b *Backend) Open(uri *url.URL) (sqlitezstd.Object, error) {
+	if uri.Host == "" {
+		return nil, fmt.Errorf("%w: %s needs a CID", ErrInvalidURL, uri.Redacted())
+	}
+
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	target := &object{
+		client: client,
+		path:   "/ipfs/" + uri.Host + uri.EscapedPath(),
+	}
+
+	query := uri.Query()
+
+	api := b.API
+	if query.Has("api") {
+		api = query.Get("api")
+	}
+
+	gateway := b.Gateway
+	if query.Has("gateway") {
+		gateway = query.Get("gateway")
+	}
+
+	if gateway == "" {
+		gateway = os.Getenv("IPFS_GATEWAY")
+	}
+
+	if gateway == "" {
+		gateway = defaultGateway
+	}
+
+	var err error
+
+	if api != "" {
+		target.api = strings.TrimSuffix(api, "/")
+		target.size, err = target.statAPI()
+	} else {
+		target.gateway = strings.TrimSuffix(gateway, "/")
+		target.size, err = target.statGateway()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return target, nil
+}
+
+// object is a file in IPFS, read through either a gateway or a node.
+type object struct {
+	client  *http.Client
+	gateway string
+	api     string
+	path    string
+	size    int64
+}
+
+func (o *object) statGateway() (int64, error) {
+	response, err := o.client.Head(o.gateway + o.path)
+	if err != nil {
+		return 0, fmt.Errorf("could not stat %s: %w", o.path, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK || response.ContentLength < 0 {
+		return 0, fmt.Errorf("%w: could not stat %s: %s", ErrUnexpectedResponse, o.path, response.Status)
+	}
+
+	return response.ContentLength, nil
+}
+
+func (o *object) statAPI() (int64, error) {
+	response, err := o.client.Post(o.api+"/api/v0/files/stat?arg="+url.QueryEscape(o.path), "", nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not stat %s: %w", o.path, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%w: could not stat %s: %s", ErrUnexpectedResponse, o.path, response.Status)
+	}
+
+	var stat struct {
+		Size int64
+		Type string
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&stat)
+	if err != nil {
+		return 0, fmt.Errorf("could not decode stat: %w", err)
+	}
+
+	if stat.Type != "file" {
+		return 0, fmt.Errorf("%w: %s is a %s", ErrUnexpectedResponse, o.path, stat.Type)
+	}
+
+	return stat.Size, nil
+}
+
+func (o *object) ReadAt(p []byte, off int64) (int, error) {
+	if off >= o.size {
+		return 0, io.EOF
+	}
+
+	end := min(off+int64(len(p)), o.size)
+
+	var (
+		response *http.Response
+		err      error
+	)
+
+	if o.api != "" {
+		response, err = o.client.Post(fmt.Sprintf("%s/api/v0/cat?arg=%s&offset=%d&length=%d", o.api, url.QueryEscape(o.path), off, end-off), "", nil)
+	} else {
+		var request *http.Request
+
+		request, err = http.NewRequest(http.MethodGet, o.gateway+o.path, nil)
+		if err != nil {
+			return 0, fmt.Errorf("could not read %s: %w", o.path, err)
+		}
+
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, end-1))
+		response, err = o.client.Do(request)
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("could not read %s: %w", o.path, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusPartialContent && (o.api == "" || response.StatusCode != http.StatusOK) {
+		return 0, fmt.Errorf("%w: could not read %s: %s", ErrUnexpectedResponse, o.path, response.Status)
+	}
+
+	count, err := io.ReadFull(response.Body, p[:end-off])
+	if err != nil {
+		return count, fmt.Errorf("could not read %s: %w", o.path, err)
+	}
+
+	if count < len(p) {
+		return count, io.EOF
+	}
+
+	return count, nil
+}
+
+func (o *object) Size() int64 {
+	return o.size
+}
+
+func (o *object) Close() error {
+	return nil
+}
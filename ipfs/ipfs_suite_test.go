@@ -0,0 +1,124 @@
+package ipfs_test
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	"github.com/jtarchie/sqlitezstd/ipfs"
+	"github.com/jtarchie/sqlitezstd/testhelper"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestIPFS(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "IPFS Suite")
+}
+
+const cid = "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"
+
+// node is a fake IPFS node, serving both a gateway and the RPC API.
+type node map[string][]byte
+
+func (n node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/api/v0/files/stat":
+		contents, ok := n[r.URL.Query().Get("arg")]
+		if !ok || r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]any{"Size": len(contents), "Type": "file"})
+	case "/api/v0/cat":
+		contents, ok := n[r.URL.Query().Get("arg")]
+		if !ok || r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		length, _ := strconv.Atoi(r.URL.Query().Get("length"))
+		_, _ = w.Write(contents[offset : offset+length])
+	default:
+		contents, ok := n[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(contents))
+	}
+}
+
+func countEntries(dsn string) (int64, error) {
+	client, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	var count int64
+	err = client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)
+
+	return count, err
+}
+
+var _ = Describe("Backend", func() {
+	var server *httptest.Server
+
+	BeforeEach(func() {
+		Expect(sqlitezstd.Init()).To(Succeed())
+
+		contents, err := os.ReadFile(testhelper.CreateEntriesDB(GinkgoT(), 1000))
+		Expect(err).ToNot(HaveOccurred())
+
+		server = httptest.NewServer(node{
+			"/ipfs/" + cid:                  contents,
+			"/ipfs/" + cid + "/data/db.zst": contents,
+		})
+		DeferCleanup(server.Close)
+	})
+
+	It("reads archives through a gateway", func() {
+		sqlitezstd.RegisterBackend("ipfs", &ipfs.Backend{Gateway: server.URL})
+
+		count, err := countEntries("ipfs://" + cid + "?vfs=zstd")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+
+		count, err = countEntries("ipfs://" + cid + "/data/db.zst?vfs=zstd")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+	})
+
+	It("reads archives through the API of a node", func() {
+		sqlitezstd.RegisterBackend("ipfs", &ipfs.Backend{Gateway: "http://127.0.0.1:1"})
+
+		count, err := countEntries(fmt.Sprintf("file:ipfs://%s/data/db.zst?vfs=zstd&api=%s", cid, server.URL))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+	})
+
+	It("errors for missing content", func() {
+		sqlitezstd.RegisterBackend("ipfs", &ipfs.Backend{Gateway: server.URL})
+
+		_, err := sqlitezstd.Inspect("ipfs://" + cid + "/missing.zst")
+		Expect(err).To(MatchError(ipfs.ErrUnexpectedResponse))
+
+		_, err = sqlitezstd.Inspect("ipfs://" + cid + "/missing.zst?api=" + server.URL)
+		Expect(err).To(MatchError(ipfs.ErrUnexpectedResponse))
+	})
+})
@@ -0,0 +1,99 @@
+package sqlitezstd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const snapshotAttempts = 10
+
+var ErrSnapshotBusy = errors.New("could not take a consistent snapshot")
+
+// CompressLive compresses the SQLite database at src, which may be in use by
+// other connections, into dst. Pages still in the write-ahead log are
+// checkpointed into the database first, then the database file is compressed
+// while an open read transaction keeps writers from changing it.
+//
+// The source is opened with its own connection. As with any other file
+// opened outside of SQLite, other connections to src in the same process
+// may lose their POSIX locks when it is closed.
+func CompressLive(src, dst string, opts ...Option) error {
+	// opened before the connection and closed after it, as closing a
+	// file descriptor releases every lock the process holds on the file
+	input, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("could not open source: %w", err)
+	}
+	defer input.Close()
+
+	client, err := sql.Open("sqlite3", fileDSN(src, "mode=rw&_busy_timeout=5000"))
+	if err != nil {
+		return fmt.Errorf("could not open source: %w", err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+
+	conn, err := client.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("could not open source: %w", err)
+	}
+	defer conn.Close()
+
+	tx, err := snapshot(ctx, conn, src)
+	if err != nil {
+		return err
+	}
+	//nolint: errcheck
+	defer tx.Rollback()
+
+	return compressDatabase(input, dst, opts)
+}
+
+// snapshot checkpoints the write-ahead log of src and starts a read
+// transaction that only sees the database file. As long as it is open,
+// no checkpoint can copy newer pages into the database file.
+func snapshot(ctx context.Context, conn *sql.Conn, src string) (*sql.Tx, error) {
+	for range snapshotAttempts {
+		var busy, log, checkpointed int
+
+		err := conn.QueryRowContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &log, &checkpointed)
+		if err != nil {
+			return nil, fmt.Errorf("could not checkpoint source: %w", err)
+		}
+
+		if busy != 0 {
+			continue
+		}
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not begin snapshot: %w", err)
+		}
+
+		var tables int
+
+		err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master").Scan(&tables)
+		if err != nil {
+			_ = tx.Rollback()
+
+			return nil, fmt.Errorf("could not begin snapshot: %w", err)
+		}
+
+		// a writer committed between the checkpoint and the read,
+		// so the snapshot includes pages only in the log
+		info, err := os.Stat(src + "-wal")
+		if err == nil && info.Size() > 0 {
+			_ = tx.Rollback()
+
+			continue
+		}
+
+		return tx, nil
+	}
+
+	return nil, ErrSnapshotBusy
+}
@@ -0,0 +1,23 @@
+package sqlitezstd
+
+import (
+	"context"
+	"log/slog"
+)
+
+// logTo logs msg to logger at level, unless no logger is set.
+func logTo(logger *slog.Logger, level slog.Level, msg string, args ...any) {
+	if logger != nil {
+		logger.Log(context.Background(), level, msg, args...)
+	}
+}
+
+// logName is the name of an archive as logged, without the credentials
+// or signature of a URL.
+func logName(name string) string {
+	if isRemote(name) {
+		return redact(name)
+	}
+
+	return name
+}
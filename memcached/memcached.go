@@ -0,0 +1,332 @@
+// Package memcached keeps the decompressed frames of sqlitezstd archives in
+// memcached, so a fleet of stateless services reading the same remote
+// archives shares one warm cache rather than each fetching from the origin:
+//
+//	cache := &memcached.Cache{Servers: []string{"cache-1:11211", "cache-2:11211"}}
+//
+//	err := sqlitezstd.Register("zstd", &sqlitezstd.ZstdVFS{Cache: cache})
+//
+// Frames are read and written with the get, set, and delete commands of the
+// text protocol, spread over the servers by key. Frames larger than
+// MaxItemSize are not written, and any error, such as a server being
+// unreachable, is a miss, so reads fall back to the archive.
+package memcached
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+)
+
+const (
+	defaultPrefix      = "sqlitezstd:"
+	defaultTimeout     = time.Second
+	defaultMaxItemSize = 1 << 20
+	// maxIdle is the most idle connections kept for every server.
+	maxIdle = 16
+	// maxRelativeExpiry is the longest expiry memcached takes as a number
+	// of seconds; longer ones are taken as a Unix time.
+	maxRelativeExpiry = 30 * 24 * time.Hour
+)
+
+var (
+	ErrNoServers       = errors.New("no memcached servers")
+	ErrUnexpectedReply = errors.New("unexpected memcached reply")
+)
+
+// Cache keeps frames in memcached servers. The zero value, with Servers
+// set, is ready to use, and it is safe for concurrent use.
+type Cache struct {
+	// Servers are the host:port of every server, each keeping the frames
+	// whose keys hash to it.
+	Servers []string
+	// TTL, if set, is how long a frame is kept after it is written.
+	TTL time.Duration
+	// Prefix is prepended to every key, "sqlitezstd:" by default.
+	Prefix string
+	// MaxItemSize is the largest frame written, 1 MiB by default, the
+	// default item size limit of memcached.
+	MaxItemSize int
+	// Timeout bounds every command, including connecting, 1 second by
+	// default.
+	Timeout time.Duration
+
+	mutex sync.Mutex
+	idle  map[string][]*conn
+}
+
+var _ sqlitezstd.Cache = &Cache{}
+
+func (c *Cache) Get(key string) ([]byte, bool) {
+	var data []byte
+
+	err := c.do(key, func(connection *conn, key string) error {
+		_, _ = fmt.Fprintf(connection.writer, "get %s\r\n", key)
+
+		err := connection.flush()
+		if err != nil {
+			return err
+		}
+
+		data, err = connection.readValue()
+
+		return err
+	})
+
+	return data, err == nil && data != nil
+}
+
+func (c *Cache) Put(key string, data []byte) {
+	size := c.MaxItemSize
+	if size <= 0 {
+		size = defaultMaxItemSize
+	}
+
+	if len(data) > size {
+		return
+	}
+
+	_ = c.do(key, func(connection *conn, key string) error {
+		_, _ = fmt.Fprintf(connection.writer, "set %s 0 %d %d\r\n", key, c.expiry(), len(data))
+		_, _ = connection.writer.Write(data)
+		_, _ = connection.writer.WriteString("\r\n")
+
+		err := connection.flush()
+		if err != nil {
+			return err
+		}
+
+		return connection.expect("STORED")
+	})
+}
+
+func (c *Cache) Remove(key string) {
+	_ = c.do(key, func(connection *conn, key string) error {
+		_, _ = fmt.Fprintf(connection.writer, "delete %s\r\n", key)
+
+		err := connection.flush()
+		if err != nil {
+			return err
+		}
+
+		return connection.expect("DELETED", "NOT_FOUND")
+	})
+}
+
+// Close closes the idle connections. The cache can still be used.
+func (c *Cache) Close() error {
+	c.mutex.Lock()
+	idle := c.idle
+	c.idle = nil
+	c.mutex.Unlock()
+
+	for _, connections := range idle {
+		for _, connection := range connections {
+			_ = connection.Close()
+		}
+	}
+
+	return nil
+}
+
+// expiry returns the expiry of a frame written now, as memcached takes it.
+func (c *Cache) expiry() int64 {
+	switch {
+	case c.TTL <= 0:
+		return 0
+	case c.TTL > maxRelativeExpiry:
+		return time.Now().Add(c.TTL).Unix()
+	default:
+		return int64(max(c.TTL/time.Second, 1))
+	}
+}
+
+func (c *Cache) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return defaultTimeout
+	}
+
+	return c.Timeout
+}
+
+// do runs command on a connection to the server of key, with the key
+// prefixed. A connection that fails is closed rather than kept.
+func (c *Cache) do(key string, command func(connection *conn, key string) error) error {
+	if len(c.Servers) == 0 {
+		return ErrNoServers
+	}
+
+	prefix := c.Prefix
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+
+	key = prefix + key
+	server := c.Servers[crc32.ChecksumIEEE([]byte(key))%uint32(len(c.Servers))]
+
+	connection, err := c.connection(server)
+	if err != nil {
+		return err
+	}
+
+	err = connection.SetDeadline(time.Now().Add(c.timeout()))
+	if err == nil {
+		err = command(connection, key)
+	}
+
+	var reply replyError
+	if err != nil && !errors.As(err, &reply) {
+		_ = connection.Close()
+
+		return err
+	}
+
+	c.release(server, connection)
+
+	return err
+}
+
+func (c *Cache) connection(server string) (*conn, error) {
+	c.mutex.Lock()
+
+	if idle := c.idle[server]; len(idle) > 0 {
+		connection := idle[len(idle)-1]
+		c.idle[server] = idle[:len(idle)-1]
+		c.mutex.Unlock()
+
+		return connection, nil
+	}
+
+	c.mutex.Unlock()
+
+	network, err := net.DialTimeout("tcp", server, c.timeout())
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %s: %w", server, err)
+	}
+
+	return &conn{
+		Conn:   network,
+		reader: bufio.NewReader(network),
+		writer: bufio.NewWriter(network),
+	}, nil
+}
+
+func (c *Cache) release(server string, connection *conn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.idle == nil {
+		c.idle = map[string][]*conn{}
+	}
+
+	if len(c.idle[server]) >= maxIdle {
+		_ = connection.Close()
+
+		return
+	}
+
+	c.idle[server] = append(c.idle[server], connection)
+}
+
+// conn is a connection to a server, with its buffers.
+type conn struct {
+	net.Conn
+
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// replyError is a reply other than the one expected, after which the
+// connection can still be used.
+type replyError struct {
+	line string
+}
+
+func (r replyError) Error() string {
+	return fmt.Sprintf("%s: %q", ErrUnexpectedReply, r.line)
+}
+
+func (r replyError) Unwrap() error {
+	return ErrUnexpectedReply
+}
+
+func (c *conn) flush() error {
+	err := c.writer.Flush()
+	if err != nil {
+		return fmt.Errorf("could not send command: %w", err)
+	}
+
+	return nil
+}
+
+func (c *conn) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("could not read reply: %w", err)
+	}
+
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+// expect reads a reply, failing unless it is one of replies.
+func (c *conn) expect(replies ...string) error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+
+	for _, reply := range replies {
+		if line == reply {
+			return nil
+		}
+	}
+
+	return replyError{line: line}
+}
+
+// readValue reads the reply to a get of one key, returning nil when the
+// server does not hold it.
+func (c *conn) readValue() ([]byte, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	if line == "END" {
+		return nil, nil
+	}
+
+	// VALUE <key> <flags> <bytes>
+	fields := strings.Fields(line)
+	if len(fields) != 4 || fields[0] != "VALUE" {
+		return nil, replyError{line: line}
+	}
+
+	size, err := strconv.Atoi(fields[3])
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("%w: %q", ErrUnexpectedReply, line)
+	}
+
+	data := make([]byte, size+2)
+
+	_, err = io.ReadFull(c.reader, data)
+	if err != nil {
+		return nil, fmt.Errorf("could not read value: %w", err)
+	}
+
+	err = c.expect("END")
+	if err != nil {
+		return nil, err
+	}
+
+	return data[:size], nil
+}
@@ -0,0 +1,241 @@
+package memcached_test
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	"github.com/jtarchie/sqlitezstd/memcached"
+	"github.com/jtarchie/sqlitezstd/testhelper"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestMemcached(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Memcached Suite")
+}
+
+// server is a fake memcached server, keeping values in memory and
+// answering the commands of the text protocol the cache sends.
+type server struct {
+	listener net.Listener
+
+	mutex    sync.Mutex
+	values   map[string][]byte
+	expiries map[string]string
+}
+
+func serve() *server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		Skip(fmt.Sprintf("memcached is unavailable: %v", err))
+	}
+
+	fake := &server{
+		listener: listener,
+		values:   map[string][]byte{},
+		expiries: map[string]string{},
+	}
+
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go fake.handle(connection)
+		}
+	}()
+
+	DeferCleanup(listener.Close)
+
+	return fake
+}
+
+func (s *server) handle(connection net.Conn) {
+	defer connection.Close()
+
+	reader := bufio.NewReader(connection)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			_, _ = io.WriteString(connection, "ERROR\r\n")
+
+			continue
+		}
+
+		switch fields[0] {
+		case "get":
+			s.mutex.Lock()
+			value, ok := s.values[fields[1]]
+			s.mutex.Unlock()
+
+			if ok {
+				_, _ = fmt.Fprintf(connection, "VALUE %s 0 %d\r\n%s\r\nEND\r\n", fields[1], len(value), value)
+			} else {
+				_, _ = io.WriteString(connection, "END\r\n")
+			}
+		case "set":
+			size, _ := strconv.Atoi(fields[4])
+			data := make([]byte, size+2)
+
+			_, err = io.ReadFull(reader, data)
+			if err != nil {
+				return
+			}
+
+			s.mutex.Lock()
+			s.values[fields[1]] = data[:size]
+			s.expiries[fields[1]] = fields[3]
+			s.mutex.Unlock()
+
+			_, _ = io.WriteString(connection, "STORED\r\n")
+		case "delete":
+			s.mutex.Lock()
+			_, ok := s.values[fields[1]]
+			delete(s.values, fields[1])
+			s.mutex.Unlock()
+
+			if ok {
+				_, _ = io.WriteString(connection, "DELETED\r\n")
+			} else {
+				_, _ = io.WriteString(connection, "NOT_FOUND\r\n")
+			}
+		default:
+			_, _ = io.WriteString(connection, "ERROR\r\n")
+		}
+	}
+}
+
+func (s *server) address() string {
+	return s.listener.Addr().String()
+}
+
+func (s *server) keys() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keys := []string{}
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+func (s *server) expiry(key string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.expiries[key]
+}
+
+func sumBodies(dsn string) int64 {
+	client, err := sql.Open("sqlite3", dsn)
+	Expect(err).ToNot(HaveOccurred())
+	defer client.Close()
+
+	var length int64
+	Expect(client.QueryRow("SELECT SUM(LENGTH(body)) FROM entries;").Scan(&length)).To(Succeed())
+
+	return length
+}
+
+var _ = Describe("Cache", func() {
+	var (
+		archiveURL string
+		ranges     atomic.Int64
+	)
+
+	BeforeEach(func() {
+		zstPath := testhelper.CreateEntriesDB(GinkgoT(), 1000, sqlitezstd.WithFrameSize(4096))
+
+		files := http.FileServer(http.Dir(filepath.Dir(zstPath)))
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Range") != "" {
+				ranges.Add(1)
+			}
+
+			files.ServeHTTP(w, r)
+		}))
+		DeferCleanup(origin.Close)
+
+		archiveURL = fmt.Sprintf("%s/%s", origin.URL, filepath.Base(zstPath))
+	})
+
+	It("shares the frames of remote archives between processes", func() {
+		one, two := serve(), serve()
+
+		first := &memcached.Cache{Servers: []string{one.address(), two.address()}, TTL: time.Hour}
+		DeferCleanup(first.Close)
+
+		Expect(sqlitezstd.Register("zstd-memcached-first", &sqlitezstd.ZstdVFS{Cache: first})).To(Succeed())
+		Expect(sumBodies(fmt.Sprintf("file:%s?vfs=zstd-memcached-first&range_size=-1", archiveURL))).To(BeEquivalentTo(1000 * 64))
+		Expect(ranges.Load()).To(BeNumerically(">", 1))
+
+		// frames are spread over both servers
+		Expect(one.keys()).ToNot(BeEmpty())
+		Expect(two.keys()).ToNot(BeEmpty())
+		Expect(one.keys()[0]).To(HavePrefix("sqlitezstd:"))
+		Expect(one.expiry(one.keys()[0])).To(Equal("3600"))
+
+		// another process finds the frames in memcached rather than the archive
+		second := &memcached.Cache{Servers: []string{one.address(), two.address()}}
+		DeferCleanup(second.Close)
+
+		ranges.Store(0)
+
+		Expect(sqlitezstd.Register("zstd-memcached-second", &sqlitezstd.ZstdVFS{Cache: second})).To(Succeed())
+		Expect(sumBodies(fmt.Sprintf("file:%s?vfs=zstd-memcached-second&range_size=-1", archiveURL))).To(BeEquivalentTo(1000 * 64))
+		// only the first byte is read, telling archives from manifests
+		Expect(ranges.Load()).To(BeEquivalentTo(1))
+	})
+
+	It("does not write frames larger than the item size", func() {
+		fake := serve()
+
+		cache := &memcached.Cache{Servers: []string{fake.address()}, MaxItemSize: 1024}
+		DeferCleanup(cache.Close)
+
+		Expect(sqlitezstd.Register("zstd-memcached-small", &sqlitezstd.ZstdVFS{Cache: cache})).To(Succeed())
+		Expect(sumBodies(fmt.Sprintf("file:%s?vfs=zstd-memcached-small&range_size=-1", archiveURL))).To(BeEquivalentTo(1000 * 64))
+		Expect(fake.keys()).To(BeEmpty())
+	})
+
+	It("reads from the archive when memcached is unreachable", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+
+		closed := listener.Addr().String()
+		Expect(listener.Close()).To(Succeed())
+
+		cache := &memcached.Cache{Servers: []string{closed}, Timeout: 100 * time.Millisecond}
+		DeferCleanup(cache.Close)
+
+		Expect(sqlitezstd.Register("zstd-memcached-unreachable", &sqlitezstd.ZstdVFS{Cache: cache})).To(Succeed())
+		Expect(sumBodies(fmt.Sprintf("file:%s?vfs=zstd-memcached-unreachable&range_size=-1", archiveURL))).To(BeEquivalentTo(1000 * 64))
+
+		_, ok := cache.Get("missing")
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -0,0 +1,151 @@
+package sqlitezstd
+
+import (
+	"io"
+	"sync"
+
+	"github.com/psanford/sqlite3vfs"
+)
+
+// memoryStore keeps written blocks in a map, they are gone once it is closed.
+type memoryStore struct {
+	blocks  map[int64][]byte
+	logical int64
+	limit   int64
+}
+
+var _ pageStore = &memoryStore{}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		blocks:  map[int64][]byte{},
+		logical: -1,
+		limit:   -1,
+	}
+}
+
+func (m *memoryStore) block(index int64) ([]byte, error) {
+	return m.blocks[index], nil
+}
+
+func (m *memoryStore) putBlock(index int64, data []byte, size int64) error {
+	m.blocks[index] = data
+	m.logical = size
+
+	return nil
+}
+
+func (m *memoryStore) size() int64 {
+	return m.logical
+}
+
+func (m *memoryStore) baseLimit() int64 {
+	return m.limit
+}
+
+func (m *memoryStore) truncate(size int64) error {
+	m.logical = size
+
+	if m.limit < 0 || size < m.limit {
+		m.limit = size
+	}
+
+	for index, block := range m.blocks {
+		if index*int64(len(block)) >= size {
+			delete(m.blocks, index)
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryStore) sync() error {
+	return nil
+}
+
+func (m *memoryStore) close() error {
+	m.blocks = nil
+
+	return nil
+}
+
+// memoryFile is a growable in-memory file, used for the journals of a memory overlay.
+type memoryFile struct {
+	mutex    sync.Mutex
+	contents []byte
+}
+
+var _ sqlite3vfs.File = &memoryFile{}
+
+func (m *memoryFile) CheckReservedLock() (bool, error) {
+	return false, nil
+}
+
+func (m *memoryFile) Close() error {
+	return nil
+}
+
+func (m *memoryFile) DeviceCharacteristics() sqlite3vfs.DeviceCharacteristic {
+	return 0
+}
+
+func (m *memoryFile) FileSize() (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return int64(len(m.contents)), nil
+}
+
+func (m *memoryFile) Lock(elock sqlite3vfs.LockType) error {
+	return nil
+}
+
+func (m *memoryFile) ReadAt(p []byte, off int64) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if off >= int64(len(m.contents)) {
+		return 0, io.EOF
+	}
+
+	read := copy(p, m.contents[off:])
+	if read < len(p) {
+		return read, io.EOF
+	}
+
+	return read, nil
+}
+
+func (m *memoryFile) SectorSize() int64 {
+	return 0
+}
+
+func (m *memoryFile) Sync(flag sqlite3vfs.SyncType) error {
+	return nil
+}
+
+func (m *memoryFile) Truncate(size int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if size < int64(len(m.contents)) {
+		m.contents = m.contents[:size]
+	}
+
+	return nil
+}
+
+func (m *memoryFile) Unlock(elock sqlite3vfs.LockType) error {
+	return nil
+}
+
+func (m *memoryFile) WriteAt(p []byte, off int64) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if end := off + int64(len(p)); end > int64(len(m.contents)) {
+		m.contents = append(m.contents, make([]byte, end-int64(len(m.contents)))...)
+	}
+
+	return copy(m.contents[off:], p), nil
+}
@@ -0,0 +1,134 @@
+package sqlitezstd
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	metadataTag     = 0x6
+	maxMetadataSize = 64 * 1024
+
+	sqliteSchemaVersionOffset = 40
+	sqliteUserVersionOffset   = 60
+)
+
+var (
+	ErrNoMetadata     = errors.New("file has no sqlitezstd metadata")
+	ErrSourceMismatch = errors.New("archive does not match the expected source")
+)
+
+// Metadata describes the source an archive was compressed from. It is
+// stored in a skippable frame just before the seek table, as the source
+// hash is only known once everything was compressed.
+type Metadata struct {
+	// SourceSHA256 is the hex encoded SHA-256 of the uncompressed source.
+	SourceSHA256 string `json:"source_sha256"`
+	// SourceSize is the size of the uncompressed source.
+	SourceSize int64 `json:"source_size"`
+	// CreatedAt is when the archive was written, or the zero time
+	// if it was written with WithDeterministic.
+	CreatedAt time.Time `json:"created_at"`
+	// SchemaVersion and UserVersion are read from the SQLite header,
+	// see PRAGMA schema_version and PRAGMA user_version.
+	SchemaVersion uint32 `json:"schema_version"`
+	UserVersion   uint32 `json:"user_version"`
+	// Level, FrameSize, and Deterministic are the compressor settings.
+	Level         int  `json:"level"`
+	FrameSize     int  `json:"frame_size"`
+	Deterministic bool `json:"deterministic,omitempty"`
+}
+
+func (m *Metadata) frame() ([]byte, error) {
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode metadata: %w", err)
+	}
+
+	return skippableFrame(metadataTag, payload), nil
+}
+
+// ReadMetadata returns the metadata written into a compressed file,
+// which may be local, remote, or split into parts.
+func ReadMetadata(path string) (*Metadata, error) {
+	file, err := openArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return readMetadata(file, file.Size())
+}
+
+func readMetadata(reader io.ReaderAt, size int64) (*Metadata, error) {
+	table, err := decodeSeekTable(reader, size)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, skippableHeaderSize)
+
+	// the metadata is one of the last frames, so look from the end
+	for index := len(table.frames) - 1; index >= 0; index-- {
+		frame := table.frames[index]
+		if frame.decompressedSize > 0 || frame.compressedSize < skippableHeaderSize {
+			continue
+		}
+
+		_, err = reader.ReadAt(prefix, frame.compressedOffset)
+		if err != nil {
+			return nil, fmt.Errorf("could not read metadata: %w", err)
+		}
+
+		if binary.LittleEndian.Uint32(prefix[0:4]) != skippableFrameMagic+metadataTag {
+			continue
+		}
+
+		length := binary.LittleEndian.Uint32(prefix[4:8])
+		if length > maxMetadataSize {
+			return nil, fmt.Errorf("%w: size %d is too large", ErrNoMetadata, length)
+		}
+
+		payload := make([]byte, length)
+
+		_, err = reader.ReadAt(payload, frame.compressedOffset+skippableHeaderSize)
+		if err != nil {
+			return nil, fmt.Errorf("could not read metadata: %w", err)
+		}
+
+		metadata := &Metadata{}
+
+		err = json.Unmarshal(payload, metadata)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode metadata: %w", err)
+		}
+
+		return metadata, nil
+	}
+
+	return nil, ErrNoMetadata
+}
+
+// verifySource checks the source hash recorded in the archive against expected.
+func verifySource(reader io.ReaderAt, size int64, expected string) error {
+	metadata, err := readMetadata(reader, size)
+	if err != nil {
+		return err
+	}
+
+	want, err := hex.DecodeString(expected)
+	if err != nil {
+		return fmt.Errorf("%w: %q is not a valid hash", ErrSourceMismatch, expected)
+	}
+
+	if metadata.SourceSHA256 != hex.EncodeToString(want) {
+		return fmt.Errorf("%w: source is %s", ErrSourceMismatch, metadata.SourceSHA256)
+	}
+
+	return nil
+}
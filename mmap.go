@@ -0,0 +1,28 @@
+package sqlitezstd
+
+import (
+	"errors"
+	"io"
+)
+
+var errMapUnavailable = errors.New("memory mapping is unavailable")
+
+// mappedFile is a local archive mapped into memory. Reads are served from
+// the page cache without a system call each, and the OS evicts pages that
+// have not been read in a while.
+type mappedFile struct {
+	data []byte
+}
+
+func (m *mappedFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+
+	count := copy(p, m.data[off:])
+	if count < len(p) {
+		return count, io.EOF
+	}
+
+	return count, nil
+}
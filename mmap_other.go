@@ -0,0 +1,13 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package sqlitezstd
+
+import "os"
+
+func mapFile(_ *os.File, _ int64) (*mappedFile, error) {
+	return nil, errMapUnavailable
+}
+
+func (m *mappedFile) Close() error {
+	return nil
+}
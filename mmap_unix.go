@@ -0,0 +1,33 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package sqlitezstd
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// mapFile maps size bytes of file into memory. The file may be
+// closed afterwards, the mapping stays valid until unmapped.
+func mapFile(file *os.File, size int64) (*mappedFile, error) {
+	if size <= 0 || int64(int(size)) != size {
+		return nil, errMapUnavailable
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("could not map file: %w", err)
+	}
+
+	return &mappedFile{data: data}, nil
+}
+
+func (m *mappedFile) Close() error {
+	err := syscall.Munmap(m.data)
+	if err != nil {
+		return fmt.Errorf("could not unmap file: %w", err)
+	}
+
+	return nil
+}
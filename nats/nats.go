@@ -0,0 +1,251 @@
+// Package nats reads sqlitezstd archives from a NATS JetStream object store,
+// so services built around NATS can pull compressed reference data from the
+// same infrastructure. Importing it registers a backend for
+// nats://host:port/bucket/name names:
+//
+//	import _ "github.com/jtarchie/sqlitezstd/nats"
+//
+//	db, err := sql.Open("sqlite3", "nats://localhost:4222/reference/db.sqlite.zst?vfs=zstd")
+//
+// Only the chunks of the object holding a range are fetched, by their
+// sequence in the stream of the bucket. Credentials are given in the user
+// info of the name, or as a credentials file with the creds query parameter.
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	defaultChunkSize = 128 * 1024
+	requestTimeout   = 30 * time.Second
+)
+
+var (
+	ErrInvalidURL   = errors.New("invalid nats url")
+	ErrLinkedObject = errors.New("linked objects are not supported")
+	ErrMissingChunk = errors.New("object chunk is missing")
+)
+
+//nolint: gochecknoinits
+func init() {
+	sqlitezstd.RegisterBackend("nats", &Backend{})
+}
+
+// Backend opens nats://host:port/bucket/name names, with a connection per archive.
+type Backend struct {
+	// Options are used for every connection, such as nats.RootCAs.
+	Options []nats.Option
+}
+
+var _ sqlitezstd.Backend = &Backend{}
+
+func (b *Backend) Open(uri *url.URL) (sqlitezstd.Object, error) {
+	bucket, name, _ := strings.Cut(strings.TrimPrefix(uri.Path, "/"), "/")
+
+	if uri.Host == "" || bucket == "" || name == "" {
+		return nil, fmt.Errorf("%w: %s needs a host, a bucket, and a name", ErrInvalidURL, uri.Redacted())
+	}
+
+	options := append([]nats.Option{nats.Timeout(requestTimeout)}, b.Options...)
+	if creds := uri.Query().Get("creds"); creds != "" {
+		options = append(options, nats.UserCredentials(creds))
+	}
+
+	server := url.URL{Scheme: "nats", User: uri.User, Host: uri.Host}
+
+	conn, err := nats.Connect(server.String(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %s: %w", uri.Host, err)
+	}
+
+	target, err := open(conn, bucket, name)
+	if err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	return target, nil
+}
+
+func open(conn *nats.Conn, bucket string, name string) (*object, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		return nil, fmt.Errorf("could not use jetstream: %w", err)
+	}
+
+	store, err := js.ObjectStore(ctx, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("could not open bucket %s: %w", bucket, err)
+	}
+
+	info, err := store.GetInfo(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("could not stat %s/%s: %w", bucket, name, err)
+	}
+
+	if info.Opts != nil && info.Opts.Link != nil {
+		return nil, fmt.Errorf("%w: %s/%s", ErrLinkedObject, bucket, name)
+	}
+
+	chunkSize := int64(defaultChunkSize)
+	if info.Opts != nil && info.Opts.ChunkSize > 0 {
+		chunkSize = int64(info.Opts.ChunkSize)
+	}
+
+	streamName := "OBJ_" + bucket
+
+	stream, err := js.Stream(ctx, streamName)
+	if err != nil {
+		return nil, fmt.Errorf("could not open bucket %s: %w", bucket, err)
+	}
+
+	sequences, err := chunkSequences(ctx, js, streamName, fmt.Sprintf("$O.%s.C.%s", bucket, info.NUID), int(info.Chunks))
+	if err != nil {
+		return nil, fmt.Errorf("could not index %s/%s: %w", bucket, name, err)
+	}
+
+	return &object{
+		conn:      conn,
+		stream:    stream,
+		sequences: sequences,
+		chunkSize: chunkSize,
+		//nolint: gosec
+		size:    int64(info.Size),
+		current: -1,
+	}, nil
+}
+
+// chunkSequences lists where each chunk of an object is in the stream
+// of its bucket, reading the headers of the chunks but not their data.
+func chunkSequences(ctx context.Context, js jetstream.JetStream, stream string, subject string, chunks int) ([]uint64, error) {
+	sequences := make([]uint64, 0, chunks)
+	if chunks == 0 {
+		return sequences, nil
+	}
+
+	consumer, err := js.OrderedConsumer(ctx, stream, jetstream.OrderedConsumerConfig{
+		FilterSubjects: []string{subject},
+		HeadersOnly:    true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create consumer: %w", err)
+	}
+
+	for len(sequences) < chunks {
+		batch, err := consumer.Fetch(chunks-len(sequences), jetstream.FetchMaxWait(requestTimeout))
+		if err != nil {
+			return nil, fmt.Errorf("could not fetch chunks: %w", err)
+		}
+
+		for message := range batch.Messages() {
+			metadata, err := message.Metadata()
+			if err != nil {
+				return nil, fmt.Errorf("could not read chunk metadata: %w", err)
+			}
+
+			sequences = append(sequences, metadata.Sequence.Stream)
+		}
+
+		if batch.Error() != nil {
+			return nil, fmt.Errorf("could not fetch chunks: %w", batch.Error())
+		}
+	}
+
+	return sequences, nil
+}
+
+// object is an object in a bucket, read a chunk at a time. The last
+// chunk read is kept, as reads of neighbouring frames often share it.
+type object struct {
+	conn      *nats.Conn
+	stream    jetstream.Stream
+	sequences []uint64
+	chunkSize int64
+	size      int64
+
+	mutex   sync.Mutex
+	current int
+	chunk   []byte
+}
+
+func (o *object) ReadAt(p []byte, off int64) (int, error) {
+	if off >= o.size {
+		return 0, io.EOF
+	}
+
+	end := min(off+int64(len(p)), o.size)
+	read := 0
+
+	for off+int64(read) < end {
+		position := off + int64(read)
+
+		chunk, err := o.read(int(position / o.chunkSize))
+		if err != nil {
+			return read, err
+		}
+
+		start := position % o.chunkSize
+		if start >= int64(len(chunk)) {
+			return read, fmt.Errorf("%w: chunk %d is short", ErrMissingChunk, position/o.chunkSize)
+		}
+
+		read += copy(p[read:end-off], chunk[start:])
+	}
+
+	if read < len(p) {
+		return read, io.EOF
+	}
+
+	return read, nil
+}
+
+func (o *object) read(index int) ([]byte, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if index == o.current {
+		return o.chunk, nil
+	}
+
+	if index >= len(o.sequences) {
+		return nil, fmt.Errorf("%w: chunk %d", ErrMissingChunk, index)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	message, err := o.stream.GetMsg(ctx, o.sequences[index])
+	if err != nil {
+		return nil, fmt.Errorf("could not read chunk %d: %w", index, err)
+	}
+
+	o.current, o.chunk = index, message.Data
+
+	return o.chunk, nil
+}
+
+func (o *object) Size() int64 {
+	return o.size
+}
+
+func (o *object) Close() error {
+	o.conn.Close()
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package nats_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	_ "github.com/jtarchie/sqlitezstd/nats"
+	"github.com/jtarchie/sqlitezstd/testhelper"
+	"github.com/nats-io/nats-server/v2/server"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestNATS(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "NATS Suite")
+}
+
+func countEntries(dsn string) (int64, error) {
+	client, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	var count int64
+	err = client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)
+
+	return count, err
+}
+
+var _ = Describe("Backend", func() {
+	var address string
+
+	BeforeEach(func() {
+		Expect(sqlitezstd.Init()).To(Succeed())
+
+		contents, err := os.ReadFile(testhelper.CreateEntriesDB(GinkgoT(), 1000))
+		Expect(err).ToNot(HaveOccurred())
+
+		natsServer, err := server.NewServer(&server.Options{
+			Host:      "127.0.0.1",
+			Port:      -1,
+			JetStream: true,
+			StoreDir:  GinkgoT().TempDir(),
+			NoLog:     true,
+			NoSigs:    true,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		go natsServer.Start()
+		DeferCleanup(natsServer.Shutdown)
+
+		if !natsServer.ReadyForConnections(10 * time.Second) {
+			Skip("NATS is unavailable")
+		}
+
+		address = natsServer.Addr().String()
+
+		conn, err := natsgo.Connect("nats://" + address)
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		js, err := jetstream.New(conn)
+		Expect(err).ToNot(HaveOccurred())
+
+		ctx := context.Background()
+
+		store, err := js.CreateObjectStore(ctx, jetstream.ObjectStoreConfig{Bucket: "reference"})
+		Expect(err).ToNot(HaveOccurred())
+
+		// other objects interleave their chunks with those of the archive
+		_, err = store.PutBytes(ctx, "other", bytes.Repeat([]byte{1}, 100_000))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = store.Put(ctx, jetstream.ObjectMeta{
+			Name: "data/db.zst",
+			Opts: &jetstream.ObjectMetaOptions{ChunkSize: 4096},
+		}, bytes.NewReader(contents))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = store.PutBytes(ctx, "later", bytes.Repeat([]byte{2}, 100_000))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("reads archives a chunk at a time", func() {
+		count, err := countEntries(fmt.Sprintf("nats://%s/reference/data/db.zst?vfs=zstd", address))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+	})
+
+	It("errors for missing objects and buckets", func() {
+		_, err := sqlitezstd.Inspect(fmt.Sprintf("nats://%s/reference/missing.zst", address))
+		Expect(err).To(MatchError(jetstream.ErrObjectNotFound))
+
+		_, err = sqlitezstd.Inspect(fmt.Sprintf("nats://%s/missing/db.zst", address))
+		Expect(err).To(MatchError(jetstream.ErrBucketNotFound))
+	})
+})
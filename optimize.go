@@ -0,0 +1,65 @@
+package sqlitezstd
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// Optimize rewrites the database at src with VACUUM INTO before compressing
+// it to dst. VACUUM copies each table and index in turn, so their pages end up
+// contiguous and a typical index scan touches far fewer frames through the VFS.
+func Optimize(src, dst string, opts ...Option) error {
+	buildPath, err := os.MkdirTemp(filepath.Dir(dst), ".optimize-*")
+	if err != nil {
+		return fmt.Errorf("could not create temp directory: %w", err)
+	}
+	defer os.RemoveAll(buildPath)
+
+	vacuumPath := filepath.Join(buildPath, "vacuum.sqlite")
+
+	err = vacuumInto(src, vacuumPath)
+	if err != nil {
+		return err
+	}
+
+	return CompressFile(vacuumPath, dst, opts...)
+}
+
+func vacuumInto(src, dst string) error {
+	_, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("could not open source: %w", err)
+	}
+
+	client, err := sql.Open("sqlite3", readOnlyDSN(src))
+	if err != nil {
+		return fmt.Errorf("could not open source: %w", err)
+	}
+	defer client.Close()
+
+	_, err = client.Exec("VACUUM INTO ?", dst)
+	if err != nil {
+		return fmt.Errorf("could not vacuum source: %w", err)
+	}
+
+	return nil
+}
+
+// readOnlyDSN returns a DSN that opens path read-only with the default VFS.
+func readOnlyDSN(path string) string {
+	return fileDSN(path, "mode=ro")
+}
+
+// fileDSN returns a URI DSN for path with the given query.
+func fileDSN(path string, query string) string {
+	uri := &url.URL{
+		Scheme:   "file",
+		Opaque:   (&url.URL{Path: path}).EscapedPath(),
+		RawQuery: query,
+	}
+
+	return uri.String()
+}
@@ -0,0 +1,245 @@
+package sqlitezstd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	defaultFrameSize = 64 * 1024
+	defaultLevel     = 3
+
+	// maxFrameSize matches the limit enforced by the seekable reader.
+	maxFrameSize = 128 << 20
+)
+
+var ErrInvalidOption = errors.New("invalid option")
+
+//nolint: gochecknoglobals
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"B", 1},
+}
+
+// ParseSize parses a byte size such as 65536, 64KiB, or 1MB, as the size
+// parameters of the VFS are, failing for sizes too large for an int64.
+func ParseSize(value string) (int64, error) {
+	multiplier := int64(1)
+	number := strings.TrimSpace(value)
+
+	for _, unit := range sizeUnits {
+		if trimmed, ok := strings.CutSuffix(number, unit.suffix); ok {
+			number = strings.TrimSpace(trimmed)
+			multiplier = unit.multiplier
+
+			break
+		}
+	}
+
+	// sizes too large for an int64 would wrap around
+	parsed, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || parsed < 0 || parsed > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("%w: size %q", ErrInvalidOption, value)
+	}
+
+	return parsed * multiplier, nil
+}
+
+// Option configures how a database is compressed.
+type Option func(*options)
+
+type options struct {
+	level     int
+	frameSize int
+	workers   int
+	pageAlign bool
+	partSize  int64
+	progress  func(Progress)
+
+	deterministic  bool
+	contentDefined bool
+}
+
+// Progress reports how far a compression has come.
+type Progress struct {
+	// BytesRead is the uncompressed size of the frames written so far.
+	BytesRead int64
+	// BytesWritten is the compressed size of the frames written so far.
+	BytesWritten int64
+	// FramesWritten is the number of frames written so far.
+	FramesWritten int
+}
+
+// Ratio is the compression ratio so far, or 0 if nothing was written yet.
+func (p Progress) Ratio() float64 {
+	if p.BytesWritten == 0 {
+		return 0
+	}
+
+	return float64(p.BytesRead) / float64(p.BytesWritten)
+}
+
+// WithLevel sets the zstd compression level, from 1 (fastest) to 22 (smallest).
+func WithLevel(level int) Option {
+	return func(o *options) {
+		o.level = level
+	}
+}
+
+// WithFrameSize sets the uncompressed size of each frame. Every read through
+// the VFS decompresses at least one whole frame, so smaller frames mean less
+// read amplification at the cost of a worse compression ratio.
+func WithFrameSize(size int) Option {
+	return func(o *options) {
+		o.frameSize = size
+	}
+}
+
+// WithWorkers sets how many frames are compressed concurrently.
+func WithWorkers(workers int) Option {
+	return func(o *options) {
+		o.workers = workers
+	}
+}
+
+// WithPageAlignment controls whether the frame size is rounded down to a
+// multiple of the SQLite page size, so that a page read never straddles two
+// frames. It is enabled by default.
+func WithPageAlignment(enabled bool) Option {
+	return func(o *options) {
+		o.pageAlign = enabled
+	}
+}
+
+// WithDeterministic pins every zstd parameter instead of relying on the
+// encoder defaults, so identical input compressed with the same options
+// produces byte-identical output, regardless of the number of workers.
+// Published archives can then be verified against a build from source
+// and deduplicated by content hash. The header records the setting.
+func WithDeterministic(enabled bool) Option {
+	return func(o *options) {
+		o.deterministic = enabled
+	}
+}
+
+// WithContentDefinedChunking cuts frames where the content says so instead
+// of at a fixed size, so inserting or removing pages only changes the frames
+// around the edit rather than shifting every frame after it. The frame size
+// becomes the average, with frames between a quarter and four times of it.
+// Updated archives then share most frames with the previous version, which
+// keeps rsync, HTTP caches, and deduplicating stores cheap.
+func WithContentDefinedChunking(enabled bool) Option {
+	return func(o *options) {
+		o.contentDefined = enabled
+	}
+}
+
+// WithPartSize splits the compressed output into parts of at most size
+// bytes, named `<dst>.000`, `<dst>.001`, and so on. A small manifest listing
+// the parts is written to dst, and the VFS opens them as one file. Object
+// stores and CDNs handle many smaller objects better than one huge one.
+func WithPartSize(size int64) Option {
+	return func(o *options) {
+		o.partSize = size
+	}
+}
+
+// WithProgress calls fn every time a frame is written to the output, from
+// the goroutine doing the compression, so long-running compressions can
+// be reported on. fn should return quickly, as compression waits for it.
+func WithProgress(fn func(Progress)) Option {
+	return func(o *options) {
+		o.progress = fn
+	}
+}
+
+func newOptions(opts []Option) (*options, error) {
+	config := &options{
+		level:     defaultLevel,
+		frameSize: defaultFrameSize,
+		workers:   runtime.GOMAXPROCS(0),
+		pageAlign: true,
+	}
+
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	//nolint: mnd
+	if config.level < 1 || config.level > 22 {
+		return nil, fmt.Errorf("%w: level %d must be between 1 and 22", ErrInvalidOption, config.level)
+	}
+
+	if config.frameSize <= 0 || config.frameSize > maxFrameSize {
+		return nil, fmt.Errorf("%w: frame size %d must be between 1 and %d", ErrInvalidOption, config.frameSize, maxFrameSize)
+	}
+
+	if config.partSize < 0 {
+		return nil, fmt.Errorf("%w: part size %d must not be negative", ErrInvalidOption, config.partSize)
+	}
+
+	if config.workers <= 0 {
+		return nil, fmt.Errorf("%w: workers %d must be positive", ErrInvalidOption, config.workers)
+	}
+
+	return config, nil
+}
+
+func (o *options) encoder() (*zstd.Encoder, error) {
+	opts := []zstd.EOption{
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(o.level)),
+		zstd.WithEncoderConcurrency(o.workers),
+	}
+
+	if o.deterministic {
+		window := zstd.MinWindowSize
+		for window < o.frameSize && window < zstd.MaxWindowSize {
+			window <<= 1
+		}
+
+		opts = append(opts,
+			zstd.WithWindowSize(window),
+			zstd.WithEncoderCRC(true),
+			zstd.WithAllLitEntropyCompression(true),
+			zstd.WithNoEntropyCompression(false),
+			zstd.WithLowerEncoderMem(false),
+		)
+	}
+
+	return zstd.NewWriter(nil, opts...)
+}
+
+// header lays out frames for a database with pageSize,
+// which is 0 when the input is not a SQLite database.
+func (o *options) header(pageSize int) *Header {
+	header := &Header{
+		PageSize:       pageSize,
+		FrameSize:      o.frameSize,
+		Deterministic:  o.deterministic,
+		ContentDefined: o.contentDefined,
+	}
+
+	if pageSize > 0 && o.pageAlign {
+		header.FrameSize = max(pageSize, header.FrameSize-header.FrameSize%pageSize)
+		header.PageAligned = true
+	}
+
+	return header
+}
@@ -0,0 +1,734 @@
+package sqlitezstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/psanford/sqlite3vfs"
+)
+
+// Overlay selects where writes to a compressed database are kept.
+// The compressed archive itself is never modified.
+type Overlay string
+
+const (
+	// OverlayNone opens the database read-only.
+	OverlayNone Overlay = ""
+	// OverlayDelta stores modified pages in a sidecar file next to the
+	// archive, named after it with a ".delta" suffix, and overlays them on
+	// top of the compressed pages when reading. Only local archives are
+	// supported, and the sidecar must only be used by one process at a time.
+	OverlayDelta Overlay = "delta"
+	// OverlayRewrite buffers modified pages in a temporary file and, when
+	// the last connection closes, recompresses the database and atomically
+	// replaces the archive. Only local archives are supported.
+	OverlayRewrite Overlay = "rewrite"
+	// OverlayMemory keeps modified pages in memory, shared by the connections
+	// to the same database and discarded once the last one closes. This
+	// allows scratch work, like temporary views or intermediate tables, on
+	// top of an immutable archive, remote ones included.
+	OverlayMemory Overlay = "memory"
+)
+
+func parseOverlay(value string) (Overlay, error) {
+	switch overlay := Overlay(value); overlay {
+	case OverlayNone, OverlayDelta, OverlayRewrite, OverlayMemory:
+		return overlay, nil
+	default:
+		if value == "none" {
+			return OverlayNone, nil
+		}
+
+		return OverlayNone, fmt.Errorf("%w: %q", ErrOverlayUnsupported, value)
+	}
+}
+
+// localJournals reports whether the overlay keeps its
+// journals as files next to the archive.
+func (o Overlay) localJournals() bool {
+	return o == OverlayDelta || o == OverlayRewrite
+}
+
+const defaultBlockSize = 4096
+
+var ErrOverlayUnsupported = errors.New("overlay is not supported for this source")
+
+// pageStore holds the blocks that were written on top of the archive.
+type pageStore interface {
+	// block returns the stored copy of the block at index, or nil.
+	block(index int64) ([]byte, error)
+	putBlock(index int64, data []byte, size int64) error
+	// size returns the logical file size, or -1 if nothing was written yet.
+	size() int64
+	// baseLimit returns how much of the archive is still visible, or -1 if
+	// the file was never truncated.
+	baseLimit() int64
+	truncate(size int64) error
+	sync() error
+	close() error
+}
+
+// overlayState is shared by every connection to the same archive,
+// so they all see the same writes and take the same locks.
+type overlayState struct {
+	key     string
+	overlay Overlay
+	refs    int
+	// names counts the connections by the database name they opened
+	names     map[string]int
+	blockSize int64
+	store     pageStore
+	locks     lockManager
+	// release runs when the last connection closes, before the store does
+	release func(last *overlayFile) error
+	// mutex guards reads and writes of the store
+	mutex sync.RWMutex
+}
+
+//nolint: gochecknoglobals
+var (
+	overlaysMutex sync.Mutex
+	overlays      = map[string]*overlayState{}
+	// releasing holds the keys of overlays being released, closed once
+	// they are, so reopening one waits for it.
+	releasing = map[string]chan struct{}{}
+)
+
+// acquireOverlay returns the shared state for key, creating it with open.
+func acquireOverlay(key string, name string, open func() (*overlayState, error)) (*overlayState, error) {
+	overlaysMutex.Lock()
+	defer overlaysMutex.Unlock()
+
+	// a rewrite overlay may still be compressing the archive again
+	for done, ok := releasing[key]; ok; done, ok = releasing[key] {
+		overlaysMutex.Unlock()
+		<-done
+		overlaysMutex.Lock()
+	}
+
+	state, ok := overlays[key]
+	if !ok {
+		var err error
+
+		state, err = open()
+		if err != nil {
+			return nil, err
+		}
+
+		state.key = key
+		prefix, _, _ := strings.Cut(key, ":")
+		state.overlay = Overlay(prefix)
+		state.names = map[string]int{}
+		overlays[key] = state
+	}
+
+	state.refs++
+	state.names[name]++
+
+	return state, nil
+}
+
+// releaseOverlay drops a reference to state, releasing it with the last.
+// Releasing, such as compressing the archive again, happens without
+// holding overlaysMutex, so other databases are not held up by it.
+func releaseOverlay(state *overlayState, name string, last *overlayFile) error {
+	overlaysMutex.Lock()
+
+	state.refs--
+
+	state.names[name]--
+	if state.names[name] == 0 {
+		delete(state.names, name)
+	}
+
+	if state.refs > 0 {
+		overlaysMutex.Unlock()
+
+		return nil
+	}
+
+	delete(overlays, state.key)
+
+	done := make(chan struct{})
+	releasing[state.key] = done
+
+	overlaysMutex.Unlock()
+
+	defer func() {
+		overlaysMutex.Lock()
+		delete(releasing, state.key)
+		overlaysMutex.Unlock()
+
+		close(done)
+	}()
+
+	var err error
+	if state.release != nil && last != nil {
+		err = state.release(last)
+	}
+
+	return errors.Join(err, state.store.close())
+}
+
+// overlayFor returns the overlay of an open database by the name
+// SQLite opened it with, or OverlayNone if it is not open with one.
+func overlayFor(name string) Overlay {
+	overlaysMutex.Lock()
+	defer overlaysMutex.Unlock()
+
+	for _, state := range overlays {
+		if state.names[name] > 0 {
+			return state.overlay
+		}
+	}
+
+	return OverlayNone
+}
+
+// journalDatabase returns the database name a journal belongs to.
+func journalDatabase(name string) (string, bool) {
+	for _, suffix := range []string{"-journal", "-wal"} {
+		if database, ok := strings.CutSuffix(name, suffix); ok {
+			return database, true
+		}
+	}
+
+	return name, false
+}
+
+// openOverlay layers the pages written to the database over base.
+func (z *ZstdVFS) openOverlay(name string, base *ZstdFile, overlay Overlay) (*overlayFile, error) {
+	key := name
+
+	if overlay.localJournals() {
+		if isRemote(name) {
+			return nil, ErrOverlayUnsupported
+		}
+
+		path, err := filepath.Abs(name)
+		if err != nil {
+			return nil, fmt.Errorf("could not resolve path: %w", err)
+		}
+
+		key = path
+	}
+
+	state, err := acquireOverlay(string(overlay)+":"+key, name, func() (*overlayState, error) {
+		switch overlay {
+		case OverlayDelta:
+			store, err := openDeltaStore(key+".delta", basePageSize(base))
+			if err != nil {
+				return nil, err
+			}
+
+			return &overlayState{
+				blockSize: store.blockSize,
+				store:     store,
+			}, nil
+		case OverlayRewrite:
+			return z.openRewrite(key, base)
+		case OverlayMemory:
+			return &overlayState{
+				blockSize: basePageSize(base),
+				store:     newMemoryStore(),
+			}, nil
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrOverlayUnsupported, overlay)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := newOverlayFile(name, base, state)
+	if err != nil {
+		_ = releaseOverlay(state, name, nil)
+
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// openRewrite buffers writes in a temporary file, and replaces
+// the archive at path once the last connection is done.
+func (z *ZstdVFS) openRewrite(path string, base *ZstdFile) (*overlayState, error) {
+	temp, err := os.CreateTemp("", "sqlitezstd-rewrite-*")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temp file: %w", err)
+	}
+
+	_ = temp.Close()
+
+	store, err := openDeltaStore(temp.Name(), basePageSize(base))
+	if err != nil {
+		_ = os.Remove(temp.Name())
+
+		return nil, err
+	}
+
+	store.temporary = true
+
+	return &overlayState{
+		blockSize: store.blockSize,
+		store:     store,
+		release: func(last *overlayFile) error {
+			if !last.dirty() {
+				return nil
+			}
+
+			previous, err := openArchive(path)
+			if err != nil {
+				return fmt.Errorf("could not open archive: %w", err)
+			}
+			defer previous.Close()
+
+			_, err = replaceArchive(last, last.size(), previous, previous.Size(), path, z.Options)
+
+			return err
+		},
+	}, nil
+}
+
+// openJournal opens the journals and temporary files SQLite
+// needs once the database is writable.
+func (z *ZstdVFS) openJournal(name string, flags sqlite3vfs.OpenFlag) (sqlite3vfs.File, sqlite3vfs.OpenFlag, error) {
+	var (
+		file *os.File
+		err  error
+	)
+
+	database, _ := journalDatabase(name)
+	overlay := overlayFor(database)
+
+	switch {
+	case name == "":
+		// temporary files are always allowed, for sorting and the like
+	case overlay == OverlayMemory:
+		return &memoryFile{}, flags, nil
+	case !overlay.localJournals():
+		return nil, 0, sqlite3vfs.CantOpenError
+	}
+
+	if name == "" {
+		file, err = os.CreateTemp("", "sqlitezstd-*")
+		if err == nil {
+			// the file lives on as long as it is open
+			_ = os.Remove(file.Name())
+		}
+	} else {
+		if isRemote(name) {
+			return nil, 0, sqlite3vfs.CantOpenError
+		}
+
+		mode := os.O_RDWR
+		if flags&sqlite3vfs.OpenCreate != 0 {
+			mode |= os.O_CREATE
+		}
+
+		file, err = os.OpenFile(name, mode, 0o644)
+	}
+
+	if err != nil {
+		return nil, 0, sqlite3vfs.CantOpenError
+	}
+
+	if flags&sqlite3vfs.OpenDeleteOnClose != 0 && name != "" {
+		_ = os.Remove(name)
+	}
+
+	return &localFile{File: file}, flags, nil
+}
+
+// basePageSize reads the page size from the header of the archive's
+// database, falling back to a default for empty or unusual files.
+func basePageSize(base *ZstdFile) int64 {
+	header := make([]byte, sqliteHeaderSize)
+
+	_, err := base.ReadAt(header, 0)
+	if err != nil {
+		return defaultBlockSize
+	}
+
+	pageSize, err := parsePageSize(header)
+	if err != nil {
+		return defaultBlockSize
+	}
+
+	return int64(pageSize)
+}
+
+type overlayFile struct {
+	name     string
+	base     *ZstdFile
+	baseSize int64
+	state    *overlayState
+	lock     sqlite3vfs.LockType
+}
+
+var _ sqlite3vfs.File = &overlayFile{}
+
+func newOverlayFile(name string, base *ZstdFile, state *overlayState) (*overlayFile, error) {
+	baseSize, err := base.FileSize()
+	if err != nil {
+		return nil, fmt.Errorf("could not read archive size: %w", err)
+	}
+
+	return &overlayFile{
+		name:     name,
+		base:     base,
+		baseSize: baseSize,
+		state:    state,
+	}, nil
+}
+
+func (o *overlayFile) CheckReservedLock() (bool, error) {
+	return o.state.locks.checkReserved(), nil
+}
+
+func (o *overlayFile) Close() error {
+	_ = o.state.locks.unlock(o, sqlite3vfs.LockNone)
+	err := releaseOverlay(o.state, o.name, o)
+	_ = o.base.Close()
+
+	return err
+}
+
+func (o *overlayFile) DeviceCharacteristics() sqlite3vfs.DeviceCharacteristic {
+	return 0
+}
+
+func (o *overlayFile) FileSize() (int64, error) {
+	o.state.mutex.RLock()
+	defer o.state.mutex.RUnlock()
+
+	return o.size(), nil
+}
+
+// dirty reports whether anything was ever written to the overlay.
+func (o *overlayFile) dirty() bool {
+	return o.state.store.size() >= 0
+}
+
+func (o *overlayFile) size() int64 {
+	size := o.state.store.size()
+	if size < 0 {
+		return o.baseSize
+	}
+
+	return size
+}
+
+func (o *overlayFile) Lock(elock sqlite3vfs.LockType) error {
+	return o.state.locks.lock(o, elock)
+}
+
+func (o *overlayFile) ReadAt(p []byte, off int64) (int, error) {
+	o.state.mutex.RLock()
+	defer o.state.mutex.RUnlock()
+
+	size := o.size()
+	end := min(off+int64(len(p)), size)
+	read := 0
+
+	for position := off; position < end; {
+		index := position / o.state.blockSize
+		blockStart := index * o.state.blockSize
+		length := min(end, blockStart+o.state.blockSize) - position
+
+		err := o.readBlockAt(p[read:read+int(length)], index, position-blockStart)
+		if err != nil {
+			return read, err
+		}
+
+		position += length
+		read += int(length)
+	}
+
+	if read < len(p) {
+		return read, io.EOF
+	}
+
+	return read, nil
+}
+
+// readBlockAt fills p from the block at index, starting within it.
+func (o *overlayFile) readBlockAt(p []byte, index int64, within int64) error {
+	block, err := o.state.store.block(index)
+	if err != nil {
+		return err
+	}
+
+	if block != nil {
+		copy(p, block[within:])
+
+		return nil
+	}
+
+	clear(p)
+
+	baseEnd := o.baseSize
+	if limit := o.state.store.baseLimit(); limit >= 0 {
+		baseEnd = min(baseEnd, limit)
+	}
+
+	offset := index*o.state.blockSize + within
+	if offset >= baseEnd {
+		return nil
+	}
+
+	_, err = o.base.ReadAt(p[:min(int64(len(p)), baseEnd-offset)], offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	return nil
+}
+
+func (o *overlayFile) SectorSize() int64 {
+	return 0
+}
+
+func (o *overlayFile) Sync(flag sqlite3vfs.SyncType) error {
+	return o.state.store.sync()
+}
+
+func (o *overlayFile) Truncate(size int64) error {
+	o.state.mutex.Lock()
+	defer o.state.mutex.Unlock()
+
+	if size >= o.size() {
+		return nil
+	}
+
+	// zero the tail of the last block, so it does not reappear if the file grows again
+	index := size / o.state.blockSize
+	if within := size % o.state.blockSize; within != 0 {
+		block, err := o.fullBlock(index)
+		if err != nil {
+			return err
+		}
+
+		clear(block[within:])
+
+		err = o.state.store.putBlock(index, block, size)
+		if err != nil {
+			return err
+		}
+	}
+
+	return o.state.store.truncate(size)
+}
+
+func (o *overlayFile) Unlock(elock sqlite3vfs.LockType) error {
+	return o.state.locks.unlock(o, elock)
+}
+
+func (o *overlayFile) WriteAt(p []byte, off int64) (int, error) {
+	o.state.mutex.Lock()
+	defer o.state.mutex.Unlock()
+
+	written := 0
+	end := off + int64(len(p))
+
+	for position := off; position < end; {
+		index := position / o.state.blockSize
+		blockStart := index * o.state.blockSize
+		length := min(end, blockStart+o.state.blockSize) - position
+
+		block, err := o.fullBlock(index)
+		if err != nil {
+			return written, err
+		}
+
+		copy(block[position-blockStart:], p[written:written+int(length)])
+
+		err = o.state.store.putBlock(index, block, max(o.size(), position+length))
+		if err != nil {
+			return written, err
+		}
+
+		position += length
+		written += int(length)
+	}
+
+	return written, nil
+}
+
+// fullBlock returns a copy of the current contents of the block at index.
+func (o *overlayFile) fullBlock(index int64) ([]byte, error) {
+	block := make([]byte, o.state.blockSize)
+
+	start := index * o.state.blockSize
+	if size := o.size(); start < size {
+		err := o.readBlockAt(block[:min(o.state.blockSize, size-start)], index, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return block, nil
+}
+
+// lockManager implements SQLite's locking protocol between the
+// connections of this process that share an overlay.
+type lockManager struct {
+	mutex     sync.Mutex
+	shared    int
+	writer    *overlayFile
+	pending   bool
+	exclusive bool
+}
+
+func (m *lockManager) lock(file *overlayFile, elock sqlite3vfs.LockType) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if file.lock >= elock {
+		return nil
+	}
+
+	switch elock {
+	case sqlite3vfs.LockNone:
+		return nil
+	case sqlite3vfs.LockShared:
+		if m.pending || m.exclusive {
+			return sqlite3vfs.BusyError
+		}
+
+		m.shared++
+	case sqlite3vfs.LockReserved:
+		if m.writer != nil && m.writer != file {
+			return sqlite3vfs.BusyError
+		}
+
+		m.writer = file
+	case sqlite3vfs.LockPending, sqlite3vfs.LockExclusive:
+		if m.writer != nil && m.writer != file {
+			return sqlite3vfs.BusyError
+		}
+
+		m.writer = file
+		m.pending = true
+		file.lock = sqlite3vfs.LockPending
+
+		// new readers are now held off, wait for the existing ones to finish
+		if m.shared > 1 {
+			return sqlite3vfs.BusyError
+		}
+
+		if elock == sqlite3vfs.LockPending {
+			return nil
+		}
+
+		m.exclusive = true
+	}
+
+	file.lock = elock
+
+	return nil
+}
+
+func (m *lockManager) unlock(file *overlayFile, elock sqlite3vfs.LockType) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if file.lock <= elock {
+		return nil
+	}
+
+	if file.lock >= sqlite3vfs.LockReserved {
+		m.writer = nil
+		m.pending = false
+		m.exclusive = false
+	}
+
+	if elock == sqlite3vfs.LockNone {
+		m.shared--
+	}
+
+	file.lock = elock
+
+	return nil
+}
+
+func (m *lockManager) checkReserved() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.writer != nil
+}
+
+// localFile is a plain file on disk, used for journals next to an overlay.
+type localFile struct {
+	*os.File
+}
+
+var _ sqlite3vfs.File = &localFile{}
+
+func (l *localFile) CheckReservedLock() (bool, error) {
+	return false, nil
+}
+
+func (l *localFile) DeviceCharacteristics() sqlite3vfs.DeviceCharacteristic {
+	return 0
+}
+
+func (l *localFile) FileSize() (int64, error) {
+	info, err := l.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
+
+func (l *localFile) Lock(elock sqlite3vfs.LockType) error {
+	return nil
+}
+
+func (l *localFile) SectorSize() int64 {
+	return 0
+}
+
+func (l *localFile) Sync(flag sqlite3vfs.SyncType) error {
+	return l.File.Sync()
+}
+
+func (l *localFile) Unlock(elock sqlite3vfs.LockType) error {
+	return nil
+}
+
+func (l *localFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := l.File.ReadAt(p, off)
+	if errors.Is(err, io.EOF) {
+		return n, io.EOF
+	}
+
+	return n, err
+}
+
+// putInt64 and getInt64 encode offsets in the sidecar formats.
+func putInt64(p []byte, value int64) {
+	//nolint: gosec
+	binary.LittleEndian.PutUint64(p, uint64(value))
+}
+
+func getInt64(p []byte) int64 {
+	//nolint: gosec
+	return int64(binary.LittleEndian.Uint64(p))
+}
+
+func getUint32(p []byte) uint32 {
+	return binary.LittleEndian.Uint32(p)
+}
+
+func appendUint32(p []byte, value uint32) []byte {
+	return binary.LittleEndian.AppendUint32(p, value)
+}
@@ -0,0 +1,189 @@
+package sqlitezstd_test
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+//nolint: gochecknoglobals
+var registerOverlays = sync.OnceValue(func() error {
+	return errors.Join(
+		sqlitezstd.Register("zstd-delta", &sqlitezstd.ZstdVFS{Overlay: sqlitezstd.OverlayDelta}),
+		sqlitezstd.Register("zstd-rewrite", &sqlitezstd.ZstdVFS{Overlay: sqlitezstd.OverlayRewrite}),
+	)
+})
+
+func countEntries(dsn string) int64 {
+	client, err := sql.Open("sqlite3", dsn)
+	Expect(err).ToNot(HaveOccurred())
+	defer client.Close()
+
+	var count int64
+	err = client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)
+	Expect(err).ToNot(HaveOccurred())
+
+	return count
+}
+
+var _ = Describe("Overlay", func() {
+	BeforeEach(func() {
+		Expect(sqlitezstd.Init()).To(Succeed())
+		Expect(registerOverlays()).To(Succeed())
+	})
+
+	Describe("delta", func() {
+		It("keeps writes in a sidecar file", func() {
+			zstPath := createDatabase()
+
+			client, err := sql.Open("sqlite3", fmt.Sprintf("%s?vfs=zstd-delta", zstPath))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = client.Exec("INSERT INTO entries (id) VALUES (1001), (1002)")
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = client.Exec("CREATE TABLE notes (body TEXT); INSERT INTO notes VALUES ('hello')")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(client.Close()).To(Succeed())
+
+			_, err = os.Stat(zstPath + ".delta")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(countEntries(fmt.Sprintf("%s?vfs=zstd", zstPath))).To(BeEquivalentTo(1000))
+			Expect(countEntries(fmt.Sprintf("%s?vfs=zstd-delta", zstPath))).To(BeEquivalentTo(1002))
+
+			client, err = sql.Open("sqlite3", fmt.Sprintf("%s?vfs=zstd-delta", zstPath))
+			Expect(err).ToNot(HaveOccurred())
+			defer client.Close()
+
+			var body string
+			err = client.QueryRow("SELECT body FROM notes").Scan(&body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(body).To(Equal("hello"))
+		})
+
+		It("shares writes between connections", func() {
+			zstPath := createDatabase()
+
+			client, err := sql.Open("sqlite3", fmt.Sprintf("%s?vfs=zstd-delta&_busy_timeout=5000", zstPath))
+			Expect(err).ToNot(HaveOccurred())
+			defer client.Close()
+
+			client.SetMaxOpenConns(4)
+
+			waiter := &sync.WaitGroup{}
+
+			for worker := range 4 {
+				waiter.Add(1)
+
+				go func() {
+					defer waiter.Done()
+					defer GinkgoRecover()
+
+					for index := range 25 {
+						_, err := client.Exec("INSERT INTO entries (id) VALUES (?)", 2000+worker*100+index)
+						Expect(err).ToNot(HaveOccurred())
+
+						var count int64
+						err = client.QueryRow("SELECT COUNT(*) FROM entries").Scan(&count)
+						Expect(err).ToNot(HaveOccurred())
+					}
+				}()
+			}
+
+			waiter.Wait()
+
+			var count int64
+			err = client.QueryRow("SELECT COUNT(*) FROM entries").Scan(&count)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(BeEquivalentTo(1100))
+		})
+	})
+	Describe("rewrite", func() {
+		It("replaces the archive when the last connection closes", func() {
+			zstPath := createDatabase()
+
+			client, err := sql.Open("sqlite3", fmt.Sprintf("%s?vfs=zstd-rewrite", zstPath))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = client.Exec("INSERT INTO entries (id) VALUES (1001)")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(countEntries(fmt.Sprintf("%s?vfs=zstd", zstPath))).To(BeEquivalentTo(1000))
+			Expect(client.Close()).To(Succeed())
+
+			Expect(countEntries(fmt.Sprintf("%s?vfs=zstd", zstPath))).To(BeEquivalentTo(1001))
+
+			_, err = os.Stat(zstPath + ".delta")
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("leaves the archive alone without writes", func() {
+			zstPath := createDatabase()
+
+			before, err := os.Stat(zstPath)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(countEntries(fmt.Sprintf("%s?vfs=zstd-rewrite", zstPath))).To(BeEquivalentTo(1000))
+
+			after, err := os.Stat(zstPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(after.ModTime()).To(Equal(before.ModTime()))
+		})
+	})
+
+	Describe("memory", func() {
+		It("discards writes once the last connection closes", func() {
+			zstPath := createDatabase()
+
+			before, err := os.Stat(zstPath)
+			Expect(err).ToNot(HaveOccurred())
+
+			dsn := fmt.Sprintf("file:%s?vfs=zstd&overlay=memory", zstPath)
+
+			client, err := sql.Open("sqlite3", dsn)
+			Expect(err).ToNot(HaveOccurred())
+
+			client.SetMaxOpenConns(2)
+
+			_, err = client.Exec("INSERT INTO entries (id) VALUES (1001); CREATE TABLE scratch AS SELECT id FROM entries WHERE id < 10")
+			Expect(err).ToNot(HaveOccurred())
+
+			var count int64
+			err = client.QueryRow("SELECT COUNT(*) FROM entries").Scan(&count)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(BeEquivalentTo(1001))
+
+			err = client.QueryRow("SELECT COUNT(*) FROM scratch").Scan(&count)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(BeEquivalentTo(9))
+			Expect(client.Close()).To(Succeed())
+
+			Expect(countEntries(dsn)).To(BeEquivalentTo(1000))
+
+			after, err := os.Stat(zstPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(after.ModTime()).To(Equal(before.ModTime()))
+
+			_, err = os.Stat(zstPath + ".delta")
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("rejects unknown overlays", func() {
+			zstPath := createDatabase()
+
+			client, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?vfs=zstd&overlay=unknown", zstPath))
+			Expect(err).ToNot(HaveOccurred())
+			defer client.Close()
+
+			_, err = client.Exec("SELECT COUNT(*) FROM entries")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
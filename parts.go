@@ -0,0 +1,335 @@
+package sqlitezstd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	manifestFormat  = "sqlitezstd-parts"
+	maxManifestSize = 1 << 20
+)
+
+var ErrInvalidManifest = errors.New("invalid parts manifest")
+
+// manifest lists the parts an archive was split into by WithPartSize.
+// It is stored as JSON in place of the archive, so the archive is
+// opened by the same name whether it was split or not.
+type manifest struct {
+	Format string `json:"format"`
+	Parts  []part `json:"parts"`
+}
+
+type part struct {
+	// Name is relative to the manifest.
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// archive is a compressed file, made up of one or more parts.
+type archive struct {
+	*io.SectionReader
+
+	closers []io.Closer
+	// identity tells the contents of the archive apart, if they can be.
+	identity string
+}
+
+func (a *archive) Close() error {
+	for _, closer := range a.closers {
+		_ = closer.Close()
+	}
+
+	return nil
+}
+
+// openArchive opens a local or remote archive, joining its parts if it
+// was split.
+func openArchive(name string) (*archive, error) {
+	reader, size, closer, err := openPart(name)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, 1)
+
+	_, err = reader.ReadAt(prefix, 0)
+	if err != nil || prefix[0] != '{' || size > maxManifestSize {
+		return &archive{
+			SectionReader: io.NewSectionReader(reader, 0, size),
+			closers:       []io.Closer{closer},
+			identity:      partIdentity(name, reader, size),
+		}, nil
+	}
+
+	defer closer.Close()
+
+	contents := make([]byte, size)
+
+	_, err = reader.ReadAt(contents, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not read manifest: %w", err)
+	}
+
+	var parts manifest
+
+	err = json.Unmarshal(contents, &parts)
+	if err != nil || parts.Format != manifestFormat {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidManifest, name)
+	}
+
+	return openParts(name, parts.Parts)
+}
+
+func openParts(name string, parts []part) (*archive, error) {
+	joined := &partsReader{}
+	result := &archive{}
+	identities := []string{}
+
+	for _, entry := range parts {
+		location, err := resolvePart(name, entry.Name)
+		if err != nil {
+			_ = result.Close()
+
+			return nil, err
+		}
+
+		reader, size, closer, err := openPart(location)
+		if err != nil {
+			_ = result.Close()
+
+			return nil, err
+		}
+
+		result.closers = append(result.closers, closer)
+
+		if size != entry.Size {
+			_ = result.Close()
+
+			return nil, fmt.Errorf("%w: part %s is %d bytes, expected %d", ErrInvalidManifest, entry.Name, size, entry.Size)
+		}
+
+		joined.readers = append(joined.readers, reader)
+		joined.offsets = append(joined.offsets, joined.size)
+		joined.size += size
+
+		identities = append(identities, partIdentity(location, reader, size))
+	}
+
+	result.SectionReader = io.NewSectionReader(joined, 0, joined.size)
+
+	// the archive changes with any of its parts
+	if !slices.Contains(identities, "") {
+		result.identity = strings.Join(identities, "\x00")
+	}
+
+	return result, nil
+}
+
+// openPart opens a single local or remote file, or a member of one.
+func openPart(name string) (io.ReaderAt, int64, io.Closer, error) {
+	if container, member, ok := splitMember(name); ok {
+		return openMember(container, member)
+	}
+
+	if backend, ok := backendFor(name); ok {
+		return openBackend(backend, name)
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("could not open file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+
+		return nil, 0, nil, fmt.Errorf("could not stat file: %w", err)
+	}
+
+	if useIOUring.Load() {
+		reader, closer, err := openRingFile(file)
+		if err == nil {
+			return reader, info.Size(), closer, nil
+		}
+	}
+
+	// read the file with system calls where it can not be mapped
+	mapped, err := mapFile(file, info.Size())
+	if err != nil {
+		return file, info.Size(), file, nil
+	}
+
+	_ = file.Close()
+
+	return mapped, info.Size(), mapped, nil
+}
+
+// resolvePart returns the location of a part relative to its manifest.
+func resolvePart(name string, partName string) (string, error) {
+	if filepath.Base(partName) != partName {
+		return "", fmt.Errorf("%w: part name %q", ErrInvalidManifest, partName)
+	}
+
+	if isHTTP(name) {
+		base, err := url.Parse(name)
+		if err != nil {
+			return "", fmt.Errorf("could not parse url: %w", err)
+		}
+
+		return base.ResolveReference(&url.URL{Path: partName}).String(), nil
+	}
+
+	// backends read their settings from the query, so parts share it, and
+	// may keep the path in the host, as in embed://reference.sqlite.zst
+	if _, ok := backendFor(name); ok {
+		location, query, _ := strings.Cut(name, "?")
+		location = location[:strings.LastIndex(location, "/")+1] + partName
+
+		if query != "" {
+			location += "?" + query
+		}
+
+		return location, nil
+	}
+
+	return filepath.Join(filepath.Dir(name), partName), nil
+}
+
+// partsReader reads the parts of an archive as one file.
+type partsReader struct {
+	readers []io.ReaderAt
+	offsets []int64
+	size    int64
+}
+
+func (p *partsReader) ReadAt(buffer []byte, off int64) (int, error) {
+	read := 0
+
+	for read < len(buffer) && off < p.size {
+		index := sort.Search(len(p.offsets), func(i int) bool {
+			return p.offsets[i] > off
+		}) - 1
+
+		end := p.size
+		if index+1 < len(p.offsets) {
+			end = p.offsets[index+1]
+		}
+
+		size := min(int64(len(buffer)-read), end-off)
+
+		count, err := p.readers[index].ReadAt(buffer[read:int64(read)+size], off-p.offsets[index])
+		read += count
+		off += int64(count)
+
+		if err != nil && !errors.Is(err, io.EOF) {
+			return read, err
+		}
+
+		if int64(count) < size {
+			return read, io.ErrUnexpectedEOF
+		}
+	}
+
+	if read < len(buffer) {
+		return read, io.EOF
+	}
+
+	return read, nil
+}
+
+// partWriter splits everything written to it across parts of
+// at most size bytes, named after dst.
+type partWriter struct {
+	dst   string
+	size  int64
+	parts []*os.File
+	sizes []int64
+}
+
+func (p *partWriter) Write(buffer []byte) (int, error) {
+	written := 0
+
+	for written < len(buffer) {
+		if len(p.parts) == 0 || p.sizes[len(p.sizes)-1] == p.size {
+			file, err := os.CreateTemp(filepath.Dir(p.dst), fmt.Sprintf("%s.%03d.*.tmp", filepath.Base(p.dst), len(p.parts)))
+			if err != nil {
+				return written, fmt.Errorf("could not create part: %w", err)
+			}
+
+			p.parts = append(p.parts, file)
+			p.sizes = append(p.sizes, 0)
+		}
+
+		last := len(p.parts) - 1
+		size := min(int64(len(buffer)-written), p.size-p.sizes[last])
+
+		count, err := p.parts[last].Write(buffer[written : int64(written)+size])
+		written += count
+		p.sizes[last] += int64(count)
+
+		if err != nil {
+			return written, fmt.Errorf("could not write part: %w", err)
+		}
+	}
+
+	return written, nil
+}
+
+// finish moves the parts in place, then the manifest that lists them.
+func (p *partWriter) finish() error {
+	parts := manifest{Format: manifestFormat}
+
+	for index, file := range p.parts {
+		err := file.Chmod(archiveMode)
+		if err != nil {
+			return fmt.Errorf("could not change part mode: %w", err)
+		}
+
+		err = file.Close()
+		if err != nil {
+			return fmt.Errorf("could not close part: %w", err)
+		}
+
+		name := fmt.Sprintf("%s.%03d", filepath.Base(p.dst), index)
+
+		err = os.Rename(file.Name(), filepath.Join(filepath.Dir(p.dst), name))
+		if err != nil {
+			return fmt.Errorf("could not move part: %w", err)
+		}
+
+		parts.Parts = append(parts.Parts, part{Name: name, Size: p.sizes[index]})
+	}
+
+	contents, err := json.Marshal(parts)
+	if err != nil {
+		return fmt.Errorf("could not encode manifest: %w", err)
+	}
+
+	return writeFile(p.dst, func(output io.Writer) error {
+		_, err := io.Copy(output, bytes.NewReader(contents))
+		if err != nil {
+			return fmt.Errorf("could not write manifest: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// remove cleans up the parts of a failed write.
+func (p *partWriter) remove() {
+	for _, file := range p.parts {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}
+}
@@ -0,0 +1,491 @@
+// Package peer shares the decompressed frames of sqlitezstd archives
+// between the instances of a fleet, the way groupcache does, so a frame of
+// a remote archive is fetched from the origin by one instance rather than
+// by all of them.
+//
+// Every instance serves its Pool over HTTP, under Path, and lists every
+// instance in Peers. Each frame is owned by one of them, chosen by
+// consistent hashing of its key, which fetches it from the archive itself
+// and keeps it in memory. An instance missing a frame asks its owner, and
+// instances asking while it is fetched wait for it, for up to FillTimeout:
+//
+//	pool := &peer.Pool{
+//		Self:     "http://10.0.0.1:8080",
+//		Peers:    []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"},
+//		Archives: []string{"https://example.com/data.sqlite.zst"},
+//		Secret:   os.Getenv("PEER_SECRET"),
+//	}
+//	http.Handle(peer.Path, pool)
+//
+//	err := sqlitezstd.Register("zstd", &sqlitezstd.ZstdVFS{Cache: pool, DiskCacheByContent: true})
+//
+// With DiskCacheByContent, the key of a frame is the hash of the contents
+// of its archive and its index, so instances reading the archive from
+// different locations share it. Peers only ever send keys: an owner reads
+// a frame from an archive it opened itself, or one listed in Archives,
+// with sqlitezstd.ReadFrame, which checks that the key names a frame of it
+// and the frame against the seek table, so one instance can not poison the
+// frames of the others, nor have them read anything else. Any error, such
+// as a peer being unreachable, is a miss, so reads fall back to the
+// archive.
+//
+// Path must not be reachable from outside the fleet, or Secret set on
+// every instance.
+package peer
+
+import (
+	"container/list"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+)
+
+// Path is where a Pool is served, followed by the key of a frame.
+const Path = "/_sqlitezstd/frames/"
+
+// SecretHeader carries the Secret of a Pool in the requests to its peers.
+const SecretHeader = "X-Sqlitezstd-Secret"
+
+const (
+	defaultCacheSize   = 64 << 20
+	defaultReplicas    = 50
+	defaultFillTimeout = 5 * time.Second
+	// requestTimeout bounds a request to a peer beyond its FillTimeout.
+	requestTimeout = time.Second
+)
+
+// Pool keeps the frames owned by this instance and asks the peers for the
+// others. The zero value, with Self and Peers set, is ready to use, and it
+// is safe for concurrent use.
+type Pool struct {
+	// Self is the base URL of this instance, as listed in Peers.
+	Self string
+	// Peers are the base URLs of every instance, including this one.
+	Peers []string
+	// Archives are the names of the archives, as opened with the VFS, this
+	// instance fetches frames of for the peers besides those it opened
+	// itself, so it owns their frames before reading them.
+	Archives []string
+	// Secret, if set, is sent to the peers with every request, and required
+	// of every request served, which are otherwise forbidden. Every
+	// instance of the fleet sets the same one.
+	Secret string
+	// CacheSize is the most bytes of frames kept for the peers, 64 MiB by
+	// default, before the least recently used are removed.
+	CacheSize int64
+	// Replicas is how many points every peer has on the hash ring, 50 by
+	// default, spreading the frames more evenly the more there are.
+	Replicas int
+	// FillTimeout is how long a frame being fetched from its archive is
+	// waited for, 5 seconds by default, before it is a miss. The fetch
+	// goes on, keeping the frame for the next to ask.
+	FillTimeout time.Duration
+	// Client sends the requests to the peers, http.DefaultClient if unset.
+	Client *http.Client
+
+	mutex  sync.Mutex
+	ring   *ring
+	frames *frames
+	fills  map[string]*fill
+	// opened are the archives opened by this instance, by the key naming
+	// their frames.
+	opened map[string]string
+}
+
+var (
+	_ sqlitezstd.FrameLoader = &Pool{}
+	_ http.Handler           = &Pool{}
+)
+
+// Set replaces the peers, such as when instances join or leave the fleet.
+// Frames are owned by their new peers from then on.
+func (p *Pool) Set(peers ...string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.Peers = peers
+	p.ring = nil
+}
+
+// Get returns the frame key from its owner, which fetches it if it knows
+// its archive.
+func (p *Pool) Get(key string) ([]byte, bool) {
+	owner := p.owner(key)
+	if owner == p.Self {
+		return p.load(key)
+	}
+
+	data, status, err := p.request(http.MethodGet, owner, key)
+
+	return data, err == nil && status == http.StatusOK
+}
+
+// Load returns the frame key of archive, which was opened by this
+// instance, so it fetches frames of it for the peers from then on. Only
+// the key is sent to the owner of the frame.
+func (p *Pool) Load(key string, archive string) ([]byte, bool) {
+	if prefix, _, ok := strings.Cut(key, "-"); ok {
+		p.mutex.Lock()
+
+		if p.opened == nil {
+			p.opened = map[string]string{}
+		}
+
+		p.opened[prefix] = archive
+		p.mutex.Unlock()
+	}
+
+	return p.Get(key)
+}
+
+// Put keeps a frame decompressed by this instance if it owns it. Frames
+// are not sent to the peers owning them, which fetch them themselves.
+func (p *Pool) Put(key string, data []byte) {
+	if p.owner(key) != p.Self {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.cache().add(key, data)
+}
+
+func (p *Pool) Remove(key string) {
+	owner := p.owner(key)
+	if owner == p.Self {
+		p.mutex.Lock()
+		p.cache().remove(key)
+		p.mutex.Unlock()
+
+		return
+	}
+
+	_, _, _ = p.request(http.MethodDelete, owner, key)
+}
+
+// ServeHTTP answers the peers asking for, and removing, the frames owned
+// by this instance. A frame asked for is fetched when it is not held, from
+// the archive its key names, if that was opened by this instance or is
+// listed in Archives.
+func (p *Pool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if p.Secret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(SecretHeader)), []byte(p.Secret)) != 1 {
+		w.WriteHeader(http.StatusForbidden)
+
+		return
+	}
+
+	key := path.Base(r.URL.Path)
+
+	switch r.Method {
+	case http.MethodGet:
+		data, ok := p.load(key)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		_, _ = w.Write(data)
+	case http.MethodDelete:
+		p.mutex.Lock()
+		p.cache().remove(key)
+		p.mutex.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// owner returns the peer owning key, or Self when there are no peers.
+func (p *Pool) owner(key string) string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.ring == nil {
+		replicas := p.Replicas
+		if replicas <= 0 {
+			replicas = defaultReplicas
+		}
+
+		p.ring = newRing(replicas, p.Peers)
+	}
+
+	owner, ok := p.ring.get(key)
+	if !ok {
+		return p.Self
+	}
+
+	return owner
+}
+
+func (p *Pool) cache() *frames {
+	if p.frames == nil {
+		size := p.CacheSize
+		if size <= 0 {
+			size = defaultCacheSize
+		}
+
+		p.frames = newFrames(size)
+	}
+
+	return p.frames
+}
+
+func (p *Pool) fillTimeout() time.Duration {
+	if p.FillTimeout <= 0 {
+		return defaultFillTimeout
+	}
+
+	return p.FillTimeout
+}
+
+// load returns a frame owned by this instance. On a miss, it is fetched
+// from its archive, unless that is not known, by the first to ask while
+// the others wait for it.
+func (p *Pool) load(key string) ([]byte, bool) {
+	p.mutex.Lock()
+
+	data, ok := p.cache().get(key)
+	if ok {
+		p.mutex.Unlock()
+
+		return data, true
+	}
+
+	pending, filling := p.fills[key]
+	if !filling {
+		archives := p.archives(key)
+		if len(archives) == 0 {
+			p.mutex.Unlock()
+
+			return nil, false
+		}
+
+		pending = p.fill(key, archives)
+	}
+
+	p.mutex.Unlock()
+
+	timeout := time.NewTimer(p.fillTimeout())
+	defer timeout.Stop()
+
+	select {
+	case <-pending.done:
+		return pending.data, pending.data != nil
+	case <-timeout.C:
+		return nil, false
+	}
+}
+
+// archives returns the archives key may name a frame of: the one opened
+// by this instance it names, else those in Archives. The mutex is held.
+func (p *Pool) archives(key string) []string {
+	prefix, _, _ := strings.Cut(key, "-")
+	if archive, ok := p.opened[prefix]; ok {
+		return []string{archive}
+	}
+
+	return p.Archives
+}
+
+// fill fetches key from the first of archives it names a frame of,
+// keeping it once read. The mutex is held.
+func (p *Pool) fill(key string, archives []string) *fill {
+	if p.fills == nil {
+		p.fills = map[string]*fill{}
+	}
+
+	pending := &fill{done: make(chan struct{})}
+	p.fills[key] = pending
+
+	go func() {
+		var (
+			data    []byte
+			archive string
+			err     error
+		)
+
+		for _, archive = range archives {
+			data, err = sqlitezstd.ReadFrame(archive, key)
+			if !errors.Is(err, sqlitezstd.ErrFrameKeyMismatch) {
+				break
+			}
+		}
+
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+
+		if err == nil {
+			if p.opened == nil {
+				p.opened = map[string]string{}
+			}
+
+			prefix, _, _ := strings.Cut(key, "-")
+			p.opened[prefix] = archive
+
+			p.cache().add(key, data)
+			pending.data = data
+		}
+
+		delete(p.fills, key)
+		close(pending.done)
+	}()
+
+	return pending
+}
+
+// request sends a request for key to peer, returning the body and status
+// of its response.
+func (p *Pool) request(method string, peer string, key string) ([]byte, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.fillTimeout()+requestTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, method, peer+Path+key, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not create request: %w", err)
+	}
+
+	if p.Secret != "" {
+		request.Header.Set(SecretHeader, p.Secret)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not reach %s: %w", peer, err)
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not read response of %s: %w", peer, err)
+	}
+
+	return data, response.StatusCode, nil
+}
+
+// fill is a frame being fetched from its archive, done once it is read,
+// with its data unless it could not be.
+type fill struct {
+	done chan struct{}
+	data []byte
+}
+
+// ring hashes keys to peers, each at many points, so a peer joining or
+// leaving moves few keys.
+type ring struct {
+	hashes []uint32
+	peers  map[uint32]string
+}
+
+func newRing(replicas int, peers []string) *ring {
+	r := &ring{peers: map[uint32]string{}}
+
+	for _, peer := range peers {
+		for replica := range replicas {
+			hash := crc32.ChecksumIEEE([]byte(strconv.Itoa(replica) + peer))
+			r.hashes = append(r.hashes, hash)
+			r.peers[hash] = peer
+		}
+	}
+
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+
+	return r
+}
+
+func (r *ring) get(key string) (string, bool) {
+	if len(r.hashes) == 0 {
+		return "", false
+	}
+
+	hash := crc32.ChecksumIEEE([]byte(key))
+
+	index := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= hash })
+	if index == len(r.hashes) {
+		index = 0
+	}
+
+	return r.peers[r.hashes[index]], true
+}
+
+// frames keeps the frames owned by this instance, removing the least
+// recently used ones once they add up to more than size bytes.
+type frames struct {
+	size    int64
+	used    int64
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	key  string
+	data []byte
+}
+
+func newFrames(size int64) *frames {
+	return &frames{
+		size:    size,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+func (f *frames) get(key string) ([]byte, bool) {
+	element, ok := f.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	f.order.MoveToFront(element)
+	cached, _ := element.Value.(*entry)
+
+	return cached.data, true
+}
+
+func (f *frames) add(key string, data []byte) {
+	f.remove(key)
+
+	if int64(len(data)) > f.size {
+		return
+	}
+
+	f.entries[key] = f.order.PushFront(&entry{key: key, data: data})
+	f.used += int64(len(data))
+
+	for f.used > f.size {
+		oldest, _ := f.order.Back().Value.(*entry)
+		f.remove(oldest.key)
+	}
+}
+
+func (f *frames) remove(key string) {
+	element, ok := f.entries[key]
+	if !ok {
+		return
+	}
+
+	cached, _ := element.Value.(*entry)
+	f.used -= int64(len(cached.data))
+	f.order.Remove(element)
+	delete(f.entries, key)
+}
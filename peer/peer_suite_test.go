@@ -0,0 +1,360 @@
+package peer_test
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	"github.com/jtarchie/sqlitezstd/peer"
+	"github.com/jtarchie/sqlitezstd/testhelper"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestPeer(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Peer Suite")
+}
+
+// instances starts count pools, each served by its own server, listing
+// every one of them as peers.
+func instances(count int) []*peer.Pool {
+	pools := make([]*peer.Pool, 0, count)
+	peers := make([]string, 0, count)
+
+	for range count {
+		mux := http.NewServeMux()
+		server := httptest.NewServer(mux)
+		DeferCleanup(server.Close)
+
+		pool := &peer.Pool{Self: server.URL}
+		mux.Handle(peer.Path, pool)
+
+		pools = append(pools, pool)
+		peers = append(peers, server.URL)
+	}
+
+	for _, pool := range pools {
+		pool.Set(peers...)
+	}
+
+	return pools
+}
+
+// slowOrigin serves the files of dir, taking delay to answer each range
+// request, which it counts.
+func slowOrigin(dir string, delay time.Duration) (string, *atomic.Int64) {
+	var ranges atomic.Int64
+
+	files := http.FileServer(http.Dir(dir))
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			ranges.Add(1)
+			time.Sleep(delay)
+		}
+
+		files.ServeHTTP(w, r)
+	}))
+	DeferCleanup(origin.Close)
+
+	return origin.URL, &ranges
+}
+
+func sumBodies(dsn string) int64 {
+	client, err := sql.Open("sqlite3", dsn)
+	Expect(err).ToNot(HaveOccurred())
+	defer client.Close()
+
+	var length int64
+	Expect(client.QueryRow("SELECT SUM(LENGTH(body)) FROM entries;").Scan(&length)).To(Succeed())
+
+	return length
+}
+
+// keys is a Cache keeping every frame put, to learn their keys.
+type keys struct {
+	mutex  sync.Mutex
+	frames map[string][]byte
+}
+
+func (k *keys) Get(key string) ([]byte, bool) {
+	return nil, false
+}
+
+func (k *keys) Put(key string, data []byte) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.frames == nil {
+		k.frames = map[string][]byte{}
+	}
+
+	k.frames[key] = data
+}
+
+func (k *keys) Remove(key string) {}
+
+// frameOf returns the key and contents of a frame of archive, read with the
+// VFS registered as name.
+func frameOf(name string, archive string) (string, []byte) {
+	kept := &keys{}
+
+	Expect(sqlitezstd.Register(name, &sqlitezstd.ZstdVFS{Cache: kept})).To(Succeed())
+	Expect(sumBodies(fmt.Sprintf("file:%s?vfs=%s", archive, name))).To(BeEquivalentTo(1000 * 64))
+
+	for key, data := range kept.frames {
+		return key, data
+	}
+
+	Fail("no frame was read")
+
+	return "", nil
+}
+
+// get sends a GET for key to pool with the secret, returning the status.
+func get(pool *peer.Pool, key string, query string, secret string) int {
+	request, err := http.NewRequest(http.MethodGet, pool.Self+peer.Path+key+query, nil)
+	Expect(err).ToNot(HaveOccurred())
+
+	if secret != "" {
+		request.Header.Set(peer.SecretHeader, secret)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(response.Body.Close()).To(Succeed())
+
+	return response.StatusCode
+}
+
+var _ = Describe("Pool", func() {
+	It("fetches every frame from the origin once across the fleet", func() {
+		zstPath := testhelper.CreateEntriesDB(GinkgoT(), 1000, sqlitezstd.WithFrameSize(4096))
+
+		var ranges atomic.Int64
+
+		files := http.FileServer(http.Dir(filepath.Dir(zstPath)))
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Range") != "" {
+				ranges.Add(1)
+			}
+
+			// every instance reads its own copy, at /<instance>/<archive>
+			_, r.URL.Path, _ = strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+			r.URL.Path = "/" + r.URL.Path
+
+			files.ServeHTTP(w, r)
+		}))
+		DeferCleanup(origin.Close)
+
+		archiveURL := func(instance string) string {
+			return fmt.Sprintf("%s/%s/%s", origin.URL, instance, filepath.Base(zstPath))
+		}
+
+		Expect(sqlitezstd.Register("zstd-peer-alone", &sqlitezstd.ZstdVFS{})).To(Succeed())
+		Expect(sumBodies(fmt.Sprintf("file:%s?vfs=zstd-peer-alone&range_size=-1", archiveURL("alone")))).To(BeEquivalentTo(1000 * 64))
+
+		alone := ranges.Load()
+
+		pools := instances(3)
+		for index, pool := range pools {
+			pool.Archives = []string{archiveURL(fmt.Sprintf("zstd-peer-%d", index))}
+		}
+
+		for index, pool := range pools {
+			ranges.Store(0)
+
+			name := fmt.Sprintf("zstd-peer-%d", index)
+			Expect(sqlitezstd.Register(name, &sqlitezstd.ZstdVFS{Cache: pool, DiskCacheByContent: true})).To(Succeed())
+			Expect(sumBodies(fmt.Sprintf("file:%s?vfs=%s&range_size=-1", archiveURL(name), name))).To(BeEquivalentTo(1000 * 64))
+
+			if index == 0 {
+				Expect(ranges.Load()).To(Equal(alone))
+
+				continue
+			}
+
+			// the others only open their copy, finding its frames in the peers
+			Expect(ranges.Load()).To(BeNumerically("<", alone/4))
+		}
+	})
+
+	It("has the owner fetch a frame once while the fleet waits for it", func() {
+		zstPath := testhelper.CreateEntriesDB(GinkgoT(), 1000, sqlitezstd.WithFrameSize(4096))
+		origin, ranges := slowOrigin(filepath.Dir(zstPath), 50*time.Millisecond)
+		archive := origin + "/" + filepath.Base(zstPath)
+
+		key, expected := frameOf("zstd-peer-fetch-once", archive)
+
+		ranges.Store(0)
+
+		pools := instances(2)
+		found := make(chan []byte, 6)
+
+		for _, pool := range append(pools, pools...) {
+			go func() {
+				data, _ := pool.Load(key, archive)
+				found <- data
+			}()
+		}
+
+		for range 4 {
+			Eventually(found).Should(Receive(Equal(expected)))
+		}
+
+		Expect(ranges.Load()).To(BeEquivalentTo(1))
+
+		for _, pool := range pools {
+			data, ok := pool.Get(key)
+			Expect(ok).To(BeTrue())
+			Expect(data).To(Equal(expected))
+		}
+
+		// the owner fetches a removed frame again
+		pools[0].Remove(key)
+
+		_, ok := pools[1].Get(key)
+		Expect(ok).To(BeTrue())
+		Expect(ranges.Load()).To(BeEquivalentTo(2))
+	})
+
+	It("misses a frame fetched for longer than the fill timeout", func() {
+		zstPath := testhelper.CreateEntriesDB(GinkgoT(), 1000, sqlitezstd.WithFrameSize(4096))
+		origin, _ := slowOrigin(filepath.Dir(zstPath), 200*time.Millisecond)
+		archive := origin + "/" + filepath.Base(zstPath)
+
+		key, _ := frameOf("zstd-peer-fill-timeout", archive)
+
+		pool := instances(1)[0]
+		pool.FillTimeout = 50 * time.Millisecond
+
+		started := time.Now()
+		_, ok := pool.Load(key, archive)
+		Expect(ok).To(BeFalse())
+		Expect(time.Since(started)).To(BeNumerically("<", 200*time.Millisecond))
+
+		// the frame is kept once fetched
+		Eventually(func() bool {
+			_, ok := pool.Get(key)
+
+			return ok
+		}).Should(BeTrue())
+	})
+
+	It("does not take frames from the peers", func() {
+		owner := instances(1)[0]
+		other := &peer.Pool{Self: "http://127.0.0.1:1", Peers: []string{owner.Self}}
+
+		// only the owner keeps the frames it decompressed itself
+		other.Put("frame-1.frame", []byte("frame"))
+
+		_, ok := owner.Get("frame-1.frame")
+		Expect(ok).To(BeFalse())
+
+		request, err := http.NewRequest(http.MethodPut, owner.Self+peer.Path+"frame-1.frame", strings.NewReader("frame"))
+		Expect(err).ToNot(HaveOccurred())
+
+		response, err := http.DefaultClient.Do(request)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.Body.Close()).To(Succeed())
+		Expect(response.StatusCode).To(Equal(http.StatusMethodNotAllowed))
+
+		_, ok = other.Get("frame-1.frame")
+		Expect(ok).To(BeFalse())
+
+		owner.Put("frame-1.frame", []byte("frame"))
+
+		data, ok := other.Get("frame-1.frame")
+		Expect(ok).To(BeTrue())
+		Expect(data).To(Equal([]byte("frame")))
+	})
+
+	It("only reads the archives it opened or is given", func() {
+		zstPath := testhelper.CreateEntriesDB(GinkgoT(), 1000, sqlitezstd.WithFrameSize(4096))
+		origin, ranges := slowOrigin(filepath.Dir(zstPath), 0)
+		archive := origin + "/" + filepath.Base(zstPath)
+
+		otherPath := testhelper.CreateEntriesDB(GinkgoT(), 1000, sqlitezstd.WithFrameSize(4096))
+		otherOrigin, otherRanges := slowOrigin(filepath.Dir(otherPath), 0)
+		other := otherOrigin + "/" + filepath.Base(otherPath)
+
+		key, expected := frameOf("zstd-peer-allowed", archive)
+		otherKey, _ := frameOf("zstd-peer-not-allowed", other)
+
+		ranges.Store(0)
+		otherRanges.Store(0)
+
+		owner := instances(1)[0]
+
+		// the archive is never taken from the peers
+		Expect(get(owner, key, "?archive="+url.QueryEscape(archive)+"&frame=1", "")).To(Equal(http.StatusNotFound))
+		Expect(ranges.Load()).To(BeZero())
+
+		owner.Archives = []string{archive}
+
+		Expect(get(owner, otherKey, "", "")).To(Equal(http.StatusNotFound))
+		Expect(get(owner, otherKey, "?archive="+url.QueryEscape(other), "")).To(Equal(http.StatusNotFound))
+		Expect(otherRanges.Load()).To(BeZero())
+
+		Expect(get(owner, "frame-1.frame", "", "")).To(Equal(http.StatusNotFound))
+
+		data, ok := owner.Get(key)
+		Expect(ok).To(BeTrue())
+		Expect(data).To(Equal(expected))
+	})
+
+	It("only answers the peers sharing its secret", func() {
+		zstPath := testhelper.CreateEntriesDB(GinkgoT(), 1000, sqlitezstd.WithFrameSize(4096))
+		origin, _ := slowOrigin(filepath.Dir(zstPath), 0)
+		archive := origin + "/" + filepath.Base(zstPath)
+
+		key, expected := frameOf("zstd-peer-secret", archive)
+
+		pools := instances(2)
+		for _, pool := range pools {
+			pool.Archives = []string{archive}
+			pool.Secret = "shared"
+		}
+
+		for _, pool := range pools {
+			Expect(get(pool, key, "", "")).To(Equal(http.StatusForbidden))
+			Expect(get(pool, key, "", "wrong")).To(Equal(http.StatusForbidden))
+		}
+
+		for _, pool := range pools {
+			data, ok := pool.Get(key)
+			Expect(ok).To(BeTrue())
+			Expect(data).To(Equal(expected))
+		}
+
+		pools[1].Secret = "other"
+
+		// only the owner of the frame finds it
+		_, ok := pools[0].Get(key)
+		_, otherOK := pools[1].Get(key)
+		Expect([]bool{ok, otherOK}).To(ConsistOf(true, false))
+	})
+
+	It("misses when a peer is unreachable", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+
+		closed := "http://" + listener.Addr().String()
+		Expect(listener.Close()).To(Succeed())
+
+		pool := &peer.Pool{Self: "http://127.0.0.1:1", Peers: []string{closed}}
+
+		_, ok := pool.Load("frame-1.frame", closed+"/db.sqlite.zst")
+		Expect(ok).To(BeFalse())
+	})
+})
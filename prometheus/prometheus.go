@@ -0,0 +1,134 @@
+// Package prometheus collects the IO of sqlitezstd archives as Prometheus
+// metrics, with an archive label, so compressed databases can be
+// dashboarded alongside the rest of a service:
+//
+//	backend := &sqlitezstd.HTTPBackend{}
+//	sqlitezstd.RegisterBackend("https", backend)
+//
+//	prometheus.MustRegister(&sqlitezstdprometheus.Collector{Backends: []*sqlitezstd.HTTPBackend{backend}})
+//
+// Every archive opened by a VFS is collected, from sqlitezstd.AllStats,
+// along with the range requests of Backends, from their Stats. Metrics
+// are gathered when scraped, so the collector holds no state.
+package prometheus
+
+import (
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector collects the metrics of every archive, and of the range
+// requests of Backends. The zero value collects archives only.
+type Collector struct {
+	// Backends are the HTTP backends whose range requests are collected.
+	Backends []*sqlitezstd.HTTPBackend
+	// Namespace prefixes every metric name, "sqlitezstd" by default.
+	Namespace string
+}
+
+var _ prometheus.Collector = &Collector{}
+
+// archiveMetric is a metric with a value for every archive.
+type archiveMetric struct {
+	desc  *prometheus.Desc
+	kind  prometheus.ValueType
+	value func(sqlitezstd.ArchiveStats) float64
+}
+
+// httpMetric is a counter with a value for every archive requested.
+type httpMetric struct {
+	desc  *prometheus.Desc
+	value func(sqlitezstd.HTTPStats) int64
+}
+
+// Describe sends the descriptions of every metric collected.
+func (c *Collector) Describe(descs chan<- *prometheus.Desc) {
+	archives, requests, duration := c.metrics()
+
+	for _, metric := range archives {
+		descs <- metric.desc
+	}
+
+	for _, metric := range requests {
+		descs <- metric.desc
+	}
+
+	descs <- duration
+}
+
+// Collect sends the metrics of every archive, and the range requests of
+// every backend, adding up those of an archive opened with more than one.
+func (c *Collector) Collect(metrics chan<- prometheus.Metric) {
+	archives, requests, duration := c.metrics()
+
+	for archive, stats := range sqlitezstd.AllStats() {
+		for _, metric := range archives {
+			metrics <- prometheus.MustNewConstMetric(metric.desc, metric.kind, metric.value(stats), archive)
+		}
+	}
+
+	if len(c.Backends) == 0 {
+		return
+	}
+
+	requested := map[string]sqlitezstd.HTTPStats{}
+
+	for _, backend := range c.Backends {
+		for archive, stats := range backend.Stats() {
+			requested[archive] = requested[archive].Add(stats)
+		}
+	}
+
+	bounds := sqlitezstd.LatencyBuckets()
+
+	for archive, stats := range requested {
+		for _, metric := range requests {
+			metrics <- prometheus.MustNewConstMetric(metric.desc, prometheus.CounterValue, float64(metric.value(stats)), archive)
+		}
+
+		// buckets are cumulative, the last being every request
+		buckets := make(map[float64]uint64, len(bounds))
+
+		var count int64
+
+		for index, bound := range bounds {
+			count += stats.Latency[index]
+			buckets[bound.Seconds()] = uint64(count)
+		}
+
+		metrics <- prometheus.MustNewConstHistogram(duration, uint64(stats.Requests), stats.TotalLatency.Seconds(), buckets, archive)
+	}
+}
+
+// metrics describes the metrics of archives, of range requests, and the
+// histogram of how long they took.
+func (c *Collector) metrics() ([]archiveMetric, []httpMetric, *prometheus.Desc) {
+	namespace := c.Namespace
+	if namespace == "" {
+		namespace = "sqlitezstd"
+	}
+
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, []string{"archive"}, nil)
+	}
+
+	archives := []archiveMetric{
+		{desc("reads_total", "Reads SQLite made from the archive."), prometheus.CounterValue, func(s sqlitezstd.ArchiveStats) float64 { return float64(s.Reads) }},
+		{desc("read_bytes_total", "Uncompressed bytes returned to SQLite."), prometheus.CounterValue, func(s sqlitezstd.ArchiveStats) float64 { return float64(s.BytesRead) }},
+		{desc("cache_hits_total", "Reads served from the frame caches, without reading the archive."), prometheus.CounterValue, func(s sqlitezstd.ArchiveStats) float64 { return float64(s.Hits) }},
+		{desc("fetched_bytes_total", "Compressed bytes read from the archive."), prometheus.CounterValue, func(s sqlitezstd.ArchiveStats) float64 { return float64(s.BytesFetched) }},
+		{desc("fetch_seconds_total", "Time spent reading from the archive."), prometheus.CounterValue, func(s sqlitezstd.ArchiveStats) float64 { return s.FetchTime.Seconds() }},
+		{desc("decompressed_bytes_total", "Bytes of the frames decompressed."), prometheus.CounterValue, func(s sqlitezstd.ArchiveStats) float64 { return float64(s.BytesDecompressed) }},
+		{desc("frames_decoded_total", "Frames decompressed."), prometheus.CounterValue, func(s sqlitezstd.ArchiveStats) float64 { return float64(s.FramesDecoded) }},
+		{desc("decode_seconds_total", "Time spent decompressing frames."), prometheus.CounterValue, func(s sqlitezstd.ArchiveStats) float64 { return s.DecodeTime.Seconds() }},
+		{desc("connections", "Connections that have the archive open."), prometheus.GaugeValue, func(s sqlitezstd.ArchiveStats) float64 { return float64(s.Connections) }},
+	}
+
+	requests := []httpMetric{
+		{desc("http_requests_total", "Range requests made, hedges included."), func(s sqlitezstd.HTTPStats) int64 { return s.Requests }},
+		{desc("http_failures_total", "Range requests that failed."), func(s sqlitezstd.HTTPStats) int64 { return s.Failures }},
+		{desc("http_transferred_bytes_total", "Compressed bytes received."), func(s sqlitezstd.HTTPStats) int64 { return s.BytesTransferred }},
+	}
+
+	return archives, requests, desc("http_request_duration_seconds", "Time range requests took.")
+}
@@ -0,0 +1,140 @@
+package prometheus_test
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	sqlitezstdprometheus "github.com/jtarchie/sqlitezstd/prometheus"
+	"github.com/jtarchie/sqlitezstd/testhelper"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestPrometheus(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Prometheus Suite")
+}
+
+func sumBodies(dsn string) int64 {
+	client, err := sql.Open("sqlite3", dsn)
+	Expect(err).ToNot(HaveOccurred())
+	defer client.Close()
+
+	var length int64
+	Expect(client.QueryRow("SELECT SUM(LENGTH(body)) FROM entries;").Scan(&length)).To(Succeed())
+
+	return length
+}
+
+// gather collects the metrics of collector with a registry of its own,
+// as a service would.
+func gather(collector *sqlitezstdprometheus.Collector) map[string]*dto.MetricFamily {
+	registry := prometheus.NewPedanticRegistry()
+	Expect(registry.Register(collector)).To(Succeed())
+
+	gathered, err := registry.Gather()
+	Expect(err).ToNot(HaveOccurred())
+
+	families := map[string]*dto.MetricFamily{}
+	for _, family := range gathered {
+		families[family.GetName()] = family
+	}
+
+	return families
+}
+
+// sample returns the metric named with the archive label in families.
+func sample(families map[string]*dto.MetricFamily, name, archive string) *dto.Metric {
+	family, ok := families[name]
+	Expect(ok).To(BeTrue(), "no metric %s", name)
+
+	for _, metric := range family.GetMetric() {
+		for _, label := range metric.GetLabel() {
+			if label.GetName() == "archive" && label.GetValue() == archive {
+				return metric
+			}
+		}
+	}
+
+	Fail(fmt.Sprintf("no metric %s for %s", name, archive))
+
+	return nil
+}
+
+var _ = Describe("Collector", func() {
+	var zstPath string
+
+	BeforeEach(func() {
+		zstPath = testhelper.CreateEntriesDB(GinkgoT(), 1000, sqlitezstd.WithFrameSize(4096))
+	})
+
+	It("collects the IO of every archive with its name", func() {
+		Expect(sqlitezstd.Register("zstd-prometheus-local", &sqlitezstd.ZstdVFS{})).To(Succeed())
+		Expect(sumBodies(fmt.Sprintf("file:%s?vfs=zstd-prometheus-local", zstPath))).To(BeEquivalentTo(1000 * 64))
+
+		families := gather(&sqlitezstdprometheus.Collector{})
+
+		Expect(families["sqlitezstd_reads_total"].GetType()).To(Equal(dto.MetricType_COUNTER))
+		Expect(families["sqlitezstd_connections"].GetType()).To(Equal(dto.MetricType_GAUGE))
+		Expect(sample(families, "sqlitezstd_reads_total", zstPath).GetCounter().GetValue()).To(BeNumerically(">", 0))
+		Expect(sample(families, "sqlitezstd_read_bytes_total", zstPath).GetCounter().GetValue()).To(BeNumerically(">=", 1000*64))
+		Expect(sample(families, "sqlitezstd_frames_decoded_total", zstPath).GetCounter().GetValue()).To(BeNumerically(">", 0))
+		Expect(sample(families, "sqlitezstd_decode_seconds_total", zstPath).GetCounter().GetValue()).To(BeNumerically(">", 0))
+		Expect(sample(families, "sqlitezstd_connections", zstPath).GetGauge().GetValue()).To(BeZero())
+
+		// archives opened with no backend have no range requests
+		Expect(families).ToNot(HaveKey("sqlitezstd_http_requests_total"))
+
+		custom := gather(&sqlitezstdprometheus.Collector{Namespace: "app_db"})
+		Expect(sample(custom, "app_db_reads_total", zstPath).GetCounter().GetValue()).To(BeNumerically(">", 0))
+	})
+
+	It("collects the range requests of backends as a histogram", func() {
+		files := http.FileServer(http.Dir(filepath.Dir(zstPath)))
+		origin := httptest.NewServer(files)
+		DeferCleanup(origin.Close)
+
+		backend := &sqlitezstd.HTTPBackend{}
+		sqlitezstd.RegisterBackend("http", backend)
+		DeferCleanup(sqlitezstd.RegisterBackend, "http", &sqlitezstd.HTTPBackend{})
+
+		archiveURL := fmt.Sprintf("%s/%s", origin.URL, filepath.Base(zstPath))
+
+		Expect(sqlitezstd.Register("zstd-prometheus-remote", &sqlitezstd.ZstdVFS{})).To(Succeed())
+		Expect(sumBodies(fmt.Sprintf("file:%s?vfs=zstd-prometheus-remote", archiveURL))).To(BeEquivalentTo(1000 * 64))
+
+		families := gather(&sqlitezstdprometheus.Collector{Backends: []*sqlitezstd.HTTPBackend{backend}})
+
+		requests := sample(families, "sqlitezstd_http_requests_total", archiveURL).GetCounter().GetValue()
+		Expect(requests).To(BeNumerically(">", 0))
+		Expect(sample(families, "sqlitezstd_http_failures_total", archiveURL).GetCounter().GetValue()).To(BeZero())
+		Expect(sample(families, "sqlitezstd_http_transferred_bytes_total", archiveURL).GetCounter().GetValue()).To(BeNumerically(">", 0))
+		Expect(sample(families, "sqlitezstd_fetched_bytes_total", archiveURL).GetCounter().GetValue()).To(BeNumerically(">", 0))
+		Expect(sample(families, "sqlitezstd_fetch_seconds_total", archiveURL).GetCounter().GetValue()).To(BeNumerically(">", 0))
+
+		histogram := sample(families, "sqlitezstd_http_request_duration_seconds", archiveURL).GetHistogram()
+		Expect(families["sqlitezstd_http_request_duration_seconds"].GetType()).To(Equal(dto.MetricType_HISTOGRAM))
+		Expect(histogram.GetSampleCount()).To(BeEquivalentTo(requests))
+		Expect(histogram.GetBucket()).To(HaveLen(len(sqlitezstd.LatencyBuckets())))
+
+		last := histogram.GetBucket()[len(histogram.GetBucket())-1]
+		Expect(last.GetUpperBound()).To(BeEquivalentTo(10))
+		Expect(last.GetCumulativeCount()).To(BeEquivalentTo(requests))
+	})
+
+	It("registers with a service's registry", func() {
+		registry := prometheus.NewRegistry()
+		Expect(registry.Register(&sqlitezstdprometheus.Collector{})).To(Succeed())
+
+		// the same metrics cannot be collected twice
+		Expect(registry.Register(&sqlitezstdprometheus.Collector{})).ToNot(Succeed())
+		Expect(registry.Register(&sqlitezstdprometheus.Collector{Namespace: "other"})).To(Succeed())
+	})
+})
@@ -0,0 +1,212 @@
+// Package rclone reads sqlitezstd archives from any of the remotes configured
+// in rclone, through the remote control API of a running rclone rcd. Importing
+// it registers a backend for rclone://remote/path names:
+//
+//	import _ "github.com/jtarchie/sqlitezstd/rclone"
+//
+//	db, err := sql.Open("sqlite3", "rclone://gdrive/datasets/db.sqlite.zst?vfs=zstd")
+//
+// The daemon must serve objects, as started with
+//
+//	rclone rcd --rc-serve --rc-user user --rc-pass pass
+//
+// Its address is read from RCLONE_RC_URL, defaulting to
+// http://127.0.0.1:5572, and can be set per name with the rc query
+// parameter. Credentials are given in the user info of the address.
+package rclone
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+)
+
+const defaultRC = "http://127.0.0.1:5572"
+
+var (
+	ErrInvalidURL         = errors.New("invalid rclone url")
+	ErrNotFound           = errors.New("object not found in rclone remote")
+	ErrUnexpectedResponse = errors.New("unexpected rclone response")
+)
+
+//nolint: gochecknoinits
+func init() {
+	sqlitezstd.RegisterBackend("rclone", &Backend{})
+}
+
+// Backend opens rclone://remote/path names through an rclone daemon.
+type Backend struct {
+	// RC is the address of the remote control API. If empty,
+	// RCLONE_RC_URL is used, and then http://127.0.0.1:5572.
+	RC string
+	// Client makes every request, defaulting to http.DefaultClient.
+	Client *http.Client
+}
+
+var _ sqlitezstd.Backend = &Backend{}
+
+func (b *Backend) Open(uri *url.URL) (sqlitezstd.Object, error) {
+	remote := strings.TrimPrefix(uri.Path, "/")
+
+	if uri.Host == "" || remote == "" {
+		return nil, fmt.Errorf("%w: %s needs a remote and a path", ErrInvalidURL, uri.Redacted())
+	}
+
+	address := b.RC
+	if query := uri.Query(); query.Has("rc") {
+		address = query.Get("rc")
+	}
+
+	if address == "" {
+		address = os.Getenv("RCLONE_RC_URL")
+	}
+
+	if address == "" {
+		address = defaultRC
+	}
+
+	rc, err := url.Parse(strings.TrimSuffix(address, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: rc address: %w", ErrInvalidURL, err)
+	}
+
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	target := &object{
+		client: client,
+		fs:     uri.Host + ":",
+		remote: remote,
+		user:   rc.User,
+	}
+
+	rc.User = nil
+	target.rc = rc.String()
+
+	target.size, err = target.stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return target, nil
+}
+
+// object is a file in an rclone remote, read through the daemon.
+type object struct {
+	client *http.Client
+	rc     string
+	user   *url.Userinfo
+	fs     string
+	remote string
+	size   int64
+}
+
+func (o *object) authorize(request *http.Request) {
+	if o.user == nil {
+		return
+	}
+
+	password, _ := o.user.Password()
+	request.SetBasicAuth(o.user.Username(), password)
+}
+
+func (o *object) stat() (int64, error) {
+	body, err := json.Marshal(map[string]string{"fs": o.fs, "remote": o.remote})
+	if err != nil {
+		return 0, fmt.Errorf("could not stat %s%s: %w", o.fs, o.remote, err)
+	}
+
+	request, err := http.NewRequest(http.MethodPost, o.rc+"/operations/stat", bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("could not stat %s%s: %w", o.fs, o.remote, err)
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	o.authorize(request)
+
+	response, err := o.client.Do(request)
+	if err != nil {
+		return 0, fmt.Errorf("could not stat %s%s: %w", o.fs, o.remote, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%w: could not stat %s%s: %s", ErrUnexpectedResponse, o.fs, o.remote, response.Status)
+	}
+
+	var stat struct {
+		Item *struct {
+			Size  int64
+			IsDir bool
+		} `json:"item"`
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&stat)
+	if err != nil {
+		return 0, fmt.Errorf("could not decode stat: %w", err)
+	}
+
+	if stat.Item == nil || stat.Item.IsDir {
+		return 0, fmt.Errorf("%w: %s%s", ErrNotFound, o.fs, o.remote)
+	}
+
+	return stat.Item.Size, nil
+}
+
+func (o *object) ReadAt(p []byte, off int64) (int, error) {
+	if off >= o.size {
+		return 0, io.EOF
+	}
+
+	end := min(off+int64(len(p)), o.size)
+
+	// objects are served at /[remote:]/path by rcd --rc-serve
+	location := fmt.Sprintf("%s/[%s]/%s", o.rc, url.PathEscape(o.fs), (&url.URL{Path: o.remote}).EscapedPath())
+
+	request, err := http.NewRequest(http.MethodGet, location, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not read %s%s: %w", o.fs, o.remote, err)
+	}
+
+	request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, end-1))
+	o.authorize(request)
+
+	response, err := o.client.Do(request)
+	if err != nil {
+		return 0, fmt.Errorf("could not read %s%s: %w", o.fs, o.remote, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("%w: could not read %s%s: %s", ErrUnexpectedResponse, o.fs, o.remote, response.Status)
+	}
+
+	count, err := io.ReadFull(response.Body, p[:end-off])
+	if err != nil {
+		return count, fmt.Errorf("could not read %s%s: %w", o.fs, o.remote, err)
+	}
+
+	if count < len(p) {
+		return count, io.EOF
+	}
+
+	return count, nil
+}
+
+func (o *object) Size() int64 {
+	return o.size
+}
+
+func (o *object) Close() error {
+	return nil
+}
@@ -0,0 +1,130 @@
+package rclone_test
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	"github.com/jtarchie/sqlitezstd/rclone"
+	"github.com/jtarchie/sqlitezstd/testhelper"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestRclone(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Rclone Suite")
+}
+
+// daemon is a fake rclone rcd --rc-serve, holding files by remote and path.
+type daemon map[string][]byte
+
+func (d daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, pass, ok := r.BasicAuth()
+	if !ok || user != "user" || pass != "pass" {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	if r.URL.Path == "/operations/stat" {
+		var request struct {
+			Fs     string `json:"fs"`
+			Remote string `json:"remote"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil || r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		contents, ok := d[request.Fs+request.Remote]
+		if !ok {
+			_ = json.NewEncoder(w).Encode(map[string]any{"item": nil})
+
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]any{"item": map[string]any{"Size": len(contents), "IsDir": false}})
+
+		return
+	}
+
+	// objects are served at /[remote:]/path
+	remote, path, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/["), "]/")
+
+	contents, ok := d[remote+path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(contents))
+}
+
+func countEntries(dsn string) (int64, error) {
+	client, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	var count int64
+	err = client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)
+
+	return count, err
+}
+
+var _ = Describe("Backend", func() {
+	var rc string
+
+	BeforeEach(func() {
+		Expect(sqlitezstd.Init()).To(Succeed())
+
+		contents, err := os.ReadFile(testhelper.CreateEntriesDB(GinkgoT(), 1000))
+		Expect(err).ToNot(HaveOccurred())
+
+		server := httptest.NewServer(daemon{"gdrive:datasets/db.zst": contents})
+		DeferCleanup(server.Close)
+
+		rc = strings.Replace(server.URL, "http://", "http://user:pass@", 1)
+	})
+
+	It("reads archives through the daemon", func() {
+		sqlitezstd.RegisterBackend("rclone", &rclone.Backend{RC: rc})
+
+		count, err := countEntries("rclone://gdrive/datasets/db.zst?vfs=zstd")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+	})
+
+	It("reads the address of the daemon from the query", func() {
+		sqlitezstd.RegisterBackend("rclone", &rclone.Backend{RC: "http://127.0.0.1:1"})
+
+		count, err := countEntries("file:rclone://gdrive/datasets/db.zst?vfs=zstd&rc=" + rc)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+	})
+
+	It("errors for missing objects and wrong credentials", func() {
+		sqlitezstd.RegisterBackend("rclone", &rclone.Backend{RC: rc})
+
+		_, err := sqlitezstd.Inspect("rclone://gdrive/datasets/missing.zst")
+		Expect(err).To(MatchError(rclone.ErrNotFound))
+
+		sqlitezstd.RegisterBackend("rclone", &rclone.Backend{RC: strings.Replace(rc, "pass@", "wrong@", 1)})
+
+		_, err = sqlitezstd.Inspect("rclone://gdrive/datasets/db.zst")
+		Expect(err).To(MatchError(rclone.ErrUnexpectedResponse))
+	})
+})
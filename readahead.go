@@ -0,0 +1,241 @@
+package sqlitezstd
+
+import (
+	"encoding/binary"
+	"io"
+	"slices"
+	"sync"
+)
+
+const (
+	// sequentialReads is how many reads in a row, each starting where
+	// the last one ended, make a sequential scan.
+	sequentialReads = 3
+	// defaultReadahead is how many frames adaptive readahead reads ahead
+	// when no number is given.
+	defaultReadahead = 4
+)
+
+// B-tree page types with child pages, and the size of their header.
+const (
+	interiorIndexPage  = 0x02
+	interiorTablePage  = 0x05
+	interiorHeaderSize = 12
+)
+
+// readahead decompresses the frames SQLite is about to read in the
+// background, so they are ready by the time it reads them: the frames
+// after a sequential scan, such as a full table scan, and, when it is
+// adaptive, the frames of the next siblings of B-tree pages read in
+// order, such as a scan of a table whose pages are not contiguous.
+// Other reads, such as point lookups, read nothing ahead.
+type readahead struct {
+	frames   int
+	adaptive bool
+	reader   io.ReaderAt
+	table    *seekTable
+	decoding decoderOptions
+	// metrics, if set, counts the bytes decompressed ahead, as do
+	// counters for the archive.
+	metrics  *cacheMetrics
+	counters *archiveCounters
+
+	mutex sync.Mutex
+	// next is where the next read of the scan starts, and run how many
+	// reads in a row the scan has made.
+	next int64
+	run  int
+	// pageSize is learnt from the database header. children are the
+	// pages below the interior page read last, and sibling the position
+	// of the child read last among them.
+	pageSize int64
+	children []int64
+	sibling  int
+	// ahead holds the frames being, or already, decompressed.
+	ahead   map[int]*aheadFrame
+	pending sync.WaitGroup
+}
+
+type aheadFrame struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func newReadahead(frames int, adaptive bool, reader io.ReaderAt, table *seekTable, decoding decoderOptions) *readahead {
+	if frames == 0 {
+		frames = defaultReadahead
+	}
+
+	return &readahead{
+		frames:   frames,
+		adaptive: adaptive,
+		reader:   reader,
+		table:    table,
+		decoding: decoding,
+		sibling:  -1,
+		ahead:    map[int]*aheadFrame{},
+	}
+}
+
+// observe records that p was read at off, and starts decompressing the
+// frames the reads so far show SQLite is about to read.
+func (r *readahead) observe(off int64, p []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	index := r.table.frameFor(off)
+
+	if off == r.next {
+		r.run++
+	} else {
+		r.run = 0
+	}
+
+	r.next = off + int64(len(p))
+
+	// frames the reads have gone past are not read again
+	for ahead := range r.ahead {
+		if ahead < index {
+			delete(r.ahead, ahead)
+		}
+	}
+
+	if r.run >= sequentialReads {
+		for ahead := index + 1; ahead <= index+r.frames && ahead < len(r.table.frames); ahead++ {
+			r.start(ahead)
+		}
+
+		return
+	}
+
+	if r.adaptive {
+		r.siblings(off, p, index)
+	}
+}
+
+// siblings follows the B-tree pages read: once two children of the same
+// interior page are read in order, the frames of the next ones are read
+// ahead.
+func (r *readahead) siblings(off int64, p []byte, index int) {
+	if off == 0 && len(p) >= sqliteHeaderSize {
+		pageSize, err := parsePageSize(p)
+		if err == nil {
+			r.pageSize = int64(pageSize)
+		}
+	}
+
+	if r.pageSize == 0 || int64(len(p)) != r.pageSize || off%r.pageSize != 0 {
+		return
+	}
+
+	page := off/r.pageSize + 1
+
+	if position := slices.Index(r.children, page); position >= 0 {
+		if r.sibling >= 0 && position == r.sibling+1 {
+			started := 0
+
+			for _, child := range r.children[position+1:] {
+				ahead := r.table.frameFor((child - 1) * r.pageSize)
+				if ahead != index && r.start(ahead) {
+					started++
+				}
+
+				if started == r.frames {
+					break
+				}
+			}
+		}
+
+		r.sibling = position
+	}
+
+	if children, ok := childPages(p, page); ok {
+		r.children, r.sibling = children, -1
+	}
+}
+
+// start decompresses frame index in the background, unless it already
+// is, and reports whether it started.
+func (r *readahead) start(index int) bool {
+	if _, ok := r.ahead[index]; ok || index < 0 || index >= len(r.table.frames) || r.table.frames[index].decompressedSize == 0 {
+		return false
+	}
+
+	frame := &aheadFrame{done: make(chan struct{})}
+	r.ahead[index] = frame
+
+	r.pending.Add(1)
+
+	go func() {
+		defer r.pending.Done()
+		defer close(frame.done)
+
+		// the frame is kept once taken, so it has a buffer of its own
+		info := r.table.frames[index]
+		frame.data, frame.err = decompressFrame(r.reader, r.decoding, info, r.table.checksums, make([]byte, 0, info.decompressedSize), r.counters)
+		if frame.err == nil {
+			r.metrics.decompressed(len(frame.data))
+		}
+	}()
+
+	return true
+}
+
+// take returns frame index once it is decompressed, if it was read ahead.
+func (r *readahead) take(index int) ([]byte, bool) {
+	r.mutex.Lock()
+	frame, ok := r.ahead[index]
+	delete(r.ahead, index)
+	r.mutex.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	<-frame.done
+
+	return frame.data, frame.err == nil
+}
+
+// close waits for the frames being decompressed, before the archive
+// is closed.
+func (r *readahead) close() {
+	r.pending.Wait()
+}
+
+// childPages returns the child pages of an interior B-tree page, in
+// order, or false for other pages. The first page starts after the
+// database header.
+func childPages(p []byte, page int64) ([]int64, bool) {
+	header := 0
+	if page == 1 {
+		header = sqliteHeaderSize
+	}
+
+	if len(p) < header+interiorHeaderSize || (p[header] != interiorIndexPage && p[header] != interiorTablePage) {
+		return nil, false
+	}
+
+	cells := int(binary.BigEndian.Uint16(p[header+3:]))
+	children := make([]int64, 0, cells+1)
+
+	for cell := range cells {
+		pointer := header + interiorHeaderSize + 2*cell
+		if pointer+2 > len(p) {
+			return nil, false
+		}
+
+		// every cell starts with the page left of it
+		offset := int(binary.BigEndian.Uint16(p[pointer:]))
+		if offset+4 > len(p) {
+			return nil, false
+		}
+
+		children = append(children, int64(binary.BigEndian.Uint32(p[offset:])))
+	}
+
+	children = append(children, int64(binary.BigEndian.Uint32(p[header+8:])))
+
+	return children, true
+}
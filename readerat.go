@@ -0,0 +1,106 @@
+package sqlitezstd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sync"
+	"sync/atomic"
+)
+
+const readerAtScheme = "readerat"
+
+var ErrSourceClosed = errors.New("connection source is closed")
+
+// ConnectionSource is an archive read from an io.ReaderAt, such as a custom
+// storage engine, a decrypted container, or a test double. It is opened by
+// its name, like any other archive, until it is closed.
+type ConnectionSource struct {
+	name   string
+	reader io.ReaderAt
+	size   int64
+}
+
+// OpenReaderAt makes the archive of the given size in r available to the VFS,
+// under the name returned by ConnectionSource.Name. The seek table is checked
+// right away, so a reader that does not hold an archive fails here rather
+// than when queried.
+func OpenReaderAt(r io.ReaderAt, size int64) (*ConnectionSource, error) {
+	_, err := decodeSeekTable(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	registerReaderAt.Do(func() {
+		RegisterBackend(readerAtScheme, readerAtBackend{})
+	})
+
+	source := &ConnectionSource{
+		name:   fmt.Sprintf("%s://%d", readerAtScheme, readerAtIDs.Add(1)),
+		reader: r,
+		size:   size,
+	}
+
+	readerAtSourcesMutex.Lock()
+	readerAtSources[source.name] = source
+	readerAtSourcesMutex.Unlock()
+
+	return source, nil
+}
+
+// Name is the name the archive is opened by, as in
+// sql.Open("sqlite3", source.Name()+"?vfs=zstd").
+func (c *ConnectionSource) Name() string {
+	return c.name
+}
+
+// DSN is a DSN that opens the archive with the default VFS.
+func (c *ConnectionSource) DSN() string {
+	return c.name + "?vfs=zstd"
+}
+
+// Close stops the archive from being opened again. Connections
+// that are already open keep reading from the reader.
+func (c *ConnectionSource) Close() error {
+	readerAtSourcesMutex.Lock()
+	delete(readerAtSources, c.name)
+	readerAtSourcesMutex.Unlock()
+
+	return nil
+}
+
+//nolint: gochecknoglobals
+var (
+	readerAtSources      = map[string]*ConnectionSource{}
+	readerAtSourcesMutex sync.Mutex
+	readerAtIDs          atomic.Int64
+	registerReaderAt     sync.Once
+)
+
+type readerAtBackend struct{}
+
+func (readerAtBackend) Open(uri *url.URL) (Object, error) {
+	name := fmt.Sprintf("%s://%s", readerAtScheme, uri.Host)
+
+	readerAtSourcesMutex.Lock()
+	source, ok := readerAtSources[name]
+	readerAtSourcesMutex.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSourceClosed, name)
+	}
+
+	return &readerAtObject{
+		SectionReader: io.NewSectionReader(source.reader, 0, source.size),
+	}, nil
+}
+
+// readerAtObject leaves closing the reader to its owner.
+type readerAtObject struct {
+	*io.SectionReader
+}
+
+func (r *readerAtObject) Close() error {
+	return nil
+}
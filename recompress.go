@@ -0,0 +1,306 @@
+package sqlitezstd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// RecompressStats reports how much of an existing archive was reused.
+type RecompressStats struct {
+	// Reused is the number of frames copied as is from the existing archive.
+	Reused int
+	// Recompressed is the number of frames that had to be compressed again.
+	Recompressed int
+}
+
+// RecompressFile updates the archive at dst to match the SQLite database at
+// src. Frames whose pages are unchanged are copied from the existing archive
+// as is, only changed or new frames are compressed, and the seek table is
+// rewritten. If dst does not exist, the whole database is compressed.
+func RecompressFile(src, dst string, opts ...Option) (*RecompressStats, error) {
+	previous, err := openArchive(dst)
+	if errors.Is(err, os.ErrNotExist) {
+		err = CompressFile(src, dst, opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		return &RecompressStats{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not open archive: %w", err)
+	}
+	defer previous.Close()
+
+	input, err := os.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("could not open source: %w", err)
+	}
+	defer input.Close()
+
+	err = validateDatabase(input)
+	if err != nil {
+		return nil, err
+	}
+
+	inputInfo, err := input.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("could not stat source: %w", err)
+	}
+
+	return replaceArchive(input, inputInfo.Size(), previous, previous.Size(), dst, opts)
+}
+
+// replaceArchive recompresses input against the archive previous,
+// then atomically moves the result to dst.
+func replaceArchive(input io.ReaderAt, inputSize int64, previous io.ReaderAt, previousSize int64, dst string, opts []Option) (*RecompressStats, error) {
+	table, err := decodeSeekTable(previous, previousSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var stats *RecompressStats
+
+	err = createArchive(dst, opts, func(output io.Writer) error {
+		stats, err = recompress(input, inputSize, previous, table, output, opts)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+//nolint: cyclop
+func recompress(input io.ReaderAt, inputSize int64, previous io.ReaderAt, table *seekTable, output io.Writer, opts []Option) (*RecompressStats, error) {
+	prefix := make([]byte, sqliteHeaderSize)
+
+	_, err := input.ReadAt(prefix, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not read source: %w", err)
+	}
+
+	header, err := readHeader(previous)
+	if errors.Is(err, ErrNoHeader) {
+		// frames were laid out by another tool, keep its boundaries but do
+		// not claim they are aligned
+		config, err := newOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+
+		pageSize, _ := parsePageSize(prefix)
+		header = &Header{PageSize: pageSize, FrameSize: config.frameSize}
+	} else if err != nil {
+		return nil, err
+	}
+
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create decoder: %w", err)
+	}
+	defer decoder.Close()
+
+	writer, _ := NewWriter(output, append(opts, WithFrameSize(header.FrameSize))...).(*writer)
+	if writer.err != nil {
+		return nil, writer.err
+	}
+
+	err = writer.start(header)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.ContentDefined {
+		return recompressChunked(writer, input, inputSize, previous, table, decoder)
+	}
+
+	stats := &RecompressStats{}
+	dataFrames := dataFrames(table)
+
+	var offset int64
+
+	for index, frame := range dataFrames {
+		size := int64(frame.decompressedSize)
+
+		// a short final frame is grown to a full frame if the source did
+		if index == len(dataFrames)-1 && size < int64(header.FrameSize) {
+			size = int64(header.FrameSize)
+		}
+
+		current := make([]byte, size)
+
+		read, err := input.ReadAt(current, offset)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("could not read source: %w", err)
+		}
+
+		current = current[:read]
+		if len(current) == 0 {
+			break
+		}
+
+		offset += int64(read)
+
+		compressed, err := unchangedFrame(previous, decoder, table.checksums, frame, current)
+		if err != nil {
+			return nil, err
+		}
+
+		if compressed != nil {
+			err = writer.writeCompressed(compressed, frame.seekTableEntry, current)
+			if err != nil {
+				return nil, err
+			}
+
+			stats.Reused++
+
+			continue
+		}
+
+		_, err = writer.write(current)
+		if err != nil {
+			return nil, err
+		}
+
+		err = writer.flush()
+		if err != nil {
+			return nil, err
+		}
+
+		stats.Recompressed++
+	}
+
+	before := len(writer.entries) + len(writer.inflight)
+
+	_, err = io.Copy(writer, io.NewSectionReader(input, offset, inputSize-offset))
+	if err != nil {
+		return nil, fmt.Errorf("could not compress source: %w", err)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	stats.Recompressed += countData(writer.entries[before:])
+
+	return stats, nil
+}
+
+// frameKey identifies frames that may hold the same contents.
+type frameKey struct {
+	size     uint32
+	checksum uint32
+}
+
+// recompressChunked compresses all of input with content-defined frames,
+// reusing any frame of the archive whose contents turn up again, even if
+// they moved because pages were inserted or removed before them.
+func recompressChunked(writer *writer, input io.ReaderAt, inputSize int64, previous io.ReaderAt, table *seekTable, decoder *zstd.Decoder) (*RecompressStats, error) {
+	stats := &RecompressStats{}
+	candidates := map[frameKey][]frameInfo{}
+
+	// without checksums there is no cheap way to find candidates
+	if table.checksums {
+		for _, frame := range dataFrames(table) {
+			key := frameKey{frame.decompressedSize, frame.checksum}
+			candidates[key] = append(candidates[key], frame)
+		}
+	}
+
+	writer.reuse = func(raw []byte) ([]byte, seekTableEntry, bool) {
+		//nolint: gosec
+		key := frameKey{uint32(len(raw)), frameChecksum(raw)}
+
+		for _, frame := range candidates[key] {
+			compressed, err := unchangedFrame(previous, decoder, table.checksums, frame, raw)
+			if err == nil && compressed != nil {
+				stats.Reused++
+
+				return compressed, frame.seekTableEntry, true
+			}
+		}
+
+		return nil, seekTableEntry{}, false
+	}
+
+	_, err := io.Copy(writer, io.NewSectionReader(input, 0, inputSize))
+	if err != nil {
+		return nil, fmt.Errorf("could not compress source: %w", err)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	stats.Recompressed = countData(writer.entries) - stats.Reused
+
+	return stats, nil
+}
+
+// countData returns how many entries are frames with database contents.
+func countData(entries []seekTableEntry) int {
+	count := 0
+
+	for _, entry := range entries {
+		if entry.decompressedSize > 0 {
+			count++
+		}
+	}
+
+	return count
+}
+
+// dataFrames returns the frames that hold database contents,
+// skipping the header and any other skippable frames.
+func dataFrames(table *seekTable) []frameInfo {
+	frames := make([]frameInfo, 0, len(table.frames))
+
+	for _, frame := range table.frames {
+		if frame.decompressedSize > 0 {
+			frames = append(frames, frame)
+		}
+	}
+
+	return frames
+}
+
+// unchangedFrame returns the compressed frame from the archive
+// if it decompresses to current, and nil otherwise.
+func unchangedFrame(archive io.ReaderAt, decoder *zstd.Decoder, checksums bool, frame frameInfo, current []byte) ([]byte, error) {
+	if int(frame.decompressedSize) != len(current) {
+		return nil, nil
+	}
+
+	if checksums && frame.checksum != frameChecksum(current) {
+		return nil, nil
+	}
+
+	compressed := make([]byte, frame.compressedSize)
+
+	_, err := archive.ReadAt(compressed, frame.compressedOffset)
+	if err != nil {
+		return nil, fmt.Errorf("could not read archive: %w", err)
+	}
+
+	decompressed, err := decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress archive: %w", err)
+	}
+
+	if !bytes.Equal(decompressed, current) {
+		return nil, nil
+	}
+
+	return compressed, nil
+}
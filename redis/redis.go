@@ -0,0 +1,320 @@
+// Package redis keeps the decompressed frames of sqlitezstd archives in
+// Redis, so a fleet of stateless services reading the same remote archives
+// shares one warm cache rather than each fetching from the origin:
+//
+//	cache := &redis.Cache{Addr: "localhost:6379", TTL: 24 * time.Hour}
+//
+//	err := sqlitezstd.Register("zstd", &sqlitezstd.ZstdVFS{Cache: cache})
+//
+// Frames are read and written with GET, SET, and DEL, over RESP, under
+// Prefix. Any error, such as Redis being unreachable, is a miss, so reads
+// fall back to the archive.
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+)
+
+const (
+	defaultPrefix  = "sqlitezstd:"
+	defaultTimeout = time.Second
+	// maxIdle is the most idle connections kept for the next commands.
+	maxIdle = 16
+)
+
+var (
+	ErrUnexpectedReply = errors.New("unexpected redis reply")
+	ErrReply           = errors.New("redis replied with an error")
+)
+
+// Cache keeps frames in a Redis server. The zero value, with Addr set, is
+// ready to use, and it is safe for concurrent use.
+type Cache struct {
+	// Addr is the host:port of the server.
+	Addr string
+	// Username and Password, if set, authenticate every connection.
+	Username string
+	Password string
+	// DB is the database frames are kept in, 0 by default.
+	DB int
+	// TTL, if set, is how long a frame is kept after it is written.
+	TTL time.Duration
+	// Prefix is prepended to every key, "sqlitezstd:" by default.
+	Prefix string
+	// Timeout bounds every command, including connecting, 1 second by
+	// default.
+	Timeout time.Duration
+
+	mutex sync.Mutex
+	idle  []*conn
+}
+
+var _ sqlitezstd.Cache = &Cache{}
+
+func (c *Cache) Get(key string) ([]byte, bool) {
+	reply, err := c.do("GET", c.key(key))
+	data, ok := reply.([]byte)
+
+	return data, err == nil && ok
+}
+
+func (c *Cache) Put(key string, data []byte) {
+	args := []any{"SET", c.key(key), data}
+	if c.TTL > 0 {
+		args = append(args, "PX", strconv.FormatInt(c.TTL.Milliseconds(), 10))
+	}
+
+	_, _ = c.do(args...)
+}
+
+func (c *Cache) Remove(key string) {
+	_, _ = c.do("DEL", c.key(key))
+}
+
+// Close closes the idle connections. The cache can still be used.
+func (c *Cache) Close() error {
+	c.mutex.Lock()
+	idle := c.idle
+	c.idle = nil
+	c.mutex.Unlock()
+
+	for _, connection := range idle {
+		_ = connection.Close()
+	}
+
+	return nil
+}
+
+func (c *Cache) key(key string) string {
+	if c.Prefix == "" {
+		return defaultPrefix + key
+	}
+
+	return c.Prefix + key
+}
+
+func (c *Cache) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return defaultTimeout
+	}
+
+	return c.Timeout
+}
+
+// do sends a command on an idle connection, or a new one, and returns
+// its reply.
+func (c *Cache) do(args ...any) (any, error) {
+	connection, err := c.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := connection.do(c.timeout(), args...)
+	if err != nil {
+		_ = connection.Close()
+
+		return nil, err
+	}
+
+	c.release(connection)
+
+	if message, ok := reply.(replyError); ok {
+		return nil, fmt.Errorf("%w: %s", ErrReply, string(message))
+	}
+
+	return reply, nil
+}
+
+func (c *Cache) connection() (*conn, error) {
+	c.mutex.Lock()
+
+	if len(c.idle) > 0 {
+		connection := c.idle[len(c.idle)-1]
+		c.idle = c.idle[:len(c.idle)-1]
+		c.mutex.Unlock()
+
+		return connection, nil
+	}
+
+	c.mutex.Unlock()
+
+	return c.dial()
+}
+
+func (c *Cache) release(connection *conn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.idle) >= maxIdle {
+		_ = connection.Close()
+
+		return
+	}
+
+	c.idle = append(c.idle, connection)
+}
+
+// dial connects to the server, then authenticates and selects the
+// database, if set.
+func (c *Cache) dial() (*conn, error) {
+	network, err := net.DialTimeout("tcp", c.Addr, c.timeout())
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %s: %w", c.Addr, err)
+	}
+
+	connection := &conn{
+		Conn:   network,
+		reader: bufio.NewReader(network),
+		writer: bufio.NewWriter(network),
+	}
+
+	setup := [][]any{}
+
+	switch {
+	case c.Username != "":
+		setup = append(setup, []any{"AUTH", c.Username, c.Password})
+	case c.Password != "":
+		setup = append(setup, []any{"AUTH", c.Password})
+	}
+
+	if c.DB != 0 {
+		setup = append(setup, []any{"SELECT", strconv.Itoa(c.DB)})
+	}
+
+	for _, command := range setup {
+		reply, err := connection.do(c.timeout(), command...)
+		if err == nil {
+			if message, ok := reply.(replyError); ok {
+				err = fmt.Errorf("%w: %s", ErrReply, string(message))
+			}
+		}
+
+		if err != nil {
+			_ = connection.Close()
+
+			return nil, fmt.Errorf("could not set up connection to %s: %w", c.Addr, err)
+		}
+	}
+
+	return connection, nil
+}
+
+// conn is a connection to the server, with its buffers.
+type conn struct {
+	net.Conn
+
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// replyError is an error replied by the server, after which the
+// connection can still be used.
+type replyError string
+
+// do writes a command of strings and byte slices, then reads its reply.
+func (c *conn) do(timeout time.Duration, args ...any) (any, error) {
+	err := c.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return nil, fmt.Errorf("could not set deadline: %w", err)
+	}
+
+	_, _ = fmt.Fprintf(c.writer, "*%d\r\n", len(args))
+
+	for _, arg := range args {
+		switch value := arg.(type) {
+		case string:
+			_, _ = fmt.Fprintf(c.writer, "$%d\r\n%s\r\n", len(value), value)
+		case []byte:
+			_, _ = fmt.Fprintf(c.writer, "$%d\r\n", len(value))
+			_, _ = c.writer.Write(value)
+			_, _ = c.writer.WriteString("\r\n")
+		}
+	}
+
+	err = c.writer.Flush()
+	if err != nil {
+		return nil, fmt.Errorf("could not send command: %w", err)
+	}
+
+	return readReply(c.reader)
+}
+
+// readReply reads a RESP reply: a string, an error, an integer, a bulk
+// string as a byte slice, or nil for a missing one, or an array of them.
+func readReply(reader *bufio.Reader) (any, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("could not read reply: %w", err)
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, ErrUnexpectedReply
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return replyError(line[1:]), nil
+	case ':':
+		value, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrUnexpectedReply, line)
+		}
+
+		return value, nil
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrUnexpectedReply, line)
+		}
+
+		if size < 0 {
+			return nil, nil
+		}
+
+		data := make([]byte, size+2)
+
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			return nil, fmt.Errorf("could not read reply: %w", err)
+		}
+
+		return data[:size], nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrUnexpectedReply, line)
+		}
+
+		if count < 0 {
+			return nil, nil
+		}
+
+		elements := make([]any, 0, count)
+
+		for range count {
+			element, err := readReply(reader)
+			if err != nil {
+				return nil, err
+			}
+
+			elements = append(elements, element)
+		}
+
+		return elements, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnexpectedReply, line)
+	}
+}
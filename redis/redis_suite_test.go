@@ -0,0 +1,288 @@
+package redis_test
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	"github.com/jtarchie/sqlitezstd/redis"
+	"github.com/jtarchie/sqlitezstd/testhelper"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestRedis(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Redis Suite")
+}
+
+// server is a fake Redis server, keeping values in memory and answering
+// the commands the cache sends.
+type server struct {
+	listener net.Listener
+	password string
+
+	mutex    sync.Mutex
+	values   map[string][]byte
+	expiries map[string]string
+}
+
+func serve(password string) *server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		Skip(fmt.Sprintf("Redis is unavailable: %v", err))
+	}
+
+	fake := &server{
+		listener: listener,
+		password: password,
+		values:   map[string][]byte{},
+		expiries: map[string]string{},
+	}
+
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go fake.handle(connection)
+		}
+	}()
+
+	DeferCleanup(listener.Close)
+
+	return fake
+}
+
+func (s *server) handle(connection net.Conn) {
+	defer connection.Close()
+
+	reader := bufio.NewReader(connection)
+	authenticated := s.password == ""
+
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+
+		switch command := strings.ToUpper(args[0]); {
+		case command == "AUTH":
+			authenticated = args[len(args)-1] == s.password
+			if authenticated {
+				_, _ = io.WriteString(connection, "+OK\r\n")
+			} else {
+				_, _ = io.WriteString(connection, "-WRONGPASS invalid password\r\n")
+			}
+		case !authenticated:
+			_, _ = io.WriteString(connection, "-NOAUTH Authentication required.\r\n")
+		case command == "SELECT":
+			_, _ = io.WriteString(connection, "+OK\r\n")
+		case command == "GET":
+			value, ok := s.values[args[1]]
+			if ok {
+				_, _ = fmt.Fprintf(connection, "$%d\r\n%s\r\n", len(value), value)
+			} else {
+				_, _ = io.WriteString(connection, "$-1\r\n")
+			}
+		case command == "SET":
+			s.values[args[1]] = []byte(args[2])
+			if len(args) == 5 {
+				s.expiries[args[1]] = args[3] + " " + args[4]
+			}
+
+			_, _ = io.WriteString(connection, "+OK\r\n")
+		case command == "DEL":
+			_, ok := s.values[args[1]]
+			delete(s.values, args[1])
+
+			if ok {
+				_, _ = io.WriteString(connection, ":1\r\n")
+			} else {
+				_, _ = io.WriteString(connection, ":0\r\n")
+			}
+		default:
+			_, _ = fmt.Fprintf(connection, "-ERR unknown command '%s'\r\n", command)
+		}
+		s.mutex.Unlock()
+	}
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, 0, count)
+
+	for range count {
+		line, err = reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		data := make([]byte, size+2)
+
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, string(data[:size]))
+	}
+
+	return args, nil
+}
+
+func (s *server) keys() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keys := []string{}
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+func (s *server) expiry(key string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.expiries[key]
+}
+
+func (s *server) set(key string, value []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.values[key] = value
+}
+
+func sumBodies(dsn string) int64 {
+	client, err := sql.Open("sqlite3", dsn)
+	Expect(err).ToNot(HaveOccurred())
+	defer client.Close()
+
+	var length int64
+	Expect(client.QueryRow("SELECT SUM(LENGTH(body)) FROM entries;").Scan(&length)).To(Succeed())
+
+	return length
+}
+
+var _ = Describe("Cache", func() {
+	var (
+		archiveURL string
+		ranges     atomic.Int64
+	)
+
+	BeforeEach(func() {
+		zstPath := testhelper.CreateEntriesDB(GinkgoT(), 1000, sqlitezstd.WithFrameSize(4096))
+
+		files := http.FileServer(http.Dir(filepath.Dir(zstPath)))
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Range") != "" {
+				ranges.Add(1)
+			}
+
+			files.ServeHTTP(w, r)
+		}))
+		DeferCleanup(origin.Close)
+
+		archiveURL = fmt.Sprintf("%s/%s", origin.URL, filepath.Base(zstPath))
+	})
+
+	It("shares the frames of remote archives between processes", func() {
+		fake := serve("secret")
+
+		first := &redis.Cache{Addr: fake.listener.Addr().String(), Password: "secret", DB: 2, TTL: time.Hour}
+		DeferCleanup(first.Close)
+
+		Expect(sqlitezstd.Register("zstd-redis-first", &sqlitezstd.ZstdVFS{Cache: first})).To(Succeed())
+		Expect(sumBodies(fmt.Sprintf("file:%s?vfs=zstd-redis-first&range_size=-1", archiveURL))).To(BeEquivalentTo(1000 * 64))
+		Expect(fake.keys()).ToNot(BeEmpty())
+		Expect(fake.keys()[0]).To(HavePrefix("sqlitezstd:"))
+		Expect(fake.expiry(fake.keys()[0])).To(Equal("PX 3600000"))
+		Expect(ranges.Load()).To(BeNumerically(">", 1))
+
+		// another process finds the frames in Redis rather than the archive
+		second := &redis.Cache{Addr: fake.listener.Addr().String(), Password: "secret", DB: 2}
+		DeferCleanup(second.Close)
+
+		ranges.Store(0)
+
+		Expect(sqlitezstd.Register("zstd-redis-second", &sqlitezstd.ZstdVFS{Cache: second})).To(Succeed())
+		Expect(sumBodies(fmt.Sprintf("file:%s?vfs=zstd-redis-second&range_size=-1", archiveURL))).To(BeEquivalentTo(1000 * 64))
+		// only the first byte is read, telling archives from manifests
+		Expect(ranges.Load()).To(BeEquivalentTo(1))
+	})
+
+	It("replaces frames that are not valid", func() {
+		fake := serve("")
+
+		cache := &redis.Cache{Addr: fake.listener.Addr().String(), Prefix: "frames:"}
+		DeferCleanup(cache.Close)
+
+		Expect(sqlitezstd.Register("zstd-redis-corrupt", &sqlitezstd.ZstdVFS{Cache: cache})).To(Succeed())
+
+		dsn := fmt.Sprintf("file:%s?vfs=zstd-redis-corrupt&range_size=-1", archiveURL)
+		Expect(sumBodies(dsn)).To(BeEquivalentTo(1000 * 64))
+
+		keys := fake.keys()
+		Expect(keys).ToNot(BeEmpty())
+
+		for _, key := range keys {
+			Expect(key).To(HavePrefix("frames:"))
+			fake.set(key, []byte("not a frame"))
+		}
+
+		Expect(sumBodies(dsn)).To(BeEquivalentTo(1000 * 64))
+
+		for _, key := range keys {
+			value, ok := cache.Get(strings.TrimPrefix(key, "frames:"))
+			Expect(ok).To(BeTrue())
+			Expect(value).ToNot(Equal([]byte("not a frame")))
+		}
+	})
+
+	It("reads from the archive when Redis is unreachable or refuses", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+
+		closed := listener.Addr().String()
+		Expect(listener.Close()).To(Succeed())
+
+		unreachable := &redis.Cache{Addr: closed, Timeout: 100 * time.Millisecond}
+		Expect(sqlitezstd.Register("zstd-redis-unreachable", &sqlitezstd.ZstdVFS{Cache: unreachable})).To(Succeed())
+		Expect(sumBodies(fmt.Sprintf("file:%s?vfs=zstd-redis-unreachable&range_size=-1", archiveURL))).To(BeEquivalentTo(1000 * 64))
+
+		fake := serve("secret")
+
+		refused := &redis.Cache{Addr: fake.listener.Addr().String(), Password: "wrong"}
+		DeferCleanup(refused.Close)
+
+		Expect(sqlitezstd.Register("zstd-redis-refused", &sqlitezstd.ZstdVFS{Cache: refused})).To(Succeed())
+		Expect(sumBodies(fmt.Sprintf("file:%s?vfs=zstd-redis-refused&range_size=-1", archiveURL))).To(BeEquivalentTo(1000 * 64))
+		Expect(fake.keys()).To(BeEmpty())
+	})
+})
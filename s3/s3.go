@@ -0,0 +1,226 @@
+// Package s3 reads sqlitezstd archives from Amazon S3. Importing it registers
+// a backend for s3://bucket/key names:
+//
+//	import _ "github.com/jtarchie/sqlitezstd/s3"
+//
+//	db, err := sql.Open("sqlite3", "s3://bucket/path/to/db.sqlite.zst?vfs=zstd")
+//
+// The region and credentials are taken from the standard AWS configuration
+// chain, such as AWS_REGION, AWS_PROFILE, or an instance role.
+//
+// S3-compatible stores, such as Cloudflare R2, MinIO, and Ceph, are reached by
+// setting the endpoint, the signing region, and path-style addressing, either
+// on a registered Backend or in the query of the name:
+//
+//	s3://bucket/key?endpoint=http://localhost:9000&path_style=true&region=auto
+//
+// The query can also be given as parameters of a file: URI:
+//
+//	file:s3://bucket/key?vfs=zstd&endpoint=http://localhost:9000&path_style=true
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+)
+
+const defaultRegion = "us-east-1"
+
+var ErrInvalidURL = errors.New("invalid s3 url")
+
+//nolint: gochecknoinits
+func init() {
+	sqlitezstd.RegisterBackend("s3", &Backend{})
+}
+
+// Backend opens s3://bucket/key names, reading them with ranged GetObject
+// calls. Register a configured Backend with sqlitezstd.RegisterBackend to
+// use a client of your own.
+type Backend struct {
+	// Client makes every request. If nil, a client is created from the
+	// standard AWS configuration chain when the first archive is opened.
+	Client *s3.Client
+	// Endpoint replaces the AWS endpoint, for S3-compatible stores.
+	Endpoint string
+	// Region is the region requests are signed for, instead of the one
+	// from the configuration chain.
+	Region string
+	// UsePathStyle addresses buckets in the path of the URL rather than
+	// its host, as most self-hosted stores expect. The path_style
+	// parameter overrides it, and the addressing of Client, either way.
+	UsePathStyle bool
+
+	mutex sync.Mutex
+}
+
+var _ sqlitezstd.Backend = &Backend{}
+
+func (b *Backend) client(ctx context.Context) (*s3.Client, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.Client != nil {
+		return b.Client, nil
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not load aws config: %w", err)
+	}
+
+	if cfg.Region == "" {
+		cfg.Region = defaultRegion
+	}
+
+	b.Client = s3.NewFromConfig(cfg)
+
+	return b.Client, nil
+}
+
+// settings returns the options for requests to an object,
+// where the query of its name overrides the settings of the backend.
+func (b *Backend) settings(query url.Values) (func(*s3.Options), error) {
+	endpoint := b.Endpoint
+	if query.Has("endpoint") {
+		endpoint = query.Get("endpoint")
+	}
+
+	region := b.Region
+	if query.Has("region") {
+		region = query.Get("region")
+	}
+
+	// a client of its own keeps its addressing unless either sets it
+	pathStyle, setPathStyle := b.UsePathStyle, b.UsePathStyle || query.Has("path_style")
+
+	if query.Has("path_style") {
+		var err error
+
+		pathStyle, err = strconv.ParseBool(query.Get("path_style"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: path_style %q is not a boolean", ErrInvalidURL, query.Get("path_style"))
+		}
+	}
+
+	return func(options *s3.Options) {
+		if endpoint != "" {
+			options.BaseEndpoint = aws.String(endpoint)
+		}
+
+		if region != "" {
+			options.Region = region
+		}
+
+		if setPathStyle {
+			options.UsePathStyle = pathStyle
+		}
+	}, nil
+}
+
+func (b *Backend) Open(uri *url.URL) (sqlitezstd.Object, error) {
+	bucket := uri.Host
+	key := strings.TrimPrefix(uri.Path, "/")
+
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("%w: %s needs a bucket and a key", ErrInvalidURL, uri.Redacted())
+	}
+
+	settings, err := b.settings(uri.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+
+	client, err := b.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}, settings)
+	if err != nil {
+		return nil, fmt.Errorf("could not stat object: %w", err)
+	}
+
+	return &object{
+		client:   client,
+		settings: settings,
+		bucket:   bucket,
+		key:      key,
+		etag:     aws.ToString(head.ETag),
+		size:     aws.ToInt64(head.ContentLength),
+	}, nil
+}
+
+// object is an S3 object, pinned to the version that was opened by its ETag.
+type object struct {
+	client   *s3.Client
+	settings func(*s3.Options)
+	bucket   string
+	key      string
+	etag     string
+	size     int64
+}
+
+func (o *object) ReadAt(p []byte, off int64) (int, error) {
+	if off >= o.size {
+		return 0, io.EOF
+	}
+
+	end := min(off+int64(len(p)), o.size)
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(o.bucket),
+		Key:    aws.String(o.key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", off, end-1)),
+	}
+
+	// a replaced object fails the request, rather than mixing frames of both
+	if o.etag != "" {
+		input.IfMatch = aws.String(o.etag)
+	}
+
+	response, err := o.client.GetObject(context.Background(), input, o.settings)
+	if err != nil {
+		return 0, fmt.Errorf("could not read object: %w", err)
+	}
+	defer response.Body.Close()
+
+	count, err := io.ReadFull(response.Body, p[:end-off])
+	if err != nil {
+		return count, fmt.Errorf("could not read object: %w", err)
+	}
+
+	if count < len(p) {
+		return count, io.EOF
+	}
+
+	return count, nil
+}
+
+// Version is the ETag of the object.
+func (o *object) Version() string {
+	return o.etag
+}
+
+func (o *object) Size() int64 {
+	return o.size
+}
+
+func (o *object) Close() error {
+	return nil
+}
@@ -0,0 +1,260 @@
+package s3_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	"github.com/jtarchie/sqlitezstd/s3"
+	"github.com/jtarchie/sqlitezstd/testhelper"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestS3(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "S3 Suite")
+}
+
+// bucket is a fake S3 bucket, serving objects with path-style URLs.
+type bucket struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+	etags   map[string]string
+	// regions are the regions requests were signed for
+	regions []string
+	// hosts are the hosts requests were sent to
+	hosts []string
+}
+
+func (b *bucket) put(key string, contents []byte) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.objects[key] = contents
+	b.etags[key] = fmt.Sprintf(`"%d-%d"`, len(contents), time.Now().UnixNano())
+}
+
+func (b *bucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mutex.Lock()
+	contents, ok := b.objects[strings.TrimPrefix(r.URL.Path, "/bucket/")]
+	etag := b.etags[strings.TrimPrefix(r.URL.Path, "/bucket/")]
+	b.hosts = append(b.hosts, r.Host)
+
+	if _, scope, found := strings.Cut(r.Header.Get("Authorization"), "Credential="); found {
+		if fields := strings.Split(scope, "/"); len(fields) > 2 {
+			b.regions = append(b.regions, fields[2])
+		}
+	}
+	b.mutex.Unlock()
+
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(contents))
+}
+
+func countEntries(dsn string) (int64, error) {
+	client, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	var count int64
+	err = client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)
+
+	return count, err
+}
+
+var _ = Describe("Backend", func() {
+	var (
+		objects *bucket
+		server  *httptest.Server
+	)
+
+	BeforeEach(func() {
+		Expect(sqlitezstd.Init()).To(Succeed())
+
+		objects = &bucket{objects: map[string][]byte{}, etags: map[string]string{}}
+		server = httptest.NewServer(objects)
+		DeferCleanup(server.Close)
+
+		sqlitezstd.RegisterBackend("s3", &s3.Backend{
+			Client: awss3.New(awss3.Options{
+				BaseEndpoint: aws.String(server.URL),
+				UsePathStyle: true,
+				Region:       "us-east-1",
+				Credentials:  aws.AnonymousCredentials{},
+			}),
+		})
+	})
+
+	createArchive := func(opts ...sqlitezstd.Option) string {
+		return testhelper.CreateEntriesDB(GinkgoT(), 1000, opts...)
+	}
+
+	upload := func(path string, key string) {
+		contents, err := os.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+
+		objects.put(key, contents)
+	}
+
+	It("reads archives from a bucket", func() {
+		upload(createArchive(), "path/db.sqlite.zst")
+
+		count, err := countEntries("s3://bucket/path/db.sqlite.zst?vfs=zstd")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+	})
+
+	It("reads archives split into parts", func() {
+		zstPath := createArchive(sqlitezstd.WithPartSize(4096))
+		upload(zstPath, filepath.Base(zstPath))
+
+		parts, err := filepath.Glob(zstPath + ".[0-9][0-9][0-9]")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(parts)).To(BeNumerically(">", 1))
+
+		for _, part := range parts {
+			upload(part, filepath.Base(part))
+		}
+
+		count, err := countEntries(fmt.Sprintf("s3://bucket/%s?vfs=zstd", filepath.Base(zstPath)))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+	})
+
+	It("errors for missing objects", func() {
+		_, err := countEntries("s3://bucket/missing.zst?vfs=zstd")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("stops reading an object once it is replaced", func() {
+		upload(createArchive(sqlitezstd.WithFrameSize(4096)), "db.sqlite.zst")
+
+		client, err := sql.Open("sqlite3", "s3://bucket/db.sqlite.zst?vfs=zstd")
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		client.SetMaxOpenConns(1)
+
+		var count int64
+		Expect(client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)).To(Succeed())
+
+		upload(createArchive(sqlitezstd.WithFrameSize(4096)), "db.sqlite.zst")
+
+		_, err = client.Exec("PRAGMA cache_size = 0; SELECT * FROM entries WHERE id = 999;")
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("with an S3-compatible store", func() {
+		var localhost string
+
+		BeforeEach(func() {
+			// a host name, so a bucket could be addressed by host as well
+			localhost = strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+			upload(createArchive(), "db.sqlite.zst")
+		})
+
+		client := func() *awss3.Client {
+			return awss3.New(awss3.Options{
+				Region:      "us-east-1",
+				Credentials: credentials.NewStaticCredentialsProvider("key", "secret", ""),
+			})
+		}
+
+		It("uses the settings of the backend", func() {
+			sqlitezstd.RegisterBackend("s3", &s3.Backend{
+				Client:       client(),
+				Endpoint:     localhost,
+				Region:       "auto",
+				UsePathStyle: true,
+			})
+
+			count, err := countEntries("s3://bucket/db.sqlite.zst?vfs=zstd")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(BeEquivalentTo(1000))
+			Expect(objects.regions).ToNot(BeEmpty())
+			Expect(objects.regions).To(HaveEach("auto"))
+		})
+
+		It("uses the settings of the DSN", func() {
+			sqlitezstd.RegisterBackend("s3", &s3.Backend{Client: client(), Region: "eu-west-1"})
+
+			dsn := fmt.Sprintf("file:s3://bucket/db.sqlite.zst?vfs=zstd&endpoint=%s&path_style=true&region=weur", url.QueryEscape(localhost))
+
+			count, err := countEntries(dsn)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(BeEquivalentTo(1000))
+			Expect(objects.regions).ToNot(BeEmpty())
+			Expect(objects.regions).To(HaveEach("weur"))
+		})
+
+		It("uses the settings of the name", func() {
+			sqlitezstd.RegisterBackend("s3", &s3.Backend{Client: client()})
+
+			info, err := sqlitezstd.Inspect(fmt.Sprintf("s3://bucket/db.sqlite.zst?endpoint=%s&path_style=1", url.QueryEscape(localhost)))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.PageSize).To(Equal(4096))
+		})
+
+		It("lets the name turn off path-style addressing", func() {
+			// every host, such as bucket.localhost, reaches the fake store
+			dialer := &net.Dialer{}
+			transport := &http.Transport{DialContext: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, server.Listener.Addr().String())
+			}}
+			DeferCleanup(transport.CloseIdleConnections)
+
+			sqlitezstd.RegisterBackend("s3", &s3.Backend{
+				Client: awss3.New(awss3.Options{
+					Region:       "us-east-1",
+					Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+					HTTPClient:   &http.Client{Transport: transport},
+					UsePathStyle: true,
+				}),
+				Endpoint: localhost,
+			})
+
+			_, err := sqlitezstd.Inspect("s3://bucket/db.sqlite.zst")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(objects.hosts).To(HaveEach(HavePrefix("localhost:")))
+
+			objects.hosts = nil
+
+			_, err = sqlitezstd.Inspect("s3://bucket/db.sqlite.zst?path_style=false")
+			Expect(err).To(HaveOccurred())
+			Expect(objects.hosts).ToNot(BeEmpty())
+			Expect(objects.hosts).To(HaveEach(HavePrefix("bucket.localhost:")))
+		})
+
+		It("errors for invalid settings", func() {
+			sqlitezstd.RegisterBackend("s3", &s3.Backend{Client: client()})
+
+			_, err := sqlitezstd.Inspect("s3://bucket/db.sqlite.zst?path_style=maybe")
+			Expect(err).To(MatchError(s3.ErrInvalidURL))
+		})
+	})
+})
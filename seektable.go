@@ -0,0 +1,222 @@
+package sqlitezstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// The seek table layout is described in the zstd seekable format spec:
+// https://github.com/facebook/zstd/blob/dev/contrib/seekable_format/zstd_seekable_compression_format.md
+const (
+	skippableFrameMagic = 0x184D2A50
+	seekableMagicNumber = 0x8F92EAB1
+	seekableTag         = 0xE
+
+	skippableHeaderSize = 8
+	seekTableEntrySize  = 12
+	seekTableFooterSize = 9
+
+	checksumFlag = 1 << 7
+
+	// maxSeekTableSize guards against allocating for a corrupt footer.
+	maxSeekTableSize = 128 << 20
+)
+
+var ErrInvalidSeekTable = errors.New("invalid seek table")
+
+// frameInfo is a seek table entry with its position in both streams.
+type frameInfo struct {
+	seekTableEntry
+
+	index              int
+	compressedOffset   int64
+	decompressedOffset int64
+}
+
+type seekTable struct {
+	frames []frameInfo
+	// size is the byte size of the seek table frame itself.
+	size int64
+	// compressedSize is the total size of all frames before the seek table.
+	compressedSize int64
+	// decompressedSize is the total size of the uncompressed stream.
+	decompressedSize int64
+	checksums        bool
+	// buckets cut the decompressed stream in buckets of 1<<shift bytes,
+	// each holding the index of the frame its first byte is in, so frames
+	// are found without searching the seek table.
+	buckets []uint32
+	shift   uint
+}
+
+type seekTableEntry struct {
+	compressedSize   uint32
+	decompressedSize uint32
+	checksum         uint32
+}
+
+func frameChecksum(p []byte) uint32 {
+	//nolint: gosec
+	return uint32(xxhash.Sum64(p))
+}
+
+// skippableFrame wraps payload in a zstd skippable frame with the given tag.
+func skippableFrame(tag uint32, payload []byte) []byte {
+	frame := make([]byte, skippableHeaderSize, skippableHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(frame[0:4], skippableFrameMagic+tag)
+	//nolint: gosec
+	binary.LittleEndian.PutUint32(frame[4:8], uint32(len(payload)))
+
+	return append(frame, payload...)
+}
+
+// encodeSeekTable returns the seek table for entries as a skippable frame.
+func encodeSeekTable(entries []seekTableEntry) []byte {
+	payload := make([]byte, len(entries)*seekTableEntrySize+seekTableFooterSize)
+
+	for index, entry := range entries {
+		offset := index * seekTableEntrySize
+		binary.LittleEndian.PutUint32(payload[offset:], entry.compressedSize)
+		binary.LittleEndian.PutUint32(payload[offset+4:], entry.decompressedSize)
+		binary.LittleEndian.PutUint32(payload[offset+8:], entry.checksum)
+	}
+
+	footer := payload[len(entries)*seekTableEntrySize:]
+	//nolint: gosec
+	binary.LittleEndian.PutUint32(footer[0:4], uint32(len(entries)))
+	footer[4] = checksumFlag
+	binary.LittleEndian.PutUint32(footer[5:9], seekableMagicNumber)
+
+	return skippableFrame(seekableTag, payload)
+}
+
+// decodeSeekTable reads the seek table at the end of a seekable
+// file of the given size.
+func decodeSeekTable(reader io.ReaderAt, size int64) (*seekTable, error) {
+	table, _, err := readSeekTable(reader, size)
+
+	return table, err
+}
+
+// readSeekTable reads the seek table at the end of a seekable file of the
+// given size, returning it with the bytes it was decoded from.
+func readSeekTable(reader io.ReaderAt, size int64) (*seekTable, []byte, error) {
+	if size < skippableHeaderSize+seekTableFooterSize {
+		return nil, nil, fmt.Errorf("%w: file is too small", ErrInvalidSeekTable)
+	}
+
+	footer := make([]byte, seekTableFooterSize)
+
+	_, err := reader.ReadAt(footer, size-seekTableFooterSize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read seek table footer: %w", err)
+	}
+
+	if binary.LittleEndian.Uint32(footer[5:9]) != seekableMagicNumber {
+		return nil, nil, fmt.Errorf("%w: footer magic mismatch", ErrInvalidSeekTable)
+	}
+
+	table := &seekTable{
+		checksums: footer[4]&checksumFlag != 0,
+	}
+
+	entrySize := int64(seekTableEntrySize)
+	if !table.checksums {
+		entrySize -= 4
+	}
+
+	numFrames := int64(binary.LittleEndian.Uint32(footer[0:4]))
+	table.size = skippableHeaderSize + numFrames*entrySize + seekTableFooterSize
+
+	if table.size > size || table.size > maxSeekTableSize {
+		return nil, nil, fmt.Errorf("%w: %d frames do not fit in the file", ErrInvalidSeekTable, numFrames)
+	}
+
+	contents := make([]byte, table.size)
+
+	_, err = reader.ReadAt(contents, size-table.size)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read seek table: %w", err)
+	}
+
+	if binary.LittleEndian.Uint32(contents[0:4]) != skippableFrameMagic+seekableTag {
+		return nil, nil, fmt.Errorf("%w: skippable frame magic mismatch", ErrInvalidSeekTable)
+	}
+
+	if int64(binary.LittleEndian.Uint32(contents[4:8])) != table.size-skippableHeaderSize {
+		return nil, nil, fmt.Errorf("%w: skippable frame size mismatch", ErrInvalidSeekTable)
+	}
+
+	table.frames = make([]frameInfo, numFrames)
+
+	for index := range table.frames {
+		offset := skippableHeaderSize + int64(index)*entrySize
+		frame := &table.frames[index]
+		frame.index = index
+		frame.compressedOffset = table.compressedSize
+		frame.decompressedOffset = table.decompressedSize
+		frame.compressedSize = binary.LittleEndian.Uint32(contents[offset:])
+		frame.decompressedSize = binary.LittleEndian.Uint32(contents[offset+4:])
+
+		if table.checksums {
+			frame.checksum = binary.LittleEndian.Uint32(contents[offset+8:])
+		}
+
+		table.compressedSize += int64(frame.compressedSize)
+		table.decompressedSize += int64(frame.decompressedSize)
+	}
+
+	if table.compressedSize+table.size != size {
+		return nil, nil, fmt.Errorf("%w: frames cover %d bytes of %d", ErrInvalidSeekTable, table.compressedSize+table.size, size)
+	}
+
+	table.index()
+
+	return table, contents, nil
+}
+
+// index buckets the frames. Buckets no larger than the average frame
+// hold the start of about one frame each, and are at most twice as many
+// as the frames.
+func (t *seekTable) index() {
+	t.shift = 0
+	if len(t.frames) > 0 && t.decompressedSize >= int64(len(t.frames)) {
+		t.shift = uint(bits.Len64(uint64(t.decompressedSize/int64(len(t.frames))))) - 1
+	}
+
+	t.buckets = make([]uint32, t.decompressedSize>>t.shift+1)
+	frame := 0
+
+	for bucket := range t.buckets {
+		start := int64(bucket) << t.shift
+		for frame+1 < len(t.frames) && t.frames[frame+1].decompressedOffset <= start {
+			frame++
+		}
+
+		//nolint: gosec
+		t.buckets[bucket] = uint32(frame)
+	}
+}
+
+// frameFor returns the index of the frame holding the decompressed
+// position, the last frame past the end, and -1 before the start.
+func (t *seekTable) frameFor(position int64) int {
+	if position < 0 || len(t.frames) == 0 {
+		return -1
+	}
+
+	bucket := min(position>>t.shift, int64(len(t.buckets)-1))
+	index := int(t.buckets[bucket])
+
+	// frames smaller than a bucket start within it
+	for index+1 < len(t.frames) && t.frames[index+1].decompressedOffset <= position {
+		index++
+	}
+
+	return index
+}
@@ -0,0 +1,65 @@
+package sqlitezstd
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// archiveName matches archives, split or not, and their parts.
+//
+//nolint: gochecknoglobals
+var archiveName = regexp.MustCompile(`^[^.].*\.zst(\.\d{3})?$`)
+
+// FileServer serves the archives below root for the VFS to read over HTTP.
+// Only archives and their parts are served, never directory listings. Every
+// response supports Range requests and carries a strong ETag, which the VFS
+// sends back with If-Range so that it never mixes frames of two versions of
+// an archive. Responses may be cached for maxAge, but must not be transformed,
+// as compressing them again in transit breaks byte ranges.
+func FileServer(root string, maxAge time.Duration) http.Handler {
+	dir := http.Dir(root)
+	cacheControl := fmt.Sprintf("public, max-age=%d, no-transform", int(maxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+			return
+		}
+
+		name := path.Clean("/" + r.URL.Path)
+		if !archiveName.MatchString(path.Base(name)) || strings.Contains(name, "/.") {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		file, err := dir.Open(name)
+		if err != nil {
+			http.NotFound(w, r)
+
+			return
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		header := w.Header()
+		header.Set("Accept-Ranges", "bytes")
+		header.Set("Cache-Control", cacheControl)
+		header.Set("Content-Type", "application/zstd")
+		header.Set("ETag", fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano()))
+
+		http.ServeContent(w, r, name, info.ModTime(), file)
+	})
+}
@@ -0,0 +1,93 @@
+package sqlitezstd_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FileServer", func() {
+	var (
+		server  *httptest.Server
+		zstPath string
+	)
+
+	BeforeEach(func() {
+		err := sqlitezstd.Init()
+		Expect(err).ToNot(HaveOccurred())
+
+		dbPath := createSQLite()
+		zstPath = dbPath + ".zst"
+
+		err = sqlitezstd.CompressFile(dbPath, zstPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		server = httptest.NewServer(sqlitezstd.FileServer(filepath.Dir(zstPath), time.Minute))
+		DeferCleanup(server.Close)
+	})
+
+	get := func(name string, headers map[string]string) *http.Response {
+		request, err := http.NewRequest(http.MethodGet, server.URL+"/"+name, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		for key, value := range headers {
+			request.Header.Set(key, value)
+		}
+
+		response, err := http.DefaultClient.Do(request)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.Body.Close()).To(Succeed())
+
+		return response
+	}
+
+	It("serves archives to the VFS", func() {
+		count := countEntries(server.URL + "/" + filepath.Base(zstPath) + "?vfs=zstd")
+		Expect(count).To(BeEquivalentTo(1000))
+	})
+
+	It("serves ranges with cache headers", func() {
+		response := get(filepath.Base(zstPath), map[string]string{"Range": "bytes=0-99"})
+		Expect(response.StatusCode).To(Equal(http.StatusPartialContent))
+		Expect(response.ContentLength).To(BeEquivalentTo(100))
+		Expect(response.Header.Get("Accept-Ranges")).To(Equal("bytes"))
+		Expect(response.Header.Get("Cache-Control")).To(Equal("public, max-age=60, no-transform"))
+		Expect(response.Header.Get("Content-Type")).To(Equal("application/zstd"))
+
+		etag := response.Header.Get("ETag")
+		Expect(etag).To(MatchRegexp(`^"[0-9a-f]+-[0-9a-f]+"$`))
+
+		response = get(filepath.Base(zstPath), map[string]string{"If-None-Match": etag})
+		Expect(response.StatusCode).To(Equal(http.StatusNotModified))
+
+		response = get(filepath.Base(zstPath), map[string]string{"Range": "bytes=0-99", "If-Range": etag})
+		Expect(response.StatusCode).To(Equal(http.StatusPartialContent))
+
+		response = get(filepath.Base(zstPath), map[string]string{"Range": "bytes=0-99", "If-Range": `"stale"`})
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("only serves archives", func() {
+		dir := filepath.Dir(zstPath)
+		Expect(os.WriteFile(filepath.Join(dir, ".hidden.zst"), []byte("hidden"), 0o600)).To(Succeed())
+		Expect(os.Mkdir(filepath.Join(dir, "nested.zst"), 0o700)).To(Succeed())
+
+		database := strings.TrimSuffix(filepath.Base(zstPath), ".zst")
+
+		for _, name := range []string{"", database, ".hidden.zst", "nested.zst"} {
+			Expect(get(name, nil).StatusCode).To(Equal(http.StatusNotFound), name)
+		}
+
+		response, err := http.Post(server.URL+"/"+filepath.Base(zstPath), "text/plain", nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.Body.Close()).To(Succeed())
+		Expect(response.StatusCode).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
@@ -0,0 +1,213 @@
+// Package sftp reads sqlitezstd archives over SSH, so archives on bastions
+// and legacy file servers can be queried in place. Importing it registers a
+// backend for sftp://user@host/path names:
+//
+//	import _ "github.com/jtarchie/sqlitezstd/sftp"
+//
+//	db, err := sql.Open("sqlite3", "sftp://user@host/srv/db.sqlite.zst?vfs=zstd")
+//
+// Paths are absolute, unless they start with /~/ for the home directory.
+// Without a password in the name, the SSH agent and the default keys in
+// ~/.ssh are tried, and host keys are checked against ~/.ssh/known_hosts.
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	defaultPort = "22"
+	dialTimeout = 30 * time.Second
+)
+
+var ErrInvalidURL = errors.New("invalid sftp url")
+
+//nolint: gochecknoinits
+func init() {
+	sqlitezstd.RegisterBackend("sftp", &Backend{})
+}
+
+// Backend opens sftp://user@host/path names, with a connection per archive.
+type Backend struct {
+	// Auth replaces the SSH agent and default keys.
+	Auth []ssh.AuthMethod
+	// HostKeyCallback replaces checking ~/.ssh/known_hosts.
+	HostKeyCallback ssh.HostKeyCallback
+}
+
+var _ sqlitezstd.Backend = &Backend{}
+
+func (b *Backend) config(uri *url.URL) (*ssh.ClientConfig, []func(), error) {
+	config := &ssh.ClientConfig{
+		User:            uri.User.Username(),
+		Auth:            b.Auth,
+		HostKeyCallback: b.HostKeyCallback,
+		Timeout:         dialTimeout,
+	}
+
+	if config.User == "" {
+		config.User = os.Getenv("USER")
+	}
+
+	var closers []func()
+
+	if password, ok := uri.User.Password(); ok {
+		config.Auth = []ssh.AuthMethod{ssh.Password(password)}
+	} else if config.Auth == nil {
+		config.Auth, closers = defaultAuth()
+	}
+
+	if config.HostKeyCallback == nil {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, closers, fmt.Errorf("could not find known hosts: %w", err)
+		}
+
+		config.HostKeyCallback, err = knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+		if err != nil {
+			return nil, closers, fmt.Errorf("could not read known hosts: %w", err)
+		}
+	}
+
+	return config, closers, nil
+}
+
+// defaultAuth tries the SSH agent, then the unencrypted default keys.
+func defaultAuth() ([]ssh.AuthMethod, []func()) {
+	var (
+		methods []ssh.AuthMethod
+		closers []func()
+	)
+
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		conn, err := net.Dial("unix", socket)
+		if err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+			closers = append(closers, func() { _ = conn.Close() })
+		}
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return methods, closers
+	}
+
+	var signers []ssh.Signer
+
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		contents, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+		if err != nil {
+			continue
+		}
+
+		signer, err := ssh.ParsePrivateKey(contents)
+		if err != nil {
+			continue
+		}
+
+		signers = append(signers, signer)
+	}
+
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	return methods, closers
+}
+
+func (b *Backend) Open(uri *url.URL) (sqlitezstd.Object, error) {
+	path := uri.Path
+	if home, ok := strings.CutPrefix(path, "/~/"); ok {
+		path = home
+	}
+
+	if uri.Hostname() == "" || strings.Trim(path, "/") == "" {
+		return nil, fmt.Errorf("%w: %s needs a host and a path", ErrInvalidURL, uri.Redacted())
+	}
+
+	port := uri.Port()
+	if port == "" {
+		port = defaultPort
+	}
+
+	config, closers, err := b.config(uri)
+
+	object := &object{closers: closers}
+	if err != nil {
+		_ = object.Close()
+
+		return nil, err
+	}
+
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(uri.Hostname(), port), config)
+	if err != nil {
+		_ = object.Close()
+
+		return nil, fmt.Errorf("could not connect: %w", err)
+	}
+
+	object.closers = append(object.closers, func() { _ = conn.Close() })
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		_ = object.Close()
+
+		return nil, fmt.Errorf("could not start sftp: %w", err)
+	}
+
+	object.closers = append(object.closers, func() { _ = client.Close() })
+
+	object.File, err = client.Open(path)
+	if err != nil {
+		_ = object.Close()
+
+		return nil, fmt.Errorf("could not open file: %w", err)
+	}
+
+	object.closers = append(object.closers, func() { _ = object.File.Close() })
+
+	info, err := object.Stat()
+	if err != nil {
+		_ = object.Close()
+
+		return nil, fmt.Errorf("could not stat file: %w", err)
+	}
+
+	object.size = info.Size()
+
+	return object, nil
+}
+
+// object is a remote file, along with the connection it is read over.
+type object struct {
+	*sftp.File
+
+	size    int64
+	closers []func()
+}
+
+func (o *object) Size() int64 {
+	return o.size
+}
+
+// Close closes the file, then the connection.
+func (o *object) Close() error {
+	for index := len(o.closers) - 1; index >= 0; index-- {
+		o.closers[index]()
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package sftp_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	"github.com/jtarchie/sqlitezstd/sftp"
+	"github.com/jtarchie/sqlitezstd/testhelper"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	pkgsftp "github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSFTP(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "SFTP Suite")
+}
+
+// serve runs an SFTP server for user and password, returning its address.
+func serve(hostKey ssh.Signer) string {
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == "user" && string(password) == "password" {
+				return &ssh.Permissions{}, nil
+			}
+
+			return nil, fmt.Errorf("access denied for %s", conn.User())
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		Skip(fmt.Sprintf("SFTP is unavailable: %v", err))
+	}
+	DeferCleanup(listener.Close)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go handle(conn, config)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func handle(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+
+	go ssh.DiscardRequests(requests)
+
+	for request := range channels {
+		channel, requests, err := request.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			for request := range requests {
+				_ = request.Reply(request.Type == "subsystem" && string(request.Payload[4:]) == "sftp", nil)
+
+				if request.Type == "subsystem" {
+					server, err := pkgsftp.NewServer(channel)
+					if err != nil {
+						return
+					}
+
+					_ = server.Serve()
+					_ = channel.Close()
+				}
+			}
+		}()
+	}
+}
+
+func countEntries(dsn string) (int64, error) {
+	client, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	var count int64
+	err = client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)
+
+	return count, err
+}
+
+var _ = Describe("Backend", func() {
+	var (
+		addr    string
+		zstPath string
+	)
+
+	BeforeEach(func() {
+		Expect(sqlitezstd.Init()).To(Succeed())
+
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+
+		hostKey, err := ssh.NewSignerFromKey(private)
+		Expect(err).ToNot(HaveOccurred())
+
+		addr = serve(hostKey)
+
+		sqlitezstd.RegisterBackend("sftp", &sftp.Backend{
+			HostKeyCallback: ssh.FixedHostKey(hostKey.PublicKey()),
+		})
+
+		zstPath = testhelper.CreateEntriesDB(GinkgoT(), 1000, sqlitezstd.WithPartSize(8192))
+	})
+
+	It("reads archives over ssh", func() {
+		count, err := countEntries(fmt.Sprintf("sftp://user:password@%s%s?vfs=zstd", addr, zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+
+		info, err := sqlitezstd.Inspect(fmt.Sprintf("sftp://user:password@%s%s", addr, zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.PageSize).To(Equal(4096))
+	})
+
+	It("errors for wrong passwords, unknown host keys, and missing files", func() {
+		_, err := sqlitezstd.Inspect(fmt.Sprintf("sftp://user:wrong@%s%s", addr, zstPath))
+		Expect(err).To(MatchError(ContainSubstring("unable to authenticate")))
+
+		_, err = sqlitezstd.Inspect(fmt.Sprintf("sftp://user:password@%s%s", addr, filepath.Join(filepath.Dir(zstPath), "missing.zst")))
+		Expect(err).To(MatchError(ContainSubstring("could not open file")))
+
+		sqlitezstd.RegisterBackend("sftp", &sftp.Backend{
+			HostKeyCallback: func(string, net.Addr, ssh.PublicKey) error {
+				return fmt.Errorf("unknown host")
+			},
+		})
+
+		_, err = sqlitezstd.Inspect(fmt.Sprintf("sftp://user:password@%s%s", addr, zstPath))
+		Expect(err).To(MatchError(ContainSubstring("unknown host")))
+	})
+})
@@ -1,20 +1,39 @@
 package sqlitezstd_test
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
 	"database/sql"
+	"embed"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	seekable "github.com/SaveTheRbtz/zstd-seekable-format-go"
 	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	"github.com/jtarchie/sqlitezstd/testhelper"
+	"github.com/klauspost/compress/zstd"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
-	"github.com/onsi/gomega/gexec"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/psanford/sqlite3vfs"
 )
 
 func TestSqliteZstd(t *testing.T) {
@@ -22,7 +41,7 @@ func TestSqliteZstd(t *testing.T) {
 	RunSpecs(t, "SqliteZstd Suite")
 }
 
-func createDatabase() string {
+func createSQLite() string {
 	buildPath, err := os.MkdirTemp("", "")
 	Expect(err).ToNot(HaveOccurred())
 
@@ -43,17 +62,45 @@ func createDatabase() string {
 		Expect(err).ToNot(HaveOccurred())
 	}
 
+	Expect(client.Close()).To(Succeed())
+
+	return dbPath
+}
+
+func createDatabase() string {
+	rows := make([]string, 0, 1000)
+	for id := 1; id <= 1000; id++ {
+		rows = append(rows, fmt.Sprintf("INSERT INTO entries (id) VALUES (%d)", id))
+	}
+
+	return testhelper.CreateCompressedDB(GinkgoT(), "CREATE TABLE entries (id INTEGER PRIMARY KEY);", rows)
+}
+
+// createForeignDatabase compresses with the seekable format library directly,
+// so the archive has none of the frames written by this package.
+func createForeignDatabase() string {
+	dbPath := createSQLite()
 	zstPath := dbPath + ".zst"
 
-	command := exec.Command(
-		"go", "run", "github.com/SaveTheRbtz/zstd-seekable-format-go/cmd/zstdseek",
-		"-f", dbPath,
-		"-o", zstPath,
-	)
+	contents, err := os.ReadFile(dbPath)
+	Expect(err).ToNot(HaveOccurred())
+
+	output, err := os.Create(zstPath)
+	Expect(err).ToNot(HaveOccurred())
+	defer output.Close()
+
+	encoder, err := zstd.NewWriter(nil)
+	Expect(err).ToNot(HaveOccurred())
 
-	session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+	writer, err := seekable.NewWriter(output, encoder)
 	Expect(err).ToNot(HaveOccurred())
-	Eventually(session).Should(gexec.Exit(0))
+
+	for offset := 0; offset < len(contents); offset += 4096 {
+		_, err = writer.Write(contents[offset:min(offset+4096, len(contents))])
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	Expect(writer.Close()).To(Succeed())
 
 	return zstPath
 }
@@ -106,6 +153,109 @@ var _ = Describe("SqliteZSTD", func() {
 		waiter.Wait()
 	})
 
+	It("opens a local archive replaced while shared again", func() {
+		zstPath := createDatabase()
+
+		client, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?vfs=zstd", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		connection, err := client.Conn(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		defer connection.Close()
+
+		var count int64
+		Expect(connection.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM entries;").Scan(&count)).To(Succeed())
+		Expect(count).To(BeEquivalentTo(1000))
+
+		rows := []string{}
+		for id := 1; id <= 10; id++ {
+			rows = append(rows, fmt.Sprintf("INSERT INTO entries (id) VALUES (%d)", id))
+		}
+
+		replacement := testhelper.CreateCompressedDB(GinkgoT(), "CREATE TABLE entries (id INTEGER PRIMARY KEY);", rows)
+		Expect(os.Rename(replacement, zstPath)).To(Succeed())
+
+		// the open connection keeps reading the archive it opened
+		Expect(countEntries(fmt.Sprintf("file:%s?vfs=zstd", zstPath))).To(BeEquivalentTo(10))
+		Expect(connection.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM entries;").Scan(&count)).To(Succeed())
+		Expect(count).To(BeEquivalentTo(1000))
+	})
+
+	It("opens a member of a local container replaced while shared again", func() {
+		bundlePath := filepath.Join(GinkgoT().TempDir(), "bundle.tar")
+
+		writeBundle := func(path string, contents []byte) {
+			bundle, err := os.Create(path)
+			Expect(err).ToNot(HaveOccurred())
+
+			writer := tar.NewWriter(bundle)
+			Expect(writer.WriteHeader(&tar.Header{Name: "db.sqlite.zst", Mode: 0o644, Size: int64(len(contents))})).To(Succeed())
+
+			_, err = writer.Write(contents)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(writer.Close()).To(Succeed())
+			Expect(bundle.Close()).To(Succeed())
+		}
+
+		contents, err := os.ReadFile(createDatabase())
+		Expect(err).ToNot(HaveOccurred())
+		writeBundle(bundlePath, contents)
+
+		dsn := bundlePath + "#db.sqlite.zst?vfs=zstd"
+
+		client, err := sql.Open("sqlite3", dsn)
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		connection, err := client.Conn(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		defer connection.Close()
+
+		var count int64
+		Expect(connection.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM entries;").Scan(&count)).To(Succeed())
+		Expect(count).To(BeEquivalentTo(1000))
+
+		rows := []string{}
+		for id := 1; id <= 10; id++ {
+			rows = append(rows, fmt.Sprintf("INSERT INTO entries (id) VALUES (%d)", id))
+		}
+
+		replacement, err := os.ReadFile(testhelper.CreateCompressedDB(GinkgoT(), "CREATE TABLE entries (id INTEGER PRIMARY KEY);", rows))
+		Expect(err).ToNot(HaveOccurred())
+
+		replacementPath := bundlePath + ".new"
+		writeBundle(replacementPath, replacement)
+		Expect(os.Rename(replacementPath, bundlePath)).To(Succeed())
+
+		Expect(countEntries(dsn)).To(BeEquivalentTo(10))
+		Expect(connection.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM entries;").Scan(&count)).To(Succeed())
+		Expect(count).To(BeEquivalentTo(1000))
+	})
+
+	It("does not open a local archive removed while shared", func() {
+		zstPath := createDatabase()
+
+		client, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?vfs=zstd", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		connection, err := client.Conn(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		defer connection.Close()
+
+		var count int64
+		Expect(connection.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM entries;").Scan(&count)).To(Succeed())
+
+		Expect(os.Remove(zstPath)).To(Succeed())
+
+		other, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?vfs=zstd", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		defer other.Close()
+
+		Expect(other.Ping()).ToNot(Succeed())
+	})
+
 	When("file does not exist", func() {
 		It("returns an error", func() {
 			client, err := sql.Open("sqlite3", "file:some.db?vfs=zstd")
@@ -117,6 +267,19 @@ var _ = Describe("SqliteZSTD", func() {
 		})
 	})
 
+	It("can read archives written by other seekable zstd tools", func() {
+		zstPath := createForeignDatabase()
+
+		client, err := sql.Open("sqlite3", fmt.Sprintf("%s?vfs=zstd", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		var count int64
+		err = client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+	})
+
 	It("allows reading from HTTP server", func() {
 		zstPath := createDatabase()
 		zstDir := filepath.Dir(zstPath)
@@ -135,4 +298,1114 @@ var _ = Describe("SqliteZSTD", func() {
 		Expect(err).ToNot(HaveOccurred())
 		Expect(count).To(BeEquivalentTo(1000))
 	})
+
+	It("counts reads with Stats", func() {
+		zstPath := createDatabase()
+
+		stats := &sqlitezstd.Stats{}
+
+		err := sqlitezstd.Register("zstd-stats", &sqlitezstd.ZstdVFS{Stats: stats})
+		Expect(err).ToNot(HaveOccurred())
+
+		info, err := os.Stat(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(countEntries(fmt.Sprintf("%s?vfs=zstd-stats", zstPath))).To(BeEquivalentTo(1000))
+		Expect(stats.Reads()).To(BeNumerically(">", 0))
+		Expect(stats.Hits()).To(BeNumerically(">", 0))
+		Expect(stats.Hits()).To(BeNumerically("<", stats.Reads()))
+		Expect(stats.HitRate()).To(BeNumerically("~", float64(stats.Hits())/float64(stats.Reads())))
+		Expect(stats.BytesRead()).To(BeNumerically(">", 0))
+		Expect(stats.BytesFetched()).To(BeNumerically(">", 0))
+		Expect(stats.BytesFetched()).To(BeNumerically("<=", 2*info.Size()))
+
+		stats.Reset()
+		Expect(stats.Reads()).To(BeZero())
+		Expect(stats.BytesFetched()).To(BeZero())
+	})
+
+	It("counts how the frames of every archive were found", func() {
+		zstPath := testhelper.CreateEntriesDB(GinkgoT(), 2000, sqlitezstd.WithFrameSize(4096))
+
+		stats := &sqlitezstd.Stats{}
+
+		err := sqlitezstd.Register("zstd-cache-stats", &sqlitezstd.ZstdVFS{Stats: stats, CacheSize: 16 << 10})
+		Expect(err).ToNot(HaveOccurred())
+
+		for range 2 {
+			client, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?vfs=zstd-cache-stats", zstPath))
+			Expect(err).ToNot(HaveOccurred())
+
+			var length int64
+			Expect(client.QueryRow("SELECT SUM(LENGTH(body)) FROM entries;").Scan(&length)).To(Succeed())
+			Expect(length).To(BeEquivalentTo(2000 * 64))
+			Expect(client.Close()).To(Succeed())
+		}
+
+		archives := stats.Archives()
+		Expect(archives).To(HaveKey(zstPath))
+
+		cache := archives[zstPath]
+		Expect(cache.Hits).To(BeNumerically(">", 0))
+		Expect(cache.Misses).To(BeNumerically(">", 0))
+		Expect(cache.HitRate()).To(BeNumerically("~", float64(cache.Hits)/float64(cache.Hits+cache.Misses)))
+		Expect(cache.BytesDecompressed).To(BeNumerically(">", 0))
+		Expect(cache.FramesEvicted).To(BeNumerically(">", 0))
+		Expect(cache.MemoryUsed).To(BeNumerically(">", 0))
+		Expect(cache.MemoryUsed).To(BeNumerically("<=", 16<<10))
+
+		stats.Reset()
+
+		cache = stats.Archives()[zstPath]
+		Expect(cache.Misses).To(BeZero())
+		Expect(cache.FramesEvicted).To(BeZero())
+		Expect(cache.MemoryUsed).To(BeNumerically(">", 0))
+	})
+
+	It("keeps the stats of every archive without a Stats", func() {
+		zstPath := createDatabase()
+		Expect(sqlitezstd.StatsFor(zstPath)).To(BeZero())
+
+		client, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?vfs=zstd&readahead=2", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+
+		var count int64
+		Expect(client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)).To(Succeed())
+		Expect(count).To(BeEquivalentTo(1000))
+
+		stats := sqlitezstd.StatsFor(fmt.Sprintf("file:%s?vfs=zstd", zstPath))
+		Expect(stats.Connections).To(BeEquivalentTo(1))
+		Expect(stats.Reads).To(BeNumerically(">", 0))
+		Expect(stats.BytesRead).To(BeNumerically(">=", stats.Reads*512))
+		Expect(stats.BytesFetched).To(BeNumerically(">", 0))
+		Expect(stats.FramesDecoded).To(BeNumerically(">", 0))
+		Expect(stats.BytesDecompressed).To(BeNumerically(">", stats.BytesFetched))
+		Expect(stats.DecodeTime).To(BeNumerically(">", 0))
+		Expect(stats.FetchTime).To(BeNumerically(">", 0))
+		Expect(stats.Hits).To(BeNumerically(">", 0))
+		Expect(stats.Hits).To(BeNumerically("<", stats.Reads))
+
+		Expect(client.Close()).To(Succeed())
+		Expect(sqlitezstd.StatsFor(zstPath).Connections).To(BeZero())
+		Expect(sqlitezstd.StatsFor(zstPath).Reads).To(Equal(stats.Reads))
+		Expect(sqlitezstd.AllStats()).To(HaveKeyWithValue(zstPath, sqlitezstd.StatsFor(zstPath)))
+	})
+
+	It("records the frames and pages read with a Heatmap", func() {
+		zstPath := testhelper.CreateEntriesDB(GinkgoT(), 1000, sqlitezstd.WithFrameSize(4096))
+
+		info, err := sqlitezstd.Inspect(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		heatmap := &sqlitezstd.Heatmap{}
+		Expect(sqlitezstd.Register("zstd-heatmap", &sqlitezstd.ZstdVFS{Heatmap: heatmap})).To(Succeed())
+
+		client, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?vfs=zstd-heatmap", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		var body string
+		Expect(client.QueryRow("SELECT body FROM entries WHERE id = 1000;").Scan(&body)).To(Succeed())
+
+		archives := heatmap.Archives()
+		Expect(archives).To(HaveKey(zstPath))
+
+		heat := archives[zstPath]
+		Expect(heat.PageSize).To(Equal(info.PageSize))
+		Expect(heat.Pages[0].Page).To(BeEquivalentTo(1))
+		Expect(heat.Pages[0].Reads).To(BeNumerically(">", 1))
+
+		// a point lookup reads a few pages of the archive
+		Expect(len(heat.Pages)).To(BeNumerically("<", int(info.DecompressedSize)/info.PageSize))
+		Expect(len(heat.Frames)).To(BeNumerically("<", len(info.Frames)))
+		Expect(heat.Frames[0].DecompressedOffset).To(BeZero())
+		Expect(heat.Frames[0].DecompressedSize).To(Equal(info.Frames[0].DecompressedSize))
+
+		var exported map[string]sqlitezstd.ArchiveHeat
+
+		buffer := &bytes.Buffer{}
+		Expect(heatmap.WriteJSON(buffer)).To(Succeed())
+		Expect(json.Unmarshal(buffer.Bytes(), &exported)).To(Succeed())
+		Expect(exported).To(Equal(archives))
+
+		buffer.Reset()
+		Expect(heatmap.WriteCSV(buffer)).To(Succeed())
+
+		records, err := csv.NewReader(buffer).ReadAll()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(records).To(HaveLen(1 + len(heat.Frames) + len(heat.Pages)))
+		Expect(records[0]).To(Equal([]string{"archive", "kind", "index", "offset", "size", "reads"}))
+		Expect(records).To(ContainElement([]string{zstPath, "page", "1", "0", fmt.Sprint(info.PageSize), fmt.Sprint(heat.Pages[0].Reads)}))
+
+		heatmap.Reset()
+		Expect(heatmap.Archives()[zstPath].Pages).To(BeEmpty())
+		Expect(heatmap.Archives()[zstPath].Frames).To(BeEmpty())
+	})
+
+	It("describes the archives of the connection with SQL functions", func() {
+		zstPath := createDatabase()
+		attachedPath := createDatabase()
+
+		info, err := sqlitezstd.Inspect(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		client, err := sql.Open(sqlitezstd.DriverName, fmt.Sprintf("file:%s?vfs=zstd", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		client.SetMaxOpenConns(1)
+
+		_, err = client.Exec(fmt.Sprintf("ATTACH DATABASE 'file:%s?vfs=zstd' AS other", attachedPath))
+		Expect(err).ToNot(HaveOccurred())
+
+		var (
+			version, source, attached string
+			ratio                     float64
+		)
+
+		err = client.QueryRow("SELECT zstd_vfs_version(), zstd_vfs_source(), zstd_vfs_ratio(), zstd_vfs_source('other')").Scan(&version, &source, &ratio, &attached)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(version).To(Equal("(devel)"))
+		Expect(source).To(Equal(zstPath))
+		Expect(ratio).To(BeNumerically("~", info.Ratio()))
+		Expect(attached).To(Equal(attachedPath))
+
+		// the temp schema is no archive
+		var missing sql.NullFloat64
+		Expect(client.QueryRow("SELECT zstd_vfs_ratio('temp')").Scan(&missing)).To(Succeed())
+		Expect(missing.Valid).To(BeFalse())
+	})
+
+	It("logs archives opening, closing, and why they could not be opened", func() {
+		zstPath := createDatabase()
+
+		output := gbytes.NewBuffer()
+		logger := slog.New(slog.NewTextHandler(output, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+		err := sqlitezstd.Register("zstd-logger", &sqlitezstd.ZstdVFS{Logger: logger})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(countEntries(fmt.Sprintf("file:%s?vfs=zstd-logger", zstPath))).To(BeEquivalentTo(1000))
+		Expect(output).To(gbytes.Say(`level=DEBUG msg="opened archive" name=%s frames=\d+`, zstPath))
+		Expect(output).To(gbytes.Say(`level=DEBUG msg="closed archive" name=%s`, zstPath))
+
+		client, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?vfs=zstd-logger&cold_start=maybe", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		Expect(client.Ping()).ToNot(Succeed())
+		Expect(output).To(gbytes.Say(`level=ERROR msg="could not open archive" name=%s error="invalid option: cold_start=\\"maybe\\""`, zstPath))
+
+		notArchive := filepath.Join(GinkgoT().TempDir(), "plain.sqlite")
+		Expect(os.WriteFile(notArchive, []byte("not an archive"), 0o600)).To(Succeed())
+
+		client, err = sql.Open("sqlite3", fmt.Sprintf("file:%s?vfs=zstd-logger", notArchive))
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		Expect(client.Ping()).ToNot(Succeed())
+		Expect(output).To(gbytes.Say(`level=ERROR msg="could not open archive" name=%s error=`, notArchive))
+	})
+
+	It("records every call SQLite makes with DebugVFS", func() {
+		zstPath := createDatabase()
+
+		output := gbytes.NewBuffer()
+
+		err := sqlitezstd.RegisterDebug("zstd-debug", &sqlitezstd.DebugVFS{VFS: &sqlitezstd.ZstdVFS{}, Output: output})
+		Expect(err).ToNot(HaveOccurred())
+
+		// the parameters of the DSN still reach the VFS wrapped
+		Expect(countEntries(fmt.Sprintf("file:%s?vfs=zstd-debug&cache_size=1MiB", zstPath))).To(BeEquivalentTo(1000))
+
+		Expect(output).To(gbytes.Say(`open name=%q flags=0x[0-9a-f]+ result=0x[0-9a-f]+ took=\S+\n`, zstPath))
+		Expect(output).To(gbytes.Say(`read name=%q offset=0 length=100 n=100 took=\S+\n`, zstPath))
+		Expect(output).To(gbytes.Say(`read name=%q offset=\d+ length=4096 n=4096 took=\S+\n`, zstPath))
+		Expect(output).To(gbytes.Say(`close name=%q took=\S+\n`, zstPath))
+
+		client, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?vfs=zstd-debug&overlay=nowhere", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		Expect(client.Ping()).ToNot(Succeed())
+		Expect(output).To(gbytes.Say(`open name=%q flags=0x[0-9a-f]+ result=0x0 took=\S+ error="sqlite \(14\) CantOpen Error"\n`, zstPath))
+	})
+
+	It("keeps whole frames or only the pages read, per archive", func() {
+		zstPath := createDatabase()
+
+		stats := &sqlitezstd.Stats{}
+
+		err := sqlitezstd.Register("zstd-policy", &sqlitezstd.ZstdVFS{Stats: stats})
+		Expect(err).ToNot(HaveOccurred())
+
+		dsn := fmt.Sprintf("file:%s?vfs=zstd-policy", zstPath)
+
+		// pages next to the ones read are read from the frame kept
+		Expect(countEntries(dsn + "&frame_policy=whole")).To(BeEquivalentTo(1000))
+		Expect(stats.Hits()).To(BeNumerically(">", 0))
+
+		stats.Reset()
+
+		Expect(countEntries(dsn + "&frame_policy=partial")).To(BeEquivalentTo(1000))
+		Expect(stats.Reads()).To(BeNumerically(">", 0))
+		Expect(stats.Hits()).To(BeZero())
+
+		client, err := sql.Open("sqlite3", dsn+"&frame_policy=some")
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		Expect(client.Ping()).ToNot(Succeed())
+	})
+
+	It("decompresses small databases into memory", func() {
+		zstPath := createDatabase()
+
+		stats := &sqlitezstd.Stats{}
+
+		err := sqlitezstd.Register("zstd-memory", &sqlitezstd.ZstdVFS{Stats: stats})
+		Expect(err).ToNot(HaveOccurred())
+
+		dsn := fmt.Sprintf("file:%s?vfs=zstd-memory&decompress=memory", zstPath)
+
+		client, err := sql.Open("sqlite3", dsn)
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		connection, err := client.Conn(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		defer connection.Close()
+
+		var count int64
+		Expect(connection.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM entries;").Scan(&count)).To(Succeed())
+		Expect(count).To(BeEquivalentTo(1000))
+		Expect(stats.Hits()).To(Equal(stats.Reads()))
+
+		// other connections read the database already in memory
+		stats.Reset()
+
+		Expect(countEntries(dsn)).To(BeEquivalentTo(1000))
+		Expect(stats.Reads()).To(BeNumerically(">", 0))
+		Expect(stats.BytesFetched()).To(BeZero())
+
+		for _, value := range []string{"decompress=memory&memory_limit=1KiB", "decompress=everything"} {
+			client, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?vfs=zstd-memory&%s", zstPath, value))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(client.Ping()).ToNot(Succeed())
+			Expect(client.Close()).To(Succeed())
+		}
+	})
+
+	It("decompresses databases into a temporary file until they close", func() {
+		zstPath := createDatabase()
+
+		tempDir := GinkgoT().TempDir()
+		GinkgoT().Setenv("TMPDIR", tempDir)
+
+		client, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?vfs=zstd&decompress=tempfile", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+
+		var count int64
+		Expect(client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)).To(Succeed())
+		Expect(count).To(BeEquivalentTo(1000))
+
+		files, err := filepath.Glob(filepath.Join(tempDir, "sqlitezstd-*.sqlite"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).To(HaveLen(1))
+
+		Expect(client.Close()).To(Succeed())
+		Expect(files[0]).ToNot(BeAnExistingFile())
+	})
+
+	It("shares decompressed frames between connections", func() {
+		zstPath := createDatabase()
+
+		stats := &sqlitezstd.Stats{}
+
+		err := sqlitezstd.Register("zstd-cached", &sqlitezstd.ZstdVFS{Stats: stats, CacheSize: 1 << 20})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(countEntries(fmt.Sprintf("%s?vfs=zstd-cached", zstPath))).To(BeEquivalentTo(1000))
+		Expect(stats.Hits()).To(BeNumerically("<", stats.Reads()))
+
+		stats.Reset()
+
+		Expect(countEntries(fmt.Sprintf("%s?vfs=zstd-cached", zstPath))).To(BeEquivalentTo(1000))
+		Expect(stats.Reads()).To(BeNumerically(">", 0))
+		Expect(stats.Hits()).To(Equal(stats.Reads()))
+	})
+
+	It("sizes the frame cache with a parameter or a default", func() {
+		zstPath := createDatabase()
+
+		stats := &sqlitezstd.Stats{}
+
+		err := sqlitezstd.Register("zstd-sized", &sqlitezstd.ZstdVFS{Stats: stats})
+		Expect(err).ToNot(HaveOccurred())
+
+		dsn := fmt.Sprintf("file:%s?vfs=zstd-sized", zstPath)
+
+		// without a size, every connection decompresses frames again
+		Expect(countEntries(dsn)).To(BeEquivalentTo(1000))
+		stats.Reset()
+		Expect(countEntries(dsn)).To(BeEquivalentTo(1000))
+		Expect(stats.Hits()).To(BeNumerically("<", stats.Reads()))
+
+		Expect(countEntries(dsn + "&cache_size=1MiB")).To(BeEquivalentTo(1000))
+		stats.Reset()
+		Expect(countEntries(dsn + "&cache_size=1MiB")).To(BeEquivalentTo(1000))
+		Expect(stats.Hits()).To(Equal(stats.Reads()))
+
+		sqlitezstd.SetDefaultCacheSize(1 << 20)
+		DeferCleanup(sqlitezstd.SetDefaultCacheSize, int64(0))
+
+		stats.Reset()
+		Expect(countEntries(dsn)).To(BeEquivalentTo(1000))
+		Expect(stats.Hits()).To(Equal(stats.Reads()))
+
+		for _, size := range []string{"lots", "-1MiB", "9000000000000GB", "9223372036854775807KiB"} {
+			client, err := sql.Open("sqlite3", dsn+"&cache_size="+size)
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(client.Close)
+
+			Expect(client.Ping()).ToNot(Succeed(), size)
+		}
+	})
+
+	It("reuses decoders while connections come and go", func() {
+		zstPath := createDatabase()
+
+		DeferCleanup(sqlitezstd.SetDecoderPoolSize, 16)
+
+		for _, size := range []int{0, 1, 16} {
+			sqlitezstd.SetDecoderPoolSize(size)
+
+			client, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?vfs=zstd", zstPath))
+			Expect(err).ToNot(HaveOccurred())
+
+			// every query opens and closes a connection
+			client.SetMaxOpenConns(4)
+			client.SetMaxIdleConns(0)
+
+			group := sync.WaitGroup{}
+
+			for range 4 {
+				group.Add(1)
+
+				go func() {
+					defer GinkgoRecover()
+					defer group.Done()
+
+					for range 10 {
+						var count int64
+
+						err := client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(count).To(BeEquivalentTo(1000))
+					}
+				}()
+			}
+
+			group.Wait()
+			Expect(client.Close()).To(Succeed())
+		}
+	})
+
+	It("reads local archives through io_uring", func() {
+		err := sqlitezstd.UseIOUring(true)
+		if errors.Is(err, sqlitezstd.ErrIOUringUnavailable) {
+			Skip("io_uring is unavailable")
+		}
+
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(sqlitezstd.UseIOUring, false)
+
+		zstPath := createDatabase()
+
+		client, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?vfs=zstd&frame_policy=partial", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		group := sync.WaitGroup{}
+
+		for range 16 {
+			group.Add(1)
+
+			go func() {
+				defer GinkgoRecover()
+				defer group.Done()
+
+				for range 20 {
+					var count int64
+
+					err := client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(count).To(BeEquivalentTo(1000))
+				}
+			}()
+		}
+
+		group.Wait()
+	})
+
+	It("tunes decoders with parameters", func() {
+		zstPath := createDatabase()
+
+		count := countEntries(fmt.Sprintf("file:%s?vfs=zstd&decoder_concurrency=2&decoder_low_memory=true&decoder_max_memory=64MiB", zstPath))
+		Expect(count).To(BeEquivalentTo(1000))
+
+		// frames larger than the memory of the decoder are not read
+		client, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?vfs=zstd&decoder_max_memory=1KiB", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		var entries int64
+		Expect(client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&entries)).ToNot(Succeed())
+
+		for _, value := range []string{"decoder_concurrency=-1", "decoder_low_memory=maybe", "decoder_max_memory=lots"} {
+			client, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?vfs=zstd&%s", zstPath, value))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(client.Ping()).ToNot(Succeed())
+			Expect(client.Close()).To(Succeed())
+		}
+	})
+
+	It("reads the seek table once for connections to the same archive", func() {
+		zstPath := createDatabase()
+
+		stats := &sqlitezstd.Stats{}
+
+		err := sqlitezstd.Register("zstd-tables", &sqlitezstd.ZstdVFS{Stats: stats})
+		Expect(err).ToNot(HaveOccurred())
+
+		dsn := fmt.Sprintf("file:%s?vfs=zstd-tables", zstPath)
+
+		ping := func() int64 {
+			stats.Reset()
+
+			client, err := sql.Open("sqlite3", dsn)
+			Expect(err).ToNot(HaveOccurred())
+			defer client.Close()
+
+			Expect(client.Ping()).To(Succeed())
+
+			return stats.BytesFetched()
+		}
+
+		first := ping()
+		Expect(ping()).To(BeNumerically("<", first))
+
+		// a replaced archive has its own seek table
+		replacement := testhelper.CreateCompressedDB(GinkgoT(), "CREATE TABLE entries (id INTEGER PRIMARY KEY);", []string{"INSERT INTO entries (id) VALUES (1)"})
+
+		contents, err := os.ReadFile(replacement)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.WriteFile(zstPath, contents, 0o600)).To(Succeed())
+
+		Expect(countEntries(dsn)).To(BeEquivalentTo(1))
+	})
+
+	It("decompresses frames ahead of sequential scans", func() {
+		zstPath := testhelper.CreateEntriesDB(GinkgoT(), 2000, sqlitezstd.WithFrameSize(4096))
+
+		stats := &sqlitezstd.Stats{}
+
+		err := sqlitezstd.Register("zstd-readahead", &sqlitezstd.ZstdVFS{Stats: stats})
+		Expect(err).ToNot(HaveOccurred())
+
+		scan := func(dsn string) float64 {
+			stats.Reset()
+
+			client, err := sql.Open("sqlite3", dsn)
+			Expect(err).ToNot(HaveOccurred())
+			defer client.Close()
+
+			var length int64
+
+			err = client.QueryRow("SELECT SUM(LENGTH(body)) FROM entries;").Scan(&length)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(length).To(BeEquivalentTo(2000 * 64))
+
+			return stats.HitRate()
+		}
+
+		dsn := fmt.Sprintf("file:%s?vfs=zstd-readahead", zstPath)
+
+		// frames read ahead are fetched before SQLite reads them
+		Expect(scan(dsn + "&readahead=4")).To(BeNumerically(">", scan(dsn)))
+
+		client, err := sql.Open("sqlite3", dsn+"&readahead=-1")
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(client.Close)
+
+		Expect(client.Ping()).ToNot(Succeed())
+	})
+
+	It("reads ahead the siblings of B-tree pages with adaptive readahead", func() {
+		// rows of the two tables take turns, so the pages of neither are
+		// contiguous
+		rows := make([]string, 0, 4000)
+		for id := 1; id <= 2000; id++ {
+			rows = append(rows,
+				fmt.Sprintf("INSERT INTO entries (id, body) VALUES (%d, hex(randomblob(32)))", id),
+				fmt.Sprintf("INSERT INTO others (id, body) VALUES (%d, hex(randomblob(32)))", id),
+			)
+		}
+
+		zstPath := testhelper.CreateCompressedDB(GinkgoT(), `
+			CREATE TABLE entries (id INTEGER PRIMARY KEY, body TEXT);
+			CREATE TABLE others (id INTEGER PRIMARY KEY, body TEXT);
+		`, rows, sqlitezstd.WithFrameSize(4096))
+
+		stats := &sqlitezstd.Stats{}
+
+		err := sqlitezstd.Register("zstd-adaptive", &sqlitezstd.ZstdVFS{Stats: stats})
+		Expect(err).ToNot(HaveOccurred())
+
+		scan := func(dsn string) float64 {
+			stats.Reset()
+
+			client, err := sql.Open("sqlite3", dsn)
+			Expect(err).ToNot(HaveOccurred())
+			defer client.Close()
+
+			var length int64
+
+			err = client.QueryRow("SELECT SUM(LENGTH(body)) FROM entries;").Scan(&length)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(length).To(BeEquivalentTo(2000 * 64))
+
+			return stats.HitRate()
+		}
+
+		dsn := fmt.Sprintf("file:%s?vfs=zstd-adaptive", zstPath)
+
+		// the scan is not sequential, so only siblings are read ahead
+		Expect(scan(dsn + "&readahead=auto")).To(BeNumerically(">", scan(dsn+"&readahead=4")))
+	})
+
+	It("reads a frame once for connections reading it at the same time", func() {
+		zstPath := testhelper.CreateEntriesDB(GinkgoT(), 1000, sqlitezstd.WithFrameSize(4096))
+
+		contents, err := os.ReadFile(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		reader := &slowReader{Reader: bytes.NewReader(contents)}
+
+		source, err := sqlitezstd.OpenReaderAt(reader, int64(len(contents)))
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(source.Close)
+
+		reader.reads.Store(0)
+		Expect(countEntries(source.DSN())).To(BeEquivalentTo(1000))
+
+		alone := reader.reads.Load()
+		reader.reads.Store(0)
+
+		group := sync.WaitGroup{}
+		start := make(chan struct{})
+
+		for range 8 {
+			group.Add(1)
+
+			go func() {
+				defer GinkgoRecover()
+				defer group.Done()
+
+				<-start
+				Expect(countEntries(source.DSN())).To(BeEquivalentTo(1000))
+			}()
+		}
+
+		close(start)
+		group.Wait()
+
+		// most frames were read by one connection for all of them
+		Expect(reader.reads.Load()).To(BeNumerically("<", 4*alone))
+	})
+
+	It("decompresses the frames of sequential reads together", func() {
+		zstPath := testhelper.CreateEntriesDB(GinkgoT(), 2000, sqlitezstd.WithFrameSize(4096))
+
+		contents, err := os.ReadFile(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		reader := &slowReader{Reader: bytes.NewReader(contents)}
+
+		source, err := sqlitezstd.OpenReaderAt(reader, int64(len(contents)))
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(source.Close)
+
+		scan := func(dsn string) int64 {
+			reader.reads.Store(0)
+
+			client, err := sql.Open("sqlite3", dsn)
+			Expect(err).ToNot(HaveOccurred())
+			defer client.Close()
+
+			var length int64
+
+			err = client.QueryRow("SELECT SUM(LENGTH(body)) FROM entries;").Scan(&length)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(length).To(BeEquivalentTo(2000 * 64))
+
+			return reader.reads.Load()
+		}
+
+		dsn := "file:" + source.DSN()
+
+		// the frames of a region are read from the archive at once
+		Expect(scan(dsn + "&coalesce_size=256KiB")).To(BeNumerically("<", scan(dsn)/4))
+
+		client, err := sql.Open("sqlite3", dsn+"&coalesce_size=lots")
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(client.Close)
+
+		Expect(client.Ping()).ToNot(Succeed())
+	})
+
+	It("decompresses the frames of a region on workers", func() {
+		zstPath := testhelper.CreateEntriesDB(GinkgoT(), 2000, sqlitezstd.WithFrameSize(4096))
+
+		for _, workers := range []int{1, 4, 64} {
+			client, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?vfs=zstd&coalesce_size=64KiB&decompress_workers=%d", zstPath, workers))
+			Expect(err).ToNot(HaveOccurred())
+
+			var length int64
+
+			err = client.QueryRow("SELECT SUM(LENGTH(body)) FROM entries NOT INDEXED;").Scan(&length)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(length).To(BeEquivalentTo(2000 * 64))
+			Expect(client.Close()).To(Succeed())
+		}
+
+		client, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?vfs=zstd&coalesce_size=64KiB&decompress_workers=-1", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(client.Close)
+
+		Expect(client.Ping()).ToNot(Succeed())
+	})
+
+	It("reads pages of cached frames without allocating", func() {
+		zstPath := testhelper.CreateEntriesDB(GinkgoT(), 1000, sqlitezstd.WithFrameSize(4096))
+
+		vfs := &sqlitezstd.ZstdVFS{Stats: &sqlitezstd.Stats{}, CacheSize: 1 << 20}
+
+		file, _, err := vfs.Open(zstPath, sqlite3vfs.OpenMainDB|sqlite3vfs.OpenReadOnly)
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(file.Close)
+
+		size, err := file.FileSize()
+		Expect(err).ToNot(HaveOccurred())
+
+		page := make([]byte, 4096)
+		pages := size / int64(len(page))
+
+		for index := range pages {
+			_, err = file.ReadAt(page, index*int64(len(page)))
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		index := int64(0)
+
+		allocations := testing.AllocsPerRun(100, func() {
+			_, _ = file.ReadAt(page, index%pages*int64(len(page)))
+			index++
+		})
+		Expect(allocations).To(BeZero())
+	})
+
+	It("reads pages of partial frames into reused buffers", func() {
+		dbPath := testhelper.CreateSQLite(GinkgoT(), testhelper.EntriesSchema, testhelper.Entries(1000))
+		zstPath := dbPath + ".zst"
+
+		err := sqlitezstd.CompressFile(dbPath, zstPath, sqlitezstd.WithFrameSize(16384))
+		Expect(err).ToNot(HaveOccurred())
+
+		contents, err := os.ReadFile(dbPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		vfs := &sqlitezstd.ZstdVFS{PartialFrames: true}
+		pages := int64(len(contents) / 4096)
+
+		// buffers reused by other reads at once hold none of their pages
+		group := sync.WaitGroup{}
+
+		for worker := range 8 {
+			group.Add(1)
+
+			go func() {
+				defer GinkgoRecover()
+				defer group.Done()
+
+				file, _, err := vfs.Open(zstPath, sqlite3vfs.OpenMainDB|sqlite3vfs.OpenReadOnly)
+				Expect(err).ToNot(HaveOccurred())
+				defer file.Close()
+
+				page := make([]byte, 4096)
+
+				for index := range 200 {
+					offset := int64(worker*31+index*7) % pages * int64(len(page))
+
+					_, err = file.ReadAt(page, offset)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(page).To(Equal(contents[offset : offset+int64(len(page))]))
+				}
+			}()
+		}
+
+		group.Wait()
+	})
+
+	It("finds the frames of reads across small, unaligned frames", func() {
+		dbPath := testhelper.CreateSQLite(GinkgoT(), testhelper.EntriesSchema, testhelper.Entries(1000))
+		zstPath := dbPath + ".zst"
+
+		err := sqlitezstd.CompressFile(dbPath, zstPath, sqlitezstd.WithFrameSize(1000), sqlitezstd.WithPageAlignment(false))
+		Expect(err).ToNot(HaveOccurred())
+
+		contents, err := os.ReadFile(dbPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		vfs := &sqlitezstd.ZstdVFS{}
+
+		file, _, err := vfs.Open(zstPath, sqlite3vfs.OpenMainDB|sqlite3vfs.OpenReadOnly)
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(file.Close)
+
+		// reads start and end anywhere in frames, and span several
+		read := make([]byte, 2777)
+
+		for off := 0; off < len(contents); off += 1333 {
+			count, _ := file.ReadAt(read, int64(off))
+			Expect(read[:count]).To(Equal(contents[off:min(off+len(read), len(contents))]))
+		}
+	})
+})
+
+// slowReader counts its reads and slows them down, so the reads of
+// connections overlap.
+type slowReader struct {
+	*bytes.Reader
+
+	reads atomic.Int64
+}
+
+func (s *slowReader) ReadAt(p []byte, off int64) (int, error) {
+	s.reads.Add(1)
+	time.Sleep(5 * time.Millisecond)
+
+	return s.Reader.ReadAt(p, off)
+}
+
+var _ = Describe("OpenReaderAt", func() {
+	BeforeEach(func() {
+		err := sqlitezstd.Init()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("opens an archive from any io.ReaderAt", func() {
+		contents, err := os.ReadFile(createDatabase())
+		Expect(err).ToNot(HaveOccurred())
+
+		source, err := sqlitezstd.OpenReaderAt(bytes.NewReader(contents), int64(len(contents)))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(countEntries(source.DSN())).To(BeEquivalentTo(1000))
+		Expect(countEntries(fmt.Sprintf("file:%s?vfs=zstd&overlay=memory", source.Name()))).To(BeEquivalentTo(1000))
+
+		info, err := sqlitezstd.Inspect(source.Name())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.PageSize).To(Equal(4096))
+
+		Expect(source.Close()).To(Succeed())
+
+		_, err = sqlitezstd.Inspect(source.Name())
+		Expect(err).To(MatchError(sqlitezstd.ErrSourceClosed))
+	})
+
+	It("errors for readers without an archive", func() {
+		_, err := sqlitezstd.OpenReaderAt(strings.NewReader("not an archive"), 14)
+		Expect(err).To(MatchError(sqlitezstd.ErrInvalidSeekTable))
+	})
+})
+
+var _ = Describe("Warm", func() {
+	It("decompresses the frames of the schema and indexes before the first query", func() {
+		zstPath := testhelper.CreateCompressedDB(GinkgoT(), `
+			CREATE TABLE entries (id INTEGER PRIMARY KEY, body TEXT);
+			CREATE INDEX entries_body ON entries (body);
+		`, testhelper.Entries(1000), sqlitezstd.WithFrameSize(4096))
+
+		stats := &sqlitezstd.Stats{}
+
+		err := sqlitezstd.Register("zstd-warm", &sqlitezstd.ZstdVFS{Stats: stats, CacheSize: 1 << 20})
+		Expect(err).ToNot(HaveOccurred())
+
+		dsn := fmt.Sprintf("file:%s?vfs=zstd-warm", zstPath)
+
+		warmed, err := sql.Open("sqlite3", dsn)
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(warmed.Close)
+
+		err = sqlitezstd.Warm(warmed, sqlitezstd.WarmOptions{Indexes: []string{"entries_body"}})
+		Expect(err).ToNot(HaveOccurred())
+
+		err = sqlitezstd.Warm(warmed, sqlitezstd.WarmOptions{Tables: []string{"missing"}})
+		Expect(err).To(MatchError(sqlitezstd.ErrUnknownObject))
+
+		// another connection finds every frame it reads decompressed
+		client, err := sql.Open("sqlite3", dsn)
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(client.Close)
+
+		stats.Reset()
+
+		var count int64
+
+		err = client.QueryRow("SELECT COUNT(*) FROM entries WHERE body >= '8'").Scan(&count)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeNumerically(">", 0))
+
+		Expect(stats.Reads()).To(BeNumerically(">", 0))
+		Expect(stats.Hits()).To(Equal(stats.Reads()))
+	})
+
+	It("pre-opens a database for the queries after", func() {
+		Expect(sqlitezstd.Init()).To(Succeed())
+
+		zstPath := createDatabase()
+		handler, serverURL := serveOrigin(filepath.Dir(zstPath))
+
+		db, err := sqlitezstd.Preopen(fmt.Sprintf("file:%s/%s?vfs=zstd&cold_start=true&cache_size=1MiB", serverURL, filepath.Base(zstPath)), sqlitezstd.WarmOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(db.Close)
+
+		ranges := handler.ranges.Load()
+		Expect(ranges).To(BeNumerically(">", 0))
+
+		var count int64
+
+		err = db.QueryRow("SELECT COUNT(*) FROM entries").Scan(&count)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+		Expect(handler.ranges.Load()).To(Equal(ranges))
+
+		_, err = sqlitezstd.Preopen(fmt.Sprintf("file:%s/missing.sqlite.zst?vfs=zstd", serverURL), sqlitezstd.WarmOptions{})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+// seekOnlyFS hides io.ReaderAt from the files of an fs.FS.
+type seekOnlyFS struct {
+	fs.FS
+}
+
+func (s seekOnlyFS) Open(name string) (fs.File, error) {
+	file, err := s.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	//nolint: forcetypeassert
+	return struct {
+		fs.File
+		io.Seeker
+	}{file, file.(io.Seeker)}, nil
+}
+
+var _ = Describe("RegisterFS", func() {
+	BeforeEach(func() {
+		err := sqlitezstd.Init()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("opens archives from an fs.FS by name", func() {
+		contents, err := os.ReadFile(createDatabase())
+		Expect(err).ToNot(HaveOccurred())
+
+		files := fstest.MapFS{"data/db.sqlite.zst": &fstest.MapFile{Data: contents}}
+
+		sqlitezstd.RegisterFS("mapfs", files)
+		Expect(countEntries("mapfs://data/db.sqlite.zst?vfs=zstd")).To(BeEquivalentTo(1000))
+
+		sqlitezstd.RegisterFS("seekfs", seekOnlyFS{files})
+		Expect(countEntries("seekfs://data/db.sqlite.zst?vfs=zstd")).To(BeEquivalentTo(1000))
+
+		_, err = sqlitezstd.Inspect("mapfs://data/missing.zst")
+		Expect(err).To(MatchError(fs.ErrNotExist))
+	})
+
+	It("opens archives split into parts", func() {
+		dbPath := createSQLite()
+		zstPath := dbPath + ".zst"
+
+		err := sqlitezstd.CompressFile(dbPath, zstPath, sqlitezstd.WithPartSize(4096))
+		Expect(err).ToNot(HaveOccurred())
+
+		sqlitezstd.RegisterFS("dirfs", os.DirFS(filepath.Dir(zstPath)))
+		Expect(countEntries(fmt.Sprintf("dirfs://%s?vfs=zstd", filepath.Base(zstPath)))).To(BeEquivalentTo(1000))
+	})
+})
+
+var _ = Describe("Containers", func() {
+	BeforeEach(func() {
+		err := sqlitezstd.Init()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("opens archives stored in a zip file", func() {
+		contents, err := os.ReadFile(createDatabase())
+		Expect(err).ToNot(HaveOccurred())
+
+		bundlePath := filepath.Join(GinkgoT().TempDir(), "bundle.zip")
+
+		bundle, err := os.Create(bundlePath)
+		Expect(err).ToNot(HaveOccurred())
+
+		writer := zip.NewWriter(bundle)
+
+		for _, member := range []struct {
+			name   string
+			method uint16
+		}{
+			{"README.md", zip.Deflate},
+			{"data/db.sqlite.zst", zip.Store},
+			{"data/deflated.sqlite.zst", zip.Deflate},
+		} {
+			output, err := writer.CreateHeader(&zip.FileHeader{Name: member.name, Method: member.method})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = output.Write(contents)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		Expect(writer.Close()).To(Succeed())
+		Expect(bundle.Close()).To(Succeed())
+
+		Expect(countEntries(bundlePath + "#data/db.sqlite.zst?vfs=zstd")).To(BeEquivalentTo(1000))
+
+		server := httptest.NewServer(http.FileServer(http.Dir(filepath.Dir(bundlePath))))
+		defer server.Close()
+
+		Expect(countEntries(server.URL + "/bundle.zip#data/db.sqlite.zst?vfs=zstd")).To(BeEquivalentTo(1000))
+
+		_, err = sqlitezstd.Inspect(bundlePath + "#data/deflated.sqlite.zst")
+		Expect(err).To(MatchError(sqlitezstd.ErrMemberNotStored))
+
+		_, err = sqlitezstd.Inspect(bundlePath + "#data/missing.sqlite.zst")
+		Expect(err).To(MatchError(sqlitezstd.ErrMemberNotFound))
+	})
+
+	It("opens archives inside a tar file", func() {
+		contents, err := os.ReadFile(createDatabase())
+		Expect(err).ToNot(HaveOccurred())
+
+		bundlePath := filepath.Join(GinkgoT().TempDir(), "bundle.tar")
+
+		bundle, err := os.Create(bundlePath)
+		Expect(err).ToNot(HaveOccurred())
+
+		writer := tar.NewWriter(bundle)
+
+		for _, member := range []struct {
+			name     string
+			contents []byte
+		}{
+			{"README.md", []byte("docs")},
+			{"data/other.bin", bytes.Repeat([]byte{1}, 1<<20)},
+			{"data/db.sqlite.zst", contents},
+		} {
+			err = writer.WriteHeader(&tar.Header{Name: member.name, Mode: 0o644, Size: int64(len(member.contents))})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = writer.Write(member.contents)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		Expect(writer.Close()).To(Succeed())
+		Expect(bundle.Close()).To(Succeed())
+
+		Expect(countEntries(bundlePath + "#data/db.sqlite.zst?vfs=zstd")).To(BeEquivalentTo(1000))
+
+		server := httptest.NewServer(http.FileServer(http.Dir(filepath.Dir(bundlePath))))
+		defer server.Close()
+
+		Expect(countEntries(server.URL + "/bundle.tar#/data/db.sqlite.zst?vfs=zstd")).To(BeEquivalentTo(1000))
+
+		_, err = sqlitezstd.Inspect(bundlePath + "#data/missing.sqlite.zst")
+		Expect(err).To(MatchError(sqlitezstd.ErrMemberNotFound))
+	})
+})
+
+// sizedFile is an os.File returned by a test backend.
+type sizedFile struct {
+	*os.File
+
+	size int64
+}
+
+func (s *sizedFile) Size() int64 {
+	return s.size
+}
+
+var _ = Describe("RegisterScheme", func() {
+	BeforeEach(func() {
+		err := sqlitezstd.Init()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("opens archives with a function registered for a scheme", func() {
+		zstPath := createDatabase()
+
+		var queries []url.Values
+
+		sqlitezstd.RegisterScheme("local", func(uri *url.URL) (sqlitezstd.Object, error) {
+			queries = append(queries, uri.Query())
+
+			file, err := os.Open(uri.Path)
+			if err != nil {
+				return nil, err
+			}
+
+			info, err := file.Stat()
+			if err != nil {
+				_ = file.Close()
+
+				return nil, err
+			}
+
+			return &sizedFile{File: file, size: info.Size()}, nil
+		})
+
+		Expect(countEntries("local://" + zstPath + "?vfs=zstd")).To(BeEquivalentTo(1000))
+		Expect(countEntries("file:local://" + zstPath + "?vfs=zstd&label=reports")).To(BeEquivalentTo(1000))
+		Expect(queries[len(queries)-1].Get("label")).To(Equal("reports"))
+
+		_, err := sqlitezstd.Inspect("local:///missing.zst")
+		Expect(err).To(MatchError(fs.ErrNotExist))
+	})
+})
+
+//go:embed testdata/entries.sqlite.zst
+var embedded embed.FS
+
+var _ = Describe("OpenEmbedded", func() {
+	It("opens an archive compiled into the binary", func() {
+		client, err := sqlitezstd.OpenEmbedded(embedded, "testdata/entries.sqlite.zst")
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		var count int64
+		err = client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+	})
+
+	It("errors for missing files", func() {
+		_, err := sqlitezstd.OpenEmbedded(embedded, "testdata/missing.sqlite.zst")
+		Expect(err).To(MatchError(fs.ErrNotExist))
+	})
 })
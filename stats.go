@@ -0,0 +1,225 @@
+package sqlitezstd
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats counts the reads SQLite makes through a ZstdVFS. Set ZstdVFS.Stats to
+// find out how much of an archive a workload fetches, which matters most for
+// remote archives, where every fetch is a request.
+type Stats struct {
+	reads        atomic.Int64
+	hits         atomic.Int64
+	bytesRead    atomic.Int64
+	bytesFetched atomic.Int64
+
+	mutex    sync.Mutex
+	archives map[string]*cacheMetrics
+}
+
+// CacheStats is how the frames of an archive were found, so cache_size can
+// be sized from what a workload reads.
+type CacheStats struct {
+	// Hits is the number of frames read already decompressed, kept by a
+	// cache, read ahead, or in a coalesced region.
+	Hits int64
+	// Misses is the number of frames a read waited to be decompressed.
+	Misses int64
+	// BytesDecompressed is the number of bytes of frames decompressed,
+	// including those read ahead.
+	BytesDecompressed int64
+	// FramesEvicted is the number of frames dropped from the frame cache
+	// to make room for others.
+	FramesEvicted int64
+	// MemoryUsed is the number of bytes of frames the frame cache holds now.
+	MemoryUsed int64
+}
+
+// HitRate is the fraction of frames read that were hits.
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Reads is the number of reads SQLite made.
+func (s *Stats) Reads() int64 {
+	return s.reads.Load()
+}
+
+// Hits is the number of reads served from an already decompressed frame,
+// without fetching from the archive.
+func (s *Stats) Hits() int64 {
+	return s.hits.Load()
+}
+
+// HitRate is the fraction of reads that were hits.
+func (s *Stats) HitRate() float64 {
+	reads := s.Reads()
+	if reads == 0 {
+		return 0
+	}
+
+	return float64(s.Hits()) / float64(reads)
+}
+
+// BytesRead is the number of uncompressed bytes returned to SQLite.
+func (s *Stats) BytesRead() int64 {
+	return s.bytesRead.Load()
+}
+
+// BytesFetched is the number of compressed bytes read from archives,
+// including their seek tables.
+func (s *Stats) BytesFetched() int64 {
+	return s.bytesFetched.Load()
+}
+
+// Reset sets every count back to zero.
+func (s *Stats) Reset() {
+	s.reads.Store(0)
+	s.hits.Store(0)
+	s.bytesRead.Store(0)
+	s.bytesFetched.Store(0)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, metrics := range s.archives {
+		metrics.hits.Store(0)
+		metrics.misses.Store(0)
+		metrics.bytesDecompressed.Store(0)
+		metrics.evicted.Store(0)
+	}
+}
+
+// Archives returns the cache stats of every archive read, by its name
+// without its query. The memory used is not reset by Reset.
+func (s *Stats) Archives() map[string]CacheStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stats := make(map[string]CacheStats, len(s.archives))
+
+	for name, metrics := range s.archives {
+		stats[name] = CacheStats{
+			Hits:              metrics.hits.Load(),
+			Misses:            metrics.misses.Load(),
+			BytesDecompressed: metrics.bytesDecompressed.Load(),
+			FramesEvicted:     metrics.evicted.Load(),
+			MemoryUsed:        metrics.memory.Load(),
+		}
+	}
+
+	return stats
+}
+
+// archive returns the metrics shared by every connection to the archive
+// name, or nil without stats.
+func (s *Stats) archive(name string) *cacheMetrics {
+	if s == nil {
+		return nil
+	}
+
+	location, _, _ := strings.Cut(name, "?")
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.archives == nil {
+		s.archives = map[string]*cacheMetrics{}
+	}
+
+	metrics, ok := s.archives[location]
+	if !ok {
+		metrics = &cacheMetrics{}
+		s.archives[location] = metrics
+	}
+
+	return metrics
+}
+
+// cacheMetrics counts how the frames of an archive were found. Its
+// methods do nothing on nil, for archives read without stats.
+type cacheMetrics struct {
+	hits              atomic.Int64
+	misses            atomic.Int64
+	bytesDecompressed atomic.Int64
+	evicted           atomic.Int64
+	memory            atomic.Int64
+}
+
+func (m *cacheMetrics) hit() {
+	if m != nil {
+		m.hits.Add(1)
+	}
+}
+
+func (m *cacheMetrics) miss() {
+	if m != nil {
+		m.misses.Add(1)
+	}
+}
+
+// decompressed counts the bytes of a frame decompressed, once however
+// many reads wait for it.
+func (m *cacheMetrics) decompressed(size int) {
+	if m != nil {
+		m.bytesDecompressed.Add(int64(size))
+	}
+}
+
+// countingReader counts the bytes read from an archive.
+type countingReader struct {
+	*archive
+
+	stats    *Stats
+	counters *archiveCounters
+	fetched  atomic.Int64
+}
+
+func (c *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	started := time.Now()
+	count, err := c.archive.ReadAt(p, off)
+	c.fetched.Add(int64(count))
+	c.counters.fetched(count, started)
+
+	if c.stats != nil {
+		c.stats.bytesFetched.Add(int64(count))
+	}
+
+	return count, err
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	started := time.Now()
+	count, err := c.archive.Read(p)
+	c.fetched.Add(int64(count))
+	c.counters.fetched(count, started)
+
+	if c.stats != nil {
+		c.stats.bytesFetched.Add(int64(count))
+	}
+
+	return count, err
+}
+
+// cached counts bytes of frames the frame cache took, or gave up when
+// negative.
+func (m *cacheMetrics) cached(size int) {
+	if m != nil {
+		m.memory.Add(int64(size))
+	}
+}
+
+// evicted counts a frame dropped from the frame cache to make room.
+func (m *cacheMetrics) evict(size int) {
+	if m != nil {
+		m.evicted.Add(1)
+		m.memory.Add(-int64(size))
+	}
+}
@@ -0,0 +1,389 @@
+package sqlitezstd
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/psanford/sqlite3vfs"
+)
+
+const streamVFSName = "zstd-stream"
+
+// streamInto compresses the database written by write to dst. It is called
+// with a DSN that opens an empty database, whose frames are compressed in
+// memory as soon as all of their pages are written, so neither an
+// uncompressed copy nor a file twice the size of the database is needed.
+func streamInto(dst string, pageSize int, opts []Option, write func(dsn string) error) error {
+	config, err := newOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	// completed frames are tracked by the pages written to them
+	config.pageAlign = true
+
+	err = registerStream()
+	if err != nil {
+		return err
+	}
+
+	target, err := newStreamTarget(config, config.header(pageSize))
+	if err != nil {
+		return err
+	}
+	defer target.close()
+
+	name := fmt.Sprintf("%s.%d.stream", dst, streamTargetIDs.Add(1))
+
+	streamTargetsMutex.Lock()
+	streamTargets[name] = target
+	streamTargetsMutex.Unlock()
+
+	defer func() {
+		streamTargetsMutex.Lock()
+		delete(streamTargets, name)
+		streamTargetsMutex.Unlock()
+	}()
+
+	err = write(fileDSN(name, "vfs="+streamVFSName))
+	if err != nil {
+		return err
+	}
+
+	return target.finish(dst, opts)
+}
+
+//nolint: gochecknoglobals
+var (
+	streamTargets      = map[string]*streamTarget{}
+	streamTargetsMutex sync.Mutex
+	streamTargetIDs    atomic.Int64
+
+	registerStream = sync.OnceValue(func() error {
+		err := sqlite3vfs.RegisterVFS(streamVFSName, &streamVFS{})
+		if err != nil {
+			return fmt.Errorf("could not register vfs: %w", err)
+		}
+
+		return nil
+	})
+)
+
+// streamVFS only opens the targets of a running VacuumInto or BackupTo.
+type streamVFS struct{}
+
+var _ sqlite3vfs.VFS = &streamVFS{}
+
+func (v *streamVFS) Access(name string, flags sqlite3vfs.AccessFlag) (bool, error) {
+	return false, nil
+}
+
+func (v *streamVFS) Delete(name string, dirSync bool) error {
+	return nil
+}
+
+func (v *streamVFS) FullPathname(name string) string {
+	return name
+}
+
+func (v *streamVFS) Open(name string, flags sqlite3vfs.OpenFlag) (sqlite3vfs.File, sqlite3vfs.OpenFlag, error) {
+	if flags&sqlite3vfs.OpenMainDB == 0 {
+		return &memoryFile{}, flags, nil
+	}
+
+	streamTargetsMutex.Lock()
+	target, ok := streamTargets[name]
+	streamTargetsMutex.Unlock()
+
+	if !ok {
+		return nil, 0, sqlite3vfs.CantOpenError
+	}
+
+	return target, flags, nil
+}
+
+// streamFrame is a frame of the target database, either
+// still being written or already compressed.
+type streamFrame struct {
+	raw        []byte
+	written    []bool
+	pending    int
+	compressed []byte
+	entry      seekTableEntry
+}
+
+// streamTarget is a database being written by SQLite, kept as compressed frames.
+type streamTarget struct {
+	mutex   sync.Mutex
+	header  *Header
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	frames  map[int64]*streamFrame
+	length  int64
+}
+
+var _ sqlite3vfs.File = &streamTarget{}
+
+func newStreamTarget(config *options, header *Header) (*streamTarget, error) {
+	if header.PageSize == 0 {
+		return nil, fmt.Errorf("%w: unknown page size", ErrInvalidDatabase)
+	}
+
+	encoder, err := config.encoder()
+	if err != nil {
+		return nil, fmt.Errorf("could not create encoder: %w", err)
+	}
+
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create decoder: %w", err)
+	}
+
+	return &streamTarget{
+		header:  header,
+		encoder: encoder,
+		decoder: decoder,
+		frames:  map[int64]*streamFrame{},
+	}, nil
+}
+
+// frame returns the uncompressed frame at index, decompressing it if needed.
+func (v *streamTarget) frame(index int64) (*streamFrame, error) {
+	current, ok := v.frames[index]
+	if !ok {
+		current = &streamFrame{
+			raw:     make([]byte, v.header.FrameSize),
+			written: make([]bool, v.header.FrameSize/v.header.PageSize),
+			pending: v.header.FrameSize / v.header.PageSize,
+		}
+		v.frames[index] = current
+	}
+
+	if current.raw == nil {
+		raw, err := v.decoder.DecodeAll(current.compressed, make([]byte, 0, v.header.FrameSize))
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress frame: %w", err)
+		}
+
+		current.raw = raw
+		current.compressed = nil
+	}
+
+	return current, nil
+}
+
+func (v *streamTarget) ReadAt(p []byte, off int64) (int, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	frameSize := int64(v.header.FrameSize)
+	read := 0
+
+	for read < len(p) && off+int64(read) < v.length {
+		position := off + int64(read)
+		size := min(int64(len(p)-read), frameSize-position%frameSize, v.length-position)
+
+		current, err := v.frame(position / frameSize)
+		if err != nil {
+			return read, sqlite3vfs.IOErrorRead
+		}
+
+		read += copy(p[read:int64(read)+size], current.raw[position%frameSize:])
+	}
+
+	if read < len(p) {
+		return read, io.EOF
+	}
+
+	return read, nil
+}
+
+func (v *streamTarget) WriteAt(p []byte, off int64) (int, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	frameSize := int64(v.header.FrameSize)
+	pageSize := int64(v.header.PageSize)
+	written := 0
+
+	for written < len(p) {
+		position := off + int64(written)
+		index := position / frameSize
+		start := position % frameSize
+		size := min(int64(len(p)-written), frameSize-start)
+
+		current, err := v.frame(index)
+		if err != nil {
+			return written, sqlite3vfs.IOErrorWrite
+		}
+
+		copy(current.raw[start:], p[written:int64(written)+size])
+
+		// only pages that are written as a whole complete the frame
+		for page := (start + pageSize - 1) / pageSize; (page+1)*pageSize <= start+size; page++ {
+			if !current.written[page] {
+				current.written[page] = true
+				current.pending--
+			}
+		}
+
+		if current.pending == 0 {
+			v.compress(current)
+		}
+
+		written += int(size)
+	}
+
+	v.length = max(v.length, off+int64(len(p)))
+
+	return written, nil
+}
+
+func (v *streamTarget) compress(current *streamFrame) {
+	current.compressed = v.encoder.EncodeAll(current.raw, nil)
+	current.entry = seekTableEntry{
+		//nolint: gosec
+		compressedSize: uint32(len(current.compressed)),
+		//nolint: gosec
+		decompressedSize: uint32(len(current.raw)),
+		checksum:         frameChecksum(current.raw),
+	}
+	current.raw = nil
+}
+
+func (v *streamTarget) Truncate(size int64) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	frameSize := int64(v.header.FrameSize)
+
+	for index := range v.frames {
+		if index*frameSize >= size {
+			delete(v.frames, index)
+		}
+	}
+
+	if size%frameSize != 0 {
+		current, err := v.frame(size / frameSize)
+		if err != nil {
+			return sqlite3vfs.IOError
+		}
+
+		clear(current.raw[size%frameSize:])
+	}
+
+	v.length = size
+
+	return nil
+}
+
+// finish writes the compressed database to dst.
+func (v *streamTarget) finish(dst string, opts []Option) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	return createArchive(dst, opts, func(output io.Writer) error {
+		return v.write(output, opts)
+	})
+}
+
+// write compresses the frames that are not compressed yet
+// and writes the archive to output.
+func (v *streamTarget) write(output io.Writer, opts []Option) error {
+	writer, _ := NewWriter(output, append(opts, WithFrameSize(v.header.FrameSize))...).(*writer)
+	if writer.err != nil {
+		return writer.err
+	}
+
+	err := writer.start(v.header)
+	if err != nil {
+		return err
+	}
+
+	frameSize := int64(v.header.FrameSize)
+
+	for index := int64(0); index*frameSize < v.length; index++ {
+		size := min(frameSize, v.length-index*frameSize)
+
+		current, ok := v.frames[index]
+		if ok && current.compressed != nil && size == frameSize {
+			// decompressed again only to be hashed for the metadata
+			raw, err := v.decoder.DecodeAll(current.compressed, nil)
+			if err != nil {
+				return fmt.Errorf("could not decompress frame: %w", err)
+			}
+
+			err = writer.writeCompressed(current.compressed, current.entry, raw)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		current, err = v.frame(index)
+		if err != nil {
+			return err
+		}
+
+		_, err = writer.write(current.raw[:size])
+		if err != nil {
+			return err
+		}
+
+		err = writer.flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (v *streamTarget) close() {
+	_ = v.encoder.Close()
+	v.decoder.Close()
+}
+
+func (v *streamTarget) Close() error {
+	return nil
+}
+
+func (v *streamTarget) Sync(flag sqlite3vfs.SyncType) error {
+	return nil
+}
+
+func (v *streamTarget) FileSize() (int64, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	return v.length, nil
+}
+
+func (v *streamTarget) Lock(elock sqlite3vfs.LockType) error {
+	return nil
+}
+
+func (v *streamTarget) Unlock(elock sqlite3vfs.LockType) error {
+	return nil
+}
+
+func (v *streamTarget) CheckReservedLock() (bool, error) {
+	return false, nil
+}
+
+func (v *streamTarget) SectorSize() int64 {
+	return 0
+}
+
+func (v *streamTarget) DeviceCharacteristics() sqlite3vfs.DeviceCharacteristic {
+	return 0
+}
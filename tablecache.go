@@ -0,0 +1,166 @@
+package sqlitezstd
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/SaveTheRbtz/zstd-seekable-format-go/env"
+)
+
+// maxSeekTables is how many parsed seek tables are kept between opens.
+const maxSeekTables = 64
+
+// parsedTable is the seek table of an archive, with the bytes it was
+// parsed from.
+type parsedTable struct {
+	*seekTable
+
+	raw []byte
+}
+
+// seekTableCache keeps the seek tables of recently opened archives by
+// their identity, so every connection database/sql opens to an archive
+// does not read and parse its seek table again.
+type seekTableCache struct {
+	mutex sync.Mutex
+	// used holds the tables, least recently used first.
+	used    *list.List
+	entries map[string]*list.Element
+}
+
+type cachedTable struct {
+	identity string
+	table    *parsedTable
+}
+
+//nolint: gochecknoglobals
+var seekTables = &seekTableCache{
+	used:    list.New(),
+	entries: map[string]*list.Element{},
+}
+
+func (s *seekTableCache) get(identity string) (*parsedTable, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	element, ok := s.entries[identity]
+	if !ok {
+		return nil, false
+	}
+
+	s.used.MoveToBack(element)
+
+	cached, _ := element.Value.(*cachedTable)
+
+	return cached.table, true
+}
+
+func (s *seekTableCache) put(identity string, table *parsedTable) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if element, ok := s.entries[identity]; ok {
+		s.used.Remove(element)
+	}
+
+	s.entries[identity] = s.used.PushBack(&cachedTable{identity: identity, table: table})
+
+	for s.used.Len() > maxSeekTables {
+		cached, _ := s.used.Remove(s.used.Front()).(*cachedTable)
+		delete(s.entries, cached.identity)
+	}
+}
+
+// loadSeekTable returns the seek table of an archive, read from reader
+// unless an archive with the same identity was opened before. Archives
+// without an identity are always read.
+func loadSeekTable(identity string, reader io.ReaderAt, size int64) (*parsedTable, error) {
+	if identity != "" {
+		if table, ok := seekTables.get(identity); ok {
+			return table, nil
+		}
+	}
+
+	table, raw, err := readSeekTable(reader, size)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := &parsedTable{seekTable: table, raw: raw}
+
+	if identity != "" {
+		seekTables.put(identity, parsed)
+	}
+
+	return parsed, nil
+}
+
+// partIdentity identifies the contents of a part opened from name: its
+// location and size, with the version of a Versioned object or the
+// modification time of a local file. Parts that can not tell when they
+// change have none.
+func partIdentity(name string, reader io.ReaderAt, size int64) string {
+	location, _, _ := strings.Cut(name, "?")
+
+	if versioned, ok := reader.(Versioned); ok {
+		version := versioned.Version()
+		if version == "" {
+			return ""
+		}
+
+		return fmt.Sprintf("%s\x00%d\x00%s", location, size, version)
+	}
+
+	if _, _, ok := splitMember(name); ok {
+		return ""
+	}
+
+	if _, ok := backendFor(name); ok {
+		return ""
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%s\x00%d\x00%d", location, size, info.ModTime().UnixNano())
+}
+
+// tableEnvironment reads the frames of an archive from reader, and its
+// seek table from an already parsed copy.
+type tableEnvironment struct {
+	reader io.ReaderAt
+	table  *parsedTable
+}
+
+var _ env.REnvironment = &tableEnvironment{}
+
+func (t *tableEnvironment) GetFrameByIndex(index env.FrameOffsetEntry) ([]byte, error) {
+	frame := make([]byte, index.CompSize)
+
+	//nolint: gosec
+	_, err := t.reader.ReadAt(frame, int64(index.CompOffset))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not read frame %d: %w", index.ID, err)
+	}
+
+	return frame, nil
+}
+
+func (t *tableEnvironment) ReadFooter() ([]byte, error) {
+	return t.table.raw[len(t.table.raw)-seekTableFooterSize:], nil
+}
+
+func (t *tableEnvironment) ReadSkipFrame(skippableFrameOffset int64) ([]byte, error) {
+	if skippableFrameOffset != int64(len(t.table.raw)) {
+		return nil, fmt.Errorf("%w: skippable frame of %d bytes, expected %d", ErrInvalidSeekTable, skippableFrameOffset, len(t.table.raw))
+	}
+
+	return t.table.raw, nil
+}
@@ -0,0 +1,105 @@
+// Package testhelper builds compressed SQLite fixtures for tests,
+// without shelling out to external tools.
+package testhelper
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// T is the subset of testing.TB used by the helpers, so they work
+// with both the testing package and ginkgo's GinkgoT().
+type T interface {
+	Helper()
+	TempDir() string
+	Fatalf(format string, args ...any)
+}
+
+// CreateSQLite creates a SQLite database in a temporary directory by running
+// schema, then each statement in rows, and returns its path.
+func CreateSQLite(t T, schema string, rows []string) string {
+	t.Helper()
+
+	dbPath := filepath.Join(t.TempDir(), "fixture.sqlite")
+
+	client, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+	defer client.Close()
+
+	_, err = client.Exec(schema)
+	if err != nil {
+		t.Fatalf("could not create schema: %v", err)
+	}
+
+	transaction, err := client.Begin()
+	if err != nil {
+		t.Fatalf("could not start transaction: %v", err)
+	}
+
+	for _, row := range rows {
+		_, err = transaction.Exec(row)
+		if err != nil {
+			_ = transaction.Rollback()
+
+			t.Fatalf("could not insert %q: %v", row, err)
+		}
+	}
+
+	err = transaction.Commit()
+	if err != nil {
+		t.Fatalf("could not commit rows: %v", err)
+	}
+
+	err = client.Close()
+	if err != nil {
+		t.Fatalf("could not close database: %v", err)
+	}
+
+	return dbPath
+}
+
+// CreateCompressedDB creates a SQLite database like CreateSQLite and
+// compresses it with opts. It returns the path of the compressed database,
+// which is the path of the database with a .zst extension.
+func CreateCompressedDB(t T, schema string, rows []string, opts ...sqlitezstd.Option) string {
+	t.Helper()
+
+	dbPath := CreateSQLite(t, schema, rows)
+	zstPath := dbPath + ".zst"
+
+	err := sqlitezstd.CompressFile(dbPath, zstPath, opts...)
+	if err != nil {
+		t.Fatalf("could not compress database: %v", err)
+	}
+
+	return zstPath
+}
+
+// EntriesSchema is the schema of the entries table filled by Entries.
+const EntriesSchema = "CREATE TABLE entries (id INTEGER PRIMARY KEY, body TEXT);"
+
+// Entries returns statements inserting n rows into the entries table of
+// EntriesSchema, numbered from 1, each with 64 random hex characters so
+// the database does not compress to almost nothing.
+func Entries(n int) []string {
+	rows := make([]string, 0, n)
+	for id := 1; id <= n; id++ {
+		rows = append(rows, fmt.Sprintf("INSERT INTO entries (id, body) VALUES (%d, hex(randomblob(32)))", id))
+	}
+
+	return rows
+}
+
+// CreateEntriesDB creates a compressed database like CreateCompressedDB,
+// with n rows of Entries.
+func CreateEntriesDB(t T, n int, opts ...sqlitezstd.Option) string {
+	t.Helper()
+
+	return CreateCompressedDB(t, EntriesSchema, Entries(n), opts...)
+}
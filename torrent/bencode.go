@@ -0,0 +1,117 @@
+package torrent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var ErrInvalidMetainfo = errors.New("invalid torrent metainfo")
+
+// maxDepth bounds how deeply lists and dictionaries nest, far more than a
+// metainfo file needs, so a crafted one can not exhaust the stack.
+const maxDepth = 32
+
+// decode parses bencoded data into int64, string, []any, and map[string]any
+// values, which is all a metainfo file needs.
+func decode(data []byte) (any, error) {
+	value, rest, err := decodeValue(data, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing data", ErrInvalidMetainfo)
+	}
+
+	return value, nil
+}
+
+// decodeValue decodes the value data starts with, nested in depth lists
+// and dictionaries, returning the data after it.
+func decodeValue(data []byte, depth int) (any, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("%w: unexpected end", ErrInvalidMetainfo)
+	}
+
+	if depth > maxDepth {
+		return nil, nil, fmt.Errorf("%w: nested more than %d deep", ErrInvalidMetainfo, maxDepth)
+	}
+
+	switch data[0] {
+	case 'i':
+		end := bytes.IndexByte(data, 'e')
+		if end < 0 {
+			return nil, nil, fmt.Errorf("%w: unterminated integer", ErrInvalidMetainfo)
+		}
+
+		value, err := strconv.ParseInt(string(data[1:end]), 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", ErrInvalidMetainfo, err)
+		}
+
+		return value, data[end+1:], nil
+	case 'l':
+		list := []any{}
+		rest := data[1:]
+
+		for len(rest) > 0 && rest[0] != 'e' {
+			var (
+				value any
+				err   error
+			)
+
+			value, rest, err = decodeValue(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			list = append(list, value)
+		}
+
+		if len(rest) == 0 {
+			return nil, nil, fmt.Errorf("%w: unterminated list", ErrInvalidMetainfo)
+		}
+
+		return list, rest[1:], nil
+	case 'd':
+		dict := map[string]any{}
+		rest := data[1:]
+
+		for len(rest) > 0 && rest[0] != 'e' {
+			key, remaining, err := decodeValue(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			name, ok := key.(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("%w: dictionary key is not a string", ErrInvalidMetainfo)
+			}
+
+			dict[name], rest, err = decodeValue(remaining, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		if len(rest) == 0 {
+			return nil, nil, fmt.Errorf("%w: unterminated dictionary", ErrInvalidMetainfo)
+		}
+
+		return dict, rest[1:], nil
+	default:
+		colon := bytes.IndexByte(data, ':')
+		if colon < 0 {
+			return nil, nil, fmt.Errorf("%w: invalid string", ErrInvalidMetainfo)
+		}
+
+		length, err := strconv.Atoi(string(data[:colon]))
+		if err != nil || length < 0 || length > len(data)-colon-1 {
+			return nil, nil, fmt.Errorf("%w: invalid string length", ErrInvalidMetainfo)
+		}
+
+		return string(data[colon+1 : colon+1+length]), data[colon+1+length:], nil
+	}
+}
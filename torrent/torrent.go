@@ -0,0 +1,424 @@
+// Package torrent reads sqlitezstd archives distributed as torrents from their
+// HTTP web seeds (BEP 19), so popular public datasets can be queried from
+// mirrors instead of a single origin. Importing it registers a backend for
+// torrent://host/path.torrent names, whose metainfo is fetched over HTTPS,
+// and torrent:///path.torrent names for a local metainfo file:
+//
+//	import _ "github.com/jtarchie/sqlitezstd/torrent"
+//
+//	db, err := sql.Open("sqlite3", "torrent://example.com/datasets/db.sqlite.zst.torrent?vfs=zstd")
+//
+// Ranges are read from the web seeds in the url-list of the metainfo, and from
+// any seed query parameters, taking turns between them and moving on to the
+// next after a failure. Every read is widened to whole pieces, which are
+// checked against their SHA-1 hashes in the metainfo, so a web seed serving
+// anything else is a failure too. In a torrent with several files, the file
+// parameter selects the archive by its path in the torrent.
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint: gosec
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+)
+
+const (
+	maxMetainfoSize = 16 << 20
+	// maxPieceLength bounds the memory a read takes, pieces being read whole.
+	maxPieceLength = 64 << 20
+)
+
+var (
+	ErrInvalidURL         = errors.New("invalid torrent url")
+	ErrNoSeeds            = errors.New("torrent has no web seeds")
+	ErrUnexpectedResponse = errors.New("unexpected web seed response")
+	ErrPieceMismatch      = errors.New("torrent piece does not match its hash")
+)
+
+//nolint: gochecknoinits
+func init() {
+	sqlitezstd.RegisterBackend("torrent", &Backend{})
+}
+
+// Backend opens torrent:// names through the web seeds of the torrent.
+type Backend struct {
+	// Seeds are web seeds used along with those of every torrent.
+	Seeds []string
+	// Client makes every request, defaulting to http.DefaultClient.
+	Client *http.Client
+}
+
+var _ sqlitezstd.Backend = &Backend{}
+
+func (b *Backend) Open(uri *url.URL) (sqlitezstd.Object, error) {
+	if uri.Path == "" || uri.Path == "/" {
+		return nil, fmt.Errorf("%w: %s needs the path of a metainfo file", ErrInvalidURL, uri.Redacted())
+	}
+
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	contents, err := fetchMetainfo(client, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := parseMetainfo(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	query := uri.Query()
+
+	file, err := info.file(query.Get("file"))
+	if err != nil {
+		return nil, err
+	}
+
+	seeds := append(append(append([]string{}, info.seeds...), b.Seeds...), query["seed"]...)
+	if len(seeds) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoSeeds, uri.Redacted())
+	}
+
+	return &object{client: client, info: info, file: file, seeds: seeds}, nil
+}
+
+// fetchMetainfo reads the metainfo from a local file if the name has no host,
+// and over HTTPS otherwise.
+func fetchMetainfo(client *http.Client, uri *url.URL) ([]byte, error) {
+	if uri.Host == "" {
+		contents, err := os.ReadFile(uri.Path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read metainfo: %w", err)
+		}
+
+		return contents, nil
+	}
+
+	location := url.URL{Scheme: "https", Host: uri.Host, Path: uri.Path}
+
+	response, err := client.Get(location.String())
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch metainfo: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: could not fetch metainfo %s: %s", ErrUnexpectedResponse, location.Redacted(), response.Status)
+	}
+
+	contents, err := io.ReadAll(io.LimitReader(response.Body, maxMetainfoSize))
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch metainfo: %w", err)
+	}
+
+	return contents, nil
+}
+
+// metainfo is the part of a .torrent file needed to read from web seeds.
+type metainfo struct {
+	name        string
+	multi       bool
+	files       []file
+	seeds       []string
+	pieceLength int64
+	pieces      []byte
+}
+
+// file is a file of a torrent, at offset in the files joined end to end,
+// as they are hashed in pieces.
+type file struct {
+	path    string
+	offset  int64
+	length  int64
+	padding bool
+}
+
+func parseMetainfo(contents []byte) (*metainfo, error) {
+	decoded, err := decode(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	root, _ := decoded.(map[string]any)
+	info, _ := root["info"].(map[string]any)
+	name, _ := info["name"].(string)
+
+	if name == "" {
+		return nil, fmt.Errorf("%w: missing name", ErrInvalidMetainfo)
+	}
+
+	pieceLength, _ := info["piece length"].(int64)
+	pieces, _ := info["pieces"].(string)
+
+	parsed := &metainfo{name: name, pieceLength: pieceLength, pieces: []byte(pieces)}
+
+	switch seeds := root["url-list"].(type) {
+	case string:
+		parsed.seeds = append(parsed.seeds, seeds)
+	case []any:
+		for _, seed := range seeds {
+			if location, ok := seed.(string); ok && location != "" {
+				parsed.seeds = append(parsed.seeds, location)
+			}
+		}
+	}
+
+	if length, ok := info["length"].(int64); ok {
+		parsed.files = []file{{length: length}}
+	} else {
+		files, ok := info["files"].([]any)
+		if !ok {
+			return nil, fmt.Errorf("%w: missing length and files", ErrInvalidMetainfo)
+		}
+
+		parsed.multi = true
+
+		offset := int64(0)
+
+		for _, entry := range files {
+			fields, _ := entry.(map[string]any)
+			length, _ := fields["length"].(int64)
+			elements, _ := fields["path"].([]any)
+			attributes, _ := fields["attr"].(string)
+
+			segments := make([]string, 0, len(elements))
+
+			for _, element := range elements {
+				segment, _ := element.(string)
+				segments = append(segments, segment)
+			}
+
+			// padding files (BEP 47) are zeros no web seed serves
+			parsed.files = append(parsed.files, file{
+				path:    strings.Join(segments, "/"),
+				offset:  offset,
+				length:  length,
+				padding: strings.Contains(attributes, "p"),
+			})
+			offset += length
+		}
+	}
+
+	for _, listed := range parsed.files {
+		if listed.length < 0 || listed.offset < 0 {
+			return nil, fmt.Errorf("%w: invalid length", ErrInvalidMetainfo)
+		}
+	}
+
+	if len(parsed.files) == 0 {
+		return nil, fmt.Errorf("%w: no files", ErrInvalidMetainfo)
+	}
+
+	if pieceLength <= 0 || pieceLength > maxPieceLength {
+		return nil, fmt.Errorf("%w: piece length %d", ErrInvalidMetainfo, pieceLength)
+	}
+
+	count := (parsed.size() + pieceLength - 1) / pieceLength
+	if int64(len(pieces)) != count*sha1.Size {
+		return nil, fmt.Errorf("%w: %d bytes of pieces for %d pieces", ErrInvalidMetainfo, len(pieces), count)
+	}
+
+	return parsed, nil
+}
+
+// size is the size of every file of the torrent.
+func (m *metainfo) size() int64 {
+	last := m.files[len(m.files)-1]
+
+	return last.offset + last.length
+}
+
+// file returns the archive at path. It may only be omitted when the
+// torrent has a single file.
+func (m *metainfo) file(path string) (file, error) {
+	path = strings.Trim(path, "/")
+
+	if !m.multi {
+		if path != "" && path != m.name {
+			return file{}, fmt.Errorf("%w: %s is not in the torrent", ErrInvalidURL, path)
+		}
+
+		return m.files[0], nil
+	}
+
+	var found []file
+
+	for _, candidate := range m.files {
+		if !candidate.padding && (path == "" || candidate.path == path) {
+			found = append(found, candidate)
+		}
+	}
+
+	if path == "" && len(found) != 1 {
+		return file{}, fmt.Errorf("%w: the torrent has %d files, choose one with the file parameter", ErrInvalidURL, len(found))
+	}
+
+	if len(found) == 0 {
+		return file{}, fmt.Errorf("%w: %s is not in the torrent", ErrInvalidURL, path)
+	}
+
+	return found[0], nil
+}
+
+// location is where seed serves the file at path, following BEP 19.
+func (m *metainfo) location(seed string, path string) string {
+	if !m.multi {
+		if strings.HasSuffix(seed, "/") {
+			return seed + url.PathEscape(m.name)
+		}
+
+		return seed
+	}
+
+	segments := []string{url.PathEscape(m.name)}
+	for _, segment := range strings.Split(path, "/") {
+		segments = append(segments, url.PathEscape(segment))
+	}
+
+	return strings.TrimSuffix(seed, "/") + "/" + strings.Join(segments, "/")
+}
+
+// object is a file of a torrent, read a piece at a time from its web seeds
+// in turn.
+type object struct {
+	client *http.Client
+	info   *metainfo
+	file   file
+	seeds  []string
+	next   atomic.Uint64
+}
+
+func (o *object) ReadAt(p []byte, off int64) (int, error) {
+	if off >= o.file.length {
+		return 0, io.EOF
+	}
+
+	start := o.file.offset + off
+	end := o.file.offset + min(off+int64(len(p)), o.file.length)
+
+	for index := start / o.info.pieceLength; index*o.info.pieceLength < end; index++ {
+		piece, err := o.readPiece(index)
+		if err != nil {
+			return 0, err
+		}
+
+		first := index * o.info.pieceLength
+		copy(p[max(first, start)-start:end-start], piece[max(first, start)-first:])
+	}
+
+	if end-start < int64(len(p)) {
+		return int(end - start), io.EOF
+	}
+
+	return len(p), nil
+}
+
+// readPiece returns the piece at index from the first web seed serving it
+// as hashed in the metainfo.
+func (o *object) readPiece(index int64) ([]byte, error) {
+	offset := index * o.info.pieceLength
+	piece := make([]byte, min(o.info.pieceLength, o.info.size()-offset))
+	expected := o.info.pieces[index*sha1.Size : (index+1)*sha1.Size]
+	first := o.next.Add(1)
+
+	var err error
+
+	for attempt := range uint64(len(o.seeds)) {
+		seed := o.seeds[(first+attempt)%uint64(len(o.seeds))]
+
+		err = o.readSpan(seed, piece, offset)
+		if err != nil {
+			continue
+		}
+
+		hash := sha1.Sum(piece) //nolint: gosec
+		if bytes.Equal(hash[:], expected) {
+			return piece, nil
+		}
+
+		err = fmt.Errorf("%w: piece %d from %s", ErrPieceMismatch, index, seed)
+	}
+
+	return nil, err
+}
+
+// readSpan fills p from seed with the files of the torrent at off.
+func (o *object) readSpan(seed string, p []byte, off int64) error {
+	for _, spanned := range o.info.files {
+		start := max(off, spanned.offset)
+		end := min(off+int64(len(p)), spanned.offset+spanned.length)
+
+		if start >= end {
+			continue
+		}
+
+		if spanned.padding {
+			clear(p[start-off : end-off])
+
+			continue
+		}
+
+		err := o.readRange(o.info.location(seed, spanned.path), p[start-off:end-off], start-spanned.offset, spanned.length)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readRange fills p from a single web seed serving a file of size bytes.
+func (o *object) readRange(location string, p []byte, off int64, size int64) error {
+	request, err := http.NewRequest(http.MethodGet, location, nil)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", location, err)
+	}
+
+	request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+
+	response, err := o.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", location, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("%w: could not read %s: %s", ErrUnexpectedResponse, location, response.Status)
+	}
+
+	// an unknown total, sent as *, is trusted
+	contentRange := response.Header.Get("Content-Range")
+
+	total, ok := strings.CutPrefix(contentRange, fmt.Sprintf("bytes %d-%d/", off, off+int64(len(p))-1))
+	if !ok || (total != "*" && total != strconv.FormatInt(size, 10)) {
+		return fmt.Errorf("%w: could not read %s: content range %q for %d-%d of %d bytes", ErrUnexpectedResponse, location, contentRange, off, off+int64(len(p))-1, size)
+	}
+
+	_, err = io.ReadFull(response.Body, p)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", location, err)
+	}
+
+	return nil
+}
+
+func (o *object) Size() int64 {
+	return o.file.length
+}
+
+func (o *object) Close() error {
+	return nil
+}
@@ -0,0 +1,280 @@
+package torrent_test
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint: gosec
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	"github.com/jtarchie/sqlitezstd/testhelper"
+	"github.com/jtarchie/sqlitezstd/torrent"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestTorrent(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Torrent Suite")
+}
+
+// encode bencodes the strings, ints, lists, and dictionaries of a metainfo file.
+func encode(value any) string {
+	switch value := value.(type) {
+	case string:
+		return fmt.Sprintf("%d:%s", len(value), value)
+	case int:
+		return fmt.Sprintf("i%de", value)
+	case []any:
+		encoded := "l"
+		for _, element := range value {
+			encoded += encode(element)
+		}
+
+		return encoded + "e"
+	case map[string]any:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		encoded := "d"
+		for _, key := range keys {
+			encoded += encode(key) + encode(value[key])
+		}
+
+		return encoded + "e"
+	default:
+		panic(fmt.Sprintf("can not encode %T", value))
+	}
+}
+
+// pieceLength is the piece length of every torrent written by the tests.
+const pieceLength = 16384
+
+// pieces returns the SHA-1 hashes of the pieces of contents.
+func pieces(contents []byte) string {
+	var hashes []byte
+
+	for offset := 0; offset < len(contents); offset += pieceLength {
+		hash := sha1.Sum(contents[offset:min(offset+pieceLength, len(contents))]) //nolint: gosec
+		hashes = append(hashes, hash[:]...)
+	}
+
+	return string(hashes)
+}
+
+func writeMetainfo(metainfo map[string]any) string {
+	path := filepath.Join(GinkgoT().TempDir(), "db.torrent")
+	Expect(os.WriteFile(path, []byte(encode(metainfo)), 0o600)).To(Succeed())
+
+	return path
+}
+
+// seed is a web seed serving files by path, counting the requests it gets.
+type seed struct {
+	files    map[string][]byte
+	requests atomic.Int64
+}
+
+func (s *seed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+
+	contents, ok := s.files[r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(contents))
+}
+
+func countEntries(dsn string) (int64, error) {
+	client, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	var count int64
+	err = client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)
+
+	return count, err
+}
+
+var _ = Describe("Backend", func() {
+	var contents []byte
+
+	BeforeEach(func() {
+		Expect(sqlitezstd.Init()).To(Succeed())
+
+		var err error
+
+		contents, err = os.ReadFile(testhelper.CreateEntriesDB(GinkgoT(), 1000))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	// open opens the archive of the metainfo at path with the seeds.
+	open := func(path string, seeds ...string) sqlitezstd.Object {
+		object, err := (&torrent.Backend{Seeds: seeds}).Open(&url.URL{Scheme: "torrent", Path: path})
+		Expect(err).ToNot(HaveOccurred())
+
+		return object
+	}
+
+	It("reads single file torrents from every web seed", func() {
+		first := &seed{files: map[string][]byte{"/mirror/db.zst": contents}}
+		second := &seed{files: map[string][]byte{"/db.zst": contents}}
+
+		firstServer := httptest.NewServer(first)
+		DeferCleanup(firstServer.Close)
+
+		secondServer := httptest.NewServer(second)
+		DeferCleanup(secondServer.Close)
+
+		path := writeMetainfo(map[string]any{
+			"info":     map[string]any{"name": "db.zst", "length": len(contents), "piece length": pieceLength, "pieces": pieces(contents)},
+			"url-list": []any{firstServer.URL + "/mirror/", secondServer.URL + "/db.zst"},
+		})
+
+		count, err := countEntries("torrent://" + path + "?vfs=zstd")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+
+		Expect(first.requests.Load()).To(BeNumerically(">", 0))
+		Expect(second.requests.Load()).To(BeNumerically(">", 0))
+	})
+
+	It("moves on to the next web seed after a failure", func() {
+		working := httptest.NewServer(&seed{files: map[string][]byte{"/db.zst": contents}})
+		DeferCleanup(working.Close)
+
+		broken := httptest.NewServer(&seed{})
+		DeferCleanup(broken.Close)
+
+		path := writeMetainfo(map[string]any{
+			"info":     map[string]any{"name": "db.zst", "length": len(contents), "piece length": pieceLength, "pieces": pieces(contents)},
+			"url-list": broken.URL + "/",
+		})
+
+		count, err := countEntries(fmt.Sprintf("file:torrent://%s?vfs=zstd&seed=%s/", path, working.URL))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+	})
+
+	It("reads a file of a torrent with several files", func() {
+		readme := []byte("hello")
+
+		server := httptest.NewServer(&seed{files: map[string][]byte{
+			"/dataset/README":      readme,
+			"/dataset/data/db.zst": contents,
+		}})
+		DeferCleanup(server.Close)
+
+		// the pieces of the archive span the other files
+		joined := append(append(append([]byte{}, readme...), make([]byte, 100)...), contents...)
+
+		path := writeMetainfo(map[string]any{
+			"info": map[string]any{
+				"name": "dataset",
+				"files": []any{
+					map[string]any{"length": len(readme), "path": []any{"README"}},
+					map[string]any{"length": 100, "path": []any{".pad", "100"}, "attr": "p"},
+					map[string]any{"length": len(contents), "path": []any{"data", "db.zst"}},
+				},
+				"piece length": pieceLength,
+				"pieces":       pieces(joined),
+			},
+			"url-list": []any{server.URL},
+		})
+
+		count, err := countEntries("file:torrent://" + path + "?vfs=zstd&file=data/db.zst")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(1000))
+
+		_, err = sqlitezstd.Inspect("torrent://" + path)
+		Expect(err).To(MatchError(torrent.ErrInvalidURL))
+	})
+
+	It("moves on from web seeds serving pieces that do not match their hash", func() {
+		corrupt := append([]byte{}, contents...)
+		corrupt[pieceLength+10] ^= 0xFF
+
+		corruptServer := httptest.NewServer(&seed{files: map[string][]byte{"/db.zst": corrupt}})
+		DeferCleanup(corruptServer.Close)
+
+		working := httptest.NewServer(&seed{files: map[string][]byte{"/db.zst": contents}})
+		DeferCleanup(working.Close)
+
+		path := writeMetainfo(map[string]any{
+			"info": map[string]any{"name": "db.zst", "length": len(contents), "piece length": pieceLength, "pieces": pieces(contents)},
+		})
+
+		read := make([]byte, 100)
+
+		_, err := open(path, corruptServer.URL+"/").ReadAt(read, pieceLength)
+		Expect(err).To(MatchError(torrent.ErrPieceMismatch))
+
+		object := open(path, corruptServer.URL+"/", working.URL+"/")
+
+		for range 2 {
+			_, err = object.ReadAt(read, pieceLength)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(read).To(Equal(contents[pieceLength : pieceLength+100]))
+		}
+	})
+
+	It("fails for web seeds answering with another range", func() {
+		files := &seed{files: map[string][]byte{"/db.zst": contents}}
+
+		// answers every range with the start of the file
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Set("Range", fmt.Sprintf("bytes=0-%d", pieceLength-1))
+			files.ServeHTTP(w, r)
+		}))
+		DeferCleanup(server.Close)
+
+		path := writeMetainfo(map[string]any{
+			"info": map[string]any{"name": "db.zst", "length": len(contents), "piece length": pieceLength, "pieces": pieces(contents)},
+		})
+
+		_, err := open(path, server.URL+"/").ReadAt(make([]byte, 100), pieceLength)
+		Expect(err).To(MatchError(torrent.ErrUnexpectedResponse))
+	})
+
+	It("errors for invalid metainfo", func() {
+		for _, metainfo := range []string{
+			encode(map[string]any{"info": map[string]any{"name": "db.zst", "length": len(contents)}, "url-list": "http://127.0.0.1:1/"}),
+			encode(map[string]any{"info": map[string]any{"name": "db.zst", "length": len(contents), "piece length": pieceLength, "pieces": "short"}}),
+			strings.Repeat("l", 100000) + strings.Repeat("e", 100000),
+		} {
+			path := filepath.Join(GinkgoT().TempDir(), "db.torrent")
+			Expect(os.WriteFile(path, []byte(metainfo), 0o600)).To(Succeed())
+
+			_, err := sqlitezstd.Inspect("torrent://" + path)
+			Expect(err).To(MatchError(torrent.ErrInvalidMetainfo))
+		}
+	})
+
+	It("errors without web seeds", func() {
+		path := writeMetainfo(map[string]any{
+			"info": map[string]any{"name": "db.zst", "length": len(contents), "piece length": pieceLength, "pieces": pieces(contents)},
+		})
+
+		_, err := sqlitezstd.Inspect("torrent://" + path)
+		Expect(err).To(MatchError(torrent.ErrNoSeeds))
+	})
+})
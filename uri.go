@@ -0,0 +1,129 @@
+package sqlitezstd
+
+/*
+#include <stddef.h>
+#include <stdlib.h>
+
+typedef struct sqlite3_vfs sqlite3_vfs;
+typedef struct sqlite3_file sqlite3_file;
+typedef int (*zstd_open_fn)(sqlite3_vfs*, const char*, sqlite3_file*, int, int*);
+
+// The leading fields of struct sqlite3_vfs, up to xOpen.
+// See https://www.sqlite.org/c3ref/vfs.html
+typedef struct {
+	int iVersion;
+	int szOsFile;
+	int mxPathname;
+	sqlite3_vfs *pNext;
+	const char *zName;
+	void *pAppData;
+	zstd_open_fn xOpen;
+} zstd_vfs_prefix;
+
+// Provided by the SQLite library that go-sqlite3 links in.
+extern sqlite3_vfs *sqlite3_vfs_find(const char *zVfsName);
+extern const char *sqlite3_uri_key(const char *zFilename, int N);
+extern const char *sqlite3_uri_parameter(const char *zFilename, const char *zParam);
+
+#define ZSTD_OPEN_MAIN_DB 0x00000100
+
+static zstd_open_fn zstd_original_open = NULL;
+static __thread const char *zstd_open_name = NULL;
+
+// zstd_open remembers the name SQLite opens the main database with, so the
+// Go side can read its URI parameters while handling the same call. It is
+// the name sqlite3_db_filename returns for the connection, too.
+static int zstd_open(sqlite3_vfs *vfs, const char *name, sqlite3_file *file, int flags, int *out) {
+	const char *previous = zstd_open_name;
+	int rc;
+
+	if (flags & ZSTD_OPEN_MAIN_DB) {
+		zstd_open_name = name;
+	}
+
+	rc = zstd_original_open(vfs, name, file, flags, out);
+	zstd_open_name = previous;
+
+	return rc;
+}
+
+static int zstd_install_open(const char *vfsName) {
+	zstd_vfs_prefix *vfs = (zstd_vfs_prefix *)sqlite3_vfs_find(vfsName);
+	if (vfs == NULL) {
+		return 1;
+	}
+
+	if (vfs->xOpen == zstd_open) {
+		return 0;
+	}
+
+	// every VFS registered through sqlite3vfs shares the same xOpen
+	if (zstd_original_open != NULL && zstd_original_open != vfs->xOpen) {
+		return 1;
+	}
+
+	zstd_original_open = vfs->xOpen;
+	vfs->xOpen = zstd_open;
+
+	return 0;
+}
+
+static const char *zstd_current_open_name(void) {
+	return zstd_open_name;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"net/url"
+	"unsafe"
+)
+
+var errInstallParameters = errors.New("could not install uri parameter support")
+
+// installParameters makes the URI parameters of the databases opened
+// with the named VFS available to openParameters.
+func installParameters(name string) error {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	if C.zstd_install_open(cname) != 0 {
+		return errInstallParameters
+	}
+
+	return nil
+}
+
+// openParameters returns the URI parameters of the main database currently
+// being opened on this thread, such as `overlay` in `file:db.zst?vfs=zstd&overlay=memory`.
+// It must only be called from within VFS.Open.
+func openParameters() url.Values {
+	name := C.zstd_current_open_name()
+	if name == nil {
+		return nil
+	}
+
+	params := url.Values{}
+
+	for index := C.int(0); ; index++ {
+		key := C.sqlite3_uri_key(name, index)
+		if key == nil {
+			break
+		}
+
+		value := C.sqlite3_uri_parameter(name, key)
+		if value != nil {
+			params.Add(C.GoString(key), C.GoString(value))
+		}
+	}
+
+	return params
+}
+
+// openHandle identifies the main database currently being opened on this
+// thread by its name, as SQLite keeps it for the connection, or is 0.
+// It must only be called from within VFS.Open.
+func openHandle() uintptr {
+	return uintptr(unsafe.Pointer(C.zstd_current_open_name()))
+}
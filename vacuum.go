@@ -0,0 +1,27 @@
+package sqlitezstd
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// VacuumInto runs VACUUM INTO on db and compresses the vacuumed database
+// to dst while SQLite writes it, without an uncompressed copy on disk.
+// Frames are always aligned to the page size.
+func VacuumInto(db *sql.DB, dst string, opts ...Option) error {
+	var pageSize int
+
+	err := db.QueryRow("PRAGMA page_size").Scan(&pageSize)
+	if err != nil {
+		return fmt.Errorf("could not read page size: %w", err)
+	}
+
+	return streamInto(dst, pageSize, opts, func(dsn string) error {
+		_, err := db.Exec("VACUUM INTO ?", dsn)
+		if err != nil {
+			return fmt.Errorf("could not vacuum source: %w", err)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,102 @@
+package sqlitezstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// skippableMagicMask matches the magic of a skippable frame with any tag.
+const skippableMagicMask = 0xFFFFFFF0
+
+var ErrCorruptFrame = errors.New("corrupt frame")
+
+// Verify checks that the seek table covers the whole file and that every
+// frame decompresses to the size and checksum the seek table records for it.
+// Every defect found is returned, joined with errors.Join, so the result can
+// be checked with errors.Is against ErrInvalidSeekTable or ErrCorruptFrame.
+func Verify(path string) error {
+	file, err := openArchive(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return verify(file, file.Size())
+}
+
+func verify(reader io.ReaderAt, size int64) error {
+	table, err := decodeSeekTable(reader, size)
+	if err != nil {
+		return err
+	}
+
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return fmt.Errorf("could not create decoder: %w", err)
+	}
+	defer decoder.Close()
+
+	var (
+		defects []error
+		raw     []byte
+	)
+
+	for _, frame := range table.frames {
+		compressed := make([]byte, frame.compressedSize)
+
+		_, err = reader.ReadAt(compressed, frame.compressedOffset)
+		if err != nil {
+			return fmt.Errorf("could not read frame %d: %w", frame.index, err)
+		}
+
+		if frame.decompressedSize == 0 {
+			err = verifySkippable(compressed)
+		} else {
+			raw, err = verifyData(decoder, compressed, raw[:0], frame, table.checksums)
+		}
+
+		if err != nil {
+			defects = append(defects, fmt.Errorf("frame %d at offset %d: %w", frame.index, frame.compressedOffset, err))
+		}
+	}
+
+	return errors.Join(defects...)
+}
+
+func verifySkippable(compressed []byte) error {
+	if len(compressed) < skippableHeaderSize {
+		return fmt.Errorf("%w: empty frame of %d bytes", ErrCorruptFrame, len(compressed))
+	}
+
+	if binary.LittleEndian.Uint32(compressed[0:4])&skippableMagicMask != skippableFrameMagic {
+		return fmt.Errorf("%w: empty frame is not a skippable frame", ErrCorruptFrame)
+	}
+
+	if int(binary.LittleEndian.Uint32(compressed[4:8])) != len(compressed)-skippableHeaderSize {
+		return fmt.Errorf("%w: skippable frame size mismatch", ErrCorruptFrame)
+	}
+
+	return nil
+}
+
+func verifyData(decoder *zstd.Decoder, compressed []byte, raw []byte, frame frameInfo, checksums bool) ([]byte, error) {
+	// DecodeAll also validates the zstd content checksum, if the frame has one
+	raw, err := decoder.DecodeAll(compressed, raw)
+	if err != nil {
+		return raw, fmt.Errorf("%w: %w", ErrCorruptFrame, err)
+	}
+
+	if len(raw) != int(frame.decompressedSize) {
+		return raw, fmt.Errorf("%w: decompressed to %d bytes, expected %d", ErrCorruptFrame, len(raw), frame.decompressedSize)
+	}
+
+	if checksums && frameChecksum(raw) != frame.checksum {
+		return raw, fmt.Errorf("%w: checksum mismatch", ErrCorruptFrame)
+	}
+
+	return raw, nil
+}
@@ -2,25 +2,134 @@ package sqlitezstd
 
 import (
 	"fmt"
-	"io"
+	"log/slog"
 	"net/url"
 	"os"
-	"strings"
+	"runtime"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	seekable "github.com/SaveTheRbtz/zstd-seekable-format-go"
-	"github.com/klauspost/compress/zstd"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/psanford/sqlite3vfs"
-	"howett.net/ranger"
 )
 
-type ZstdVFS struct{}
+type ZstdVFS struct {
+	// Overlay allows writing to the database, keeping the changes
+	// outside of the compressed archive. The default is read-only.
+	Overlay Overlay
+	// Options are used when OverlayRewrite compresses the archive again.
+	Options []Option
+	// Stats, if set, counts the reads of every file opened by this VFS.
+	Stats *Stats
+	// Heatmap, if set, records the frames and pages of every archive
+	// opened by this VFS that are read.
+	Heatmap *Heatmap
+	// DiskCacheDir, if set, keeps the decompressed frames of remote archives
+	// in this directory, so they are not fetched again after a restart. It is
+	// overridden by the disk_cache_dir parameter.
+	DiskCacheDir string
+	// DiskCacheSize is the most bytes kept in DiskCacheDir, defaulting to
+	// 1 GiB, before the least recently used frames are removed. It is
+	// overridden by the disk_cache_size parameter.
+	DiskCacheSize int64
+	// DiskCacheByContent, if set, keeps frames by the contents of their
+	// archive rather than its location, so an archive reopened from a new
+	// location, such as after a redeploy, finds its frames. It is
+	// overridden by the disk_cache_key parameter, content or location.
+	DiskCacheByContent bool
+	// CacheSize, if set, is the most bytes of decompressed frames kept in
+	// memory, shared by every connection of the VFS, so hot frames are
+	// not decompressed by each of them. It defaults to the size given to
+	// SetDefaultCacheSize, and is overridden by the cache_size parameter.
+	CacheSize int64
+	// Readahead, if set, is how many frames are decompressed in the
+	// background once reads turn into a sequential scan, such as a full
+	// table scan. It is overridden by the readahead parameter.
+	Readahead int
+	// AdaptiveReadahead, if set, also reads ahead the next siblings of
+	// B-tree pages read in order, and nothing for point lookups, reading
+	// Readahead frames ahead, 4 if unset. It is set by readahead=auto.
+	AdaptiveReadahead bool
+	// PartialFrames, if set, decompresses the frame of every read and
+	// keeps nothing of it but the pages read, rather than keeping the
+	// whole frame for the neighbouring pages SQLite usually reads next,
+	// skipping the caches and readahead, such as for archives of large
+	// frames read at random. It is overridden by
+	// the frame_policy parameter, whole or partial.
+	PartialFrames bool
+	// CoalesceSize, if set, is the most bytes of adjacent frames
+	// decompressed in one pass once reads run sequentially, such as in
+	// full scans without readahead, so the reads after are copied from one
+	// region. It is overridden by the coalesce_size parameter.
+	CoalesceSize int64
+	// DecompressWorkers is how many frames of a region are decompressed
+	// at once, GOMAXPROCS if unset. It is overridden by the
+	// decompress_workers parameter.
+	DecompressWorkers int
+	// DecoderConcurrency is how many frames a decoder decodes at once, 1
+	// by default. DecoderLowMemory trades speed for smaller buffers, and
+	// DecoderMaxMemory, if set, is the most memory a decoder may use for
+	// a frame, such as on small devices. They are overridden by the
+	// decoder_concurrency, decoder_low_memory and decoder_max_memory
+	// parameters.
+	DecoderConcurrency int
+	DecoderLowMemory   bool
+	DecoderMaxMemory   uint64
+	// Decompression, if set, decompresses the whole database at open,
+	// into memory, refusing those over MemoryLimit, 128 MiB if unset, or
+	// into a temporary file. They are overridden by the decompress and
+	// memory_limit parameters.
+	Decompression Decompression
+	MemoryLimit   int64
+	// Cache, if set, keeps the decompressed frames of remote archives
+	// outside the process, after the frame cache and the disk cache, so
+	// every process reading an archive shares them. Set DiskCacheByContent
+	// for processes reading an archive from different locations.
+	Cache Cache
+	// ColdStart, if set, opens archives with as little work as it can, for
+	// serverless functions whose cold starts wait on it: remote archives
+	// with one request for their tail, holding the seek table, and without
+	// a decoder until the first frame read needs one. It is overridden by
+	// the cold_start parameter. Preopen opens a database during the init
+	// phase of the function instead.
+	ColdStart bool
+	// Logger, if set, logs archives opening and closing, at debug, the
+	// reasons they could not be opened or read, at error, and frames of
+	// the caches found corrupt and read from the archive again, at warn.
+	// Range requests are logged by the Logger of the HTTPBackend.
+	Logger *slog.Logger
+	// SlowRead, if set, logs the reads of SQLite that take longer, with
+	// how long was spent fetching and decompressing, at warn. It is
+	// overridden by the slow_read parameter.
+	SlowRead time.Duration
+
+	cacheMutex sync.Mutex
+	cache      *frameCache
+}
+
+//nolint: gochecknoglobals
+var defaultCacheSize atomic.Int64
+
+// SetDefaultCacheSize sets the CacheSize of every VFS that sets none,
+// such as the zstd VFS registered by Init. Frames are not kept in memory
+// until it is set.
+func SetDefaultCacheSize(size int64) {
+	defaultCacheSize.Store(size)
+}
 
 var _ sqlite3vfs.VFS = &ZstdVFS{}
 
 func (z *ZstdVFS) Access(name string, flags sqlite3vfs.AccessFlag) (bool, error) {
-	if strings.HasSuffix(name, "-wal") || strings.HasSuffix(name, "-journal") {
+	if database, ok := journalDatabase(name); ok {
+		if overlayFor(database).localJournals() {
+			_, err := os.Stat(name)
+
+			return err == nil, nil
+		}
+
 		return false, nil
 	}
 
@@ -28,7 +137,24 @@ func (z *ZstdVFS) Access(name string, flags sqlite3vfs.AccessFlag) (bool, error)
 }
 
 func (z *ZstdVFS) Delete(name string, dirSync bool) error {
-	return sqlite3vfs.ReadOnlyError
+	database, ok := journalDatabase(name)
+	if !ok {
+		return sqlite3vfs.ReadOnlyError
+	}
+
+	switch overlay := overlayFor(database); {
+	case overlay.localJournals():
+		err := os.Remove(name)
+		if err != nil && !os.IsNotExist(err) {
+			return sqlite3vfs.IOError
+		}
+
+		return nil
+	case overlay == OverlayMemory:
+		return nil
+	default:
+		return sqlite3vfs.ReadOnlyError
+	}
 }
 
 func (z *ZstdVFS) FullPathname(name string) string {
@@ -36,55 +162,463 @@ func (z *ZstdVFS) FullPathname(name string) string {
 }
 
 func (z *ZstdVFS) Open(name string, flags sqlite3vfs.OpenFlag) (sqlite3vfs.File, sqlite3vfs.OpenFlag, error) {
-	var (
-		err    error
-		reader io.ReadSeeker
-	)
+	if flags&sqlite3vfs.OpenMainDB == 0 {
+		return z.openJournal(name, flags)
+	}
+
+	// SQLite only sees that the database could not be opened, so why is
+	// logged
+	file, flags, err := z.openDatabase(name, flags)
+	if err != nil {
+		logTo(z.Logger, slog.LevelError, "could not open archive", "name", logName(name), "error", err)
+
+		return nil, 0, sqlite3vfs.CantOpenError
+	}
 
-	if strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://") {
-		uri, err := url.Parse(name)
+	return file, flags, nil
+}
+
+// openDatabase opens the archive at name, with the parameters of its DSN.
+func (z *ZstdVFS) openDatabase(name string, flags sqlite3vfs.OpenFlag) (sqlite3vfs.File, sqlite3vfs.OpenFlag, error) {
+	overlay := z.Overlay
+
+	params := openParameters()
+	if params.Has("overlay") {
+		var err error
+
+		overlay, err = parseOverlay(params.Get("overlay"))
 		if err != nil {
-			return nil, 0, sqlite3vfs.CantOpenError
+			return nil, 0, err
 		}
+	}
+
+	// a URL with a query of its own, such as a signed URL, is given
+	// escaped in the url parameter, so SQLite does not parse its query
+	if remote := params.Get("url"); remote != "" {
+		name = remote
+		params.Del("url")
+	}
+
+	// the backend of a remote archive requests only its tail, too
+	if z.ColdStart && !params.Has("cold_start") {
+		params.Set("cold_start", "true")
+	}
 
-		reader, err = ranger.NewReader(&ranger.HTTPRanger{URL: uri})
+	location := name
+	if isRemote(name) {
+		location = withParameters(name, params)
+	}
+
+	decoding, err := z.decoderOptions(params)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	coldStart := z.ColdStart
+	if params.Has("cold_start") {
+		coldStart, err = strconv.ParseBool(params.Get("cold_start"))
 		if err != nil {
-			return nil, 0, sqlite3vfs.CantOpenError
+			return nil, 0, fmt.Errorf("%w: cold_start=%q", ErrInvalidOption, params.Get("cold_start"))
 		}
-	} else {
-		reader, err = os.Open(name)
+	}
+
+	slowRead := z.SlowRead
+	if params.Has("slow_read") {
+		slowRead, err = time.ParseDuration(params.Get("slow_read"))
+		if err != nil || slowRead < 0 {
+			return nil, 0, fmt.Errorf("%w: slow_read=%q", ErrInvalidOption, params.Get("slow_read"))
+		}
+	}
+
+	base, err := z.openBase(location, decoding, coldStart)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	base.slowRead = slowRead
+	base.heat = z.Heatmap.archive(name, base.table)
+
+	err = z.decompress(base, name, params)
+	if err != nil {
+		_ = base.Close()
+
+		return nil, 0, err
+	}
+
+	// refuse archives that were not compressed from the expected source
+	if expected := params.Get("source_sha256"); expected != "" {
+		reader, _ := base.reader.(*archive)
+
+		err = verifySource(reader, reader.Size(), expected)
 		if err != nil {
-			return nil, 0, sqlite3vfs.CantOpenError
+			_ = base.Close()
+
+			return nil, 0, err
 		}
 	}
 
-	decoder, err := zstd.NewReader(nil)
+	logTo(z.Logger, slog.LevelDebug, "opened archive", "name", logName(name), "frames", len(base.table.frames))
+
+	trackConnection(base)
+
+	if overlay == OverlayNone {
+		return base, flags | sqlite3vfs.OpenReadOnly, nil
+	}
+
+	file, err := z.openOverlay(name, base, overlay)
 	if err != nil {
-		return nil, 0, sqlite3vfs.CantOpenError
+		_ = base.Close()
+
+		return nil, 0, err
 	}
 
-	seekable, err := seekable.NewReader(reader, decoder)
+	return file, flags &^ sqlite3vfs.OpenReadOnly, nil
+}
+
+// openBase opens the archive at name. With coldStart, its decoder is taken
+// from the pool on the first frame decoded rather than at open, so files
+// whose first reads are cached, or that are never read, create none.
+func (z *ZstdVFS) openBase(name string, decoding decoderOptions, coldStart bool) (*ZstdFile, error) {
+	reader, err := openSharedArchive(name)
 	if err != nil {
-		return nil, 0, sqlite3vfs.CantOpenError
+		return nil, err
+	}
+
+	file := &ZstdFile{decoding: decoding, reader: reader}
+
+	if !coldStart {
+		_, err = file.zstdDecoder()
+		if err != nil {
+			_ = reader.Close()
+
+			return nil, err
+		}
 	}
 
-	return &ZstdFile{
-		decoder:  decoder,
-		reader:   reader,
-		seekable: seekable,
-	}, flags | sqlite3vfs.OpenReadOnly, nil
+	counters := &archiveCounters{archive: countersFor(name)}
+	file.counter = &countingReader{archive: reader, stats: z.Stats, counters: counters}
+
+	file.table, err = loadSeekTable(reader.identity, file.counter, reader.Size())
+	if err != nil {
+		_ = file.Close()
+
+		return nil, err
+	}
+
+	environment := &tableEnvironment{reader: file.counter, table: file.table}
+
+	file.seekable, err = seekable.NewReader(file.counter, lazyDecoder{file: file}, seekable.WithREnvironment(environment))
+	if err != nil {
+		_ = file.Close()
+
+		return nil, fmt.Errorf("could not read seek table: %w", err)
+	}
+
+	file.counters = counters
+	counters.opened(1)
+
+	file.name, file.logger = name, z.Logger
+
+	return file, nil
 }
 
-//nolint: gochecknoglobals
-var once = sync.OnceValue(func() error {
-	err := sqlite3vfs.RegisterVFS("zstd", &ZstdVFS{})
+// decompress reads base from memory or a temporary file, decompressed at
+// once, or a frame at a time through the caches, as the VFS or the
+// parameters choose.
+func (z *ZstdVFS) decompress(base *ZstdFile, name string, params url.Values) error {
+	decompression, limit := z.Decompression, z.MemoryLimit
+
+	if params.Has("decompress") {
+		var err error
+
+		decompression, err = parseDecompression(params.Get("decompress"))
+		if err != nil {
+			return err
+		}
+	}
+
+	if params.Has("memory_limit") {
+		var err error
+
+		limit, err = ParseSize(params.Get("memory_limit"))
+		if err != nil {
+			return fmt.Errorf("%w: memory_limit=%q", ErrInvalidOption, params.Get("memory_limit"))
+		}
+	}
+
+	if limit == 0 {
+		limit = defaultMemoryLimit
+	}
+
+	if decompression != DecompressFrames {
+		var err error
+
+		base.decompressed, err = openDecompressed(base, decompression, limit)
+
+		return err
+	}
+
+	return z.useCaches(base, name, params)
+}
+
+// useCaches reads base a frame at a time, through the frame cache of the
+// VFS and, for remote archives, the disk cache of the VFS or of the
+// parameters and the external cache of the VFS, if any, reading frames
+// ahead of sequential scans when asked to. Frames read by several
+// connections at once are read once. Files with the partial frame policy
+// read through none of them.
+func (z *ZstdVFS) useCaches(base *ZstdFile, name string, params url.Values) error {
+	partial := z.PartialFrames
+
+	switch policy := params.Get("frame_policy"); policy {
+	case "":
+	case "whole":
+		partial = false
+	case "partial":
+		partial = true
+	default:
+		return fmt.Errorf("%w: frame_policy=%q", ErrInvalidOption, policy)
+	}
+
+	stores := []frameStore{}
+
+	cache, err := z.frameCache(params)
 	if err != nil {
-		return fmt.Errorf("could not register vfs: %w", err)
+		return err
+	}
+
+	if cache != nil {
+		stores = append(stores, cache)
+	}
+
+	if isRemote(name) {
+		cache, err := z.diskCache(params)
+		if err != nil {
+			return err
+		}
+
+		if cache != nil {
+			stores = append(stores, cache)
+		}
+
+		if z.Cache != nil {
+			stores = append(stores, externalCache{cache: z.Cache, archive: name})
+		}
+	}
+
+	readahead, adaptive := z.Readahead, z.AdaptiveReadahead
+
+	switch value := params.Get("readahead"); {
+	case value == "auto":
+		adaptive = true
+	case value != "":
+		adaptive = false
+
+		readahead, err = strconv.Atoi(value)
+		if err != nil || readahead < 0 {
+			return fmt.Errorf("%w: readahead=%q", ErrInvalidOption, value)
+		}
+	}
+
+	reader, _ := base.reader.(*archive)
+
+	byContent := z.DiskCacheByContent
+
+	switch key := params.Get("disk_cache_key"); key {
+	case "":
+	case "content":
+		byContent = true
+	case "location":
+		byContent = false
+	default:
+		return fmt.Errorf("%w: disk_cache_key=%q", ErrInvalidOption, key)
+	}
+
+	coalesce := z.CoalesceSize
+	if params.Has("coalesce_size") {
+		coalesce, err = ParseSize(params.Get("coalesce_size"))
+		if err != nil {
+			return fmt.Errorf("%w: coalesce_size=%q", ErrInvalidOption, params.Get("coalesce_size"))
+		}
+	}
+
+	workers := z.DecompressWorkers
+	if params.Has("decompress_workers") {
+		workers, err = strconv.Atoi(params.Get("decompress_workers"))
+		if err != nil || workers < 0 {
+			return fmt.Errorf("%w: decompress_workers=%q", ErrInvalidOption, params.Get("decompress_workers"))
+		}
+	}
+
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	metrics := z.Stats.archive(name)
+
+	if partial {
+		base.frames = newCachedFrames(nil, name, reader.Size(), base.table, false)
+		base.frames.partial, base.frames.reader, base.frames.decoding = true, base.counter, base.decoding
+		base.frames.metrics, base.frames.counters = metrics, base.counters
+
+		return nil
+	}
+
+	base.frames = newCachedFrames(stores, name, reader.Size(), base.table, byContent)
+	base.frames.reader, base.frames.decoding = base.counter, base.decoding
+	base.frames.coalesce, base.frames.workers = coalesce, workers
+	base.frames.metrics, base.frames.counters = metrics, base.counters
+	base.frames.logger = z.Logger
+
+	if cache != nil && metrics != nil {
+		cache.track(base.frames.key, metrics)
+	}
+
+	if readahead > 0 || adaptive {
+		base.frames.ahead = newReadahead(readahead, adaptive, base.counter, base.table.seekTable, base.decoding)
+		base.frames.ahead.metrics, base.frames.ahead.counters = metrics, base.counters
 	}
 
 	return nil
+}
+
+// frameCache returns the frame cache shared by the files of the VFS,
+// with the size of the VFS or of the parameters, or nil when it is off.
+// As the cache is shared, the size given last applies to every file.
+func (z *ZstdVFS) frameCache(params url.Values) (*frameCache, error) {
+	size := z.CacheSize
+	if size == 0 {
+		size = defaultCacheSize.Load()
+	}
+
+	if params.Has("cache_size") {
+		var err error
+
+		size, err = ParseSize(params.Get("cache_size"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: cache_size=%q", ErrInvalidOption, params.Get("cache_size"))
+		}
+	}
+
+	// a file without a cache leaves the cache of the others alone
+	if size <= 0 {
+		return nil, nil
+	}
+
+	z.cacheMutex.Lock()
+	defer z.cacheMutex.Unlock()
+
+	if z.cache == nil {
+		z.cache = newFrameCache(size)
+	} else {
+		z.cache.resize(size)
+	}
+
+	return z.cache, nil
+}
+
+// decoderOptions returns the decoder tuning of the VFS, overridden by
+// that of the parameters.
+func (z *ZstdVFS) decoderOptions(params url.Values) (decoderOptions, error) {
+	options := decoderOptions{
+		concurrency: z.DecoderConcurrency,
+		lowMemory:   z.DecoderLowMemory,
+		maxMemory:   z.DecoderMaxMemory,
+	}
+
+	if params.Has("decoder_concurrency") {
+		concurrency, err := strconv.Atoi(params.Get("decoder_concurrency"))
+		if err != nil || concurrency < 0 {
+			return decoderOptions{}, fmt.Errorf("%w: decoder_concurrency=%q", ErrInvalidOption, params.Get("decoder_concurrency"))
+		}
+
+		options.concurrency = concurrency
+	}
+
+	if params.Has("decoder_low_memory") {
+		lowMemory, err := strconv.ParseBool(params.Get("decoder_low_memory"))
+		if err != nil {
+			return decoderOptions{}, fmt.Errorf("%w: decoder_low_memory=%q", ErrInvalidOption, params.Get("decoder_low_memory"))
+		}
+
+		options.lowMemory = lowMemory
+	}
+
+	if params.Has("decoder_max_memory") {
+		maxMemory, err := ParseSize(params.Get("decoder_max_memory"))
+		if err != nil {
+			return decoderOptions{}, fmt.Errorf("%w: decoder_max_memory=%q", ErrInvalidOption, params.Get("decoder_max_memory"))
+		}
+
+		options.maxMemory = uint64(maxMemory)
+	}
+
+	return options, nil
+}
+
+// diskCache returns the disk cache of the VFS or of the parameters,
+// or nil when there is none.
+func (z *ZstdVFS) diskCache(params url.Values) (*diskCache, error) {
+	dir, size := z.DiskCacheDir, z.DiskCacheSize
+	if params.Has("disk_cache_dir") {
+		dir = params.Get("disk_cache_dir")
+	}
+
+	if params.Has("disk_cache_size") {
+		var err error
+
+		size, err = ParseSize(params.Get("disk_cache_size"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: disk_cache_size=%q", ErrInvalidOption, params.Get("disk_cache_size"))
+		}
+	}
+
+	if dir == "" {
+		return nil, nil
+	}
+
+	if size == 0 {
+		size = defaultDiskCacheSize
+	}
+
+	return openDiskCache(dir, size)
+}
+
+// isRemote reports whether name is opened by a backend rather than
+// as a local file.
+func isRemote(name string) bool {
+	_, ok := backendFor(name)
+
+	return ok
+}
+
+//nolint: gochecknoglobals
+var once = sync.OnceValue(func() error {
+	return Register("zstd", &ZstdVFS{})
 })
 
 func Init() error {
 	return once()
 }
+
+// Register makes vfs available to SQLite under name, for use
+// with a differently configured VFS alongside the default one.
+func Register(name string, vfs *ZstdVFS) error {
+	return register(name, vfs)
+}
+
+// register makes vfs available to SQLite under name, with the URI
+// parameters of the databases it opens.
+func register(name string, vfs sqlite3vfs.VFS) error {
+	err := sqlite3vfs.RegisterVFS(name, vfs)
+	if err != nil {
+		return fmt.Errorf("could not register vfs: %w", err)
+	}
+
+	err = installParameters(name)
+	if err != nil {
+		return fmt.Errorf("could not register vfs: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+//go:build sqlite_vtable || vtable
+
+package sqlitezstd
+
+import (
+	"fmt"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+//nolint: gochecknoinits
+func init() {
+	connectHooks = append(connectHooks, func(conn *sqlite3.SQLiteConn) error {
+		return conn.CreateModule("zstd_vfs_stats", &rowsModule{
+			schema: "CREATE TABLE x(schema TEXT, archive TEXT, reads INTEGER, bytes_read INTEGER, hits INTEGER, bytes_fetched INTEGER, fetch_seconds REAL, bytes_decompressed INTEGER, frames_decoded INTEGER, decode_seconds REAL)",
+			rows:   statsRows,
+		})
+	})
+
+	connectHooks = append(connectHooks, func(conn *sqlite3.SQLiteConn) error {
+		return conn.CreateModule("zstd_vfs_frames", &rowsModule{
+			schema: "CREATE TABLE x(schema TEXT, frame INTEGER, compressed_offset INTEGER, compressed_size INTEGER, decompressed_offset INTEGER, decompressed_size INTEGER)",
+			rows:   frameRows,
+		})
+	})
+}
+
+// statsRows is a row for every archive conn has open, with what was done
+// for it by conn alone.
+func statsRows(conn *sqlite3.SQLiteConn) [][]any {
+	rows := [][]any{}
+
+	for _, open := range connectionFilesOf(conn) {
+		stats := open.file.counters.snapshot()
+
+		rows = append(rows, []any{
+			open.schema,
+			logName(open.file.name),
+			stats.Reads,
+			stats.BytesRead,
+			stats.Hits,
+			stats.BytesFetched,
+			stats.FetchTime.Seconds(),
+			stats.BytesDecompressed,
+			stats.FramesDecoded,
+			stats.DecodeTime.Seconds(),
+		})
+	}
+
+	return rows
+}
+
+// frameRows is a row for every frame of contents of the archives conn
+// has open, numbered as in the seek table, like the frames slow reads are
+// logged with.
+func frameRows(conn *sqlite3.SQLiteConn) [][]any {
+	rows := [][]any{}
+
+	for _, open := range connectionFilesOf(conn) {
+		for _, frame := range open.file.table.frames {
+			// the header and metadata frames hold no contents
+			if frame.decompressedSize == 0 {
+				continue
+			}
+
+			rows = append(rows, []any{
+				open.schema,
+				int64(frame.index),
+				frame.compressedOffset,
+				int64(frame.compressedSize),
+				frame.decompressedOffset,
+				int64(frame.decompressedSize),
+			})
+		}
+	}
+
+	return rows
+}
+
+// rowsModule is an eponymous virtual table of the rows returned for the
+// connection querying it, gathered as it is scanned.
+type rowsModule struct {
+	schema string
+	rows   func(*sqlite3.SQLiteConn) [][]any
+}
+
+var _ sqlite3.EponymousOnlyModule = &rowsModule{}
+
+func (m *rowsModule) EponymousOnlyModule() {}
+
+func (m *rowsModule) Create(conn *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	return m.Connect(conn, args)
+}
+
+func (m *rowsModule) Connect(conn *sqlite3.SQLiteConn, _ []string) (sqlite3.VTab, error) {
+	err := conn.DeclareVTab(m.schema)
+	if err != nil {
+		return nil, fmt.Errorf("could not declare virtual table: %w", err)
+	}
+
+	return &rowsTable{module: m, conn: conn}, nil
+}
+
+func (m *rowsModule) DestroyModule() {}
+
+type rowsTable struct {
+	module *rowsModule
+	conn   *sqlite3.SQLiteConn
+}
+
+var _ sqlite3.VTab = &rowsTable{}
+
+func (t *rowsTable) BestIndex(constraints []sqlite3.InfoConstraint, _ []sqlite3.InfoOrderBy) (*sqlite3.IndexResult, error) {
+	// every row is scanned, SQLite filtering them
+	return &sqlite3.IndexResult{Used: make([]bool, len(constraints))}, nil
+}
+
+func (t *rowsTable) Disconnect() error { return nil }
+
+func (t *rowsTable) Destroy() error { return nil }
+
+func (t *rowsTable) Open() (sqlite3.VTabCursor, error) {
+	return &rowsCursor{table: t}, nil
+}
+
+type rowsCursor struct {
+	table *rowsTable
+	rows  [][]any
+	index int
+}
+
+var _ sqlite3.VTabCursor = &rowsCursor{}
+
+func (c *rowsCursor) Filter(int, string, []any) error {
+	c.rows = c.table.module.rows(c.table.conn)
+	c.index = 0
+
+	return nil
+}
+
+func (c *rowsCursor) Next() error {
+	c.index++
+
+	return nil
+}
+
+func (c *rowsCursor) EOF() bool {
+	return c.index >= len(c.rows)
+}
+
+func (c *rowsCursor) Column(context *sqlite3.SQLiteContext, column int) error {
+	switch value := c.rows[c.index][column].(type) {
+	case int64:
+		context.ResultInt64(value)
+	case float64:
+		context.ResultDouble(value)
+	case string:
+		context.ResultText(value)
+	default:
+		context.ResultNull()
+	}
+
+	return nil
+}
+
+func (c *rowsCursor) Rowid() (int64, error) {
+	return int64(c.index), nil
+}
+
+func (c *rowsCursor) Close() error {
+	c.rows = nil
+
+	return nil
+}
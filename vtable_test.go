@@ -0,0 +1,116 @@
+//go:build sqlite_vtable || vtable
+
+package sqlitezstd_test
+
+import (
+	"database/sql"
+	"fmt"
+
+	sqlitezstd "github.com/jtarchie/sqlitezstd"
+	"github.com/jtarchie/sqlitezstd/testhelper"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("virtual tables", func() {
+	BeforeEach(func() {
+		Expect(sqlitezstd.Init()).To(Succeed())
+	})
+
+	It("queries the stats of the archives of the connection with zstd_vfs_stats", func() {
+		zstPath := createDatabase()
+		attachedPath := createDatabase()
+
+		client, err := sql.Open(sqlitezstd.DriverName, fmt.Sprintf("file:%s?vfs=zstd", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		client.SetMaxOpenConns(1)
+
+		// another connection reads the same archive
+		Expect(countEntries(fmt.Sprintf("file:%s?vfs=zstd", zstPath))).To(BeEquivalentTo(1000))
+
+		_, err = client.Exec(fmt.Sprintf("ATTACH DATABASE 'file:%s?vfs=zstd' AS other", attachedPath))
+		Expect(err).ToNot(HaveOccurred())
+
+		var count int64
+		Expect(client.QueryRow("SELECT COUNT(*) FROM entries;").Scan(&count)).To(Succeed())
+		Expect(count).To(BeEquivalentTo(1000))
+
+		rows, err := client.Query("SELECT schema, archive, reads, bytes_read, hits, bytes_fetched, fetch_seconds, frames_decoded, decode_seconds FROM zstd_vfs_stats")
+		Expect(err).ToNot(HaveOccurred())
+		defer rows.Close()
+
+		type row struct {
+			schema, archive                      string
+			reads, bytesRead, hits, bytesFetched int64
+			fetchSeconds, decodeSeconds          float64
+			framesDecoded                        int64
+		}
+
+		found := map[string]row{}
+
+		for rows.Next() {
+			var r row
+			Expect(rows.Scan(&r.schema, &r.archive, &r.reads, &r.bytesRead, &r.hits, &r.bytesFetched, &r.fetchSeconds, &r.framesDecoded, &r.decodeSeconds)).To(Succeed())
+			found[r.schema] = r
+		}
+
+		Expect(rows.Err()).ToNot(HaveOccurred())
+		Expect(found).To(HaveLen(2))
+
+		main := found["main"]
+		Expect(main.archive).To(Equal(zstPath))
+		Expect(main.reads).To(BeNumerically(">", 0))
+		Expect(main.bytesRead).To(BeNumerically(">=", main.reads*512))
+		Expect(main.bytesFetched).To(BeNumerically(">", 0))
+		Expect(main.framesDecoded).To(BeNumerically(">", 0))
+
+		// only what this connection read is counted
+		Expect(main.reads).To(BeNumerically("<", sqlitezstd.StatsFor(zstPath).Reads))
+
+		Expect(found["other"].archive).To(Equal(attachedPath))
+		Expect(found["other"].reads).To(BeNumerically("<", main.reads))
+	})
+
+	It("lists the frames of the archives of the connection with zstd_vfs_frames", func() {
+		zstPath := testhelper.CreateEntriesDB(GinkgoT(), 1000, sqlitezstd.WithFrameSize(4096))
+
+		info, err := sqlitezstd.Inspect(zstPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(info.Frames)).To(BeNumerically(">", 1))
+
+		client, err := sql.Open(sqlitezstd.DriverName, fmt.Sprintf("file:%s?vfs=zstd", zstPath))
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		frames, err := client.Query("SELECT schema, frame, compressed_offset, compressed_size, decompressed_offset, decompressed_size FROM zstd_vfs_frames ORDER BY frame")
+		Expect(err).ToNot(HaveOccurred())
+		defer frames.Close()
+
+		found := []sqlitezstd.ArchiveFrame{}
+		previous := int64(-1)
+
+		for frames.Next() {
+			var (
+				schema string
+				index  int64
+				frame  sqlitezstd.ArchiveFrame
+			)
+
+			Expect(frames.Scan(&schema, &index, &frame.CompressedOffset, &frame.CompressedSize, &frame.DecompressedOffset, &frame.DecompressedSize)).To(Succeed())
+			Expect(schema).To(Equal("main"))
+			Expect(index).To(BeNumerically(">", previous))
+
+			previous = index
+			found = append(found, frame)
+		}
+
+		Expect(frames.Err()).ToNot(HaveOccurred())
+		Expect(found).To(Equal(info.Frames))
+
+		var largest int64
+		Expect(client.QueryRow("SELECT MAX(decompressed_size) FROM zstd_vfs_frames WHERE schema = 'main'").Scan(&largest)).To(Succeed())
+		Expect(largest).To(BeNumerically(">=", 4096))
+	})
+})
@@ -0,0 +1,112 @@
+package sqlitezstd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrUnknownObject = errors.New("no such table or index")
+
+// WarmOptions chooses what Warm reads besides the header and the schema.
+type WarmOptions struct {
+	// Tables and Indexes are read in full, by name, such as the index
+	// behind the first query of a service.
+	Tables  []string
+	Indexes []string
+}
+
+// Warm reads the database header, the schema, and the tables and indexes
+// of opts through db, so their frames are decompressed before the first
+// query. The frames are kept for every connection by the frame cache of
+// the VFS, so set ZstdVFS.CacheSize, or cache_size, or only the
+// connection Warm runs on is warmed.
+func Warm(db *sql.DB, opts WarmOptions) error {
+	var count int64
+
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_schema").Scan(&count)
+	if err != nil {
+		return fmt.Errorf("could not read schema: %w", err)
+	}
+
+	for _, table := range opts.Tables {
+		err = warmObject(db, "table", table)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, index := range opts.Indexes {
+		err = warmObject(db, "index", index)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Preopen opens the database of dsn and warms it as Warm does, such as
+// in the init phase of a serverless function, so the first request it
+// handles does not wait on opening the archive. The connection stays open
+// in the database returned, along with the archive and its seek table.
+func Preopen(dsn string, opts WarmOptions) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("could not open database: %w", err)
+	}
+
+	err = Warm(db, opts)
+	if err != nil {
+		_ = db.Close()
+
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// warmObject reads every page of a table or index by counting its rows.
+func warmObject(db *sql.DB, kind string, name string) error {
+	var table string
+
+	err := db.QueryRow("SELECT tbl_name FROM sqlite_schema WHERE type = ? AND name = ?", kind, name).Scan(&table)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %s %q", ErrUnknownObject, kind, name)
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not find %s %q: %w", kind, name, err)
+	}
+
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s NOT INDEXED", quoteIdentifier(table))
+
+	// counting the first column of an index scans the index, rather than
+	// the table, which expression indexes fall back to
+	if kind == "index" {
+		var column sql.NullString
+
+		err = db.QueryRow("SELECT name FROM pragma_index_info(?) WHERE seqno = 0", name).Scan(&column)
+		if err != nil {
+			return fmt.Errorf("could not read index %q: %w", name, err)
+		}
+
+		if column.Valid {
+			query = fmt.Sprintf("SELECT COUNT(%s) FROM %s INDEXED BY %s", quoteIdentifier(column.String), quoteIdentifier(table), quoteIdentifier(name))
+		}
+	}
+
+	var count int64
+
+	err = db.QueryRow(query).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("could not read %s %q: %w", kind, name, err)
+	}
+
+	return nil
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,390 @@
+package sqlitezstd
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var ErrWriterClosed = errors.New("writer is closed")
+
+type frame struct {
+	done       chan struct{}
+	compressed []byte
+	entry      seekTableEntry
+}
+
+type writer struct {
+	output  io.Writer
+	encoder *zstd.Encoder
+	config  *options
+
+	prefix   []byte
+	aligned  bool
+	buffer   []byte
+	inflight []*frame
+	entries  []seekTableEntry
+	progress Progress
+	err      error
+
+	header  *Header
+	chunker *chunker
+	// reuse returns an already compressed frame for raw, if there is one.
+	reuse func(raw []byte) ([]byte, seekTableEntry, bool)
+
+	// digest and source track the uncompressed input for the metadata.
+	digest hash.Hash
+	source []byte
+	size   int64
+}
+
+var _ io.WriteCloser = &writer{}
+
+// NewWriter returns a writer that compresses everything written to it into
+// the seekable zstd format, emitting each frame to w as soon as it is full.
+// Close flushes the final frame and the seek table, but does not close w.
+func NewWriter(w io.Writer, opts ...Option) io.WriteCloser {
+	config, err := newOptions(opts)
+	if err != nil {
+		return &writer{err: err}
+	}
+
+	encoder, err := config.encoder()
+	if err != nil {
+		return &writer{err: fmt.Errorf("could not create encoder: %w", err)}
+	}
+
+	return &writer{
+		output:  w,
+		encoder: encoder,
+		config:  config,
+		prefix:  make([]byte, 0, sqliteHeaderSize),
+		digest:  sha256.New(),
+		source:  make([]byte, 0, sqliteHeaderSize),
+	}
+}
+
+func (z *writer) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+
+	if z.aligned {
+		return z.write(p)
+	}
+
+	// hold back data until the SQLite header can be inspected
+	size := min(len(p), sqliteHeaderSize-len(z.prefix))
+	z.prefix = append(z.prefix, p[:size]...)
+
+	if len(z.prefix) < sqliteHeaderSize {
+		return size, nil
+	}
+
+	err := z.align()
+	if err != nil {
+		return size, err
+	}
+
+	written, err := z.write(p[size:])
+
+	return size + written, err
+}
+
+// align picks the frame size from the buffered header, writes the
+// header frame, and replays the buffered data into the first frame.
+func (z *writer) align() error {
+	var pageSize int
+
+	if len(z.prefix) == sqliteHeaderSize && string(z.prefix[:len(sqliteHeaderMagic)]) == sqliteHeaderMagic {
+		pageSize, _ = parsePageSize(z.prefix)
+	}
+
+	err := z.start(z.config.header(pageSize))
+	if err != nil {
+		return err
+	}
+
+	_, err = z.write(z.prefix)
+	z.prefix = nil
+
+	return err
+}
+
+// start writes the header frame and sizes frames according to it.
+func (z *writer) start(header *Header) error {
+	contents, err := header.frame()
+	if err != nil {
+		z.err = err
+
+		return z.err
+	}
+
+	_, err = z.output.Write(contents)
+	if err != nil {
+		z.err = fmt.Errorf("could not write header: %w", err)
+
+		return z.err
+	}
+
+	z.entries = append(z.entries, seekTableEntry{
+		//nolint: gosec
+		compressedSize: uint32(len(contents)),
+		checksum:       frameChecksum(nil),
+	})
+	z.aligned = true
+	z.header = header
+	z.buffer = make([]byte, 0, header.FrameSize)
+
+	if header.ContentDefined {
+		z.chunker = newChunker(header)
+		z.buffer = make([]byte, 0, z.chunker.max)
+	}
+
+	return nil
+}
+
+func (z *writer) write(p []byte) (int, error) {
+	z.observe(p)
+
+	written := 0
+
+	for len(p) > 0 {
+		size := min(len(p), cap(z.buffer)-len(z.buffer))
+		full := len(z.buffer)+size == cap(z.buffer)
+
+		if z.chunker != nil {
+			size, full = z.chunker.boundary(z.buffer, p)
+		}
+
+		z.buffer = append(z.buffer, p[:size]...)
+		p = p[size:]
+		written += size
+
+		if full {
+			err := z.flush()
+			if err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+func (z *writer) Close() error {
+	if z.err != nil {
+		if errors.Is(z.err, ErrWriterClosed) {
+			return nil
+		}
+
+		return z.err
+	}
+
+	if !z.aligned {
+		err := z.align()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := z.flush()
+	if err != nil {
+		return err
+	}
+
+	for len(z.inflight) > 0 {
+		err = z.writeOldest()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = z.writeMetadata()
+	if err != nil {
+		return err
+	}
+
+	_, err = z.output.Write(encodeSeekTable(z.entries))
+	if err != nil {
+		z.err = fmt.Errorf("could not write seek table: %w", err)
+
+		return z.err
+	}
+
+	_ = z.encoder.Close()
+	z.err = ErrWriterClosed
+
+	return nil
+}
+
+// flush hands the buffered data to a worker, writing out
+// the oldest compressed frame once all workers are busy.
+func (z *writer) flush() error {
+	if len(z.buffer) == 0 {
+		return nil
+	}
+
+	if len(z.inflight) >= z.config.workers {
+		err := z.writeOldest()
+		if err != nil {
+			return err
+		}
+	}
+
+	pending := &frame{
+		done: make(chan struct{}),
+	}
+	source := z.buffer
+	z.buffer = make([]byte, 0, cap(z.buffer))
+
+	if z.reuse != nil {
+		compressed, entry, ok := z.reuse(source)
+		if ok {
+			pending.compressed = compressed
+			pending.entry = entry
+			close(pending.done)
+
+			z.inflight = append(z.inflight, pending)
+
+			return nil
+		}
+	}
+
+	go func() {
+		defer close(pending.done)
+
+		pending.compressed = z.encoder.EncodeAll(source, nil)
+		pending.entry = seekTableEntry{
+			//nolint: gosec
+			compressedSize: uint32(len(pending.compressed)),
+			//nolint: gosec
+			decompressedSize: uint32(len(source)),
+			checksum:         frameChecksum(source),
+		}
+	}()
+
+	z.inflight = append(z.inflight, pending)
+
+	return nil
+}
+
+// writeCompressed ends the current frame and copies an already
+// compressed frame of raw into the output as is.
+func (z *writer) writeCompressed(compressed []byte, entry seekTableEntry, raw []byte) error {
+	err := z.flush()
+	if err != nil {
+		return err
+	}
+
+	z.observe(raw)
+
+	for len(z.inflight) > 0 {
+		err = z.writeOldest()
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = z.output.Write(compressed)
+	if err != nil {
+		z.err = fmt.Errorf("could not write frame: %w", err)
+
+		return z.err
+	}
+
+	z.entries = append(z.entries, entry)
+	z.report(entry)
+
+	return nil
+}
+
+// writeOldest waits for the oldest frame so frames are written in order.
+func (z *writer) writeOldest() error {
+	oldest := z.inflight[0]
+	z.inflight = z.inflight[1:]
+
+	<-oldest.done
+
+	_, err := z.output.Write(oldest.compressed)
+	if err != nil {
+		z.err = fmt.Errorf("could not write frame: %w", err)
+
+		return z.err
+	}
+
+	z.entries = append(z.entries, oldest.entry)
+	z.report(oldest.entry)
+
+	return nil
+}
+
+// report passes the progress after a frame was written to the callback.
+func (z *writer) report(entry seekTableEntry) {
+	z.progress.BytesRead += int64(entry.decompressedSize)
+	z.progress.BytesWritten += int64(entry.compressedSize)
+	z.progress.FramesWritten++
+
+	if z.config.progress != nil {
+		z.config.progress(z.progress)
+	}
+}
+
+// observe records uncompressed input, in order, for the metadata.
+func (z *writer) observe(p []byte) {
+	_, _ = z.digest.Write(p)
+	z.size += int64(len(p))
+
+	if len(z.source) < cap(z.source) {
+		z.source = append(z.source, p[:min(len(p), cap(z.source)-len(z.source))]...)
+	}
+}
+
+// writeMetadata writes the metadata frame describing the input.
+func (z *writer) writeMetadata() error {
+	metadata := &Metadata{
+		SourceSHA256:  hex.EncodeToString(z.digest.Sum(nil)),
+		SourceSize:    z.size,
+		Level:         z.config.level,
+		FrameSize:     z.header.FrameSize,
+		Deterministic: z.config.deterministic,
+	}
+
+	if !z.config.deterministic {
+		metadata.CreatedAt = time.Now().UTC()
+	}
+
+	if len(z.source) == sqliteHeaderSize && string(z.source[:len(sqliteHeaderMagic)]) == sqliteHeaderMagic {
+		metadata.SchemaVersion = binary.BigEndian.Uint32(z.source[sqliteSchemaVersionOffset:])
+		metadata.UserVersion = binary.BigEndian.Uint32(z.source[sqliteUserVersionOffset:])
+	}
+
+	contents, err := metadata.frame()
+	if err != nil {
+		z.err = err
+
+		return z.err
+	}
+
+	_, err = z.output.Write(contents)
+	if err != nil {
+		z.err = fmt.Errorf("could not write metadata: %w", err)
+
+		return z.err
+	}
+
+	z.entries = append(z.entries, seekTableEntry{
+		//nolint: gosec
+		compressedSize: uint32(len(contents)),
+		checksum:       frameChecksum(nil),
+	})
+
+	return nil
+}